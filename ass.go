@@ -0,0 +1,120 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ASS/SSA timestamp, h:mm:ss.cc with centiseconds
+	assTimestamp = regexp.MustCompile(`^\s*(\d+):(\d{1,2}):(\d{1,2})(?:\.(\d{1,3}))?\s*$`)
+
+	// Override block like {\i1\pos(10,10)}, and the styling tags in it that WebVTT can show
+	assOverride = regexp.MustCompile(`\{([^}]*)\}`)
+	assStyle    = regexp.MustCompile(`\\([biu])(\d*)`)
+
+	// Vector drawing mode, whose "text" is a list of coordinates
+	assDrawing = regexp.MustCompile(`\\p[1-9]`)
+)
+
+// Parse the dialogue events of an ASS or SSA script. Positioning, fonts, colors and karaoke
+// are dropped; timing, text and italic/bold/underline are kept.
+func parseASS(script string) []cue {
+	script = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(script)
+
+	var cues []cue
+	inEvents := false
+	var fields []string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Format":
+			fields = strings.Split(value, ",")
+			for i := range fields {
+				fields[i] = strings.ToLower(strings.TrimSpace(fields[i]))
+			}
+		case "Dialogue":
+			if c, ok := parseASSDialogue(value, fields); ok {
+				cues = append(cues, c)
+			}
+		}
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].start < cues[j].start })
+	return cues
+}
+
+func parseASSDialogue(value string, fields []string) (cue, bool) {
+	// Scripts missing the Format line use the standard ASS column order
+	if fields == nil {
+		fields = []string{"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text"}
+	}
+
+	// Text is the last column and may itself contain commas
+	values := strings.SplitN(value, ",", len(fields))
+	if len(values) != len(fields) || fields[len(fields)-1] != "text" {
+		return cue{}, false
+	}
+	column := map[string]string{}
+	for i, field := range fields {
+		column[field] = values[i]
+	}
+
+	start, okStart := parseASSTimestamp(column["start"])
+	end, okEnd := parseASSTimestamp(column["end"])
+	text := column["text"]
+	if !okStart || !okEnd || assDrawing.MatchString(text) {
+		return cue{}, false
+	}
+
+	// ASS has no HTML-like markup, so text like "<sigh>" is escaped as it is. Override
+	// blocks then become the WebVTT tags, everything else in them is dropped.
+	text = assOverride.ReplaceAllStringFunc(vttEscape(text), func(block string) string {
+		var tags strings.Builder
+		for _, m := range assStyle.FindAllStringSubmatch(block, -1) {
+			if m[2] == "0" {
+				tags.WriteString("</" + m[1] + ">")
+			} else if m[2] != "" {
+				tags.WriteString("<" + m[1] + ">")
+			}
+		}
+		return tags.String()
+	})
+	text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+
+	c := cue{start: start, end: end}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			c.lines = append(c.lines, line)
+		}
+	}
+	return c, len(c.lines) > 0
+}
+
+// Milliseconds from an ASS timestamp like 0:01:02.50
+func parseASSTimestamp(s string) (int64, bool) {
+	m := assTimestamp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	// Centiseconds are the norm, padding makes "5" 500ms like in parseTimestamp
+	return parseTimestamp(m[1:5]), true
+}
+
+// Convert ASS or SSA subtitles into WebVTT
+func assToVTT(script string) string {
+	return cuesToVTT(parseASS(script))
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+const assHeader = "[Script Info]\nScriptType: v4.00+\n\n[V4+ Styles]\nFormat: Name, Fontname\nStyle: Default,Arial\n\n"
+
+func TestASSToVTT(t *testing.T) {
+	for _, tt := range []struct {
+		name, script, want string
+	}{
+		{
+			"ASS",
+			assHeader + "[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+				"Dialogue: 0,0:00:01.50,0:00:03.00,Default,,0,0,0,,{\\pos(10,10)\\i1}Hello{\\i0}, world\\NSecond line\n",
+			"WEBVTT\n\n00:00:01.500 --> 00:00:03.000\n<i>Hello</i>, world\nSecond line\n",
+		},
+		{
+			"SSA with Marked",
+			"[Script Info]\nScriptType: v4.00\n\n[Events]\nFormat: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+				"Dialogue: Marked=0,0:00:02.00,0:00:04.25,Default,,0000,0000,0000,,{\\b1}Bold{\\b0} <sigh>\n",
+			"WEBVTT\n\n00:00:02.000 --> 00:00:04.250\n<b>Bold</b> &lt;sigh&gt;\n",
+		},
+		{
+			"sorted, comments and drawings skipped",
+			assHeader + "[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+				"Dialogue: 0,0:00:05.00,0:00:06.00,Default,,0,0,0,,Later\r\n" +
+				"Comment: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Not shown\r\n" +
+				"Dialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,{\\p1}m 0 0 l 100 0 100 100\r\n" +
+				"Dialogue: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,Earlier\r\n",
+			"WEBVTT\n\n00:00:03.000 --> 00:00:04.000\nEarlier\n\n00:00:05.000 --> 00:00:06.000\nLater\n",
+		},
+		{
+			"no Format line",
+			"[Events]\nDialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Plain\n",
+			"WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nPlain\n",
+		},
+	} {
+		if got := assToVTT(tt.script); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
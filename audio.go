@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Number of audio streams by movie path, reused until the file changes
+var audioStreamCache sync.Map
+
+type cachedAudioStreams struct {
+	modTime time.Time
+	size    int64
+	count   int
+}
+
+// Ask ffprobe how many audio streams a movie has, numbered like ffmpeg's 0:a:N
+func countAudioStreams(rid, movieFilePath string) (int, error) {
+	info, err := os.Stat(movieFilePath)
+	if err != nil {
+		return 0, err
+	}
+	if cached, ok := audioStreamCache.Load(movieFilePath); ok {
+		cached := cached.(cachedAudioStreams)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.count, nil
+		}
+	}
+
+	output, err := runTool(rid, "ffprobe", "-v", "error",
+		"-select_streams", "a", "-show_entries", "stream=index", "-of", "json", movieFilePath)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Streams []struct{} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return 0, fmt.Errorf("ffprobe output: %w", err)
+	}
+
+	audioStreamCache.Store(movieFilePath, cachedAudioStreams{modTime: info.ModTime(), size: info.Size(), count: len(result.Streams)})
+	return len(result.Streams), nil
+}
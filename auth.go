@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Guard for endpoints that change the library, using the -api-token bearer token
+func requireAuth(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Without a token nobody may change anything
+		if cfg.APIToken == "" {
+			return c.Status(fiber.StatusForbidden).SendString("Library changes are disabled, start the server with -api-token to enable them.")
+		}
+
+		if !validToken(cfg, c.Get(fiber.HeaderAuthorization)) {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return c.Status(fiber.StatusUnauthorized).SendString("Invalid or missing API token.")
+		}
+
+		return c.Next()
+	}
+}
+
+// Guard for endpoints that write to the library, refused while in read-only mode
+func writable(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if cfg.readOnly.Load() {
+			c.Set(fiber.HeaderRetryAfter, "300")
+			return c.Status(fiber.StatusServiceUnavailable).SendString("The library is read-only for maintenance, changes are disabled for now.")
+		}
+		return c.Next()
+	}
+}
+
+// Whether an Authorization header carries the -api-token bearer token
+func validToken(cfg *Config, authorization string) bool {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	return ok && cfg.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.APIToken)) == 1
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testToken = "secret"
+
+func authorized(req *http.Request) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	return req
+}
+
+func TestLibraryChangesNeedToken(t *testing.T) {
+	for _, tt := range []struct {
+		args          []string
+		authorization string
+		status        int
+	}{
+		{nil, "Bearer " + testToken, http.StatusForbidden},
+		{[]string{"-api-token", testToken}, "", http.StatusUnauthorized},
+		{[]string{"-api-token", testToken}, "Bearer wrong", http.StatusUnauthorized},
+	} {
+		app, _ := newTestServer(t, tt.args...)
+		req, _ := http.NewRequest(http.MethodPatch, "/api/movies/a", strings.NewReader(`{"newName":"b"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		if resp, body := send(t, app, req); resp.StatusCode != tt.status {
+			t.Errorf("%v with Authorization %q answered %d: %s", tt.args, tt.authorization, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken, "-read-only")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	setReadOnly := func(body string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPut, "/api/read-only", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return send(t, app, authorized(req))
+	}
+	upload := func() *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, "/api/upload/b.mp4", strings.NewReader(testMovie))
+		// A refused body isn't read, so the connection can't be used again
+		req.Header.Set("Connection", "close")
+		resp, _ := send(t, app, authorized(req))
+		return resp
+	}
+
+	// Writes are refused
+	if resp, body := renameMovie(t, app, "a", "c"); resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("rename answered %d with Retry-After %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if resp := upload(); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("upload answered %d", resp.StatusCode)
+	}
+	// So is everything else that changes the library or what is kept about it
+	for _, write := range []struct{ method, target, body string }{
+		{http.MethodPost, "/api/movies/a/regenerate", ""},
+		{http.MethodPost, "/api/movies/a/faststart", ""},
+		{http.MethodPut, "/api/movies/a/meta", `{"rating": 5}`},
+		{http.MethodPost, "/api/uploads", ""},
+		{http.MethodPut, "/api/favorites/a", ""},
+		{http.MethodPut, "/api/watched/a", ""},
+	} {
+		req, _ := http.NewRequest(write.method, write.target, strings.NewReader(write.body))
+		if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s %s answered %d: %s", write.method, write.target, resp.StatusCode, body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("movies", "a.mp4")); err != nil {
+		t.Error("the movie was changed in read-only mode")
+	}
+	if _, err := os.Stat(filepath.Join("movies", "b.mp4")); err == nil {
+		t.Error("an upload was stored in read-only mode")
+	}
+
+	// Reads aren't
+	for _, target := range []string{"/video/a", "/stream/a", "/download-folder/", "/api/read-only"} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s answered %d: %s", target, resp.StatusCode, body)
+		} else if target == "/api/read-only" && body != `{"readOnly":true}` {
+			t.Errorf("%s answered %s", target, body)
+		}
+	}
+
+	// Switched off at runtime, writes go through again
+	if resp, body := setReadOnly(`{"readOnly": false}`); resp.StatusCode != http.StatusOK || body != `{"readOnly":false}` {
+		t.Fatalf("switching off answered %d: %s", resp.StatusCode, body)
+	}
+	if resp := upload(); resp.StatusCode != http.StatusCreated {
+		t.Errorf("upload after switching off answered %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "/api/movies/a/regenerate", nil)
+	if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusOK {
+		t.Errorf("regenerate after switching off answered %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := setReadOnly(`{}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("toggle without a value answered %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		token  bool
+		status int
+	}{
+		{[]string{"-api-token", testToken}, true, http.StatusNotFound},
+		{[]string{"-api-token", testToken, "-pprof"}, false, http.StatusUnauthorized},
+		{[]string{"-api-token", testToken, "-pprof"}, true, http.StatusOK},
+	} {
+		app, _ := newTestServer(t, tt.args...)
+		for _, target := range []string{"/debug/pprof/", "/debug/pprof/heap"} {
+			req, _ := http.NewRequest(http.MethodGet, target, nil)
+			if tt.token {
+				authorized(req)
+			}
+			if resp, _ := send(t, app, req); resp.StatusCode != tt.status {
+				t.Errorf("%v: %s with token %t answered %d", tt.args, target, tt.token, resp.StatusCode)
+			}
+		}
+	}
+
+	if _, err := loadConfig([]string{"-pprof"}); err == nil {
+		t.Error("-pprof without -api-token accepted")
+	}
+}
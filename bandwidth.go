@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Video bytes sent since the process started, in total and per movie. Kept in memory only,
+// so a restart starts counting from zero.
+var (
+	bandwidthSince = time.Now()
+	bytesServed    atomic.Int64
+	movieBytes     sync.Map // Movie name to *atomic.Int64
+)
+
+func countBytesServed(movie string, n int64) {
+	bytesServed.Add(n)
+	counter, _ := movieBytes.LoadOrStore(movie, &atomic.Int64{})
+	counter.(*atomic.Int64).Add(n)
+}
+
+type movieBandwidth struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Bytes served since startup, busiest movies first
+func bandwidthHandler(c *fiber.Ctx) error {
+	movies := []movieBandwidth{}
+	movieBytes.Range(func(name, counter any) bool {
+		movies = append(movies, movieBandwidth{name.(string), counter.(*atomic.Int64).Load()})
+		return true
+	})
+	sort.Slice(movies, func(i, j int) bool {
+		if movies[i].Bytes != movies[j].Bytes {
+			return movies[i].Bytes > movies[j].Bytes
+		}
+		return movies[i].Name < movies[j].Name
+	})
+
+	return c.JSON(fiber.Map{
+		"since":      bandwidthSince.UTC(),
+		"totalBytes": bytesServed.Load(),
+		"movies":     movies,
+	})
+}
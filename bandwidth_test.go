@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bandwidthReport struct {
+	Since      time.Time        `json:"since"`
+	TotalBytes int64            `json:"totalBytes"`
+	Movies     []movieBandwidth `json:"movies"`
+}
+
+func TestBandwidth(t *testing.T) {
+	app, _ := newTestServer(t, "-prefetch-bytes", "8", "-sendfile-min-size", "0", "-native-range-formats", "webm")
+	writeFile(t, filepath.Join("movies", "bandwidth-a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "bandwidth-b.webm"), []byte(testMovie))
+	report := func() bandwidthReport {
+		t.Helper()
+		var r bandwidthReport
+		if _, body := get(t, app, "/api/bandwidth"); json.Unmarshal([]byte(body), &r) != nil {
+			t.Fatalf("bandwidth: %s", body)
+		}
+		return r
+	}
+	request := func(method, target, rangeHeader string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, target, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		if resp, body := send(t, app, req); resp.StatusCode >= 300 {
+			t.Fatalf("%s %s answered %d: %s", method, target, resp.StatusCode, body)
+		}
+	}
+	before := report()
+
+	request(http.MethodGet, "/video/bandwidth-a", "bytes=0-")     // 8 bytes, streamed
+	request(http.MethodGet, "/video/bandwidth-a", "bytes=16-")    // 4 bytes, streamed
+	request(http.MethodGet, "/video/bandwidth-a", "")             // 20 bytes, sendfile
+	request(http.MethodHead, "/video/bandwidth-a", "")            // nothing
+	request(http.MethodGet, "/video/bandwidth-b", "bytes=10-14")  // 5 bytes, sendfile range
+	request(http.MethodHead, "/video/bandwidth-b", "bytes=10-14") // nothing
+
+	after := report()
+	if after.TotalBytes-before.TotalBytes != 37 || !after.Since.Equal(before.Since) || after.Since.After(time.Now()) {
+		t.Errorf("total went from %d to %d since %s", before.TotalBytes, after.TotalBytes, after.Since)
+	}
+	movies := map[string]int64{}
+	var order []string
+	for _, movie := range after.Movies {
+		movies[movie.Name] = movie.Bytes
+		order = append(order, movie.Name)
+	}
+	if movies["bandwidth-a"] != 32 || movies["bandwidth-b"] != 5 {
+		t.Errorf("per movie: %v", movies)
+	}
+	for i := 1; i < len(after.Movies); i++ {
+		if after.Movies[i].Bytes > after.Movies[i-1].Bytes {
+			t.Errorf("movies not busiest first: %v", order)
+		}
+	}
+
+	_, metrics := get(t, app, "/metrics")
+	if !strings.Contains(metrics, fmt.Sprintf("# TYPE display_video_bytes_served_total counter\ndisplay_video_bytes_served_total %d\n", after.TotalBytes)) {
+		t.Errorf("metrics lack a total of %d:\n%s", after.TotalBytes, metrics)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Largest request body any route other than the uploads accepts. Their bodies are small
+// JSON documents, the biggest being a movie's 64 KB of metadata.
+const maxRequestBodySize = 64 << 10
+
+// Request bodies are streamed (StreamRequestBody), and fasthttp then doesn't refuse big
+// ones but hands them over as a stream that c.Body() would read into memory whole. So
+// every body except an upload's is read here, up to maxRequestBodySize, and refused with
+// 413 beyond it. Uploads read theirs with requestBody, limited by -max-upload-size.
+func limitBody() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if isUploadRequest(c.Method(), c.Path()) {
+			return c.Next()
+		}
+		if c.Request().Header.ContentLength() > maxRequestBodySize {
+			return bodyTooLarge(c)
+		}
+		body := c.Context().RequestBodyStream()
+		if body == nil {
+			if len(c.Body()) > maxRequestBodySize {
+				return bodyTooLarge(c)
+			}
+			return c.Next()
+		}
+		content, err := io.ReadAll(io.LimitReader(body, maxRequestBodySize+1))
+		if err != nil {
+			c.Context().SetConnectionClose()
+			return c.Status(fiber.StatusBadRequest).SendString("Could not read the request body.")
+		}
+		if len(content) > maxRequestBodySize {
+			return bodyTooLarge(c)
+		}
+		c.Request().SetBody(content)
+		return c.Next()
+	}
+}
+
+// Refuse a body without reading the rest of it, which also means the connection can't be
+// used for another request
+func bodyTooLarge(c *fiber.Ctx) error {
+	c.Context().SetConnectionClose()
+	return c.Status(fiber.StatusRequestEntityTooLarge).SendString("Request body is too large.")
+}
+
+// Whether a request is one of the uploads, whose bodies are streamed to disk
+func isUploadRequest(method, path string) bool {
+	_, isUpload := cutPrefixFold(path, "/api/upload/")
+	_, isTus := cutPrefixFold(path, "/api/uploads/")
+	return isUpload && method == fiber.MethodPut || isTus && method == fiber.MethodPatch
+}
+
+// The body of an upload as it arrives. Never nil, and never read into memory whole.
+func requestBody(c *fiber.Ctx) io.Reader {
+	if body := c.Context().RequestBodyStream(); body != nil {
+		return body
+	}
+	return bytes.NewReader(nil)
+}
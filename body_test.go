@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Send a request whose body has no known length with chunked encoding, app.Test would
+// otherwise write it without any framing. The header only keeps app.Test from adding one.
+func sendChunked(req *http.Request) {
+	if req.ContentLength == 0 && req.Body != nil && req.Body != http.NoBody {
+		req.TransferEncoding = []string{"chunked"}
+		req.Header.Set("Content-Length", "-1")
+	}
+}
+
+// Every other route takes at most a small JSON document, however it is sent
+func TestRequestBodyLimit(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	report := func(padding int) string {
+		return `{"position": 1` + strings.Repeat(" ", padding) + "}"
+	}
+
+	for _, tt := range []struct {
+		name   string
+		body   io.Reader
+		status int
+	}{
+		{"within the limit", strings.NewReader(report(1000)), http.StatusOK},
+		{"over the limit", strings.NewReader(report(maxRequestBodySize)), http.StatusRequestEntityTooLarge},
+		{"chunked within the limit", io.MultiReader(strings.NewReader(report(1000))), http.StatusOK},
+		{"chunked over the limit", io.MultiReader(strings.NewReader(report(maxRequestBodySize))), http.StatusRequestEntityTooLarge},
+	} {
+		req, _ := http.NewRequest(http.MethodPut, "/api/progress/a", tt.body)
+		sendChunked(req)
+		req.Header.Set("Content-Type", "application/json")
+		if tt.status != http.StatusOK {
+			req.Header.Set("Connection", "close")
+		}
+		if resp, body := send(t, app, req); resp.StatusCode != tt.status {
+			t.Errorf("%s: answered %d: %s", tt.name, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken, "-max-upload-size", "100000")
+
+	tests := []struct {
+		name, file string
+		body       io.Reader
+		status     int
+	}{
+		{"within the limit", "a.mp4", strings.NewReader(strings.Repeat("m", 90000)), http.StatusCreated},
+		{"over the limit", "b.mp4", strings.NewReader(strings.Repeat("m", 100001)), http.StatusRequestEntityTooLarge},
+		{"chunked within the limit", "c.mp4", io.MultiReader(strings.NewReader(strings.Repeat("m", 90000))), http.StatusCreated},
+		{"chunked over the limit", "d.mp4", io.MultiReader(strings.NewReader(strings.Repeat("m", 100001))), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPut, "/api/upload/"+tt.file, tt.body)
+		sendChunked(req)
+		// A refused body isn't read to its end, so the connection can't be used again
+		if tt.status != http.StatusCreated {
+			req.Header.Set("Connection", "close")
+		}
+		if resp, body := send(t, app, authorized(req)); resp.StatusCode != tt.status {
+			t.Errorf("%s: answered %d: %s", tt.name, resp.StatusCode, body)
+		}
+		content, err := os.ReadFile(filepath.Join("movies", tt.file))
+		if stored := err == nil; stored != (tt.status == http.StatusCreated) || stored && len(content) != 90000 {
+			t.Errorf("%s: stored is %t with %d bytes", tt.name, stored, len(content))
+		}
+	}
+	// Nothing is left of the refused uploads
+	if files, _ := filepath.Glob(filepath.Join("movies", ".upload-*")); len(files) != 0 {
+		t.Errorf("uploads left %v", files)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Matches the error runTool returns instead of running a tool while its breaker is open
+var errToolUnavailable = errors.New("tool is failing repeatedly, not running it for now")
+
+type breakerOpenError struct {
+	tool string
+	wait time.Duration
+}
+
+func (e *breakerOpenError) Error() string {
+	return fmt.Sprintf("%s: %v, retrying in %s", e.tool, errToolUnavailable, e.wait.Round(time.Second))
+}
+
+func (e *breakerOpenError) Is(target error) bool {
+	return target == errToolUnavailable
+}
+
+// Breaker states, also the values of the display_tool_breaker_state metric
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// Stops running a tool that keeps failing, e.g. after a broken upgrade or while the machine
+// is out of memory, so requests fail fast instead of piling up more doomed processes. After
+// the cooldown a single run is let through to test whether the tool recovered.
+type toolBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+}
+
+// One breaker per tool, created by probeTools. A threshold of 0 never opens.
+var toolBreakers = map[string]*toolBreaker{}
+
+// Whether a run may go ahead. In the half-open state only the one trial run is allowed
+// until its outcome is known.
+func (b *toolBreaker) allow() bool {
+	if b == nil || b.threshold == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// Record how a run allowed by allow went
+func (b *toolBreaker) record(tool string, failed bool) {
+	if b == nil || b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		if b.state != breakerClosed {
+			log.Printf("%s works again, closing its circuit breaker", tool)
+		}
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			log.Printf("%s failed %d times in a row, not running it for %s", tool, b.failures, b.cooldown)
+		}
+		b.state, b.openedAt = breakerOpen, time.Now()
+	}
+}
+
+// Time until the breaker lets a trial run through, zero unless it is open
+func (b *toolBreaker) retryAfter() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	return max(0, b.cooldown-time.Since(b.openedAt))
+}
+
+func (b *toolBreaker) currentState() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Whether a failure says the tool itself is in trouble rather than the input being bad:
+// it couldn't start or was killed, ran out of resources, or the shell couldn't run it
+func isToolOutage(err *toolError) bool {
+	if isTransientToolError(err) {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err.err, &exitErr) {
+		return true
+	}
+	return exitErr.ExitCode() == 126 || exitErr.ExitCode() == 127
+}
+
+// Answer a failed request that needed a tool: 503 while its breaker is open or while every
+// ffmpeg slot stayed taken, 504 when the job hit -job-timeout, 507 when the disk filled up,
+// otherwise 500 with the given message
+func toolFailure(c *fiber.Ctx, err error, message string) error {
+	var open *breakerOpenError
+	if errors.As(err, &open) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(1, int(math.Ceil(open.wait.Seconds())))))
+		return c.Status(fiber.StatusServiceUnavailable).SendString(open.tool + " is failing repeatedly, try again later.")
+	}
+	if errors.Is(err, errToolBusy) {
+		c.Set(fiber.HeaderRetryAfter, "30")
+		return c.Status(fiber.StatusServiceUnavailable).SendString("The server is busy with other ffmpeg jobs, try again later.")
+	}
+	if errors.Is(err, errJobTimeout) {
+		return c.Status(fiber.StatusGatewayTimeout).SendString("This took longer than the server allows and was stopped.")
+	}
+	if isDiskFullError(err) {
+		return c.Status(fiber.StatusInsufficientStorage).SendString("The server is out of disk space.")
+	}
+	return c.Status(fiber.StatusInternalServerError).SendString(message)
+}
+
+// Breaker state per tool for /metrics
+func writeBreakerStates(b *strings.Builder) {
+	const name = "display_tool_breaker_state"
+	fmt.Fprintf(b, "# HELP %s Circuit breaker of each external tool: 0 closed, 1 open, 2 half-open.\n# TYPE %s gauge\n", name, name)
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if breaker, ok := toolBreakers[tool]; ok {
+			fmt.Fprintf(b, "%s{tool=%q} %d\n", name, tool, breaker.currentState())
+		}
+	}
+}
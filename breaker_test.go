@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Give ffmpeg a breaker for the duration of the test
+func useBreaker(t *testing.T, threshold int, cooldown time.Duration) *toolBreaker {
+	t.Helper()
+	previous, ok := toolBreakers["ffmpeg"]
+	breaker := &toolBreaker{threshold: threshold, cooldown: cooldown}
+	toolBreakers["ffmpeg"] = breaker
+	t.Cleanup(func() {
+		if ok {
+			toolBreakers["ffmpeg"] = previous
+		} else {
+			delete(toolBreakers, "ffmpeg")
+		}
+	})
+	return breaker
+}
+
+func TestToolBreaker(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "slow.mp4"), []byte(slowStartMP4))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	breaker := useBreaker(t, 2, 100*time.Millisecond)
+	remux := func() *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, "/api/movies/slow/faststart", nil)
+		resp, _ := send(t, app, authorized(req))
+		return resp
+	}
+	metric := func() string {
+		_, body := get(t, app, "/metrics")
+		for _, line := range strings.Split(body, "\n") {
+			if strings.HasPrefix(line, `display_tool_breaker_state{tool="ffmpeg"}`) {
+				return line
+			}
+		}
+		return "missing"
+	}
+
+	// ffmpeg can't even run
+	logs := captureLog(t)
+	runs := scriptTool(t, "ffmpeg", toolRun{stderr: "error while loading shared libraries", exit: 127})
+	for i := 0; i < 2; i++ {
+		if resp := remux(); resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("failure %d answered %d", i+1, resp.StatusCode)
+		}
+	}
+	resp := remux()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" || runs.Load() != 2 {
+		t.Fatalf("open breaker answered %d with Retry-After %q after %d runs", resp.StatusCode, resp.Header.Get("Retry-After"), runs.Load())
+	}
+	if _, err := runTool("rid", "ffmpeg", "-version"); !errors.Is(err, errToolUnavailable) {
+		t.Errorf("runTool with the breaker open gave %v", err)
+	}
+	if line := metric(); line != `display_tool_breaker_state{tool="ffmpeg"} 1` {
+		t.Errorf("open breaker exported as %s", line)
+	}
+	if !strings.Contains(logs.String(), "ffmpeg failed 2 times in a row, not running it for 100ms") {
+		t.Errorf("opening not logged:\n%s", logs)
+	}
+
+	// A failed trial opens it again right away
+	time.Sleep(breaker.cooldown)
+	if resp := remux(); resp.StatusCode != http.StatusInternalServerError || runs.Load() != 3 {
+		t.Fatalf("trial answered %d after %d runs", resp.StatusCode, runs.Load())
+	}
+	if resp := remux(); resp.StatusCode != http.StatusServiceUnavailable || runs.Load() != 3 {
+		t.Fatalf("after a failed trial answered %d after %d runs", resp.StatusCode, runs.Load())
+	}
+
+	// A successful trial closes it
+	time.Sleep(breaker.cooldown)
+	runs = scriptTool(t, "ffmpeg", toolRun{output: faststartMP4})
+	if resp := remux(); resp.StatusCode != http.StatusOK || runs.Load() != 1 {
+		t.Fatalf("successful trial answered %d after %d runs", resp.StatusCode, runs.Load())
+	}
+	if line := metric(); line != `display_tool_breaker_state{tool="ffmpeg"} 0` {
+		t.Errorf("closed breaker exported as %s", line)
+	}
+	if !strings.Contains(logs.String(), "ffmpeg works again, closing its circuit breaker") {
+		t.Errorf("closing not logged:\n%s", logs)
+	}
+}
+
+func TestToolBreakerIgnoresBadInput(t *testing.T) {
+	useBreaker(t, 2, time.Hour)
+	outage := toolRun{exit: 127}
+	badInput := toolRun{stderr: "Invalid data found when processing input", exit: 1}
+
+	// A movie ffmpeg can't read shows it works, so only the outages after it add up
+	scriptTool(t, "ffmpeg", outage, badInput, badInput, badInput, outage)
+	for i := 0; i < 6; i++ {
+		if _, err := runTool("rid", "ffmpeg", "-version"); errors.Is(err, errToolUnavailable) {
+			t.Fatalf("run %d refused", i+1)
+		}
+	}
+	if _, err := runTool("rid", "ffmpeg", "-version"); !errors.Is(err, errToolUnavailable) {
+		t.Errorf("run after two outages in a row gave %v", err)
+	}
+
+	// 0 never opens
+	useBreaker(t, 0, time.Hour)
+	runs := scriptTool(t, "ffmpeg", outage)
+	for i := 0; i < 10; i++ {
+		runTool("rid", "ffmpeg", "-version")
+	}
+	if runs.Load() != 10 {
+		t.Errorf("disabled breaker let %d of 10 runs through", runs.Load())
+	}
+}
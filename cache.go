@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bytes used by generated files as of the last prune, for /metrics
+var cacheBytes atomic.Int64
+
+var cacheMu sync.Mutex
+
+// One evictable item: a cover file, or the directory of a movie's DASH package, sprites or previews
+type cacheEntry struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// Every cached item, sizes included. Temporary files of work in progress are not entries.
+func cacheEntries(cfg *Config) []cacheEntry {
+	var entries []cacheEntry
+	for _, dir := range []string{cfg.CoverDir, cfg.DashDir, cfg.SpriteDir, cfg.PreviewDir} {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil || strings.HasSuffix(file.Name(), ".tmp") {
+				continue
+			}
+			entry := cacheEntry{path: filepath.Join(dir, file.Name()), size: info.Size(), used: info.ModTime()}
+			if file.IsDir() {
+				entry.size = 0
+				filepath.WalkDir(entry.path, func(_ string, d fs.DirEntry, err error) error {
+					if err == nil && !d.IsDir() {
+						if info, err := d.Info(); err == nil {
+							entry.size += info.Size()
+						}
+					}
+					return nil
+				})
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Mark a cached item as used, so eviction keeps it longer
+func touchCache(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// Remove the least recently used items until the cache fits in -cache-size. The most
+// recently used one is always kept, even when it is larger than the limit on its own.
+func pruneCache(cfg *Config) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	entries := cacheEntries(cfg)
+	total := int64(0)
+	for _, entry := range entries {
+		total += entry.size
+	}
+
+	if cfg.CacheSize > 0 && total > cfg.CacheSize {
+		// Newest first, evict from the end
+		sort.Slice(entries, func(i, j int) bool { return entries[i].used.After(entries[j].used) })
+		for i := len(entries) - 1; i > 0 && total > cfg.CacheSize; i-- {
+			log.Printf("Evicting %s (%d bytes) from the cache", entries[i].path, entries[i].size)
+			if err := os.RemoveAll(entries[i].path); err != nil {
+				log.Printf("Could not evict %s: %v", entries[i].path, err)
+				continue
+			}
+			total -= entries[i].size
+		}
+	}
+	cacheBytes.Store(total)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneCache(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := loadConfig([]string{"-cache-dir", dir, "-cache-size", "700"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write 300 bytes to file in the entry, last used age ago
+	add := func(entry, file string, age time.Duration) {
+		writeFile(t, filepath.Join(dir, entry, file), []byte(strings.Repeat("x", 300)))
+		used := time.Now().Add(-age)
+		os.Chtimes(filepath.Join(dir, entry), used, used)
+	}
+	cached := func() string {
+		var paths []string
+		for _, entry := range cacheEntries(cfg) {
+			rel, _ := filepath.Rel(dir, entry.path)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return strings.Join(paths, " ")
+	}
+
+	// Three entries of 300 bytes, the oldest has to go. Work in progress isn't an entry.
+	add("covers/a.jpg", "", 3*time.Hour)
+	add("sprites/b", "sprite.jpg", 2*time.Hour)
+	add("dash/c", "manifest.mpd", time.Hour)
+	add("covers/e.jpg.tmp", "", 4*time.Hour)
+	pruneCache(cfg)
+	if got := cached(); got != "dash/c sprites/b" || cacheBytes.Load() != 600 {
+		t.Errorf("cache holds %q with %d bytes, want dash/c and sprites/b with 600", got, cacheBytes.Load())
+	}
+
+	// A hit makes an entry the newest, so the one left alone goes next
+	touchCache(filepath.Join(dir, "sprites", "b"))
+	add("covers/d.jpg", "", 0)
+	pruneCache(cfg)
+	if got := cached(); got != "covers/d.jpg sprites/b" || cacheBytes.Load() != 600 {
+		t.Errorf("cache holds %q with %d bytes, want covers/d.jpg and sprites/b with 600", got, cacheBytes.Load())
+	}
+
+	// The newest entry stays even when it alone is over the limit
+	cfg.CacheSize = 100
+	pruneCache(cfg)
+	if got := cached(); got != "covers/d.jpg" || cacheBytes.Load() != 300 {
+		t.Errorf("cache holds %q with %d bytes, want only covers/d.jpg", got, cacheBytes.Load())
+	}
+}
+
+func TestCacheDirs(t *testing.T) {
+	app, cfg := newTestServer(t, "-cache-dir", "generated", "-cover-dir", "covers")
+	if cfg.DashDir != filepath.Join("generated", "dash") || cfg.SpriteDir != filepath.Join("generated", "sprites") || cfg.CoverDir != "covers" {
+		t.Errorf("cache directories are %s, %s and %s", cfg.DashDir, cfg.SpriteDir, cfg.CoverDir)
+	}
+
+	// Generated files are found again below -cache-dir
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(testMovie))
+	scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":320,"height":240}],"format":{"duration":"5"}}`})
+	renders := scriptTool(t, "ffmpeg", toolRun{output: "jpeg"})
+	for i := 0; i < 2; i++ {
+		image, _, err := ensureSprite(cfg, "rid", "a", movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(image, filepath.Join("generated", "sprites", "a")+string(filepath.Separator)) {
+			t.Errorf("sprite sheet at %s", image)
+		}
+	}
+	if renders.Load() != 1 {
+		t.Errorf("sprite sheet rendered %d times", renders.Load())
+	}
+	if _, body := get(t, app, "/metrics"); !strings.Contains(body, "\ndisplay_cache_bytes "+fmt.Sprint(cacheBytes.Load())+"\n") || cacheBytes.Load() == 0 {
+		t.Errorf("metrics don't report the %d cached bytes:\n%s", cacheBytes.Load(), body)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Movie directories found unreadable, logged once until they can be read again
+var unreadableRoots sync.Map
+
+// Every movie in the library, one entry per title in the directory and format findMovie
+// would serve it from. A directory that can't be read, like an unmounted drive, is left
+// out; only when none can be read is that an error.
+func listMovies(cfg *Config) ([]MovieEntry, error) {
+	var files []os.DirEntry
+	var lastErr error
+	readable := 0
+	for _, root := range cfg.MoviesDirs {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			if _, logged := unreadableRoots.LoadOrStore(root, true); !logged {
+				log.Printf("Leaving %s out of the catalog, it can't be read: %v", root, err)
+			}
+			lastErr = err
+			continue
+		}
+		if _, logged := unreadableRoots.LoadAndDelete(root); logged {
+			log.Printf("%s can be read again", root)
+		}
+		readable++
+		files = append(files, entries...)
+	}
+	if readable == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	seen := map[string]bool{}
+	movies := []MovieEntry{}
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		movieName := strings.TrimSuffix(file.Name(), ext)
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || !cfg.servesFormat(ext) || seen[movieName] {
+			continue
+		}
+		seen[movieName] = true
+
+		path, found := findMovie(cfg, movieName)
+		if !found {
+			continue
+		}
+		entry, err := movieEntry(cfg, movieName, path)
+		if err != nil {
+			continue
+		}
+		movies = append(movies, entry)
+	}
+
+	// Numbers by their value, so Episode 2 is listed before Episode 10
+	sort.Slice(movies, func(i, j int) bool { return naturalLess(movies[i].Name, movies[j].Name) })
+	return movies, nil
+}
+
+// Extensions asked for with ?format=, either repeated or comma-separated. Returns false
+// when one of them isn't served.
+func formatFilter(c *fiber.Ctx, cfg *Config) (map[string]bool, bool) {
+	var formats map[string]bool
+	for _, value := range c.Context().QueryArgs().PeekMulti("format") {
+		for _, format := range strings.Split(string(value), ",") {
+			format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+			if format == "" {
+				continue
+			}
+			if !cfg.servesFormat(format) {
+				return nil, false
+			}
+			if formats == nil {
+				formats = map[string]bool{}
+			}
+			formats[format] = true
+		}
+	}
+	return formats, true
+}
+
+// List the library, optionally only the movies in some formats
+func moviesHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		formats, ok := formatFilter(c, cfg)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).SendString("Unknown format, expected one of: " + strings.Join(cfg.Tunables().Formats, ", ") + ".")
+		}
+
+		movies, scanning, err := catalog.list(cfg)
+		if err != nil {
+			logRequest(requestID(c), "Could not list movies: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not list movies.")
+		}
+		// An older listing while a rescan runs, clients can poll until the header is gone
+		if scanning {
+			c.Set("X-Catalog-Scanning", "true")
+		}
+
+		// The list stays an array for existing clients, the hint for a fresh install goes in a header
+		if len(movies) == 0 && cfg.EmptyHint != "" {
+			c.Set("X-Empty-Library-Hint", emptyHint(cfg))
+		}
+
+		if formats != nil {
+			filtered := []MovieEntry{}
+			for _, movie := range movies {
+				if formats[movie.Format] {
+					filtered = append(filtered, movie)
+				}
+			}
+			movies = filtered
+		}
+		movies = groupQualities(movies)
+
+		// The answer depends on Prefer, caches must not hand one form to a client asking for the other
+		c.Vary("Prefer")
+		if prefersMinimal(c) {
+			names := make([]minimalMovieEntry, len(movies))
+			for i, movie := range movies {
+				names[i] = minimalMovieEntry{Name: movie.Name}
+			}
+			c.Set("Preference-Applied", "return=minimal")
+			return c.JSON(names)
+		}
+		return c.JSON(movies)
+	}
+}
+
+// List the library again after files were changed by other means, answering how many
+// entries /api/movies now has
+func rescanHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movies, err := catalog.refresh(cfg)
+		if err != nil {
+			logRequest(requestID(c), "Could not rescan the library: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not list movies.")
+		}
+		count := len(groupQualities(movies))
+		logRequest(requestID(c), "Rescanned the library: %d movies", count)
+		return c.JSON(fiber.Map{"movies": count})
+	}
+}
+
+// A catalog entry for clients that only need the names
+type minimalMovieEntry struct {
+	Name string `json:"name"`
+}
+
+// Whether the request sent "Prefer: return=minimal" (RFC 7240), possibly among other
+// preferences or spread over several Prefer headers
+func prefersMinimal(c *fiber.Ctx) bool {
+	for _, header := range c.Context().Request.Header.PeekAll("Prefer") {
+		for _, preference := range strings.Split(string(header), ",") {
+			token, _, _ := strings.Cut(preference, ";")
+			name, value, _ := strings.Cut(token, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "return") && strings.EqualFold(strings.Trim(strings.TrimSpace(value), `"`), "minimal") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether a movie can be played, for front-ends checking a link before showing it. Only
+// resolves the file, and answers 200 either way.
+func movieExistsHandler(cfg *Config) fiber.Handler {
+	type existsResponse struct {
+		Exists      bool    `json:"exists"`
+		ContentType *string `json:"contentType"` // Null when the movie doesn't exist
+	}
+	return func(c *fiber.Ctx) error {
+		movieFilePath, found := findMovie(cfg, c.Params("movie"))
+		if !found {
+			return c.JSON(existsResponse{})
+		}
+		contentType := contentTypes[strings.ToLower(filepath.Ext(movieFilePath))]
+		return c.JSON(existsResponse{Exists: true, ContentType: &contentType})
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Names and formats listed by /api/movies with the given query
+func listFormats(t *testing.T, app *fiber.App, query string) string {
+	t.Helper()
+	resp, body := get(t, app, "/api/movies"+query)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s answered %d: %s", query, resp.StatusCode, body)
+	}
+	var movies []MovieEntry
+	if err := json.Unmarshal([]byte(body), &movies); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	var listed []string
+	for _, movie := range movies {
+		listed = append(listed, movie.Name+"."+movie.Format)
+	}
+	return strings.Join(listed, " ")
+}
+
+func TestMoviesFormatFilter(t *testing.T) {
+	app, _ := newTestServer(t)
+	for _, file := range []string{"b.mp4", "a.mkv", "c.webm", "d.mkv", "d.mp4", "notes.txt"} {
+		writeFile(t, filepath.Join("movies", file), []byte(testMovie))
+	}
+
+	for query, want := range map[string]string{
+		"":                          "a.mkv b.mp4 c.webm d.mp4",
+		"?format=mp4":               "b.mp4 d.mp4",
+		"?format=MKV":               "a.mkv",
+		"?format=mkv,webm":          "a.mkv c.webm",
+		"?format=mkv&format=webm":   "a.mkv c.webm",
+		"?format=avi":               "",
+		"?format=mp4,&format=.webm": "b.mp4 c.webm d.mp4",
+	} {
+		if got := listFormats(t, app, query); got != want {
+			t.Errorf("%q listed %q, want %q", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"?format=txt", "?format=mp4,flv", "?format=mp4&format=../x"} {
+		if resp, body := get(t, app, "/api/movies"+query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q answered %d: %s", query, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMoviesNaturalOrder(t *testing.T) {
+	app, _ := newTestServer(t)
+	for _, file := range []string{"Episode.10.mp4", "episode.3.mp4", "Episode.2.mp4", "Episode.1.mp4", "Alien.10.mp4", "alien.3.mp4", "Alien.mp4", "E1.mp4", "E01.mp4"} {
+		writeFile(t, filepath.Join("movies", file), []byte(testMovie))
+	}
+	want := "Alien.mp4 alien.3.mp4 Alien.10.mp4 E01.mp4 E1.mp4 Episode.1.mp4 Episode.2.mp4 episode.3.mp4 Episode.10.mp4"
+	if got := listFormats(t, app, ""); got != want {
+		t.Errorf("listed %q, want %q", got, want)
+	}
+}
+
+func TestMoviesAddedOutOfBand(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	if got := listFormats(t, app, ""); got != "a.mp4" {
+		t.Fatalf("listed %q", got)
+	}
+
+	// Copied in and removed by hand, no rescan needed
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte(testMovie))
+	if got := listFormats(t, app, ""); got != "a.mp4 b.mkv" {
+		t.Errorf("after adding b.mkv listed %q", got)
+	}
+	os.Remove(filepath.Join("movies", "a.mp4"))
+	if got := listFormats(t, app, ""); got != "b.mkv" {
+		t.Errorf("after removing a.mp4 listed %q", got)
+	}
+}
+
+// List until the catalog no longer reports a scan, failing after a second
+func listFormatsScanned(t *testing.T, app *fiber.App) string {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if resp, _ := get(t, app, "/api/movies"); resp.Header.Get("X-Catalog-Scanning") == "" {
+			return listFormats(t, app, "")
+		}
+	}
+	t.Fatal("the catalog is still scanning")
+	return ""
+}
+
+func TestCatalogStaleWhileRescanning(t *testing.T) {
+	app, _ := newTestServer(t, "-catalog-max-age", "20ms")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	if got := listFormats(t, app, ""); got != "a.mp4" {
+		t.Fatalf("listed %q", got)
+	}
+
+	// Within the age limit the listing is answered as it is
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+	if resp, body := get(t, app, "/api/movies"); resp.Header.Get("X-Catalog-Scanning") != "" || strings.Contains(body, `"b"`) {
+		t.Errorf("a fresh listing answered with X-Catalog-Scanning %q: %s", resp.Header.Get("X-Catalog-Scanning"), body)
+	}
+
+	// Past it, still at once, while a scan replaces it
+	time.Sleep(30 * time.Millisecond)
+	resp, body := get(t, app, "/api/movies")
+	if resp.Header.Get("X-Catalog-Scanning") != "true" || strings.Contains(body, `"b"`) {
+		t.Errorf("an outdated listing answered with X-Catalog-Scanning %q: %s", resp.Header.Get("X-Catalog-Scanning"), body)
+	}
+	if got := listFormatsScanned(t, app); got != "a.mp4 b.mp4" {
+		t.Errorf("listed %q after the scan", got)
+	}
+}
+
+// A slow background scan that lists the library now and finishes when the returned
+// function is called, whatever happened meanwhile
+func slowBackgroundScan(t *testing.T, cfg *Config) func() {
+	t.Helper()
+	catalog.mu.Lock()
+	catalog.scanning = true
+	generation := catalog.generation
+	catalog.mu.Unlock()
+	started := time.Now()
+	movies, err := listMovies(cfg)
+	return func() { catalog.finishRescan(movies, err, started, generation) }
+}
+
+func TestCatalogDropsOutdatedScan(t *testing.T) {
+	app, cfg := newTestServer(t, "-catalog-max-age", "1h")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	listFormats(t, app, "")
+
+	for _, change := range []func(){
+		func() { catalog.refresh(cfg) },
+		catalog.expire,
+	} {
+		finish := slowBackgroundScan(t, cfg)
+		writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+		change()
+		finish()
+		if got := listFormatsScanned(t, app); got != "a.mp4 b.mp4" {
+			t.Errorf("a scan that started before the change replaced the listing: %q", got)
+		}
+		os.Remove(filepath.Join("movies", "b.mp4"))
+		catalog.refresh(cfg)
+	}
+}
+
+func TestCatalogExpiredByRename(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken, "-catalog-max-age", "1h")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	listFormats(t, app, "")
+
+	if resp, body := renameMovie(t, app, "a", "b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	get(t, app, "/api/movies")
+	if got := listFormatsScanned(t, app); got != "b.mp4" {
+		t.Errorf("listed %q after the rename", got)
+	}
+}
+
+func TestMultipleMovieDirs(t *testing.T) {
+	app, _ := newTestServer(t, "-movies-dir", "first,second", "-api-token", testToken)
+	writeFile(t, filepath.Join("first", "a.mp4"), []byte("first a"))
+	writeFile(t, filepath.Join("second", "a.mp4"), []byte("second a"))
+	writeFile(t, filepath.Join("second", "b.mkv"), []byte("second b"))
+	writeFile(t, filepath.Join("second", "b.srt"), []byte(strings.Replace(testSRT, "%s", "Hello", 1)))
+	writeFile(t, filepath.Join("second", "Show", "e01.mp4"), []byte(testMovie))
+
+	// The first directory wins a name, the others fill in the rest
+	for target, want := range map[string]string{"/video/a": "first a", "/video/b": "second b"} {
+		if resp, body := get(t, app, target); resp.StatusCode != http.StatusOK || body != want {
+			t.Errorf("%s answered %d with %q, want %q", target, resp.StatusCode, body, want)
+		}
+	}
+	if resp, body := get(t, app, "/subtitles/b"); resp.StatusCode != http.StatusOK || !strings.Contains(body, "Hello") {
+		t.Errorf("subtitles next to b answered %d: %s", resp.StatusCode, body)
+	}
+
+	resp, body := get(t, app, "/api/movies")
+	var movies []MovieEntry
+	if err := json.Unmarshal([]byte(body), &movies); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("listing answered %d: %s", resp.StatusCode, body)
+	}
+	var listed []string
+	for _, movie := range movies {
+		listed = append(listed, movie.Name+" in "+movie.Library)
+	}
+	if got, want := strings.Join(listed, ", "), "a in first, b in second"; got != want {
+		t.Errorf("listed %s, want %s", got, want)
+	}
+
+	// Folders and renames work in whichever directory has them
+	req, _ := http.NewRequest(http.MethodGet, "/download-folder/Show", nil)
+	if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusOK {
+		t.Errorf("folder in the second directory answered %d: %s", resp.StatusCode, body)
+	}
+	writeFile(t, filepath.Join("outside", "x.mp4"), []byte(testMovie))
+	req, _ = http.NewRequest(http.MethodGet, "/download-folder/..%2Foutside", nil)
+	if resp, _ := send(t, app, authorized(req)); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("folder outside the directories answered %d", resp.StatusCode)
+	}
+	if resp, body := renameMovie(t, app, "b", "c"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	for _, file := range []string{"c.mkv", "c.srt"} {
+		if _, err := os.Stat(filepath.Join("second", file)); err != nil {
+			t.Errorf("%s not renamed in place: %v", file, err)
+		}
+	}
+
+	// A missing directory, like an unmounted drive, makes the server not ready
+	if err := os.RemoveAll("second"); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := get(t, app, "/readyz"); resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "second") {
+		t.Errorf("/readyz answered %d without the second directory: %s", resp.StatusCode, body)
+	}
+}
+
+func TestMoviesPreferMinimal(t *testing.T) {
+	app, _ := newTestServer(t)
+	for _, file := range []string{"b.mp4", "a.mkv"} {
+		writeFile(t, filepath.Join("movies", file), []byte(testMovie))
+	}
+	request := func(target string, prefer ...string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		for _, header := range prefer {
+			req.Header.Add("Prefer", header)
+		}
+		return send(t, app, req)
+	}
+	const minimal = `[{"name":"a"},{"name":"b"}]`
+
+	for _, prefer := range [][]string{
+		{"return=minimal"},
+		{"Return=Minimal"},
+		{`respond-async, return="minimal"; foo=bar`},
+		{"respond-async", "return=minimal"},
+	} {
+		resp, body := request("/api/movies", prefer...)
+		if body != minimal || resp.Header.Get("Preference-Applied") != "return=minimal" || resp.Header.Get("Vary") != "Prefer" {
+			t.Errorf("Prefer %q answered %s with Preference-Applied %q, Vary %q", prefer, body, resp.Header.Get("Preference-Applied"), resp.Header.Get("Vary"))
+		}
+	}
+	if _, body := request("/api/movies?format=mp4", "return=minimal"); body != `[{"name":"b"}]` {
+		t.Errorf("minimal list filtered to mp4: %s", body)
+	}
+
+	// The full entries otherwise
+	for _, prefer := range [][]string{nil, {"return=representation"}, {"respond-async"}} {
+		resp, body := request("/api/movies", prefer...)
+		var movies []MovieEntry
+		if err := json.Unmarshal([]byte(body), &movies); err != nil || len(movies) != 2 || movies[0].Format != "mkv" || movies[1].Size != int64(len(testMovie)) {
+			t.Errorf("Prefer %q answered %s", prefer, body)
+		}
+		if resp.Header.Get("Preference-Applied") != "" || resp.Header.Get("Vary") != "Prefer" {
+			t.Errorf("Prefer %q: Preference-Applied %q, Vary %q", prefer, resp.Header.Get("Preference-Applied"), resp.Header.Get("Vary"))
+		}
+	}
+}
+
+func TestMovieExists(t *testing.T) {
+	app, _ := newTestServer(t, "-formats", "mp4,webm")
+	writeFile(t, filepath.Join("movies", "a.webm"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte(testMovie))
+
+	for movie, want := range map[string]string{
+		"a":       `{"exists":true,"contentType":"video/webm"}`,
+		"missing": `{"exists":false,"contentType":null}`,
+		// Not a format that is served
+		"b":                      `{"exists":false,"contentType":null}`,
+		strings.Repeat("x", 201): `{"exists":false,"contentType":null}`,
+	} {
+		if resp, body := get(t, app, "/api/movies/"+movie+"/exists"); resp.StatusCode != http.StatusOK || body != want {
+			t.Errorf("%.10s answered %d: %s, want %s", movie, resp.StatusCode, body, want)
+		}
+	}
+}
+
+// The listed URLs have to lead back to the movie, whatever its name
+func TestCatalogEscapedNames(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "The Matrix.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "100% Love.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "Top Gun.1080p.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "Top Gun.720p.mp4"), []byte(testMovie))
+
+	_, body := get(t, app, "/api/movies")
+	var movies []MovieEntry
+	if err := json.Unmarshal([]byte(body), &movies); err != nil || len(movies) != 3 {
+		t.Fatalf("listed %d movies: %v: %s", len(movies), err, body)
+	}
+	var targets []string
+	for _, movie := range movies {
+		targets = append(targets, movie.StreamURL, movie.VideoURL)
+		for _, variant := range movie.Qualities {
+			targets = append(targets, variant.StreamURL, variant.VideoURL)
+		}
+	}
+	if len(targets) != 10 {
+		t.Errorf("got %d URLs, want 10: %v", len(targets), targets)
+	}
+	for _, target := range targets {
+		if resp, body := get(t, app, target); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s answered %d: %s", target, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestRescan(t *testing.T) {
+	app, cfg := newTestServer(t, "-api-token", testToken, "-catalog-max-age", "1h")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	listFormats(t, app, "")
+
+	// Copied in by hand, the remembered listing doesn't know it yet
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+	if got := listFormats(t, app, ""); got != "a.mp4" {
+		t.Fatalf("listed %q before the rescan", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/rescan", nil)
+	if resp, _ := send(t, app, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("rescan without a token answered %d", resp.StatusCode)
+	}
+	// A background scan from before the copy is still running and ends after the rescan
+	finish := slowBackgroundScan(t, cfg)
+	writeFile(t, filepath.Join("movies", "c.mp4"), []byte(testMovie))
+	if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusOK || body != `{"movies":3}` {
+		t.Fatalf("rescan answered %d: %s", resp.StatusCode, body)
+	}
+	finish()
+	if resp, body := get(t, app, "/api/movies"); resp.Header.Get("X-Catalog-Scanning") != "" || !strings.Contains(body, `"b"`) || !strings.Contains(body, `"c"`) {
+		t.Errorf("listed after the rescan with X-Catalog-Scanning %q: %s", resp.Header.Get("X-Catalog-Scanning"), body)
+	}
+}
+
+func TestCatalogExpiredByChanges(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, config, []byte(`{"formats": "mp4"}`))
+	app, _ := newTestServer(t, "-config", config, "-api-token", testToken, "-catalog-max-age", "1h")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte(testMovie))
+	listFormats(t, app, "")
+
+	req, _ := http.NewRequest(http.MethodPut, "/api/movies/a/meta", strings.NewReader(`{"rating": 5}`))
+	req.Header.Set("Content-Type", "application/json")
+	if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("meta answered %d: %s", resp.StatusCode, body)
+	}
+	get(t, app, "/api/movies")
+	listFormatsScanned(t, app)
+	if _, body := get(t, app, "/api/movies"); !strings.Contains(body, `"rating":5`) {
+		t.Errorf("the catalog kept the listing from before the metadata: %s", body)
+	}
+
+	// A reload that adds a format lists its movies
+	writeFile(t, config, []byte(`{"formats": "mkv,mp4"}`))
+	req, _ = http.NewRequest(http.MethodPost, "/api/reload", nil)
+	if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("reload answered %d: %s", resp.StatusCode, body)
+	}
+	get(t, app, "/api/movies")
+	if got := listFormatsScanned(t, app); got != "a.mp4 b.mkv" {
+		t.Errorf("listed %q after the reload", got)
+	}
+}
+
+func TestCatalogSkipsUnreadableDirectory(t *testing.T) {
+	app, _ := newTestServer(t, "-movies-dir", "unmounted,movies")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	logged := captureLog(t)
+
+	// The others are listed, the missing one is logged once
+	for i := 0; i < 2; i++ {
+		if got := listFormats(t, app, ""); got != "a.mp4" {
+			t.Errorf("listed %q with a directory missing", got)
+		}
+	}
+	if n := strings.Count(logged.String(), "Leaving unmounted out of the catalog"); n != 1 {
+		t.Errorf("the missing directory was logged %d times:\n%s", n, logged)
+	}
+	if resp, _ := get(t, app, "/readyz"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readyz answered %d with a directory missing", resp.StatusCode)
+	}
+
+	// Back once it can be read
+	writeFile(t, filepath.Join("unmounted", "b.mp4"), []byte(testMovie))
+	if got := listFormats(t, app, ""); got != "a.mp4 b.mp4" || !strings.Contains(logged.String(), "unmounted can be read again") {
+		t.Errorf("listed %q once the directory is back:\n%s", got, logged)
+	}
+
+	app, _ = newTestServer(t, "-movies-dir", "unmounted")
+	if resp, _ := get(t, app, "/api/movies"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("listing without any readable directory answered %d", resp.StatusCode)
+	}
+}
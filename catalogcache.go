@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// The last listing of the library under -catalog-max-age, shared by every /api/movies request
+type catalogCache struct {
+	mu       sync.Mutex
+	movies   []MovieEntry
+	scanned  time.Time
+	scanning bool
+	// Counts refresh and expire calls, so a scan that started before one is dropped
+	generation int
+}
+
+var catalog catalogCache
+
+// The library for /api/movies and whether a scan is refreshing it. Without -catalog-max-age
+// it is listed for every request. With it, a listing older than that is still answered
+// while a single scan in the background replaces it; only the very first request waits.
+func (cc *catalogCache) list(cfg *Config) ([]MovieEntry, bool, error) {
+	if cfg.CatalogMaxAge == 0 {
+		movies, err := listMovies(cfg)
+		return movies, false, err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.movies == nil {
+		// Requests arriving meanwhile wait for this scan instead of starting their own
+		movies, err := listMovies(cfg)
+		if err != nil {
+			return nil, false, err
+		}
+		cc.movies, cc.scanned = movies, time.Now()
+		return movies, false, nil
+	}
+	if !cc.scanning && time.Since(cc.scanned) >= cfg.CatalogMaxAge {
+		cc.scanning = true
+		go cc.rescan(cfg, cc.generation)
+	}
+	return cc.movies, cc.scanning, nil
+}
+
+func (cc *catalogCache) rescan(cfg *Config, generation int) {
+	started := time.Now()
+	movies, err := listMovies(cfg)
+	cc.finishRescan(movies, err, started, generation)
+}
+
+// Keep what a background scan started at generation listed, unless the listing was
+// refreshed or expired meanwhile: then it may be older than the one answered now.
+func (cc *catalogCache) finishRescan(movies []MovieEntry, err error, started time.Time, generation int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.scanning = false
+	if err != nil {
+		// The old listing stays, the next request tries again
+		log.Printf("Could not rescan the library: %v", err)
+		return
+	}
+	if generation != cc.generation {
+		return
+	}
+	cc.movies, cc.scanned = movies, started
+	// Only slow scans are worth a line, this runs every -catalog-max-age while clients poll
+	if took := time.Since(started).Round(time.Millisecond); took >= time.Second {
+		log.Printf("Rescanned the library in %s: %d movies", took, len(movies))
+	}
+}
+
+// List the library right away and answer from that listing from now on, for POST /api/rescan
+func (cc *catalogCache) refresh(cfg *Config) ([]MovieEntry, error) {
+	movies, err := listMovies(cfg)
+	if err != nil || cfg.CatalogMaxAge == 0 {
+		return movies, err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	// A background scan still running started before this one, its listing is dropped
+	cc.generation++
+	cc.movies, cc.scanned = movies, time.Now()
+	return movies, nil
+}
+
+// Have the next request refresh the listing, after the server itself added or renamed a movie
+func (cc *catalogCache) expire() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.scanned = time.Time{}
+	cc.generation++
+}
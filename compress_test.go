@@ -0,0 +1,118 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Fetch target offering the encodings, returning the encoding used and the decoded body
+func fetchEncoded(t *testing.T, app *fiber.App, target, acceptEncoding string) (string, string, int) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	resp, body := send(t, app, req)
+	var reader io.Reader = strings.NewReader(body)
+	switch encoding := resp.Header.Get("Content-Encoding"); encoding {
+	case "br":
+		reader = brotli.NewReader(reader)
+	case "gzip":
+		r, err := gzip.NewReader(reader)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		reader = r
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("%s: %v", target, err)
+	}
+	return resp.Header.Get("Content-Encoding"), string(decoded), len(body)
+}
+
+func TestCompression(t *testing.T) {
+	sizes := map[string]int{}
+	for _, level := range []string{"speed", "default", "best", "off"} {
+		app, _ := newTestServer(t, "-compression", level)
+		for i := 0; i < 20; i++ {
+			writeFile(t, filepath.Join("movies", fmt.Sprintf("movie%02d.mp4", i)), []byte(testMovie))
+		}
+		writeFile(t, filepath.Join("movies", "notes.srt"), []byte(strings.Replace(testSRT, "%s", strings.Repeat("Subtitle text. ", 50), 1)))
+		_, plain, _ := fetchEncoded(t, app, "/api/movies", "")
+
+		for _, tt := range []struct {
+			acceptEncoding, want string
+		}{
+			{"br, gzip", "br"},
+			{"gzip, br", "br"},
+			{"gzip", "gzip"},
+			{"identity", ""},
+		} {
+			if level == "off" {
+				tt.want = ""
+			}
+			encoding, body, size := fetchEncoded(t, app, "/api/movies", tt.acceptEncoding)
+			if encoding != tt.want || body != plain {
+				t.Errorf("-compression %s, %q: encoded as %q, decoded to the same JSON %t", level, tt.acceptEncoding, encoding, body == plain)
+			}
+			if tt.want == "br" {
+				sizes[level] = size
+			}
+		}
+		if encoding, body, _ := fetchEncoded(t, app, "/subtitles/notes", "br"); encoding != map[bool]string{true: "", false: "br"}[level == "off"] || !strings.HasPrefix(body, "WEBVTT") {
+			t.Errorf("-compression %s: subtitles encoded as %q: %.20q", level, encoding, body)
+		}
+
+		// Video and ranges keep their bytes as they are
+		for _, rangeHeader := range []string{"", "bytes=0-"} {
+			req, _ := http.NewRequest(http.MethodGet, "/video/movie01", nil)
+			req.Header.Set("Accept-Encoding", "br, gzip")
+			if rangeHeader != "" {
+				req.Header.Set("Range", rangeHeader)
+			}
+			if resp, body := send(t, app, req); resp.Header.Get("Content-Encoding") != "" || body != testMovie {
+				t.Errorf("-compression %s: video with Range %q encoded as %q", level, rangeHeader, resp.Header.Get("Content-Encoding"))
+			}
+		}
+	}
+	if sizes["best"] > sizes["speed"] {
+		t.Errorf("best compressed the catalog to %d bytes, speed to %d", sizes["best"], sizes["speed"])
+	}
+
+	if _, err := loadConfig([]string{"-compression", "max"}); err == nil {
+		t.Error("unknown -compression accepted")
+	}
+}
+
+func TestCompressSkip(t *testing.T) {
+	app, _ := newTestServer(t, "-compress-skip", "/api/movies, /subtitles/*,/stream")
+	for i := 0; i < 20; i++ {
+		writeFile(t, filepath.Join("movies", fmt.Sprintf("movie%02d.mp4", i)), []byte(testMovie))
+	}
+	writeFile(t, filepath.Join("movies", "notes.srt"), []byte(strings.Replace(testSRT, "%s", strings.Repeat("Subtitle text. ", 50), 1)))
+
+	// An exact path doesn't match what starts with it
+	for target, want := range map[string]string{
+		"/api/movies":      "",
+		"/subtitles/notes": "",
+		"/stream/movie01":  "gzip",
+		"/metrics":         "gzip",
+	} {
+		if encoding, _, _ := fetchEncoded(t, app, target, "gzip"); encoding != want {
+			t.Errorf("%s encoded as %q, want %q", target, encoding, want)
+		}
+	}
+
+	for _, pattern := range []string{"api/movies", "/api/*/x", "/api/mov?es", "/api/a b"} {
+		if _, err := loadConfig([]string{"-compress-skip", pattern}); err == nil {
+			t.Errorf("-compress-skip %q accepted", pattern)
+		}
+	}
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Values of -compression
+var compressionLevels = map[string]compress.Level{
+	"off":     compress.LevelDisabled,
+	"speed":   compress.LevelBestSpeed,
+	"default": compress.LevelDefault,
+	"best":    compress.LevelBestCompression,
+}
+
+// Runtime configuration, filled from command line flags and the optional -config file
+type Config struct {
+	// JSON file with flag values, re-read by /api/reload
+	ConfigFile string
+
+	// Directories holding the movies, searched in order so the first one wins a name
+	MoviesDirs []string
+
+	// Subtitle languages shown by default when the viewer's own preferences have no match
+	SubtitleLanguages []string
+
+	// Encoding of subtitle files that are neither UTF-8 nor recognized as another encoding
+	SubtitleEncoding encoding.Encoding
+
+	// Words that end a movie's display title, like 1080p or x264, matched as whole words
+	TitleTags *regexp.Regexp
+
+	// Fall back to matching movie names without regard to case when there is no exact match
+	CaseInsensitive bool
+
+	// Say so when a movie exists but in a format that isn't served, instead of a plain 404
+	ExplainUnsupported bool
+
+	// TCP address to listen on, unless a Unix socket is given
+	Listen     string
+	UnixSocket string
+
+	// Peers whose X-Forwarded-For names the client, like a reverse proxy on another host
+	TrustedProxies []netip.Prefix
+
+	// Clients let in, all when empty, and clients refused even when allowed
+	AllowIPs []netip.Prefix
+	DenyIPs  []netip.Prefix
+
+	// Serve HTTPS with these settings, nil for plain HTTP
+	TLS *tls.Config
+
+	// Path / redirects to, e.g. a single movie's player for a kiosk; empty leaves / to the
+	// getting-started page of an empty library
+	RootRedirect string
+	// Hint shown at / and with /api/movies while the library is empty, "" for none
+	EmptyHint string
+
+	// nginx location to hand video files to with X-Accel-Redirect, empty to send them ourselves
+	AccelRedirect string
+
+	// HTTP server whose files /remote/ relays, ranges included; nil leaves /remote/ unrouted
+	RemoteURL *url.URL
+
+	// Reuse connections between requests, closing idle ones after IdleTimeout
+	KeepAlive   bool
+	IdleTimeout time.Duration
+
+	// How hard text responses are compressed, with Brotli or gzip depending on the client
+	Compression compress.Level
+
+	// Paths never compressed on top of the media routes: exact ones, and prefixes given
+	// with a trailing *
+	CompressSkip         map[string]bool
+	CompressSkipPrefixes []string
+
+	// Log lines kept in memory for /api/logs/stream
+	LogBuffer int
+
+	// Log a salted hash instead of client IPs
+	NoIPLog bool
+
+	// Bearer token for endpoints that change the library, empty disables them
+	APIToken string
+
+	// Go's profiler at /debug/pprof, behind the API token
+	Pprof bool
+
+	// Refuse library changes while the library is being reorganized, toggled at runtime
+	// through /api/read-only
+	readOnly atomic.Bool
+
+	// Largest accepted upload in bytes
+	MaxUploadSize int64
+
+	// Poster fallback: "builtin", "none" or a path to an image file
+	Placeholder string
+
+	// State kept across restarts, like favorites
+	DataDir string
+
+	// Shortest time between two writes of one movie's heartbeat progress
+	ProgressWriteInterval time.Duration
+
+	// Generated files (covers, DASH packages, sprites, previews) live below CacheDir unless their own
+	// directory is given, and are evicted least recently used first beyond CacheSize bytes
+	CacheDir  string
+	CacheSize int64
+
+	// Stop running ffmpeg or ffprobe for ToolBreakerCooldown after this many consecutive
+	// failures that aren't the input's fault, 0 to always run them
+	ToolBreakerFailures int
+	ToolBreakerCooldown time.Duration
+
+	// Longest an ffmpeg job that processes a whole movie (DASH, sprites, faststart) may run
+	JobTimeout time.Duration
+	// How many ffmpeg processes may run at once, 0 for no limit
+	MaxFFmpegJobs int
+	// How long a job waits for one of those before the request gets 503, 0 for no limit
+	FFmpegQueueTimeout time.Duration
+
+	// Cache for cover art extracted from the movie files
+	CoverDir string
+
+	// Formats whose ranges are served as asked for by SendFile, skipping the prefetch windows
+	NativeRangeFormats map[string]bool
+
+	// Whole-file downloads at least this large are sent with sendfile instead of the
+	// streaming loop
+	SendFileMinSize int64
+
+	// How long /api/movies answers from its last listing before a background scan refreshes
+	// it, 0 to list the library for every request
+	CatalogMaxAge time.Duration
+
+	// Size of the buffer the streaming loop reads the file into and sends from
+	ReadBufferBytes int
+
+	// Bytes from the start of each movie kept in memory, 0 to keep none, and the most
+	// kept for all movies together
+	HeaderCacheBytes int64
+	HeaderCacheTotal int64
+
+	// Close streams whose client accepted nothing for this long, 0 to keep them
+	StreamIdleTimeout time.Duration
+
+	// How long a range past the end of the file waits for it to grow, for watching files
+	// that are still being written. 0 answers such ranges right away.
+	GrowingWait time.Duration
+
+	// DASH packaging with ffmpeg, cached per movie
+	Dash          bool
+	DashDir       string
+	DashCacheSize int
+
+	// Serve a web app manifest and service worker so the player can be installed
+	PWA bool
+
+	// Short low-bitrate clips of the start of each movie, for previews while browsing
+	PreviewDir      string
+	PreviewDuration time.Duration
+	PreviewHeight   int
+
+	// Thumbnail sprite sheets for seek bar previews, one tile every SpriteInterval
+	SpriteDir      string
+	SpriteInterval time.Duration
+	SpriteWidth    int
+
+	// Settings that can change while running, read them through Tunables()
+	mu       sync.RWMutex
+	tunables Tunables
+
+	// Effective value of every flag, to tell what a reload changed
+	values map[string]string
+}
+
+// Settings that /api/reload applies without a restart
+type Tunables struct {
+	// Movie extensions served, in order of preference when a title exists in several
+	Formats []string
+
+	// Bytes sent per range response, and optionally a different amount for the first one
+	PrefetchBytes int64
+	StartWindow   int64
+
+	// Bytes sent per range response to clients asking to save data, 0 to ignore Save-Data
+	SaveDataBytes int64
+
+	// Concurrent video streams allowed, 0 for no limit
+	MaxStreams int
+
+	// Concurrent streams and folder downloads allowed per client address, 0 for no limit
+	MaxStreamsPerIP int
+
+	// Paths left out of the access log, like health checks polled by monitoring
+	LogSkip map[string]bool
+
+	// Extra headers sent with every response, e.g. for security policies or a CDN
+	Headers []customHeader
+
+	// Origins whose pages may use the server, and overrides for /api/ and the media routes
+	CORSOrigins      []string
+	CORSAPIOrigins   []string
+	CORSMediaOrigins []string
+}
+
+// Flags whose Tunables field is swapped in place on reload
+var reloadableFlags = map[string]bool{
+	"formats":            true,
+	"prefetch-bytes":     true,
+	"start-window":       true,
+	"save-data-bytes":    true,
+	"max-streams":        true,
+	"max-streams-per-ip": true,
+	"log-skip":           true,
+	"headers":            true,
+	"cors-origins":       true,
+	"cors-api-origins":   true,
+	"cors-media-origins": true,
+}
+
+func parseConfig() *Config {
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// Build the configuration from command line arguments and the -config file.
+// Flags given on the command line win over the file.
+func loadConfig(args []string) (*Config, error) {
+	cfg := &Config{}
+	t := &cfg.tunables
+	var moviesDirs, formats, logSkip, compression, nativeRangeFormats, subtitleLanguages, subtitleEncoding string
+	var tlsCert, tlsKey, tlsMinVersion, tlsCiphers string
+	var corsOrigins, corsAPIOrigins, corsMediaOrigins, compressSkip string
+	var trustedProxies, allowIPs, denyIPs, titleTags, remoteURL string
+	var readOnly bool
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.StringVar(&cfg.ConfigFile, "config", "", "JSON file with flag values, keyed by flag name; re-read by POST /api/reload")
+	flags.StringVar(&moviesDirs, "movies-dir", "movies", "comma-separated movie directories, e.g. one per drive; earlier ones win when a name exists twice")
+	flags.StringVar(&subtitleLanguages, "subtitle-languages", "", "comma-separated subtitle languages to show by default when the browser's languages have none, e.g. en,es")
+	flags.StringVar(&subtitleEncoding, "subtitle-encoding", "windows-1252", "encoding of subtitle files that are not UTF-8 and don't look like Shift-JIS or Windows-1251, e.g. windows-1250 or gbk; any other than windows-1252 also replaces the Windows-1251 guess")
+	flags.StringVar(&titleTags, "title-tags", defaultTitleTags, "comma-separated regular expressions for release tags that end a movie's display title, matched against whole words ignoring case")
+	flags.StringVar(&cfg.RootRedirect, "root-redirect", "", "local path to redirect / to, e.g. /stream/Movie or /api/movies")
+	flags.StringVar(&cfg.EmptyHint, "empty-hint", defaultEmptyHint, "getting-started hint shown at / and in the X-Empty-Library-Hint header of /api/movies while the library is empty, {dirs} and {formats} filled in (\"\" for none)")
+	flags.StringVar(&cfg.AccelRedirect, "accel-redirect", "", "internal nginx location, e.g. /internal-movies/, to hand video files to with X-Accel-Redirect instead of sending them")
+	flags.StringVar(&remoteURL, "remote-url", "", "base URL of an HTTP server whose files are relayed at /remote/, e.g. https://media.example.com/movies/")
+	flags.BoolVar(&cfg.CaseInsensitive, "case-insensitive", false, "find movies whose file name differs from the requested one only in case")
+	flags.BoolVar(&cfg.ExplainUnsupported, "explain-unsupported", true, "answer 415 naming the file when a movie only exists in a format that isn't served")
+	flags.StringVar(&cfg.Listen, "listen", "0.0.0.0:3000", "TCP address to listen on")
+	flags.StringVar(&cfg.UnixSocket, "unix-socket", "", "listen on this Unix socket instead of -listen, e.g. behind nginx")
+	flags.StringVar(&trustedProxies, "trusted-proxies", "", "comma-separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For is believed")
+	flags.StringVar(&allowIPs, "allow-ips", "", "comma-separated addresses or CIDR ranges of the only clients let in, e.g. 192.168.1.0/24 (empty lets everyone in)")
+	flags.StringVar(&denyIPs, "deny-ips", "", "comma-separated addresses or CIDR ranges of clients refused with 403, even when -allow-ips matches")
+	flags.StringVar(&corsOrigins, "cors-origins", "", "comma-separated origins whose pages may call the server, or * for any (empty sends no CORS headers)")
+	flags.StringVar(&corsAPIOrigins, "cors-api-origins", "", "origins allowed for /api/ routes instead of -cors-origins")
+	flags.StringVar(&corsMediaOrigins, "cors-media-origins", "", "origins allowed for video, subtitle, poster and other media routes instead of -cors-origins")
+	flags.StringVar(&tlsCert, "tls-cert", "", "PEM certificate (chain) to serve HTTPS with, together with -tls-key")
+	flags.StringVar(&tlsKey, "tls-key", "", "PEM private key of -tls-cert")
+	flags.StringVar(&tlsMinVersion, "tls-min-version", "1.2", "oldest TLS version accepted: 1.2 or 1.3")
+	flags.StringVar(&tlsCiphers, "tls-ciphers", "", "comma-separated TLS 1.2 cipher suites to allow, by Go name (empty for Go's defaults)")
+	flags.BoolVar(&cfg.KeepAlive, "keep-alive", true, "reuse connections for further requests, which players make many of while seeking")
+	flags.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "close kept-alive connections idle between requests for this long (0 for no limit)")
+	flags.StringVar(&formats, "formats", "mp4,webm,mkv,avi", "comma-separated movie extensions to serve, most preferred first")
+	flags.Var(headerFlag{&t.Headers}, "headers", `extra response header as "Name: Value", repeat for several`)
+	flags.StringVar(&compression, "compression", "default", `compression of text responses: "speed", "default", "best" or "off"`)
+	flags.IntVar(&cfg.LogBuffer, "log-buffer", 1000, "recent log lines kept in memory for /api/logs/stream")
+	flags.BoolVar(&cfg.NoIPLog, "no-ip-log", false, "log a salted hash instead of client IP addresses")
+	flags.StringVar(&compressSkip, "compress-skip", "", "comma-separated paths never compressed, a trailing * matches every path starting with the rest, e.g. /api/export/*")
+	flags.StringVar(&logSkip, "log-skip", "/healthz,/readyz,/metrics", "comma-separated paths left out of the access log (empty logs everything)")
+	flags.StringVar(&cfg.APIToken, "api-token", "", "bearer token required to rename movies and other library changes (empty disables them)")
+	flags.BoolVar(&cfg.Pprof, "pprof", false, "serve CPU and memory profiles at /debug/pprof for anyone with -api-token")
+	flags.BoolVar(&readOnly, "read-only", false, "start in read-only mode, refusing uploads, renames and other library changes")
+	flags.Int64Var(&cfg.MaxUploadSize, "max-upload-size", 8<<30, "largest accepted upload in bytes")
+	flags.StringVar(&cfg.Placeholder, "placeholder", "builtin", `poster fallback image: "builtin", "none" to disable, or a path to an image`)
+	flags.StringVar(&cfg.DataDir, "data-dir", "data", "directory for state kept across restarts, like favorites")
+	flags.DurationVar(&cfg.ProgressWriteInterval, "progress-write-interval", 10*time.Second, "write a movie's progress from heartbeats at most this often")
+	flags.StringVar(&cfg.CacheDir, "cache-dir", "cache", "directory for generated files like covers, DASH packages, sprite sheets and previews")
+	flags.Int64Var(&cfg.CacheSize, "cache-size", 20<<30, "bytes of generated files kept before the least recently used are removed (0 for no limit)")
+	flags.StringVar(&cfg.CoverDir, "cover-dir", "", "directory for cover art extracted from movie files (default <cache-dir>/covers)")
+	flags.Int64Var(&t.PrefetchBytes, "prefetch-bytes", 2*1024*1024, "bytes sent per video range response")
+	flags.Int64Var(&t.StartWindow, "start-window", 0, "bytes sent for a range starting at byte 0, to get playback going sooner (0 uses -prefetch-bytes)")
+	flags.Int64Var(&t.SaveDataBytes, "save-data-bytes", 256*1024, "bytes sent per video range response to clients sending Save-Data: on (0 to ignore the hint)")
+	flags.IntVar(&t.MaxStreams, "max-streams", 0, "maximum concurrent video streams, each holding an open file (0 for no limit)")
+	flags.IntVar(&t.MaxStreamsPerIP, "max-streams-per-ip", 5, "maximum concurrent video streams and folder downloads per client address, answered with 429 beyond it (0 for no limit)")
+	flags.StringVar(&nativeRangeFormats, "native-range-formats", "", "comma-separated extensions whose ranges are served exactly as requested, without -prefetch-bytes windows")
+	flags.DurationVar(&cfg.CatalogMaxAge, "catalog-max-age", 0, "answer /api/movies from a listing up to this old and refresh older ones in the background, for large libraries (0 lists the library for every request)")
+	flags.Int64Var(&cfg.SendFileMinSize, "sendfile-min-size", 64<<20, "files at least this many bytes are sent with sendfile when requested without a range (0 for all)")
+	flags.IntVar(&cfg.ReadBufferBytes, "read-buffer-bytes", 6144, "bytes the streaming loop reads and sends at a time; buffers are reused across streams")
+	flags.DurationVar(&cfg.StreamIdleTimeout, "stream-idle-timeout", 5*time.Minute, "close video streams that made no progress for this long (0 to disable)")
+	flags.Int64Var(&cfg.HeaderCacheBytes, "header-cache-bytes", 0, "bytes from the start of each movie kept in memory for faster playback starts (0 to disable)")
+	flags.Int64Var(&cfg.HeaderCacheTotal, "header-cache-total", 64<<20, "most bytes -header-cache-bytes keeps in memory for all movies together")
+	flags.DurationVar(&cfg.GrowingWait, "growing-wait", 0, "wait up to this long for a file that is still being written to reach a requested range (0 to disable)")
+	flags.IntVar(&cfg.ToolBreakerFailures, "tool-breaker-failures", 5, "consecutive ffmpeg or ffprobe failures before it is left alone for -tool-breaker-cooldown (0 to disable)")
+	flags.DurationVar(&cfg.ToolBreakerCooldown, "tool-breaker-cooldown", 30*time.Second, "how long ffmpeg or ffprobe requests are answered with 503 after repeated failures")
+	flags.IntVar(&cfg.MaxFFmpegJobs, "max-ffmpeg-jobs", 2, "maximum ffmpeg processes running at once, later jobs wait for one to finish (0 for no limit)")
+	flags.DurationVar(&cfg.FFmpegQueueTimeout, "ffmpeg-queue-timeout", time.Minute, "how long an ffmpeg job waits for one of -max-ffmpeg-jobs to finish before the request gets 503 (0 to wait as long as it takes)")
+	flags.DurationVar(&cfg.JobTimeout, "job-timeout", 30*time.Minute, "kill ffmpeg jobs over a whole movie (DASH, thumbnails, faststart) that run longer than this (0 for no limit)")
+	flags.BoolVar(&cfg.Dash, "dash", true, "offer DASH playback when ffmpeg is installed")
+	flags.StringVar(&cfg.DashDir, "dash-dir", "", "directory for packaged DASH segments (default <cache-dir>/dash)")
+	flags.IntVar(&cfg.DashCacheSize, "dash-cache-size", 5, "number of packaged movies kept before the least recently used is removed")
+	flags.BoolVar(&cfg.PWA, "pwa", true, "serve a web app manifest and service worker so the player can be installed on phones")
+	flags.StringVar(&cfg.PreviewDir, "preview-dir", "", "directory for preview clips (default <cache-dir>/previews)")
+	flags.DurationVar(&cfg.PreviewDuration, "preview-duration", 10*time.Second, "length of the preview clip taken from the start of each movie")
+	flags.IntVar(&cfg.PreviewHeight, "preview-height", 240, "height of preview clips in pixels, the width follows the video")
+	flags.StringVar(&cfg.SpriteDir, "sprite-dir", "", "directory for thumbnail sprite sheets (default <cache-dir>/sprites)")
+	flags.DurationVar(&cfg.SpriteInterval, "sprite-interval", 10*time.Second, "time between the thumbnails of a sprite sheet")
+	flags.IntVar(&cfg.SpriteWidth, "sprite-width", 160, "width of each thumbnail in pixels, the height follows the video")
+	flags.Parse(args)
+
+	if cfg.ConfigFile != "" {
+		if err := applyConfigFile(flags, cfg.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dir := range strings.Split(moviesDirs, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			cfg.MoviesDirs = append(cfg.MoviesDirs, filepath.Clean(dir))
+		}
+	}
+	if len(cfg.MoviesDirs) == 0 {
+		return nil, errors.New("-movies-dir needs at least one directory")
+	}
+
+	for _, format := range strings.Split(formats, ",") {
+		format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+		if _, ok := contentTypes["."+format]; !ok {
+			return nil, fmt.Errorf("unknown format %q in -formats", format)
+		}
+		t.Formats = append(t.Formats, format)
+	}
+
+	level, ok := compressionLevels[compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown -compression %q, expected speed, default, best or off", compression)
+	}
+	cfg.Compression = level
+
+	for _, tag := range strings.Split(subtitleLanguages, ",") {
+		if tag = strings.TrimSpace(tag); tag == "" {
+			continue
+		}
+		if !languageTag.MatchString(tag) {
+			return nil, fmt.Errorf("invalid language %q in -subtitle-languages", tag)
+		}
+		cfg.SubtitleLanguages = append(cfg.SubtitleLanguages, normalizeLanguage(tag))
+	}
+	if cfg.SubtitleEncoding, _ = htmlindex.Get(subtitleEncoding); cfg.SubtitleEncoding == nil {
+		return nil, fmt.Errorf("unknown -subtitle-encoding %q, expected e.g. windows-1251 or shift_jis", subtitleEncoding)
+	}
+
+	var err error
+	if cfg.TitleTags, err = parseTitleTags(titleTags); err != nil {
+		return nil, err
+	}
+
+	cfg.NativeRangeFormats = map[string]bool{}
+	for _, format := range strings.Split(nativeRangeFormats, ",") {
+		format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+		if format == "" {
+			continue
+		}
+		if _, ok := contentTypes["."+format]; !ok {
+			return nil, fmt.Errorf("unknown format %q in -native-range-formats", format)
+		}
+		cfg.NativeRangeFormats[format] = true
+	}
+
+	cfg.CompressSkip = map[string]bool{}
+	for _, pattern := range strings.Split(compressSkip, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if !strings.HasPrefix(pattern, "/") || strings.ContainsAny(prefix, "*? ") {
+			return nil, fmt.Errorf("invalid path %q in -compress-skip, expected e.g. /api/report or /api/export/*", pattern)
+		}
+		if isPrefix {
+			cfg.CompressSkipPrefixes = append(cfg.CompressSkipPrefixes, prefix)
+		} else {
+			cfg.CompressSkip[pattern] = true
+		}
+	}
+
+	t.LogSkip = map[string]bool{}
+	for _, path := range strings.Split(logSkip, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			t.LogSkip[path] = true
+		}
+	}
+
+	if t.PrefetchBytes <= 0 || t.StartWindow < 0 || t.SaveDataBytes < 0 {
+		return nil, errors.New("-prefetch-bytes must be positive and -start-window and -save-data-bytes not negative")
+	}
+	if cfg.MaxUploadSize <= 0 {
+		return nil, errors.New("-max-upload-size must be positive")
+	}
+	if t.MaxStreams < 0 || t.MaxStreamsPerIP < 0 || cfg.StreamIdleTimeout < 0 {
+		return nil, errors.New("-max-streams, -max-streams-per-ip and -stream-idle-timeout must not be negative")
+	}
+	if cfg.ProgressWriteInterval <= 0 {
+		return nil, errors.New("-progress-write-interval must be positive")
+	}
+	if cfg.HeaderCacheBytes < 0 || cfg.HeaderCacheTotal < cfg.HeaderCacheBytes {
+		return nil, errors.New("-header-cache-bytes must not be negative or more than -header-cache-total")
+	}
+	if cfg.GrowingWait < 0 {
+		return nil, errors.New("-growing-wait must not be negative")
+	}
+	if cfg.CatalogMaxAge < 0 {
+		return nil, errors.New("-catalog-max-age must not be negative")
+	}
+	if cfg.SendFileMinSize < 0 {
+		return nil, errors.New("-sendfile-min-size must not be negative")
+	}
+	if cfg.ReadBufferBytes < 1 || cfg.ReadBufferBytes > 16<<20 {
+		return nil, errors.New("-read-buffer-bytes must be between 1 and 16777216")
+	}
+	if cfg.LogBuffer < 1 {
+		return nil, errors.New("-log-buffer must be at least 1")
+	}
+	if cfg.IdleTimeout < 0 {
+		return nil, errors.New("-idle-timeout must not be negative")
+	}
+	if cfg.TrustedProxies, err = parseCIDRs("trusted-proxies", trustedProxies); err != nil {
+		return nil, err
+	}
+	if cfg.AllowIPs, err = parseCIDRs("allow-ips", allowIPs); err != nil {
+		return nil, err
+	}
+	if cfg.DenyIPs, err = parseCIDRs("deny-ips", denyIPs); err != nil {
+		return nil, err
+	}
+	if t.CORSOrigins, err = parseOrigins("cors-origins", corsOrigins); err != nil {
+		return nil, err
+	}
+	if t.CORSAPIOrigins, err = parseOrigins("cors-api-origins", corsAPIOrigins); err != nil {
+		return nil, err
+	}
+	if t.CORSMediaOrigins, err = parseOrigins("cors-media-origins", corsMediaOrigins); err != nil {
+		return nil, err
+	}
+	// Without a policy of their own, both groups follow the general one
+	if t.CORSAPIOrigins == nil {
+		t.CORSAPIOrigins = t.CORSOrigins
+	}
+	if t.CORSMediaOrigins == nil {
+		t.CORSMediaOrigins = t.CORSOrigins
+	}
+	if tlsCert != "" || tlsKey != "" {
+		if cfg.TLS, err = buildTLSConfig(tlsCert, tlsKey, tlsMinVersion, tlsCiphers); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RootRedirect != "" && !localPath(cfg.RootRedirect) {
+		return nil, errors.New("-root-redirect must be a path on this server, e.g. /stream/Movie")
+	}
+	// It is sent in a header as well
+	if strings.ContainsAny(cfg.EmptyHint, "\r\n") {
+		return nil, errors.New("-empty-hint must be a single line")
+	}
+	if cfg.AccelRedirect != "" && !strings.HasPrefix(cfg.AccelRedirect, "/") {
+		return nil, errors.New("-accel-redirect must be a location path starting with /")
+	}
+	if remoteURL != "" {
+		if cfg.RemoteURL, err = url.Parse(remoteURL); err != nil || (cfg.RemoteURL.Scheme != "http" && cfg.RemoteURL.Scheme != "https") || cfg.RemoteURL.Host == "" {
+			return nil, errors.New("-remote-url must be an http or https URL, e.g. https://media.example.com/movies/")
+		}
+	}
+	// Profiles show paths, request details and memory contents, never without a token
+	if cfg.Pprof && cfg.APIToken == "" {
+		return nil, errors.New("-pprof needs -api-token")
+	}
+	if cfg.JobTimeout < 0 || cfg.MaxFFmpegJobs < 0 || cfg.FFmpegQueueTimeout < 0 {
+		return nil, errors.New("-job-timeout, -max-ffmpeg-jobs and -ffmpeg-queue-timeout must not be negative")
+	}
+	if cfg.ToolBreakerFailures < 0 || cfg.ToolBreakerCooldown <= 0 {
+		return nil, errors.New("-tool-breaker-failures must not be negative and -tool-breaker-cooldown must be positive")
+	}
+	if cfg.CacheSize < 0 {
+		return nil, errors.New("-cache-size must not be negative")
+	}
+	for dir, name := range map[*string]string{&cfg.CoverDir: "covers", &cfg.DashDir: "dash", &cfg.SpriteDir: "sprites", &cfg.PreviewDir: "previews"} {
+		if *dir == "" {
+			*dir = filepath.Join(cfg.CacheDir, name)
+		}
+	}
+	if cfg.DashCacheSize < 1 {
+		return nil, errors.New("-dash-cache-size must be at least 1")
+	}
+
+	if cfg.PreviewDuration < time.Second || cfg.PreviewDuration > 5*time.Minute || cfg.PreviewHeight < 16 || cfg.PreviewHeight > 1080 {
+		return nil, errors.New("-preview-duration must be between 1s and 5m and -preview-height between 16 and 1080")
+	}
+	if cfg.SpriteInterval < time.Second || cfg.SpriteWidth < 16 || cfg.SpriteWidth > 1920 {
+		return nil, errors.New("-sprite-interval must be at least 1s and -sprite-width between 16 and 1920")
+	}
+
+	// Fail early on a custom placeholder that can't be served
+	if cfg.Placeholder != "builtin" && cfg.Placeholder != "none" {
+		if _, err := os.Stat(cfg.Placeholder); err != nil {
+			return nil, fmt.Errorf("placeholder image: %w", err)
+		}
+	}
+
+	cfg.readOnly.Store(readOnly)
+
+	cfg.values = map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) { cfg.values[f.Name] = f.Value.String() })
+	return cfg, nil
+}
+
+// Set flags from a JSON object like {"formats": "mp4,mkv", "max-streams": 10},
+// skipping those already given on the command line
+func applyConfigFile(flags *flag.FlagSet, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	var values map[string]any
+	if err := json.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for name, value := range values {
+		if flags.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("config file %s: unknown setting %q", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case bool:
+			s = strconv.FormatBool(v)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		case []any:
+			// Flags that can be repeated take each item on its own
+			if _, ok := flags.Lookup(name).Value.(interface{ repeatable() }); ok {
+				for _, part := range v {
+					if err := flags.Set(name, fmt.Sprint(part)); err != nil {
+						return fmt.Errorf("config file %s: %q: %w", path, name, err)
+					}
+				}
+				continue
+			}
+
+			// Lists like "formats" may be written as JSON arrays
+			parts := make([]string, len(v))
+			for i, part := range v {
+				parts[i] = fmt.Sprint(part)
+			}
+			s = strings.Join(parts, ",")
+		default:
+			return fmt.Errorf("config file %s: unsupported value for %q", path, name)
+		}
+		if err := flags.Set(name, s); err != nil {
+			return fmt.Errorf("config file %s: %q: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// Current values of the settings that can change while running
+func (cfg *Config) Tunables() Tunables {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	return cfg.tunables
+}
+
+// Apply the reloadable settings of next, returning the names of the settings that changed
+// and of those that changed but only take effect after a restart
+func (cfg *Config) reload(next *Config) (applied, restartRequired []string) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+
+	for name, value := range next.values {
+		if cfg.values[name] == value {
+			continue
+		}
+		if reloadableFlags[name] {
+			applied = append(applied, name)
+			cfg.values[name] = value
+		} else {
+			restartRequired = append(restartRequired, name)
+		}
+	}
+	cfg.tunables = next.tunables
+
+	sort.Strings(applied)
+	sort.Strings(restartRequired)
+	return applied, restartRequired
+}
+
+// Whether ext (like ".mp4") is one of the configured formats
+func (cfg *Config) servesFormat(ext string) bool {
+	ext = strings.TrimPrefix(strings.ToLower(ext), ".")
+	for _, format := range cfg.Tunables().Formats {
+		if format == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether -compress-skip leaves responses for the path uncompressed
+func (cfg *Config) skipsCompression(path string) bool {
+	if cfg.CompressSkip[path] {
+		return true
+	}
+	for _, prefix := range cfg.CompressSkipPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// A path on this server, so a redirect to it can't send visitors elsewhere: it starts with
+// a single slash ("//host" is another site to browsers) and has no scheme or host
+func localPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return false
+	}
+	parsed, err := url.Parse(path)
+	return err == nil && parsed.Scheme == "" && parsed.Host == ""
+}
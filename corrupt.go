@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Why the start of a movie file can't be the container its extension says, or "" when it
+// looks right: an MP4 opens with a box (a size and a four-letter type), Matroska and WebM
+// with the EBML magic, AVI with a RIFF header. Only the first bytes are read, so this
+// catches empty and truncated files and ones that are something else, not damage further in.
+func movieHeaderProblem(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(file, header)
+	header = header[:n]
+
+	ext := strings.ToLower(filepath.Ext(path))
+	var minSize int
+	var valid bool
+	switch ext {
+	case ".mp4":
+		minSize = 8
+		valid = n >= minSize && isBoxType(header[4:8])
+	case ".mkv", ".webm":
+		minSize = 4
+		valid = n >= minSize && bytes.Equal(header[:4], []byte{0x1a, 0x45, 0xdf, 0xa3})
+	case ".avi":
+		minSize = 12
+		valid = n >= minSize && string(header[:4]) == "RIFF" && string(header[8:12]) == "AVI "
+	default:
+		return ""
+	}
+	switch {
+	case n == 0:
+		return "Movie file is empty."
+	case n < minSize:
+		return fmt.Sprintf("Movie file is too small to be a video (%d bytes).", n)
+	case !valid:
+		return fmt.Sprintf("Movie file doesn't start like a %s file, it may be damaged.", strings.ToUpper(strings.TrimPrefix(ext, ".")))
+	}
+	return ""
+}
+
+// Box types are four printable ASCII characters, like ftyp or moov
+func isBoxType(kind []byte) bool {
+	for _, b := range kind {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Check the movie's header before describing it, answering 422 when it can't be a video
+func checkMovieHeader(c *fiber.Ctx, movieFilePath string) bool {
+	problem := movieHeaderProblem(movieFilePath)
+	if problem == "" {
+		return true
+	}
+	logRequest(requestID(c), "%s: %s", movieFilePath, problem)
+	c.Status(fiber.StatusUnprocessableEntity).SendString(problem)
+	return false
+}
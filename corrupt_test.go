@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetadataRefusesDamagedFiles(t *testing.T) {
+	app, _ := newTestServer(t)
+	for name, content := range map[string]string{
+		"empty.mp4":     "",
+		"truncated.mp4": "\x00\x00\x00",
+		"garbage.mp4":   "\x00\x00\x00\x18\x00\x01\x02\x03 more",
+		"text.mkv":      "not matroska",
+		"short.avi":     "RIFF\x00\x00",
+		"header.mp4":    "\x00\x00\x00\x18ftypisom",
+		"header.mkv":    testMatroska,
+		"header.avi":    "RIFF\x00\x00\x00\x00AVI LIST",
+	} {
+		writeFile(t, filepath.Join("movies", name), []byte(content))
+	}
+
+	for _, tt := range []struct {
+		movie  string
+		status int
+		body   string
+	}{
+		{"empty", http.StatusUnprocessableEntity, "Movie file is empty."},
+		{"truncated", http.StatusUnprocessableEntity, "Movie file is too small to be a video (3 bytes)."},
+		{"garbage", http.StatusUnprocessableEntity, "Movie file doesn't start like a MP4 file, it may be damaged."},
+		{"text", http.StatusUnprocessableEntity, "Movie file doesn't start like a MKV file, it may be damaged."},
+		{"short", http.StatusUnprocessableEntity, "Movie file is too small to be a video (6 bytes)."},
+		{"header", http.StatusOK, ""},
+	} {
+		for _, route := range []string{"playback", "fileinfo"} {
+			resp, body := get(t, app, "/api/movies/"+tt.movie+"/"+route)
+			if resp.StatusCode != tt.status || (tt.body != "" && body != tt.body) {
+				t.Errorf("%s of %s answered %d: %s", route, tt.movie, resp.StatusCode, body)
+			}
+		}
+	}
+	for _, format := range []string{"mp4", "mkv", "avi"} {
+		if resp, body := get(t, app, "/api/movies/header/fileinfo?format="+format); resp.StatusCode != http.StatusOK {
+			t.Errorf("a valid %s header answered %d: %s", format, resp.StatusCode, body)
+		}
+	}
+}
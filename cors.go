@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// Routes serving movie files and what belongs to them, as opposed to the /api/ routes
+var mediaPrefixes = []string{"/video/", "/stream/", "/subtitles/", "/poster/", "/dash/", "/sprite/", "/thumbnails/", "/preview/", "/remote/", "/download-folder/"}
+
+func isMediaPath(path string) bool {
+	for _, prefix := range mediaPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Request headers a page on another origin may send, and response headers it may read
+const (
+	corsAllowHeaders  = "Authorization, Content-Type, Range, If-Range, Prefer, Save-Data, Last-Event-ID, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata"
+	corsExposeHeaders = "Content-Length, Content-Range, Accept-Ranges, X-Total-Size, X-Request-Id, Retry-After, Preference-Applied, Location, Tus-Resumable, Upload-Offset, Upload-Length"
+)
+
+// Parse a comma-separated list of origins, "*" for any. Origins are a scheme (http or https)
+// and a host with an optional port, nothing more, which is all a browser ever sends.
+func parseOrigins(flag, list string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			parsed, err := url.Parse(origin)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || strings.Contains(parsed.Host, "*") ||
+				strings.TrimSuffix(parsed.Path, "/") != "" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
+				return nil, fmt.Errorf("invalid origin %q in -%s, expected e.g. https://example.com or *", origin, flag)
+			}
+			origin = parsed.Scheme + "://" + strings.ToLower(parsed.Host)
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// Let pages on other origins use the server. /api/ routes and media routes each follow
+// their own origins when given, everything else and any group without its own follows
+// -cors-origins. A group without origins gets no CORS headers. The lists are read for every
+// request, so a reload changes them; a policy is built once per list and kept.
+func useCORS(app *fiber.App, cfg *Config) {
+	groups := []struct {
+		origins func(t Tunables) []string
+		matches func(path string) bool
+	}{
+		{func(t Tunables) []string { return t.CORSAPIOrigins }, func(path string) bool { return strings.HasPrefix(path, "/api/") }},
+		{func(t Tunables) []string { return t.CORSMediaOrigins }, isMediaPath},
+		{func(t Tunables) []string { return t.CORSOrigins }, func(path string) bool { return true }},
+	}
+	var policies sync.Map
+	app.Use(func(c *fiber.Ctx) error {
+		for _, group := range groups {
+			if !group.matches(c.Path()) {
+				continue
+			}
+			origins := group.origins(cfg.Tunables())
+			if len(origins) == 0 {
+				return c.Next()
+			}
+			key := strings.Join(origins, ",")
+			policy, ok := policies.Load(key)
+			if !ok {
+				policy, _ = policies.LoadOrStore(key, cors.New(cors.Config{
+					AllowOrigins:  key,
+					AllowHeaders:  corsAllowHeaders,
+					ExposeHeaders: corsExposeHeaders,
+					MaxAge:        600,
+				}))
+			}
+			return policy.(fiber.Handler)(c)
+		}
+		return c.Next()
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The Access-Control-Allow-Origin a page on origin gets for a GET of target
+func allowedOrigin(t *testing.T, target, origin string, args ...string) string {
+	t.Helper()
+	app, _ := newTestServer(t, args...)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte("movie"))
+	return allowedOriginOn(t, app, target, origin)
+}
+
+func allowedOriginOn(t *testing.T, app *fiber.App, target, origin string) string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Origin", origin)
+	resp, _ := send(t, app, req)
+	return resp.Header.Get("Access-Control-Allow-Origin")
+}
+
+func TestCORS(t *testing.T) {
+	const spa, player = "https://spa.example.com", "https://player.example.com"
+	split := []string{"-cors-api-origins", spa, "-cors-media-origins", player}
+
+	for _, tt := range []struct {
+		name, target, origin string
+		args                 []string
+		allowed              string
+	}{
+		{"no policy", "/api/movies", spa, nil, ""},
+		{"general policy on the API", "/api/movies", spa, []string{"-cors-origins", spa}, spa},
+		{"general policy on media", "/video/a", spa, []string{"-cors-origins", spa}, spa},
+		{"any origin", "/video/a", player, []string{"-cors-origins", "*"}, "*"},
+		{"API origin on the API", "/api/movies", spa, split, spa},
+		{"API origin on media", "/video/a", spa, split, ""},
+		{"media origin on media", "/video/a", player, split, player},
+		{"media origin on the API", "/api/movies", player, split, ""},
+		{"media origin on subtitles", "/subtitles/a", player, split, player},
+		{"API override falls back for media", "/video/a", player, []string{"-cors-origins", player, "-cors-api-origins", spa}, player},
+		{"media override falls back for the API", "/api/movies", spa, []string{"-cors-origins", spa, "-cors-media-origins", player}, spa},
+		{"other routes follow the general policy", "/health", spa, append([]string{"-cors-origins", spa}, split...), spa},
+		{"other routes without a general policy", "/health", spa, split, ""},
+	} {
+		if got := allowedOrigin(t, tt.target, tt.origin, tt.args...); got != tt.allowed {
+			t.Errorf("%s: allowed %q, want %q", tt.name, got, tt.allowed)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	const spa = "https://spa.example.com"
+	app, _ := newTestServer(t, "-cors-api-origins", spa, "-api-token", testToken)
+	req, _ := http.NewRequest(http.MethodOptions, "/api/uploads", nil)
+	req.Header.Set("Origin", spa)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "authorization, upload-length")
+	resp, _ := send(t, app, req)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != spa {
+		t.Errorf("preflight answered %d for %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	if allowed := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(allowed, "Upload-Length") {
+		t.Errorf("allowed headers %q", allowed)
+	}
+	if allowed := resp.Header.Get("Access-Control-Allow-Methods"); !strings.Contains(allowed, http.MethodPost) {
+		t.Errorf("allowed methods %q", allowed)
+	}
+}
+
+func TestCORSReload(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, config, []byte(`{"cors-origins": "https://old.example.com"}`))
+	app, _ := newTestServer(t, "-config", config, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte("movie"))
+	if got := allowedOriginOn(t, app, "/api/movies", "https://old.example.com"); got != "https://old.example.com" {
+		t.Fatalf("allowed %q before the reload", got)
+	}
+
+	writeFile(t, config, []byte(`{"cors-origins": "https://new.example.com", "cors-media-origins": "*"}`))
+	req, _ := http.NewRequest(http.MethodPost, "/api/reload", nil)
+	resp, body := send(t, app, authorized(req))
+	if resp.StatusCode != http.StatusOK || body != `{"applied":["cors-media-origins","cors-origins"],"restartRequired":[]}` {
+		t.Fatalf("reload answered %d: %s", resp.StatusCode, body)
+	}
+	for origin, want := range map[string]string{"https://old.example.com": "", "https://new.example.com": "https://new.example.com"} {
+		if got := allowedOriginOn(t, app, "/api/movies", origin); got != want {
+			t.Errorf("%s allowed %q after the reload, want %q", origin, got, want)
+		}
+	}
+	if got := allowedOriginOn(t, app, "/video/a", "https://any.example.com"); got != "*" {
+		t.Errorf("media allowed %q after the reload, want *", got)
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	origins, err := parseOrigins("cors-origins", " https://A.example.com/ , http://localhost:8080,*")
+	if err != nil || strings.Join(origins, " ") != "https://a.example.com http://localhost:8080 *" {
+		t.Errorf("parsed %q: %v", origins, err)
+	}
+	for _, origin := range []string{"example.com", "ftp://example.com", "https://", "https://example.com/app", "https://*.example.com", "https://user@example.com", "https://example.com?x"} {
+		if _, err := loadConfig([]string{"-cors-media-origins", origin}); err == nil {
+			t.Errorf("accepted %q", origin)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func isCSPHeader(name string) bool {
+	return strings.EqualFold(name, fiber.HeaderContentSecurityPolicy) || strings.EqualFold(name, fiber.HeaderContentSecurityPolicyReportOnly)
+}
+
+// A fresh nonce for the inline scripts and styles of a page when -headers sets a
+// Content-Security-Policy, "" otherwise. customHeaders adds it to the policy of the
+// response, so a strict policy doesn't block the page's own scripts.
+func cspNonce(c *fiber.Ctx, cfg *Config) string {
+	found := false
+	for _, h := range cfg.Tunables().Headers {
+		found = found || isCSPHeader(h.name)
+	}
+	if !found {
+		return ""
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	// URL-safe, so templates write it into attributes unescaped
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	c.Locals("cspNonce", nonce)
+	return nonce
+}
+
+// The policy with the nonce allowed for scripts and styles. Directives that aren't there
+// fall back to default-src, so they are added as a copy of it; without default-src scripts
+// and styles aren't restricted and nothing needs adding.
+func withNonce(policy, nonce string) string {
+	source := "'nonce-" + nonce + "'"
+	var directives [][]string
+	index := map[string]int{}
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		// Only the first of a repeated directive counts
+		if _, seen := index[name]; !seen {
+			index[name] = len(directives)
+		}
+		directives = append(directives, fields)
+	}
+
+	allow := func(i int) {
+		// 'none' can't be combined with other sources
+		kept := directives[i][:1]
+		for _, s := range directives[i][1:] {
+			if !strings.EqualFold(s, "'none'") {
+				kept = append(kept, s)
+			}
+		}
+		directives[i] = append(kept, source)
+	}
+	for _, name := range []string{"script-src", "style-src"} {
+		if i, ok := index[name]; ok {
+			allow(i)
+		} else if i, ok := index["default-src"]; ok {
+			index[name] = len(directives)
+			directives = append(directives, append([]string{name}, directives[i][1:]...))
+			allow(index[name])
+		}
+		// The -elem directives override the plain ones for script and style elements
+		if i, ok := index[name+"-elem"]; ok {
+			allow(i)
+		}
+	}
+
+	parts := make([]string, len(directives))
+	for i, fields := range directives {
+		parts[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(parts, "; ")
+}
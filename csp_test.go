@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestWithNonce(t *testing.T) {
+	for _, tt := range []struct{ policy, want string }{
+		{"default-src 'self'", "default-src 'self'; script-src 'self' 'nonce-N'; style-src 'self' 'nonce-N'"},
+		{"script-src 'none'; img-src *", "script-src 'nonce-N'; img-src *"},
+		{"img-src *", "img-src *"},
+		{"default-src 'none'; script-src-elem https://cdn.example", "default-src 'none'; script-src-elem https://cdn.example 'nonce-N'; script-src 'nonce-N'; style-src 'nonce-N'"},
+		{"Script-Src 'self'; script-src https://ignored.example", "Script-Src 'self' 'nonce-N'; script-src https://ignored.example"},
+	} {
+		if got := withNonce(tt.policy, "N"); got != tt.want {
+			t.Errorf("withNonce(%q) = %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+}
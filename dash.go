@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// One lock per movie so concurrent requests don't package the same file twice
+var dashLocks sync.Map
+
+// What a package was made from: the movie's path, modification time and size. A package
+// whose source doesn't match the movie as it is now is made again.
+func dashSource(movieFilePath string) (string, error) {
+	info, err := os.Stat(movieFilePath)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\n%d\n%d\n", movieFilePath, info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// Package the movie into DASH segments with ffmpeg, unless already cached for this version
+// of the file
+func ensureDashManifest(cfg *Config, rid, movieName, movieFilePath string) (string, error) {
+	defer lockKey(&dashLocks, movieName)()
+
+	source, err := dashSource(movieFilePath)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cfg.DashDir, movieName)
+	manifest := filepath.Join(dir, "manifest.mpd")
+	if _, err := os.Stat(manifest); err == nil {
+		if stamp, err := os.ReadFile(filepath.Join(dir, "source")); err == nil && string(stamp) == source {
+			// Mark the entry as recently used for the LRU cleanup
+			touchCache(dir)
+			return manifest, nil
+		}
+		logRequest(rid, "%s changed since it was packaged for DASH, packaging it again", movieFilePath)
+	}
+
+	// Write into a temporary directory so a failed run never leaves a half-packaged movie
+	// behind. Its stamp goes in first: the segments an interrupted run finished are reused
+	// by the next one as long as the movie is unchanged.
+	tmpDir := dir + ".tmp"
+	done, start, resume := 0, 0.0, false
+	if stamp, err := os.ReadFile(filepath.Join(tmpDir, "source")); err == nil && string(stamp) == source {
+		done, start, resume = dashResumePoint(tmpDir)
+	}
+	if !resume {
+		os.RemoveAll(tmpDir)
+		if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "source"), []byte(source), 0o644); err != nil {
+			os.RemoveAll(tmpDir)
+			return "", err
+		}
+	}
+
+	output := tmpDir
+	args := []string{"-nostdin", "-loglevel", "error"}
+	if resume {
+		// Timestamps as the first run had them, so the segments continue its timeline
+		logRequest(rid, "Resuming the DASH package of %s after %d segments, at %.3fs", movieFilePath, done, start)
+		dropDashSegmentsAfter(tmpDir, done)
+		output = filepath.Join(tmpDir, "resume")
+		os.RemoveAll(output)
+		if err := os.Mkdir(output, 0o755); err != nil {
+			return "", err
+		}
+		args = append(args, "-copyts", "-start_at_zero", "-ss", fmt.Sprintf("%.3f", start))
+	} else {
+		logRequest(rid, "Packaging %s for DASH", movieFilePath)
+	}
+	args = append(args, "-i", movieFilePath,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c", "copy",
+		"-f", "dash", "-seg_duration", "4", "-use_template", "1", "-use_timeline", "1",
+		"-init_seg_name", dashInitTemplate, "-media_seg_name", dashMediaTemplate,
+		filepath.Join(output, "manifest.mpd"))
+	_, err = runToolFor(rid, cfg.JobTimeout, "ffmpeg", args...)
+	if err == nil && resume {
+		if err = mergeDashResume(tmpDir, output, done, start); err != nil {
+			// Whatever went wrong, the next request packages the whole movie
+			os.RemoveAll(tmpDir)
+		}
+	}
+	if err != nil {
+		// The finished segments stay for the next request, a resumed run's only once merged
+		if resume {
+			os.RemoveAll(output)
+		}
+		logRequest(rid, "Failed to package %s: %v", movieFilePath, err)
+		return "", err
+	}
+
+	// Replace an outdated package, a rename can't overwrite a directory
+	os.RemoveAll(dir)
+	if err := os.Rename(tmpDir, dir); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	pruneDashCache(cfg)
+	pruneCache(cfg)
+	return manifest, nil
+}
+
+// Remove the least recently used packaged movies beyond the configured cache size
+func pruneDashCache(cfg *Config) {
+	entries, err := os.ReadDir(cfg.DashDir)
+	if err != nil {
+		return
+	}
+
+	type cached struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []cached
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || filepath.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		dirs = append(dirs, cached{filepath.Join(cfg.DashDir, entry.Name()), info.ModTime()})
+	}
+
+	// Newest first, everything past the limit goes
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].modTime.After(dirs[j].modTime) })
+	for i := cfg.DashCacheSize; i < len(dirs); i++ {
+		log.Printf("Evicting DASH cache %s", dirs[i].path)
+		os.RemoveAll(dirs[i].path)
+	}
+}
+
+// Route for the DASH manifest and its segments
+func dashHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !cfg.Dash {
+			return c.Status(fiber.StatusNotFound).SendString("DASH is disabled.")
+		}
+		if !haveTool("ffmpeg") {
+			return c.Status(fiber.StatusNotImplemented).SendString("DASH needs ffmpeg, which is not installed.")
+		}
+
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		file := c.Params("file")
+		if file == "manifest.mpd" {
+			manifest, err := ensureDashManifest(cfg, requestID(c), movieName, movieFilePath)
+			if err != nil {
+				return toolFailure(c, err, "Failed to package movie for DASH.")
+			}
+			return sendFileAs(c, manifest, "application/dash+xml")
+		}
+
+		// Segments only ever live directly inside the movie's cache directory
+		if file != filepath.Base(file) || filepath.Ext(file) != ".m4s" {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid segment name.")
+		}
+		segment := filepath.Join(cfg.DashDir, movieName, file)
+		if _, err := os.Stat(segment); err != nil {
+			return c.Status(fiber.StatusNotFound).SendString("Segment not found.")
+		}
+		return sendFileAs(c, segment, "video/iso.segment")
+	}
+}
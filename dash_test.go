@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashManifest(t *testing.T) {
+	requireTools(t, "ffmpeg")
+	app, _ := newTestServer(t)
+	movie := filepath.Join("movies", "a.mp4")
+	if output, err := exec.Command("ffmpeg", "-nostdin", "-loglevel", "error", "-f", "lavfi", "-i", "testsrc=duration=2:size=64x64:rate=10",
+		"-c:v", "mpeg4", movie).CombinedOutput(); err != nil {
+		t.Fatalf("could not make a sample movie: %v: %s", err, output)
+	}
+
+	resp, body := get(t, app, "/dash/a/manifest.mpd")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/dash+xml" || !strings.Contains(body, "<MPD") {
+		t.Fatalf("manifest answered %d as %s: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	if _, err := os.Stat(filepath.Join("cache", "dash", "a", "manifest.mpd")); err != nil {
+		t.Errorf("manifest not cached: %v", err)
+	}
+}
+
+func TestDashDisabled(t *testing.T) {
+	app, _ := newTestServer(t, "-dash=false")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	if resp, body := get(t, app, "/dash/a/manifest.mpd"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("manifest with -dash=false answered %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestDashCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	_, cfg := newTestServer(t, "-dash-cache-size", "2")
+	for i, movie := range []string{"old", "newer", "newest"} {
+		dir := filepath.Join(cfg.DashDir, movie)
+		writeFile(t, filepath.Join(dir, "manifest.mpd"), nil)
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(dir, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A package still being written is never evicted
+	writeFile(t, filepath.Join(cfg.DashDir, "writing.tmp", "manifest.mpd"), nil)
+
+	pruneDashCache(cfg)
+	for movie, kept := range map[string]bool{"old": false, "newer": true, "newest": true, "writing.tmp": true} {
+		if _, err := os.Stat(filepath.Join(cfg.DashDir, movie)); (err == nil) != kept {
+			t.Errorf("%s kept: %v, want %v", movie, err == nil, kept)
+		}
+	}
+}
+
+func TestDashRepackagesChangedMovie(t *testing.T) {
+	app, cfg := newTestServer(t)
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	runs := scriptTool(t, "ffmpeg", toolRun{output: "<MPD>first</MPD>"}, toolRun{output: "<MPD>second</MPD>"}, toolRun{output: "<MPD>third</MPD>"})
+	logged := captureLog(t)
+
+	manifest := func(movie, want string) {
+		t.Helper()
+		if resp, body := get(t, app, "/dash/"+movie+"/manifest.mpd"); resp.StatusCode != http.StatusOK || body != want {
+			t.Errorf("manifest of %s answered %d: %s, want %s", movie, resp.StatusCode, body, want)
+		}
+	}
+
+	// An unchanged movie reuses its package
+	manifest("a", "<MPD>first</MPD>")
+	manifest("a", "<MPD>first</MPD>")
+	if runs.Load() != 1 {
+		t.Errorf("ffmpeg ran %d times for an unchanged movie", runs.Load())
+	}
+	stamp, err := os.ReadFile(filepath.Join(cfg.DashDir, "a", "source"))
+	if err != nil || !strings.HasPrefix(string(stamp), movie+"\n") {
+		t.Errorf("package source %q: %v", stamp, err)
+	}
+
+	// A package can't be asked for its source
+	if resp, _ := get(t, app, "/dash/a/source"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("source file answered %d", resp.StatusCode)
+	}
+
+	// A changed movie is packaged again, once
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(movie, later, later)
+	manifest("a", "<MPD>second</MPD>")
+	manifest("a", "<MPD>second</MPD>")
+	if runs.Load() != 2 || !strings.Contains(logged.String(), "changed since it was packaged for DASH") {
+		t.Errorf("ffmpeg ran %d times after a change:\n%s", runs.Load(), logged)
+	}
+
+	// A package from before packages recorded their source is made again
+	writeFile(t, filepath.Join(cfg.DashDir, "b", "manifest.mpd"), []byte("<MPD>unknown</MPD>"))
+	writeFile(t, filepath.Join(cfg.DashDir, "b", "chunk-stream0-00001.m4s"), []byte("old"))
+	manifest("b", "<MPD>third</MPD>")
+	if _, err := os.Stat(filepath.Join(cfg.DashDir, "b", "chunk-stream0-00001.m4s")); err == nil {
+		t.Error("segments of the outdated package are left")
+	}
+}
+
+// A manifest like ffmpeg writes for a video and an audio representation of 4 second
+// segments, the first starting at the given second
+func testDashManifest(kind string, from, segments int, duration string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" type=\"%s\" mediaPresentationDuration=\"%s\">\n\t<Period id=\"0\" start=\"PT0.0S\">\n", kind, duration)
+	for i, timescale := range []int{12800, 48000} {
+		fmt.Fprintf(&b, "\t\t<AdaptationSet id=\"%d\">\n\t\t\t<Representation id=\"%d\" bandwidth=\"1000\">\n", i, i)
+		fmt.Fprintf(&b, "\t\t\t\t<SegmentTemplate timescale=\"%d\" initialization=\"%s\" media=\"%s\" startNumber=\"1\">\n", timescale, dashInitTemplate, dashMediaTemplate)
+		fmt.Fprintf(&b, "\t\t\t\t\t<SegmentTimeline>\n\t\t\t\t\t\t<S t=\"%d\" d=\"%d\" r=\"%d\" />\n\t\t\t\t\t</SegmentTimeline>\n", from*timescale, 4*timescale, segments-1)
+		b.WriteString("\t\t\t\t</SegmentTemplate>\n\t\t\t</Representation>\n\t\t</AdaptationSet>\n")
+	}
+	b.WriteString("\t</Period>\n</MPD>\n")
+	return b.String()
+}
+
+// Stand in for ffmpeg packaging a movie into the given number of segments, recording its
+// arguments. A run with -ss continues at that second.
+func scriptDashPackager(t *testing.T, segments int) *[][]string {
+	t.Helper()
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	var calls [][]string
+	scriptTool(t, "ffmpeg", toolRun{prepare: func(args []string) {
+		calls = append(calls, args)
+		from := 0
+		if i := slices.Index(args, "-ss"); i >= 0 {
+			fmt.Sscanf(args[i+1], "%d", &from)
+		}
+		manifest := args[len(args)-1]
+		writeFile(t, manifest, []byte(testDashManifest("static", from, segments, fmt.Sprintf("PT%d.0S", 4*segments))))
+		for r := 0; r < 2; r++ {
+			writeFile(t, filepath.Join(filepath.Dir(manifest), fmt.Sprintf("init-%d.m4s", r)), []byte("new init"))
+			for n := 1; n <= segments; n++ {
+				writeFile(t, filepath.Join(filepath.Dir(manifest), fmt.Sprintf("chunk-%d-%05d.m4s", r, n)), []byte(fmt.Sprintf("new %d %d", r, n)))
+			}
+		}
+	}})
+	return &calls
+}
+
+// An interrupted run finished 3 video and 2 audio segments and was writing the fourth
+func writeInterruptedPackage(t *testing.T, dir, source string) {
+	t.Helper()
+	writeFile(t, filepath.Join(dir, "source"), []byte(source))
+	writeFile(t, filepath.Join(dir, "manifest.mpd"), []byte(testDashManifest("dynamic", 0, 3, "PT0.0S")))
+	for r := 0; r < 2; r++ {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("init-%d.m4s", r)), []byte("old init"))
+	}
+	for _, name := range []string{"chunk-0-00001.m4s", "chunk-0-00002.m4s", "chunk-0-00003.m4s", "chunk-1-00001.m4s", "chunk-1-00002.m4s", "chunk-0-00004.m4s.tmp"} {
+		writeFile(t, filepath.Join(dir, name), []byte("old"))
+	}
+}
+
+func TestDashResumesInterruptedPackage(t *testing.T) {
+	calls := scriptDashPackager(t, 2)
+	app, cfg := newTestServer(t)
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(testMovie))
+	source, err := dashSource(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeInterruptedPackage(t, filepath.Join(cfg.DashDir, "a.tmp"), source)
+
+	resp, body := get(t, app, "/dash/a/manifest.mpd")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("manifest answered %d: %s", resp.StatusCode, body)
+	}
+	// Both representations have 2 segments done, the rest starts at 8 seconds
+	if len(*calls) != 1 {
+		t.Fatalf("ffmpeg ran %d times", len(*calls))
+	}
+	if args := (*calls)[0]; !slices.Contains(args, "-copyts") || slices.Index(args, "-ss") < 0 || args[slices.Index(args, "-ss")+1] != "8.000" {
+		t.Errorf("ffmpeg didn't continue at 8 seconds: %v", args)
+	}
+
+	manifest, text, err := readDashManifest(filepath.Join(cfg.DashDir, "a", "manifest.mpd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != body {
+		t.Error("served a different manifest than the package's")
+	}
+	if manifest.Duration != "PT16.000S" {
+		t.Errorf("duration %s, want PT16.000S", manifest.Duration)
+	}
+	for _, r := range manifest.Representations {
+		segments, ok := r.segments()
+		if !ok || len(segments) != 4 || r.Template.StartNumber != 1 {
+			t.Fatalf("representation %s has %d segments from %d", r.ID, len(segments), r.Template.StartNumber)
+		}
+		for i, segment := range segments {
+			if want := int64(i) * 4 * r.Template.Timescale; segment.start != want {
+				t.Errorf("segment %d of representation %s starts at %d, want %d", i+1, r.ID, segment.start, want)
+			}
+		}
+	}
+
+	// The finished segments are kept and the new ones numbered after them
+	dir := filepath.Join(cfg.DashDir, "a")
+	for name, want := range map[string]string{
+		"init-0.m4s":        "old init",
+		"chunk-0-00001.m4s": "old",
+		"chunk-0-00002.m4s": "old",
+		"chunk-0-00003.m4s": "new 0 1",
+		"chunk-0-00004.m4s": "new 0 2",
+		"chunk-1-00002.m4s": "old",
+		"chunk-1-00003.m4s": "new 1 1",
+	} {
+		if content, err := os.ReadFile(filepath.Join(dir, name)); string(content) != want {
+			t.Errorf("%s holds %q, want %q: %v", name, content, want, err)
+		}
+	}
+	for _, name := range []string{"chunk-0-00004.m4s.tmp", "resume"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s is left over", name)
+		}
+	}
+}
+
+func TestDashRestartsInterruptedPackageOfChangedMovie(t *testing.T) {
+	calls := scriptDashPackager(t, 2)
+	app, cfg := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeInterruptedPackage(t, filepath.Join(cfg.DashDir, "a.tmp"), "another version\n")
+
+	if resp, body := get(t, app, "/dash/a/manifest.mpd"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("manifest answered %d: %s", resp.StatusCode, body)
+	}
+	if len(*calls) != 1 || slices.Contains((*calls)[0], "-ss") {
+		t.Errorf("ffmpeg ran as %v, want one run over the whole movie", *calls)
+	}
+	if content, _ := os.ReadFile(filepath.Join(cfg.DashDir, "a", "chunk-0-00001.m4s")); string(content) != "new 0 1" {
+		t.Errorf("kept a segment of another version: %q", content)
+	}
+}
+
+func TestDashKeepsSegmentsOfFailedPackage(t *testing.T) {
+	app, cfg := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	scriptTool(t, "ffmpeg", toolRun{stderr: "Invalid data found when processing input", exit: 1, prepare: func(args []string) {
+		writeFile(t, filepath.Join(filepath.Dir(args[len(args)-1]), "chunk-0-00001.m4s"), []byte("done"))
+	}})
+
+	if resp, _ := get(t, app, "/dash/a/manifest.mpd"); resp.StatusCode == http.StatusOK {
+		t.Fatal("a failed package was served")
+	}
+	// Kept for the next request, with the stamp that tells whether the movie changed
+	for _, name := range []string{"source", "chunk-0-00001.m4s"} {
+		if _, err := os.Stat(filepath.Join(cfg.DashDir, "a.tmp", name)); err != nil {
+			t.Errorf("after the failure: %v", err)
+		}
+	}
+}
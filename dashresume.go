@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Segment names given to ffmpeg, so a partial package can be read back without depending
+// on its defaults
+const (
+	dashInitTemplate  = "init-$RepresentationID$.m4s"
+	dashMediaTemplate = "chunk-$RepresentationID$-$Number%05d$.m4s"
+)
+
+// The parts of a manifest ffmpeg wrote that resuming needs. ffmpeg rewrites the manifest
+// after every segment it finishes, so an interrupted run leaves one listing those.
+type dashManifest struct {
+	Duration        string               `xml:"mediaPresentationDuration,attr"`
+	Representations []dashRepresentation `xml:"Period>AdaptationSet>Representation"`
+}
+
+type dashRepresentation struct {
+	ID       string `xml:"id,attr"`
+	Template struct {
+		Timescale   int64  `xml:"timescale,attr"`
+		Media       string `xml:"media,attr"`
+		StartNumber int    `xml:"startNumber,attr"`
+		Timeline    []struct {
+			T *int64 `xml:"t,attr"`
+			D int64  `xml:"d,attr"`
+			R int    `xml:"r,attr"`
+		} `xml:"SegmentTimeline>S"`
+	} `xml:"SegmentTemplate"`
+}
+
+// A segment's start and duration in the representation's timescale
+type dashSegment struct {
+	start, duration int64
+}
+
+func readDashManifest(path string) (*dashManifest, string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var manifest dashManifest
+	if err := xml.Unmarshal(content, &manifest); err != nil {
+		return nil, "", err
+	}
+	return &manifest, string(content), nil
+}
+
+// Every segment the representation's timeline lists, in order. Returns false for timelines
+// resuming can't continue, like one repeating until the end.
+func (r *dashRepresentation) segments() ([]dashSegment, bool) {
+	if r.Template.Timescale <= 0 || r.Template.Media != dashMediaTemplate {
+		return nil, false
+	}
+	var segments []dashSegment
+	time := int64(0)
+	for _, s := range r.Template.Timeline {
+		if s.T != nil {
+			time = *s.T
+		}
+		if s.D <= 0 || s.R < 0 {
+			return nil, false
+		}
+		for i := 0; i <= s.R; i++ {
+			segments = append(segments, dashSegment{time, s.D})
+			time += s.D
+		}
+	}
+	return segments, true
+}
+
+func (r *dashRepresentation) segmentFile(number int) string {
+	return fmt.Sprintf("chunk-%s-%05d.m4s", r.ID, number)
+}
+
+// How many segments of the partial package in dir are done in every representation, and
+// the second the first missing one starts at. ok is false when nothing can be reused.
+func dashResumePoint(dir string) (done int, start float64, ok bool) {
+	manifest, _, err := readDashManifest(filepath.Join(dir, "manifest.mpd"))
+	if err != nil || len(manifest.Representations) == 0 {
+		return 0, 0, false
+	}
+	for i, r := range manifest.Representations {
+		segments, ok := r.segments()
+		if !ok || r.Template.StartNumber != 1 {
+			return 0, 0, false
+		}
+		if _, err := os.Stat(filepath.Join(dir, strings.ReplaceAll(dashInitTemplate, "$RepresentationID$", r.ID))); err != nil {
+			return 0, 0, false
+		}
+		// Listed segments were finished before the manifest was written, check they're all still there
+		complete := 0
+		for complete < len(segments) {
+			if _, err := os.Stat(filepath.Join(dir, r.segmentFile(complete+1))); err != nil {
+				break
+			}
+			complete++
+		}
+		if i == 0 || complete < done {
+			done = complete
+		}
+	}
+	if done == 0 {
+		return 0, 0, false
+	}
+	// The first representation is the video, its segments start on keyframes
+	first := &manifest.Representations[0]
+	segments, _ := first.segments()
+	last := segments[done-1]
+	return done, float64(last.start+last.duration) / float64(first.Template.Timescale), true
+}
+
+// Remove what an interrupted run left of the segments after the first done ones
+func dropDashSegmentsAfter(dir string, done int) {
+	files, _ := filepath.Glob(filepath.Join(dir, "chunk-*"))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".tmp")
+		i := strings.LastIndex(name, "-")
+		number, err := strconv.Atoi(strings.TrimSuffix(name[i+1:], ".m4s"))
+		if err != nil || number > done || name != filepath.Base(file) {
+			os.Remove(file)
+		}
+	}
+}
+
+var (
+	dashRepresentationBlock = regexp.MustCompile(`(?s)<Representation [^>]*\bid="([^"]*)".*?</Representation>`)
+	dashTimelineBlock       = regexp.MustCompile(`(?s)<SegmentTimeline>.*?</SegmentTimeline>`)
+	dashStartNumber         = regexp.MustCompile(`startNumber="\d+"`)
+	dashDuration            = regexp.MustCompile(`mediaPresentationDuration="[^"]*"`)
+	isoDuration             = regexp.MustCompile(`^PT(?:([\d.]+)H)?(?:([\d.]+)M)?(?:([\d.]+)S)?$`)
+)
+
+// Seconds of an ISO 8601 duration like ffmpeg writes them, PT1H2M3.5S
+func parseISODuration(value string) (float64, bool) {
+	m := isoDuration.FindStringSubmatch(value)
+	if m == nil {
+		return 0, false
+	}
+	seconds := 0.0
+	for i, unit := range []float64{3600, 60, 1} {
+		if m[i+1] != "" {
+			n, err := strconv.ParseFloat(m[i+1], 64)
+			if err != nil {
+				return 0, false
+			}
+			seconds += n * unit
+		}
+	}
+	return seconds, true
+}
+
+// Join the first done segments of the partial package in dir with the rest, which a run
+// started at the second start packaged into resumeDir, numbering them from 1 again: they
+// move into dir, numbered on from the kept ones, and the manifest is the resumed one with
+// both timelines. The first run's init segments are kept.
+func mergeDashResume(dir, resumeDir string, done int, start float64) error {
+	partial, _, err := readDashManifest(filepath.Join(dir, "manifest.mpd"))
+	if err != nil {
+		return err
+	}
+	resumed, text, err := readDashManifest(filepath.Join(resumeDir, "manifest.mpd"))
+	if err != nil {
+		return err
+	}
+	if len(resumed.Representations) != len(partial.Representations) {
+		return fmt.Errorf("the resumed package has %d representations instead of %d", len(resumed.Representations), len(partial.Representations))
+	}
+
+	timelines := map[string]string{}
+	for i, r := range resumed.Representations {
+		before, _ := partial.Representations[i].segments()
+		after, ok := r.segments()
+		if !ok || r.ID != partial.Representations[i].ID || r.Template.Timescale != partial.Representations[i].Template.Timescale || r.Template.StartNumber != 1 {
+			return fmt.Errorf("representation %s of the resumed package doesn't continue the partial one", r.ID)
+		}
+		var timeline strings.Builder
+		timeline.WriteString("<SegmentTimeline>\n")
+		time := int64(0)
+		for _, segment := range before[:done] {
+			fmt.Fprintf(&timeline, "\t\t\t\t\t\t<S t=\"%d\" d=\"%d\" />\n", segment.start, segment.duration)
+			time = segment.start + segment.duration
+		}
+		// Continue right where the kept segments end, whatever time the resumed run counted from
+		for _, segment := range after {
+			fmt.Fprintf(&timeline, "\t\t\t\t\t\t<S t=\"%d\" d=\"%d\" />\n", time, segment.duration)
+			time += segment.duration
+		}
+		timeline.WriteString("\t\t\t\t\t</SegmentTimeline>")
+		timelines[r.ID] = timeline.String()
+
+		for n := range after {
+			if err := os.Rename(filepath.Join(resumeDir, r.segmentFile(n+1)), filepath.Join(dir, r.segmentFile(done+1+n))); err != nil {
+				return err
+			}
+		}
+	}
+
+	text = dashRepresentationBlock.ReplaceAllStringFunc(text, func(block string) string {
+		id := dashRepresentationBlock.FindStringSubmatch(block)[1]
+		block = dashStartNumber.ReplaceAllString(block, `startNumber="1"`)
+		return dashTimelineBlock.ReplaceAllLiteralString(block, timelines[id])
+	})
+	if duration, ok := parseISODuration(resumed.Duration); ok {
+		text = dashDuration.ReplaceAllLiteralString(text, fmt.Sprintf(`mediaPresentationDuration="PT%.3fS"`, start+duration))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "manifest.mpd.tmp"), []byte(text), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(dir, "manifest.mpd.tmp"), filepath.Join(dir, "manifest.mpd")); err != nil {
+		return err
+	}
+	return os.RemoveAll(resumeDir)
+}
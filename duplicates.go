@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Bytes hashed from each end of a file. Two different movies of the same size practically
+// never share both, and reading them is cheap even for a large library.
+const fingerprintChunk = 64 << 10
+
+// Fingerprints by path, reused until the file changes
+var fingerprintCache sync.Map
+
+type cachedFingerprint struct {
+	modTime     time.Time
+	size        int64
+	fingerprint string
+}
+
+// A fast partial hash of a file: its size, its first and its last fingerprintChunk bytes
+func fingerprintFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if cached, ok := fingerprintCache.Load(path); ok {
+		cached := cached.(cachedFingerprint)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.fingerprint, info.Size(), nil
+		}
+	}
+
+	hash := sha256.New()
+	binary.Write(hash, binary.BigEndian, info.Size())
+	if _, err := io.Copy(hash, io.LimitReader(file, fingerprintChunk)); err != nil {
+		return "", 0, err
+	}
+	if tail := info.Size() - fingerprintChunk; tail > fingerprintChunk {
+		if _, err := io.Copy(hash, io.NewSectionReader(file, tail, fingerprintChunk)); err != nil {
+			return "", 0, err
+		}
+	} else if tail > 0 {
+		// Small files are hashed in full
+		if _, err := io.Copy(hash, io.NewSectionReader(file, fingerprintChunk, tail)); err != nil {
+			return "", 0, err
+		}
+	}
+	fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+	fingerprintCache.Store(path, cachedFingerprint{modTime: info.ModTime(), size: info.Size(), fingerprint: fingerprint})
+	return fingerprint, info.Size(), nil
+}
+
+type duplicateFile struct {
+	Name    string `json:"name"`
+	Library string `json:"library"`
+	Format  string `json:"format"`
+}
+
+// Files that are most likely the same movie
+type duplicateGroup struct {
+	Size  int64           `json:"size"`
+	Files []duplicateFile `json:"files"`
+}
+
+// Every movie file in every movie directory, grouped by fingerprint. Includes the files
+// the catalog doesn't show because an earlier directory or format wins for their name,
+// which is where forgotten copies tend to be.
+func findDuplicates(cfg *Config) ([]duplicateGroup, error) {
+	groups := map[string]*duplicateGroup{}
+	for _, root := range cfg.MoviesDirs {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !cfg.servesFormat(ext) {
+				continue
+			}
+			fingerprint, size, err := fingerprintFile(filepath.Join(root, entry.Name()))
+			if err != nil || size == 0 {
+				continue
+			}
+			if groups[fingerprint] == nil {
+				groups[fingerprint] = &duplicateGroup{Size: size}
+			}
+			groups[fingerprint].Files = append(groups[fingerprint].Files, duplicateFile{
+				Name:    strings.TrimSuffix(entry.Name(), ext),
+				Library: root,
+				Format:  strings.ToLower(strings.TrimPrefix(ext, ".")),
+			})
+		}
+	}
+
+	duplicates := []duplicateGroup{}
+	for _, group := range groups {
+		if len(group.Files) > 1 {
+			duplicates = append(duplicates, *group)
+		}
+	}
+	// Biggest first, that is where cleaning up saves the most
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Size != duplicates[j].Size {
+			return duplicates[i].Size > duplicates[j].Size
+		}
+		return duplicates[i].Files[0].Name < duplicates[j].Files[0].Name
+	})
+	return duplicates, nil
+}
+
+func duplicatesHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		duplicates, err := findDuplicates(cfg)
+		if err != nil {
+			logRequest(requestID(c), "Could not look for duplicates: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not list movies.")
+		}
+		return c.JSON(duplicates)
+	}
+}
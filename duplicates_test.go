@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The duplicate groups as "size: library/name.format ..." lines
+func listDuplicates(t *testing.T, app *fiber.App) string {
+	t.Helper()
+	resp, body := get(t, app, "/api/duplicates")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("duplicates answered %d: %s", resp.StatusCode, body)
+	}
+	var groups []duplicateGroup
+	if err := json.Unmarshal([]byte(body), &groups); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	var lines []string
+	for _, group := range groups {
+		line := fmt.Sprintf("%d:", group.Size)
+		for _, file := range group.Files {
+			line += fmt.Sprintf(" %s/%s.%s", file.Library, file.Name, file.Format)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDuplicates(t *testing.T) {
+	app, _ := newTestServer(t, "-movies-dir", "movies,more")
+	if err := os.Mkdir("more", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := listDuplicates(t, app); got != "" {
+		t.Errorf("empty library has duplicates:\n%s", got)
+	}
+
+	// Large enough that only the ends are hashed
+	big := bytes.Repeat([]byte("0123456789abcdef"), 3*fingerprintChunk/16)
+	changedEnd := bytes.Clone(big)
+	changedEnd[len(changedEnd)-1] = 'x'
+	changedMiddle := bytes.Clone(big)
+	changedMiddle[len(changedMiddle)/2] = 'x'
+
+	writeFile(t, filepath.Join("movies", "Big.mp4"), big)
+	writeFile(t, filepath.Join("movies", "Big copy.mkv"), big)
+	writeFile(t, filepath.Join("more", "Big.mp4"), big)
+	writeFile(t, filepath.Join("movies", "Big edited.mp4"), changedEnd)
+	writeFile(t, filepath.Join("movies", "Big remux.mp4"), changedMiddle)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("more", "b.webm"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "c.mp4"), []byte(testMovie+"!"))
+	// Empty files, hidden files and other formats aren't movies
+	writeFile(t, filepath.Join("movies", "empty.mp4"), nil)
+	writeFile(t, filepath.Join("movies", "empty2.mp4"), nil)
+	writeFile(t, filepath.Join("movies", ".upload-a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "a.srt"), []byte(testMovie))
+
+	// The partial hash misses a change in the middle, which is the price of its speed
+	want := fmt.Sprintf("%d: movies/Big copy.mkv movies/Big remux.mp4 movies/Big.mp4 more/Big.mp4\n%d: movies/a.mp4 more/b.webm", len(big), len(testMovie))
+	if got := listDuplicates(t, app); got != want {
+		t.Errorf("duplicates:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A file changed since it was hashed is hashed again
+	writeFile(t, filepath.Join("more", "b.webm"), []byte(strings.ToUpper(testMovie)))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join("more", "b.webm"), later, later)
+	want = fmt.Sprintf("%d: movies/Big copy.mkv movies/Big remux.mp4 movies/Big.mp4 more/Big.mp4", len(big))
+	if got := listDuplicates(t, app); got != want {
+		t.Errorf("duplicates after a change:\n%s\nwant:\n%s", got, want)
+	}
+}
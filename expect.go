@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Decide on uploads sent with Expect: 100-continue before their body is read. fasthttp
+// answers 100 Continue before any handler runs, so the handlers' checks would only come
+// after a client sent gigabytes. Uploads the handler would refuse anyway are answered with
+// 417 instead, which tells clients not to send the body; the handler's own answer, with
+// the precise reason, follows when a client retries without the expectation. The
+// connection is closed after a refusal, in case the client sends the body regardless.
+func continueUpload(cfg *Config) func(header *fasthttp.RequestHeader) bool {
+	return func(header *fasthttp.RequestHeader) bool {
+		path, _, _ := strings.Cut(string(header.RequestURI()), "?")
+		method := string(header.Method())
+		// Routes are matched without regard to case
+		fileName, isUpload := cutPrefixFold(path, "/api/upload/")
+		// Unescaped like the route's parameter will be
+		if unescaped, err := url.PathUnescape(fileName); err == nil {
+			fileName = unescaped
+		}
+		isUpload = isUpload && method == fasthttp.MethodPut
+		isTus := method == fasthttp.MethodPatch && strings.HasPrefix(strings.ToLower(path), "/api/uploads/")
+		if !isUpload && !isTus {
+			return true
+		}
+
+		reason := ""
+		switch {
+		case !validToken(cfg, string(header.Peek(fasthttp.HeaderAuthorization))):
+			reason = "No valid API token"
+		case cfg.readOnly.Load():
+			reason = "The library is read-only"
+		case isUpload:
+			if _, message := checkUpload(cfg, fileName, header.ContentLength()); message != "" {
+				reason = message
+			}
+		}
+		if reason == "" {
+			return true
+		}
+		log.Printf("Refused %s %s before its body was sent: %s", method, path, strings.TrimSuffix(reason, "."))
+		header.SetConnectionClose()
+		return false
+	}
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Send the headers of an upload with Expect: 100-continue and the body only when the server
+// asks for it. Returns the final status and whether the body was sent.
+func expectContinue(t *testing.T, addr, method, target, token string, body []byte) (int, bool) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "%s %s HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer %s\r\nContent-Type: application/offset+octet-stream\r\n"+
+		"Upload-Offset: 0\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", method, target, token, len(body))
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusContinue {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, false
+	}
+	conn.Write(body)
+	if resp, err = http.ReadResponse(reader, nil); err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, true
+}
+
+func TestExpectContinue(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken, "-max-upload-size", "2000000")
+	writeFile(t, filepath.Join("movies", "taken.mp4"), []byte(testMovie))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.ShutdownWithTimeout(time.Second) })
+	logged := captureLog(t)
+
+	movie := []byte(strings.Repeat("m", 1000000))
+	for _, tt := range []struct {
+		name, target, token string
+		body                []byte
+		status              int
+	}{
+		{"bad extension", "/api/upload/a.txt", testToken, movie, http.StatusExpectationFailed},
+		{"taken name", "/api/upload/taken.mkv", testToken, movie, http.StatusExpectationFailed},
+		{"escaped taken name", "/api/upload/tak%65n.mkv", testToken, movie, http.StatusExpectationFailed},
+		{"wrong token", "/api/upload/a.mp4", "wrong", movie, http.StatusExpectationFailed},
+		{"too large", "/api/upload/a.mp4", testToken, append(append(movie, movie...), 'm'), http.StatusExpectationFailed},
+		{"path in another case", "/API/Upload/a.txt", testToken, movie, http.StatusExpectationFailed},
+		{"valid upload", "/api/upload/a.mp4", testToken, movie, http.StatusCreated},
+	} {
+		status, sent := expectContinue(t, ln.Addr().String(), http.MethodPut, tt.target, tt.token, tt.body)
+		if status != tt.status || sent != (tt.status == http.StatusCreated) {
+			t.Errorf("%s: answered %d, body sent %t", tt.name, status, sent)
+		}
+	}
+	if content, err := os.ReadFile(filepath.Join("movies", "a.mp4")); err != nil || len(content) != len(movie) {
+		t.Errorf("valid upload stored %d bytes: %v", len(content), err)
+	}
+	if !strings.Contains(logged.String(), "Refused PUT /api/upload/a.txt before its body was sent: Unsupported file format\n") {
+		t.Errorf("refusal not logged:\n%s", logged)
+	}
+
+	// tus appends are checked for the token
+	if status, sent := expectContinue(t, ln.Addr().String(), http.MethodPatch, "/api/uploads/0123456789abcdef0123456789abcdef", "wrong", movie); status != http.StatusExpectationFailed || sent {
+		t.Errorf("tus append with a wrong token answered %d, body sent %t", status, sent)
+	}
+	// Other requests continue to their handler
+	if status, sent := expectContinue(t, ln.Addr().String(), http.MethodPatch, "/api/uploads/0123456789abcdef0123456789abcdef", testToken, movie); status != http.StatusNotFound || !sent {
+		t.Errorf("tus append to an unknown upload answered %d, body sent %t", status, sent)
+	}
+}
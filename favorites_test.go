@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Names in GET /api/<set>, like favorites or watched, in the order listed
+func listMovieSet(t *testing.T, app *fiber.App, set string) string {
+	t.Helper()
+	resp, body := get(t, app, "/api/"+set)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s answered %d: %s", set, resp.StatusCode, body)
+	}
+	var entries []movieSetEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.AddedAt.IsZero() || entry.VideoURL != "/video/"+entry.Name {
+			t.Errorf("incomplete entry %+v", entry)
+		}
+		names = append(names, entry.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// Add a movie to the set with PUT or take it out with DELETE
+func changeMovieSet(t *testing.T, app *fiber.App, method, set, movie string) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, "/api/"+set+"/"+movie, nil)
+	resp, _ := send(t, app, req)
+	return resp.StatusCode
+}
+
+func TestFavorites(t *testing.T) {
+	app, cfg := newTestServer(t, "-api-token", testToken)
+	for _, movie := range []string{"a", "b", "c"} {
+		writeFile(t, filepath.Join("movies", movie+".mp4"), []byte(testMovie))
+	}
+
+	if got := listMovieSet(t, app, "favorites"); got != "" {
+		t.Errorf("new server lists favorites %q", got)
+	}
+	for _, movie := range []string{"a", "b", "c", "a"} {
+		if status := changeMovieSet(t, app, http.MethodPut, "favorites", movie); status != http.StatusNoContent {
+			t.Errorf("adding %s answered %d", movie, status)
+		}
+	}
+	// Adding a again keeps it where it was
+	if got := listMovieSet(t, app, "favorites"); got != "c b a" {
+		t.Errorf("listed %q, want newest first", got)
+	}
+
+	for _, movie := range []string{"b", "b", "missing"} {
+		if status := changeMovieSet(t, app, http.MethodDelete, "favorites", movie); status != http.StatusNoContent {
+			t.Errorf("removing %s answered %d", movie, status)
+		}
+	}
+	if got := listMovieSet(t, app, "favorites"); got != "c a" {
+		t.Errorf("listed %q after removing b", got)
+	}
+
+	// Only movies in the library can be favorites
+	if status := changeMovieSet(t, app, http.MethodPut, "favorites", "missing"); status != http.StatusNotFound {
+		t.Errorf("adding a missing movie answered %d", status)
+	}
+
+	// A rename carries the favorite along, a movie gone for now is just not listed
+	if resp, body := renameMovie(t, app, "a", "d"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	if err := os.Rename(filepath.Join("movies", "c.mp4"), "c.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if got := listMovieSet(t, app, "favorites"); got != "d" {
+		t.Errorf("listed %q after renaming a and moving c away", got)
+	}
+	if err := os.Rename("c.mp4", filepath.Join("movies", "c.mp4")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything is back after a restart
+	data, err := openUserData(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := listMovieSet(t, newApp(cfg, data), "favorites"); got != "c d" {
+		t.Errorf("listed %q after a restart", got)
+	}
+}
+
+// Read-only mode refuses changes to a movie set while listing keeps working
+func testMovieSetReadOnly(t *testing.T, set string) {
+	app, cfg := newTestServer(t, "-read-only")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		if status := changeMovieSet(t, app, method, set, "a"); status != http.StatusServiceUnavailable {
+			t.Errorf("%s of %s in read-only mode answered %d", method, set, status)
+		}
+	}
+	if got := listMovieSet(t, app, set); got != "" {
+		t.Errorf("read-only mode lists %s %q", set, got)
+	}
+
+	cfg.readOnly.Store(false)
+	if status := changeMovieSet(t, app, http.MethodPut, set, "a"); status != http.StatusNoContent {
+		t.Errorf("adding to %s after read-only mode ended answered %d", set, status)
+	}
+}
+
+func TestFavoritesReadOnly(t *testing.T) {
+	testMovieSetReadOnly(t, "favorites")
+}
+
+func TestWatchedReadOnly(t *testing.T) {
+	testMovieSetReadOnly(t, "watched")
+}
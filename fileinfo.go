@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// How /video/ answers for a file, as reported by the fileinfo route
+type fileInfoResponse struct {
+	Name        string    `json:"name"`
+	Library     string    `json:"library"`
+	Format      string    `json:"format"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	// The ETag /video/ sends, If-Range is matched against it or the modification time
+	ETag         string `json:"etag"`
+	AcceptRanges bool   `json:"acceptRanges"`
+	// "windowed" when ranges are cut to the -start-window/-prefetch-bytes windows, "native"
+	// when each range is answered in full (-native-range-formats), "accel-redirect" when
+	// nginx sends the file
+	Ranges string `json:"ranges"`
+	// Window sizes of windowed ranges in bytes, null for the other kinds. saveDataBytes is
+	// also null when -save-data-bytes is 0.
+	StartWindow   *int64 `json:"startWindow"`
+	PrefetchBytes *int64 `json:"prefetchBytes"`
+	SaveDataBytes *int64 `json:"saveDataBytes"`
+	// Whether a request without a Range is sent with sendfile instead of the streaming loop
+	SendFile bool `json:"sendfile"`
+	// Whether the file counts as still being written under -growing-wait
+	Growing   bool  `json:"growing"`
+	Faststart *bool `json:"faststart"`
+}
+
+// Route describing the file /video/ serves for a movie and how it answers ranges, to tell
+// why a client plays it the way it does. Like /video/, ?format= picks a variant.
+func fileInfoHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tunables := cfg.Tunables()
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if format := strings.ToLower(c.Query("format")); format != "" {
+			if !cfg.servesFormat(format) {
+				return c.Status(fiber.StatusBadRequest).SendString("Unknown format, expected one of: " + strings.Join(tunables.Formats, ", ") + ".")
+			}
+			if movieFilePath, found = findMovieIn(cfg, movieName, []string{format}); !found {
+				return c.Status(fiber.StatusNotFound).SendString("Movie not found in that format.")
+			}
+		}
+		if !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+		if !checkMovieHeader(c, movieFilePath) {
+			return nil
+		}
+
+		info, err := os.Stat(movieFilePath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not get file info.")
+		}
+
+		ext := strings.ToLower(filepath.Ext(movieFilePath))
+		format := strings.TrimPrefix(ext, ".")
+		resp := fileInfoResponse{
+			Name:         movieName,
+			Library:      filepath.Dir(movieFilePath),
+			Format:       format,
+			ContentType:  contentTypes[ext],
+			Size:         info.Size(),
+			ModTime:      info.ModTime().UTC(),
+			ETag:         videoETag(info),
+			AcceptRanges: true,
+			Growing:      cfg.GrowingWait > 0 && time.Since(info.ModTime()) < cfg.GrowingWait,
+		}
+		switch {
+		case cfg.AccelRedirect != "":
+			resp.Ranges = "accel-redirect"
+		case cfg.NativeRangeFormats[format]:
+			resp.Ranges = "native"
+			resp.SendFile = true
+		default:
+			resp.Ranges = "windowed"
+			startWindow, prefetch, saveData := tunables.StartWindow, tunables.PrefetchBytes, tunables.SaveDataBytes
+			if startWindow == 0 {
+				startWindow = prefetch
+			}
+			resp.StartWindow, resp.PrefetchBytes = &startWindow, &prefetch
+			if saveData > 0 {
+				resp.SaveDataBytes = &saveData
+			}
+			resp.SendFile = useSendFile(cfg, info.Size())
+		}
+		if faststart, ok := isFaststart(movieFilePath); ok {
+			resp.Faststart = &faststart
+		}
+		return c.JSON(resp)
+	}
+}
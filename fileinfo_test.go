@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileInfo(t *testing.T) {
+	app, _ := newTestServer(t, "-prefetch-bytes", "8", "-start-window", "4", "-save-data-bytes", "0", "-native-range-formats", "webm")
+	path := filepath.Join("movies", "a.mp4")
+	writeFile(t, path, []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "a.webm"), []byte(testMatroska))
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := get(t, app, "/api/movies/a/fileinfo?format=mp4")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("fileinfo answered %d: %s", resp.StatusCode, body)
+	}
+	var info fileInfoResponse
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatalf("fileinfo body %s: %v", body, err)
+	}
+	etag := fmt.Sprintf(`W/"14-%x"`, modTime.UnixNano())
+	if info.Name != "a" || info.Format != "mp4" || info.ContentType != "video/mp4" || info.Size != 20 ||
+		!info.ModTime.Equal(modTime) || info.ETag != etag || !info.AcceptRanges || info.Ranges != "windowed" ||
+		info.StartWindow == nil || *info.StartWindow != 4 || info.PrefetchBytes == nil || *info.PrefetchBytes != 8 ||
+		info.SaveDataBytes != nil || info.Growing {
+		t.Errorf("fileinfo %s", body)
+	}
+
+	// The modification time and the ETag are what If-Range is matched against
+	video, _ := get(t, app, "/video/a?format=mp4")
+	if got := video.Header.Get("Last-Modified"); got != modTime.Format(http.TimeFormat) {
+		t.Errorf("/video/ sent Last-Modified %q, fileinfo %s", got, info.ModTime)
+	}
+	if got := video.Header.Get("ETag"); got != info.ETag {
+		t.Errorf("/video/ sent ETag %q, fileinfo %q", got, info.ETag)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/video/a?format=mp4", nil)
+	req.Header.Set("Range", "bytes=2-")
+	req.Header.Set("If-Range", info.ETag)
+	if resp, body := send(t, app, req); resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("If-Range with the fileinfo ETag answered %d: %s", resp.StatusCode, body)
+	}
+
+	// Native ranges for -native-range-formats, without windows
+	_, body = get(t, app, "/api/movies/a/fileinfo?format=webm")
+	info = fileInfoResponse{}
+	if err := json.Unmarshal([]byte(body), &info); err != nil || info.Format != "webm" || info.Ranges != "native" ||
+		!info.SendFile || info.StartWindow != nil || info.PrefetchBytes != nil {
+		t.Errorf("fileinfo of the webm %s", body)
+	}
+
+	for target, status := range map[string]int{
+		"/api/movies/missing/fileinfo":      http.StatusNotFound,
+		"/api/movies/a/fileinfo?format=mkv": http.StatusNotFound,
+		"/api/movies/a/fileinfo?format=txt": http.StatusBadRequest,
+	} {
+		if resp, body := get(t, app, target); resp.StatusCode != status {
+			t.Errorf("%s answered %d: %s", target, resp.StatusCode, body)
+		}
+	}
+
+	app, _ = newTestServer(t, "-accel-redirect", "/internal/")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	_, body = get(t, app, "/api/movies/a/fileinfo")
+	info = fileInfoResponse{}
+	if err := json.Unmarshal([]byte(body), &info); err != nil || info.Ranges != "accel-redirect" || info.SendFile {
+		t.Errorf("fileinfo with -accel-redirect %s", body)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Stream a ZIP of every movie below a library folder, e.g. a whole season.
+// The archive is written straight to the connection, so memory use doesn't grow with its size.
+func downloadFolderHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		// Already unescaped, fiber's UnescapePath decodes the whole path
+		rel := c.Params("*")
+
+		// The first movie directory that has the folder wins, like it does for movie names
+		folder := ""
+		for _, root := range cfg.MoviesDirs {
+			path, err := libraryPath(root, rel)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid folder path.")
+			}
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				folder = path
+				break
+			}
+		}
+		if folder == "" {
+			return c.Status(fiber.StatusNotFound).SendString("Folder not found.")
+		}
+
+		// Collect the files first so an empty folder is a clean 404 rather than an empty archive
+		var files []string
+		filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() && cfg.servesFormat(filepath.Ext(path)) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if len(files) == 0 {
+			return c.Status(fiber.StatusNotFound).SendString("No movies in this folder.")
+		}
+
+		c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(folder) + ".zip"}))
+		c.Set(fiber.HeaderContentType, "application/zip")
+		releaseClient := holdClientStream(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer releaseClient()
+			archive := zip.NewWriter(w)
+			for _, path := range files {
+				if err := addToZip(archive, folder, path); err != nil {
+					logRequest(rid, "Folder download of %s stopped at %s: %v", folder, path, err)
+					return
+				}
+			}
+			if err := archive.Close(); err != nil {
+				logRequest(rid, "Folder download of %s failed: %v", folder, err)
+			}
+		})
+		return nil
+	}
+}
+
+// Add one file to the archive, stored as-is since video doesn't compress any further
+func addToZip(archive *zip.Writer, root, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	name, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	header.Method = zip.Store
+
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
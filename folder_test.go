@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDownloadFolder(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "Show", "S01", "e01.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "Show", "S01", "e02.mkv"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "Show", "S01", "extras", "e03.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "Show", "S01", "notes.txt"), []byte("not a movie"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/download-folder/Show/S01", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=10-")
+	resp, body := send(t, app, authorized(req))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("download answered %d: %s", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename=S01.zip` {
+		t.Errorf("Content-Disposition is %q", got)
+	}
+	// Streamed as it is written, so the length isn't known up front and there is no range of it
+	if resp.ContentLength != -1 || resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Range") != "" {
+		t.Errorf("archive sent with length %d, encoding %q and Content-Range %q, want it streamed whole as is",
+			resp.ContentLength, resp.Header.Get("Content-Encoding"), resp.Header.Get("Content-Range"))
+	}
+
+	archive, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+		if f.Method != zip.Store {
+			t.Errorf("%s is compressed with method %d", f.Name, f.Method)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var content bytes.Buffer
+		content.ReadFrom(r)
+		r.Close()
+		if content.String() != testMovie {
+			t.Errorf("%s holds %q", f.Name, content.String())
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"e01.mp4", "e02.mkv", "extras/e03.mp4"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("archive holds %v, want %v", names, want)
+	}
+}
+
+func TestDownloadFolderStaysInLibrary(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("outside", "secret.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "Empty", "notes.txt"), []byte("not a movie"))
+	if err := os.Symlink(filepath.Join("..", "outside"), filepath.Join("movies", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for target, status := range map[string]int{
+		"/download-folder/..%2Foutside": http.StatusBadRequest,
+		"/download-folder/link":         http.StatusBadRequest,
+		"/download-folder/Missing":      http.StatusNotFound,
+		"/download-folder/Empty":        http.StatusNotFound,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		if resp, body := send(t, app, authorized(req)); resp.StatusCode != status {
+			t.Errorf("%s answered %d, want %d: %s", target, resp.StatusCode, status, body)
+		}
+	}
+
+	if resp, _ := get(t, app, "/download-folder/Empty"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("download without a token answered %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The first bytes of movie files kept in memory, where players find the container header
+// (the moov atom of a faststart MP4, the EBML header and seek index of an MKV). The first
+// range of a playback starts from memory instead of waiting on the disk, which matters
+// most for drives that spin down.
+type headerCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedHeader
+	order   []string // Oldest first, evicted first when over the budget
+	total   int64
+}
+
+type cachedHeader struct {
+	modTime time.Time
+	size    int64
+	data    []byte
+}
+
+var headers = &headerCache{entries: map[string]*cachedHeader{}}
+
+// The cached start of the file, nil unless it is cached for this version of the file
+func (h *headerCache) get(path string, info os.FileInfo) []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.entries[path]
+	if !ok || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		return nil
+	}
+	return entry.data
+}
+
+// Read and keep the first -header-cache-bytes of the file, dropping the oldest headers to
+// stay within -header-cache-total. Returns what it read.
+func (h *headerCache) load(cfg *Config, path string, info os.FileInfo) []byte {
+	if cfg.HeaderCacheBytes == 0 {
+		return nil
+	}
+	if data := h.get(path, info); data != nil {
+		return data
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, min(cfg.HeaderCacheBytes, info.Size())))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old, ok := h.entries[path]; ok {
+		h.total -= int64(len(old.data))
+	} else {
+		h.order = append(h.order, path)
+	}
+	h.entries[path] = &cachedHeader{modTime: info.ModTime(), size: info.Size(), data: data}
+	h.total += int64(len(data))
+	for h.total > cfg.HeaderCacheTotal && len(h.order) > 1 {
+		oldest := h.order[0]
+		h.order = h.order[1:]
+		h.total -= int64(len(h.entries[oldest].data))
+		delete(h.entries, oldest)
+	}
+	return data
+}
+
+func (h *headerCache) bytes() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+// Read the headers of the library on startup, as many as the budget holds
+func warmHeaders(cfg *Config) {
+	if cfg.HeaderCacheBytes == 0 {
+		return
+	}
+	movies, err := listMovies(cfg)
+	if err != nil {
+		return
+	}
+	for _, movie := range movies {
+		if headers.bytes()+cfg.HeaderCacheBytes > cfg.HeaderCacheTotal {
+			break
+		}
+		movieFilePath, found := findMovie(cfg, movie.Name)
+		if !found {
+			continue
+		}
+		if info, err := os.Stat(movieFilePath); err == nil {
+			headers.load(cfg, movieFilePath, info)
+		}
+	}
+}
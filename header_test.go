@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Start from an empty header cache and put the previous one back afterwards
+func useHeaderCache(t *testing.T) {
+	t.Helper()
+	previous := headers
+	headers = &headerCache{entries: map[string]*cachedHeader{}}
+	t.Cleanup(func() { headers = previous })
+}
+
+func cachedHeaderOf(t *testing.T, path string) string {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(headers.get(path, info))
+}
+
+func fetchRange(t *testing.T, app *fiber.App, movie, rangeHeader string) string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "/video/"+movie, nil)
+	req.Header.Set("Range", rangeHeader)
+	resp, body := send(t, app, req)
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("%s with %s answered %d: %s", movie, rangeHeader, resp.StatusCode, body)
+	}
+	return body
+}
+
+func TestHeaderCache(t *testing.T) {
+	useHeaderCache(t)
+	app, _ := newTestServer(t, "-header-cache-bytes", "8", "-header-cache-total", "16")
+	path := filepath.Join("movies", "a.mp4")
+	writeFile(t, path, []byte(testMovie))
+	logged := captureLog(t)
+
+	// The first playback reads the header in
+	if body := fetchRange(t, app, "a", "bytes=0-"); body != testMovie {
+		t.Errorf("first range: %q", body)
+	}
+	if got := cachedHeaderOf(t, path); got != testMovie[:8] {
+		t.Fatalf("cached header %q", got)
+	}
+
+	// Rewrite the file's start behind the cache's back, keeping its time and size, so what
+	// comes from memory can be told from what comes from the file
+	info, _ := os.Stat(path)
+	writeFile(t, path, []byte(strings.ToUpper(testMovie)))
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+	upper := strings.ToUpper(testMovie)
+	for rangeHeader, want := range map[string]string{
+		"bytes=0-":  testMovie[:8] + upper[8:],
+		"bytes=2-":  testMovie[2:8] + upper[8:],
+		"bytes=7-":  testMovie[7:8] + upper[8:],
+		"bytes=8-":  upper[8:],
+		"bytes=10-": upper[10:],
+	} {
+		if body := fetchRange(t, app, "a", rangeHeader); body != want {
+			t.Errorf("%s: %q, want %q", rangeHeader, body, want)
+		}
+	}
+	if !strings.Contains(logged.String(), "at byte 2: first byte after") || !strings.Contains(logged.String(), "from the header cache)") {
+		t.Errorf("log doesn't say the cache served the start:\n%s", logged)
+	}
+
+	// A changed file is read again
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if body := fetchRange(t, app, "a", "bytes=0-"); body != upper {
+		t.Errorf("range after a change: %q", body)
+	}
+	if got := cachedHeaderOf(t, path); got != upper[:8] {
+		t.Errorf("cached header after a change %q", got)
+	}
+
+	// The oldest header goes once the total is reached
+	for _, name := range []string{"b", "c"} {
+		writeFile(t, filepath.Join("movies", name+".mp4"), []byte(testMovie))
+		fetchRange(t, app, name, "bytes=0-")
+	}
+	if cachedHeaderOf(t, path) != "" || cachedHeaderOf(t, filepath.Join("movies", "c.mp4")) != testMovie[:8] {
+		t.Error("the oldest header wasn't evicted")
+	}
+	if _, body := get(t, app, "/metrics"); !strings.Contains(body, "display_header_cache_bytes 16\n") {
+		t.Errorf("metrics lack the cache size:\n%s", body)
+	}
+}
+
+func TestWarmHeaders(t *testing.T) {
+	useHeaderCache(t)
+	_, cfg := newTestServer(t, "-header-cache-bytes", "8", "-header-cache-total", "20")
+	for _, name := range []string{"a", "b", "c"} {
+		writeFile(t, filepath.Join("movies", name+".mp4"), []byte(testMovie))
+	}
+	warmHeaders(cfg)
+	if got := headers.bytes(); got != 16 {
+		t.Errorf("warmed %d bytes, want as many whole headers as fit", got)
+	}
+	if cachedHeaderOf(t, filepath.Join("movies", "a.mp4")) != testMovie[:8] {
+		t.Error("a's header isn't cached")
+	}
+}
+
+func TestHeaderCacheOff(t *testing.T) {
+	useHeaderCache(t)
+	app, cfg := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	warmHeaders(cfg)
+	if body := fetchRange(t, app, "a", "bytes=0-"); body != testMovie || headers.bytes() != 0 {
+		t.Errorf("cached %d bytes without -header-cache-bytes", headers.bytes())
+	}
+
+	for _, args := range [][]string{
+		{"-header-cache-bytes", "-1"},
+		{"-header-cache-bytes", "100", "-header-cache-total", "10"},
+	} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A response header from -headers
+type customHeader struct {
+	name  string
+	value string
+}
+
+// Flag value for -headers, which can be given several times as "Name: Value"
+type headerFlag struct {
+	headers *[]customHeader
+}
+
+func (f headerFlag) String() string {
+	if f.headers == nil {
+		return ""
+	}
+	var parts []string
+	for _, h := range *f.headers {
+		parts = append(parts, h.name+": "+h.value)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (f headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || !validHeaderName(name) {
+		return fmt.Errorf("expected \"Name: Value\" with a valid header name, got %q", s)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("header %s: value must be a single line", name)
+	}
+	*f.headers = append(*f.headers, customHeader{name, value})
+	return nil
+}
+
+// Values in a config file array are set one by one instead of joined with commas,
+// which header values like Content-Security-Policy contain
+func (f headerFlag) repeatable() {}
+
+// Header names are RFC 9110 tokens
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Add the -headers to every response. They are set after the handler ran, so they win over
+// headers the server sets itself. A page that drew a nonce with cspNonce gets it added to
+// its Content-Security-Policy.
+func customHeaders(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		nonce, _ := c.Locals("cspNonce").(string)
+		for _, h := range cfg.Tunables().Headers {
+			if nonce != "" && isCSPHeader(h.name) {
+				c.Set(h.name, withNonce(h.value, nonce))
+				continue
+			}
+			c.Set(h.name, h.value)
+		}
+		return err
+	}
+}
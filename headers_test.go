@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestCustomHeaders(t *testing.T) {
+	csp := "default-src 'self'; img-src 'self' data:, https://cdn.example"
+	app, _ := newTestServer(t, "-headers", "X-Frame-Options: DENY", "-headers", "Content-Security-Policy: "+csp, "-headers", "Accept-Ranges: none")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	for _, target := range []string{"/api/version", "/video/missing", "/no/such/route", "/video/a"} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Range", "bytes=0-")
+		resp, _ := send(t, app, req)
+		if resp.Header.Get("X-Frame-Options") != "DENY" || resp.Header.Get("Content-Security-Policy") != csp {
+			t.Errorf("%s (%d) sent X-Frame-Options %q and Content-Security-Policy %q", target, resp.StatusCode, resp.Header.Get("X-Frame-Options"), resp.Header.Get("Content-Security-Policy"))
+		}
+		// Configured values win over the server's own
+		if target == "/video/a" && (resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Accept-Ranges") != "none") {
+			t.Errorf("video answered %d with Accept-Ranges %q", resp.StatusCode, resp.Header.Get("Accept-Ranges"))
+		}
+	}
+}
+
+func TestCustomHeadersFromConfigFile(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, config, []byte(`{"headers": ["X-CDN: a, b", "X-Frame-Options: DENY"]}`))
+	app, _ := newTestServer(t, "-config", config, "-api-token", testToken)
+	if resp, _ := get(t, app, "/api/version"); resp.Header.Get("X-CDN") != "a, b" || resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("sent X-CDN %q and X-Frame-Options %q", resp.Header.Get("X-CDN"), resp.Header.Get("X-Frame-Options"))
+	}
+
+	writeFile(t, config, []byte(`{"headers": ["X-CDN: c"]}`))
+	req, _ := http.NewRequest(http.MethodPost, "/api/reload", nil)
+	if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("reload answered %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, app, "/api/version"); resp.Header.Get("X-CDN") != "c" || resp.Header.Get("X-Frame-Options") != "" {
+		t.Errorf("after the reload sent X-CDN %q and X-Frame-Options %q", resp.Header.Get("X-CDN"), resp.Header.Get("X-Frame-Options"))
+	}
+}
+
+func TestInvalidCustomHeaders(t *testing.T) {
+	for _, header := range []string{"No colon", ": empty name", "Bad Name: x", "X-Split: a\r\nX-Injected: b", "X-Nul: a\x00"} {
+		if err := (headerFlag{&[]customHeader{}}).Set(header); err == nil {
+			t.Errorf("-headers %q accepted", header)
+		}
+	}
+
+	// A config file with one gets as far as the command line would
+	config := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, config, []byte(`{"headers": ["X-Fine: yes", "Bad Name: x"]}`))
+	if _, err := loadConfig([]string{"-config", config}); err == nil {
+		t.Error("config file with an invalid header accepted")
+	}
+}
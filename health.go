@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Liveness: the process is up and serving requests
+func healthzHandler(c *fiber.Ctx) error {
+	return c.SendString("OK")
+}
+
+// Readiness: every movie directory can be read, so no part of the library is missing,
+// e.g. because a drive isn't mounted
+func readyzHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, root := range cfg.MoviesDirs {
+			info, err := os.Stat(root)
+			if err != nil || !info.IsDir() {
+				return c.Status(fiber.StatusServiceUnavailable).SendString("Movies directory " + root + " is not available.")
+			}
+		}
+		return c.SendString("OK")
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	app, _ := newTestServer(t)
+	for _, target := range []string{"/healthz", "/readyz"} {
+		if resp, body := get(t, app, target); resp.StatusCode != http.StatusOK || body != "OK" {
+			t.Errorf("%s answered %d: %s", target, resp.StatusCode, body)
+		}
+	}
+
+	// Without a library the server is alive but can't serve anything
+	if err := os.Remove("movies"); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get(t, app, "/healthz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("/healthz answered %d without a library", resp.StatusCode)
+	}
+	if resp, _ := get(t, app, "/readyz"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz answered %d without a library", resp.StatusCode)
+	}
+}
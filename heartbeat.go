@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Playback positions reported through the heartbeat, kept in memory and written at most
+// once per -progress-write-interval per movie instead of on every ping
+type progressBuffer struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]pendingProgress
+	written map[string]time.Time
+
+	// Held for a whole flush, so an older position can't be written after a newer one
+	flushMu sync.Mutex
+}
+
+type pendingProgress struct {
+	rid      string
+	entry    progressEntry
+	duration float64
+}
+
+func newProgressBuffer(interval time.Duration) *progressBuffer {
+	return &progressBuffer{interval: interval, pending: map[string]pendingProgress{}, written: map[string]time.Time{}}
+}
+
+// Keep the latest position of a movie, returning whether it is due to be written
+func (b *progressBuffer) report(movieName string, p pendingProgress) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending[movieName] = p
+	return time.Since(b.written[movieName]) >= b.interval
+}
+
+// Take the position to write for a movie, counting it as written now
+func (b *progressBuffer) take(movieName string) (pendingProgress, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.pending[movieName]
+	if ok {
+		delete(b.pending, movieName)
+		b.written[movieName] = time.Now()
+	}
+	return p, ok
+}
+
+// Movies with a position waiting whose last write is at least an interval ago, or all of them
+func (b *progressBuffer) due(all bool) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var names []string
+	for name := range b.pending {
+		if all || time.Since(b.written[name]) >= b.interval {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (b *progressBuffer) latest(movieName string) (progressEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.pending[movieName]
+	return p.entry, ok
+}
+
+func (b *progressBuffer) discard(movieName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pending, movieName)
+}
+
+func (b *progressBuffer) rename(oldName, newName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p, ok := b.pending[oldName]; ok {
+		delete(b.pending, oldName)
+		b.pending[newName] = p
+	}
+}
+
+// Write the movie's waiting position, if it has one. A movie that is gone by now is dropped.
+func flushHeartbeat(cfg *Config, data *userData, movieName string) error {
+	data.heartbeats.flushMu.Lock()
+	defer data.heartbeats.flushMu.Unlock()
+	p, ok := data.heartbeats.take(movieName)
+	if !ok {
+		return nil
+	}
+	movieFilePath, found := findMovie(cfg, movieName)
+	if !found {
+		return nil
+	}
+	return saveProgress(p.rid, data, movieName, movieFilePath, p.entry, p.duration)
+}
+
+func flushHeartbeats(cfg *Config, data *userData, all bool) {
+	for _, name := range data.heartbeats.due(all) {
+		if err := flushHeartbeat(cfg, data, name); err != nil {
+			log.Printf("Could not save progress of %s: %v", name, err)
+		}
+	}
+}
+
+// Write waiting positions once they are due, and everything still waiting on shutdown
+func writeHeartbeats(cfg *Config, data *userData) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	// Checked more often than the interval, so a position isn't held for almost two of them
+	ticker := time.NewTicker(max(data.heartbeats.interval/4, time.Second))
+	for {
+		select {
+		case <-ticker.C:
+			flushHeartbeats(cfg, data, false)
+		case sig := <-stop:
+			flushHeartbeats(cfg, data, true)
+			log.Printf("Saved pending progress, exiting on %s", sig)
+			os.Exit(0)
+		}
+	}
+}
+
+// Body of a heartbeat: a progress report, final when the player is going away
+type heartbeatRequest struct {
+	progressRequest
+	Final bool `json:"final"`
+}
+
+// Take a progress ping. JSON is read whatever the content type, since navigator.sendBeacon
+// sends strings as text/plain. A final ping, e.g. from pagehide, is written right away.
+func heartbeatHandler(cfg *Config, data *userData) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		movieName := c.Params("movie")
+		if _, found := findMovie(cfg, movieName); !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		var req heartbeatRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil || req.Position == nil || *req.Position < 0 || req.Duration < 0 {
+			return c.Status(fiber.StatusBadRequest).SendString(`Expected {"position": seconds}.`)
+		}
+
+		p := pendingProgress{rid: rid, entry: progressEntry{Position: *req.Position, UpdatedAt: time.Now().UTC()}, duration: req.Duration}
+		if data.heartbeats.report(movieName, p) || req.Final {
+			if err := flushHeartbeat(cfg, data, movieName); err != nil {
+				logRequest(rid, "Could not save progress: %v", err)
+				return c.Status(fiber.StatusInternalServerError).SendString("Could not save progress.")
+			}
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
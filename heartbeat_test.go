@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func heartbeat(t *testing.T, app *fiber.App, movie, body string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, "/api/progress/"+movie+"/heartbeat", strings.NewReader(body))
+	// What sendBeacon sends for a string
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	resp, _ := send(t, app, req)
+	return resp.StatusCode
+}
+
+// The position progress.json holds for the movie, -1 for none
+func writtenPosition(t *testing.T, cfg *Config, movie string) float64 {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(cfg.DataDir, "progress.json"))
+	if os.IsNotExist(err) {
+		return -1
+	}
+	var progress progressSet
+	if err := json.Unmarshal(content, &progress); err != nil {
+		t.Fatalf("%v: %s", err, content)
+	}
+	if entry, ok := progress[movie]; ok {
+		return entry.Position
+	}
+	return -1
+}
+
+func TestHeartbeat(t *testing.T) {
+	_, cfg := newTestServer(t, "-progress-write-interval", "300ms")
+	data, err := openUserData(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newApp(cfg, data)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	expect := func(what string, want float64) {
+		t.Helper()
+		if got := writtenPosition(t, cfg, "a"); got != want {
+			t.Errorf("%s: progress.json has %g, want %g", what, got, want)
+		}
+	}
+
+	// The first ping is written, those right after it only kept
+	for _, body := range []string{`{"position": 10}`, `{"position": 20}`, `{"position": 30}`} {
+		if status := heartbeat(t, app, "a", body); status != http.StatusNoContent {
+			t.Fatalf("heartbeat %s answered %d", body, status)
+		}
+	}
+	expect("after three pings", 10)
+	if _, body := get(t, app, "/api/progress/a"); !strings.Contains(body, `"position":30`) {
+		t.Errorf("progress doesn't include the waiting ping: %s", body)
+	}
+	flushHeartbeats(cfg, data, false)
+	expect("flush before the interval", 10)
+
+	// Once the interval is over the background flush writes the latest one
+	time.Sleep(cfg.ProgressWriteInterval)
+	flushHeartbeats(cfg, data, false)
+	expect("flush after the interval", 30)
+
+	// A final ping is written right away
+	heartbeat(t, app, "a", `{"position": 40}`)
+	heartbeat(t, app, "a", `{"position": 50, "final": true}`)
+	expect("final ping", 50)
+
+	// A PUT supersedes a waiting ping
+	heartbeat(t, app, "a", `{"position": 60}`)
+	if status, body := reportProgress(t, app, "a", `{"position": 55}`); status != http.StatusOK {
+		t.Fatalf("progress answered %d: %s", status, body)
+	}
+	flushHeartbeats(cfg, data, true)
+	expect("PUT after a ping", 55)
+
+	// Shutdown writes whatever is still waiting
+	heartbeat(t, app, "a", `{"position": 70}`)
+	expect("ping right after a write", 55)
+	flushHeartbeats(cfg, data, true)
+	expect("flush on shutdown", 70)
+
+	// Written pings mark the movie watched like PUTs do
+	heartbeat(t, app, "a", `{"position": 95, "duration": 100, "final": true}`)
+	if got := listMovieSet(t, app, "watched"); got != "a" {
+		t.Errorf("watched after a final ping near the end: %q", got)
+	}
+
+	for body, want := range map[string]int{`{"duration": 100}`: http.StatusBadRequest, `{"position": -1}`: http.StatusBadRequest, `not json`: http.StatusBadRequest} {
+		if status := heartbeat(t, app, "a", body); status != want {
+			t.Errorf("heartbeat %s answered %d", body, status)
+		}
+	}
+	if status := heartbeat(t, app, "missing", `{"position": 1}`); status != http.StatusNotFound {
+		t.Errorf("heartbeat for a missing movie answered %d", status)
+	}
+}
+
+func TestHeartbeatRename(t *testing.T) {
+	_, cfg := newTestServer(t, "-progress-write-interval", "1h", "-api-token", testToken)
+	data, err := openUserData(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newApp(cfg, data)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	heartbeat(t, app, "a", `{"position": 10}`)
+	heartbeat(t, app, "a", `{"position": 20}`)
+	if resp, body := renameMovie(t, app, "a", "b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	flushHeartbeats(cfg, data, true)
+	if a, b := writtenPosition(t, cfg, "a"), writtenPosition(t, cfg, "b"); a != -1 || b != 20 {
+		t.Errorf("after the rename progress.json has %g for a and %g for b", a, b)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A server over an empty library, with the given flags: movies are looked up in movies/ of
+// the working directory, so the test runs in a temporary one
+func newTestServer(t *testing.T, args ...string) (*fiber.App, *Config) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "movies"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The player's template is read from the working directory too
+	page, err := os.ReadFile("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "index.html"), page)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	cfg, err := loadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// POST /api/reload reads the command line again
+	commandLine := os.Args
+	os.Args = append([]string{commandLine[0]}, args...)
+	t.Cleanup(func() { os.Args = commandLine })
+	data, err := openUserData(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The catalog cache is shared, a listing of an earlier test's library must not be answered
+	catalog.mu.Lock()
+	catalog.movies, catalog.scanned = nil, time.Time{}
+	catalog.generation++
+	catalog.mu.Unlock()
+	return newApp(cfg, data), cfg
+}
+
+// Write a file, creating its directory
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Send a request through the app and read the whole response
+func send(t *testing.T, app *fiber.App, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// A name as it appears in a request path
+func escapePath(name string) string {
+	return url.PathEscape(name)
+}
+
+func get(t *testing.T, app *fiber.App, target string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	return send(t, app, req)
+}
+
+// How one run of a faked tool ends, exit -1 meaning killed by a signal. A non-empty output
+// is written to the file named by the tool's last argument, like ffmpeg's output file,
+// before the run sleeps for the given time. prepare, when set, is called with the
+// arguments as the run starts, e.g. to write several output files.
+type toolRun struct {
+	stdout, stderr, output string
+	sleep                  time.Duration
+	exit                   int
+	prepare                func(args []string)
+}
+
+// Run this test binary in place of the tool, each run ending like the next of runs and the
+// last one repeating. Returns how often it was started.
+func scriptTool(t *testing.T, tool string, runs ...toolRun) *atomic.Int32 {
+	t.Helper()
+	var started atomic.Int32
+	command := execCommand
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		if name != tool {
+			return command(name, args...)
+		}
+		run := runs[min(int(started.Add(1)), len(runs))-1]
+		if run.prepare != nil {
+			run.prepare(args)
+		}
+		cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestHelperProcess$", "--"}, args...)...)
+		cmd.Env = append(os.Environ(), "HELPER_PROCESS=1", "HELPER_STDOUT="+run.stdout, "HELPER_STDERR="+run.stderr, "HELPER_OUTPUT="+base64.StdEncoding.EncodeToString([]byte(run.output)), "HELPER_SLEEP="+run.sleep.String(), "HELPER_EXIT="+strconv.Itoa(run.exit))
+		return cmd
+	}
+	t.Cleanup(func() { execCommand = command })
+	return &started
+}
+
+// Stand-in for ffmpeg and ffprobe started by scriptTool
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("HELPER_PROCESS") != "1" {
+		return
+	}
+	// Base64 in the environment, which can't hold binary output
+	if output, _ := base64.StdEncoding.DecodeString(os.Getenv("HELPER_OUTPUT")); len(output) > 0 {
+		if err := os.WriteFile(os.Args[len(os.Args)-1], output, 0o644); err != nil {
+			os.Exit(2)
+		}
+	}
+	fmt.Print(os.Getenv("HELPER_STDOUT"))
+	fmt.Fprint(os.Stderr, os.Getenv("HELPER_STDERR"))
+	if sleep, _ := time.ParseDuration(os.Getenv("HELPER_SLEEP")); sleep > 0 {
+		time.Sleep(sleep)
+	}
+	exit, _ := strconv.Atoi(os.Getenv("HELPER_EXIT"))
+	if exit == -1 {
+		syscall.Kill(os.Getpid(), syscall.SIGKILL)
+	}
+	os.Exit(exit)
+}
+
+// Probe for the real tools, skipping the test unless all of them are installed
+func requireTools(t *testing.T, tools ...string) {
+	t.Helper()
+	available, breakers := availableTools, toolBreakers
+	availableTools, toolBreakers = map[string]bool{}, map[string]*toolBreaker{}
+	t.Cleanup(func() { availableTools, toolBreakers = available, breakers })
+	probeTools(&Config{})
+	for _, tool := range tools {
+		if !haveTool(tool) {
+			t.Skip(tool + " is not installed")
+		}
+	}
+}
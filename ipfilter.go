@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Parse a comma-separated list of CIDR ranges, a bare address standing for itself
+func parseCIDRs(flag, list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address range %q in -%s, expected e.g. 192.168.1.0/24 or 10.0.0.5", entry, flag)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func inRanges(addr netip.Addr, prefixes []netip.Prefix) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// The address of the client: the peer, unless the peer is a trusted proxy, in which case
+// the last address in X-Forwarded-For that isn't one. Walking from the right matters,
+// clients can put anything at the start of the header. Peers on the Unix socket are the
+// local reverse proxy by definition.
+func clientAddr(cfg *Config, c *fiber.Ctx) netip.Addr {
+	peer, _ := netip.AddrFromSlice(c.Context().RemoteIP())
+	peer = peer.Unmap()
+	_, overTCP := c.Context().RemoteAddr().(*net.TCPAddr)
+	if overTCP && !inRanges(peer, cfg.TrustedProxies) {
+		return peer
+	}
+
+	hops := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !inRanges(client, cfg.TrustedProxies) {
+			break
+		}
+	}
+	return client
+}
+
+func clientIP(cfg *Config, c *fiber.Ctx) string {
+	return clientAddr(cfg, c).String()
+}
+
+// Refuse clients outside -allow-ips or inside -deny-ips, deny winning when both match
+func ipFilter(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if len(cfg.AllowIPs) == 0 && len(cfg.DenyIPs) == 0 {
+			return c.Next()
+		}
+		addr := clientAddr(cfg, c)
+		if inRanges(addr, cfg.DenyIPs) || (len(cfg.AllowIPs) > 0 && !inRanges(addr, cfg.AllowIPs)) {
+			return c.Status(fiber.StatusForbidden).SendString("Access denied.")
+		}
+		return c.Next()
+	}
+}
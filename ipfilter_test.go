@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIPFilter(t *testing.T) {
+	// app.Test connects from 0.0.0.0, trusting it lets X-Forwarded-For name any client
+	trusted := []string{"-trusted-proxies", "0.0.0.0"}
+	for _, tt := range []struct {
+		name, forwardedFor string
+		args               []string
+		status             int
+	}{
+		{"no lists", "192.0.2.1", nil, http.StatusOK},
+		{"allowed address", "192.0.2.1", []string{"-allow-ips", "192.0.2.1"}, http.StatusOK},
+		{"other address", "192.0.2.2", []string{"-allow-ips", "192.0.2.1"}, http.StatusForbidden},
+		{"start of the range", "10.1.2.4", []string{"-allow-ips", "10.1.2.4/30"}, http.StatusOK},
+		{"end of the range", "10.1.2.7", []string{"-allow-ips", "10.1.2.4/30"}, http.StatusOK},
+		{"past the range", "10.1.2.8", []string{"-allow-ips", "10.1.2.4/30"}, http.StatusForbidden},
+		{"before the range", "10.1.2.3", []string{"-allow-ips", "10.1.2.4/30"}, http.StatusForbidden},
+		{"unmasked range", "10.1.2.5", []string{"-allow-ips", "10.1.2.6/30"}, http.StatusOK},
+		{"denied address", "192.0.2.9", []string{"-deny-ips", "192.0.2.9"}, http.StatusForbidden},
+		{"not denied", "192.0.2.10", []string{"-deny-ips", "192.0.2.9"}, http.StatusOK},
+		{"deny wins", "192.168.1.66", []string{"-allow-ips", "192.168.1.0/24", "-deny-ips", "192.168.1.64/28"}, http.StatusForbidden},
+		{"allowed next to the denied", "192.168.1.80", []string{"-allow-ips", "192.168.1.0/24", "-deny-ips", "192.168.1.64/28"}, http.StatusOK},
+		{"IPv6 range", "2001:db8::1", []string{"-allow-ips", "2001:db8::/32"}, http.StatusOK},
+		{"IPv6 outside", "2001:db9::1", []string{"-allow-ips", "2001:db8::/32"}, http.StatusForbidden},
+		{"mapped IPv4", "::ffff:192.0.2.1", []string{"-allow-ips", "192.0.2.0/24"}, http.StatusOK},
+		{"mapped range", "192.0.2.1", []string{"-allow-ips", "::ffff:192.0.2.0/120"}, http.StatusOK},
+		// The proxy appends the address it sees, whatever the client sent before it
+		{"spoofed start of the header", "192.0.2.1, 203.0.113.5", []string{"-allow-ips", "192.0.2.1"}, http.StatusForbidden},
+		{"chain of trusted proxies", "192.0.2.1, 10.0.0.2", []string{"-allow-ips", "192.0.2.1", "-trusted-proxies", "0.0.0.0,10.0.0.0/8"}, http.StatusOK},
+	} {
+		args := append(append([]string{}, trusted...), tt.args...)
+		app, _ := newTestServer(t, args...)
+		req, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+		req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		resp, body := send(t, app, req)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: answered %d: %s", tt.name, resp.StatusCode, body)
+		}
+	}
+
+	// Without a trusted proxy the header is only the client's claim
+	app, _ := newTestServer(t, "-allow-ips", "192.0.2.1")
+	req, _ := http.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	if resp, body := send(t, app, req); resp.StatusCode != http.StatusForbidden || body != "Access denied." {
+		t.Errorf("untrusted X-Forwarded-For answered %d: %s", resp.StatusCode, body)
+	}
+
+	for _, list := range []string{"192.0.2", "192.0.2.0/33", "example.com", "10.0.0.0/8;10.1.0.0/16"} {
+		if _, err := loadConfig([]string{"-deny-ips", list}); err == nil {
+			t.Errorf("-deny-ips %q accepted", list)
+		}
+	}
+}
+
+func TestIPFilterOverTCP(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"-allow-ips", "127.0.0.1"}, http.StatusOK},
+		{[]string{"-deny-ips", "127.0.0.0/8"}, http.StatusForbidden},
+	} {
+		app, _ := newTestServer(t, tt.args...)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go app.Listener(ln)
+		resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: answered %d", strings.Join(tt.args, " "), resp.StatusCode)
+		}
+		app.ShutdownWithTimeout(time.Second)
+	}
+}
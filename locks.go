@@ -0,0 +1,11 @@
+package main
+
+import "sync"
+
+// Lock the mutex for key in m, creating it on first use, and return the unlock function.
+// Used so concurrent requests for the same movie don't run the same ffmpeg job twice.
+func lockKey(m *sync.Map, key string) func() {
+	lock, _ := m.LoadOrStore(key, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+// Access log line, with the request ID so it can be matched to the handler's own log lines
+const accessLogFormat = "${time} | ${locals:requestid} | ${status} | ${latency} | ${clientip} | ${method} | ${path} | ${error}\n"
+
+// Random per process, so hashed IPs can't be reversed with a lookup table
+var ipSalt = func() []byte {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return salt
+}()
+
+// How a client shows up in logs: its IP, or with -no-ip-log a salted hash of it,
+// which still tells repeat visitors apart without storing the address
+func clientLabel(cfg *Config, ip string) string {
+	if !cfg.NoIPLog {
+		return ip
+	}
+	sum := sha256.Sum256(append(append([]byte{}, ipSalt...), ip...))
+	return "client-" + hex.EncodeToString(sum[:6])
+}
+
+// Access logger middleware
+func newAccessLogger(cfg *Config) fiber.Handler {
+	return logger.New(logger.Config{
+		Next: func(c *fiber.Ctx) bool {
+			return cfg.Tunables().LogSkip[c.Path()]
+		},
+		Format: accessLogFormat,
+		Output: io.MultiWriter(os.Stdout, recentLogs),
+		CustomTags: map[string]logger.LogFunc{
+			"clientip": func(output logger.Buffer, c *fiber.Ctx, _ *logger.Data, _ string) (int, error) {
+				return output.WriteString(clientLabel(cfg, clientIP(cfg, c)))
+			},
+		},
+	})
+}
+
+// ID assigned to the request by the requestid middleware
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
+
+// Log a line tagged with the ID of the request it belongs to
+func logRequest(id string, format string, args ...any) {
+	log.Printf("["+id+"] "+format, args...)
+}
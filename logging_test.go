@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Log output collected by captureLog, written to by handlers and stream writers at once
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Collect what is logged while the test runs
+func captureLog(t *testing.T) *logBuffer {
+	t.Helper()
+	logged, previous := &logBuffer{}, log.Writer()
+	log.SetOutput(logged)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return logged
+}
+
+func TestRequestID(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), nil)
+	logged := captureLog(t)
+
+	req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+	req.Header.Set("X-Request-ID", "client-id")
+	resp, _ := send(t, app, req)
+	if got := resp.Header.Get("X-Request-ID"); got != "client-id" {
+		t.Errorf("X-Request-ID %q, want the client's", got)
+	}
+	if !strings.Contains(logged.String(), "[client-id] Movie file") {
+		t.Errorf("log lacks the request ID:\n%s", logged)
+	}
+
+	resp, _ = get(t, app, "/video/a")
+	id := resp.Header.Get("X-Request-ID")
+	if id == "" || !strings.Contains(logged.String(), "["+id+"] Movie file") {
+		t.Errorf("generated request ID %q not in the log:\n%s", id, logged)
+	}
+}
+
+// The access log of a server started with the given flags, for one request to target
+func accessLog(t *testing.T, target string, args ...string) string {
+	t.Helper()
+	// The access logger writes to the stdout it finds when the server is made
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	app, _ := newTestServer(t, args...)
+	os.Stdout = stdout
+
+	get(t, app, target)
+	w.Close()
+	logged, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(logged)
+}
+
+func TestNoIPLog(t *testing.T) {
+	line := accessLog(t, "/api/version")
+	ip := strings.Fields(strings.Split(line, "|")[4])[0]
+	if strings.HasPrefix(ip, "client-") {
+		t.Fatalf("the client's address is hashed without -no-ip-log: %s", line)
+	}
+	line = accessLog(t, "/api/version", "-no-ip-log")
+	if strings.Contains(line, ip) || !strings.Contains(line, "| client-") {
+		t.Errorf("with -no-ip-log the access log still has the address %s: %s", ip, line)
+	}
+	if first, second := accessLog(t, "/api/version", "-no-ip-log"), line; strings.Split(first, "|")[4] != strings.Split(second, "|")[4] {
+		t.Errorf("one client is logged as two: %s and %s", first, second)
+	}
+}
+
+func TestLogSkip(t *testing.T) {
+	for _, tt := range []struct {
+		target string
+		args   []string
+		logged bool
+	}{
+		{"/healthz", nil, false},
+		{"/readyz", nil, false},
+		{"/metrics", nil, false},
+		{"/video/a", nil, true},
+		{"/api/version", nil, true},
+		{"/healthz", []string{"-log-skip", ""}, true},
+		{"/metrics", []string{"-log-skip", "/healthz"}, true},
+		{"/api/version", []string{"-log-skip", "/api/version"}, false},
+	} {
+		line := accessLog(t, tt.target, tt.args...)
+		if logged := strings.Contains(line, tt.target); logged != tt.logged {
+			t.Errorf("%s with %v: logged is %t, want %t: %q", tt.target, tt.args, logged, tt.logged, line)
+		}
+	}
+}
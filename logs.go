@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Lines a slow log viewer may fall behind by before lines are dropped for it
+const logSubscriberBuffer = 256
+
+// Comment sent to idle log viewers, so a closed connection is noticed
+const logHeartbeat = 15 * time.Second
+
+type logLine struct {
+	id   uint64
+	text string
+}
+
+// Recent log lines kept in memory for /api/logs/stream. Both the standard logger and the
+// access logger write here as well as to their usual output.
+type logRing struct {
+	mu          sync.Mutex
+	lines       []logLine
+	size        int
+	next        uint64
+	subscribers map[chan logLine]bool
+}
+
+var recentLogs = &logRing{size: 1000, subscribers: map[chan logLine]bool{}}
+
+// Every write from the loggers is one or more whole lines
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, text := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		r.next++
+		line := logLine{id: r.next, text: text}
+		if len(r.lines) >= r.size {
+			r.lines = r.lines[len(r.lines)-r.size+1:]
+		}
+		r.lines = append(r.lines, line)
+		for ch := range r.subscribers {
+			select {
+			case ch <- line:
+			default:
+				// The viewer can't keep up, it misses this line rather than holding up logging
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Start receiving new lines, along with the buffered ones after the given ID
+func (r *logRing) subscribe(after uint64) (chan logLine, []logLine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch := make(chan logLine, logSubscriberBuffer)
+	r.subscribers[ch] = true
+	var backlog []logLine
+	for _, line := range r.lines {
+		if line.id > after {
+			backlog = append(backlog, line)
+		}
+	}
+	return ch, backlog
+}
+
+func (r *logRing) unsubscribe(ch chan logLine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subscribers, ch)
+}
+
+// Server-Sent Events with the recent log lines, then new ones as they are written. Each
+// event carries the line's ID, so a reconnecting EventSource continues where it left off.
+func logStreamHandler(c *fiber.Ctx) error {
+	after, _ := strconv.ParseUint(c.Get("Last-Event-ID"), 10, 64)
+	ch, backlog := recentLogs.subscribe(after)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	// Keeps nginx from buffering the stream
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer recentLogs.unsubscribe(ch)
+
+		for _, line := range backlog {
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", line.id, line.text)
+		}
+		if w.Flush() != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(logHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case line := <-ch:
+				fmt.Fprintf(w, "id: %d\ndata: %s\n\n", line.id, line.text)
+			case <-heartbeat.C:
+				w.WriteString(": keep-alive\n\n")
+			}
+			if w.Flush() != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
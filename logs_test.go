@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Read the next Server-Sent Event as "id: data"
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var id, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event stream ended: %v", err)
+		}
+		switch line = strings.TrimSuffix(line, "\n"); {
+		case line == "" && data != "":
+			return id + ": " + data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestLogStream(t *testing.T) {
+	ring, previous := &logRing{size: 3, subscribers: map[chan logLine]bool{}}, recentLogs
+	recentLogs = ring
+	t.Cleanup(func() { recentLogs = previous })
+	flags := log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() { log.SetFlags(flags) })
+	captureLog(t)
+	log.SetOutput(ring)
+	for i := 1; i <= 5; i++ {
+		log.Printf("line %d", i)
+	}
+
+	// Keep the stream's own requests out of what it shows
+	app, _ := newTestServer(t, "-api-token", testToken, "-log-skip", "/api/logs/stream")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.ShutdownWithTimeout(time.Second) })
+	connect := func(lastEventID string) *bufio.Reader {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/api/logs/stream", nil)
+		req.Header.Set("Accept-Encoding", "br, gzip")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(authorized(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" || resp.Header.Get("Content-Encoding") != "" {
+			t.Fatalf("log stream answered %d with %q, encoded as %q", resp.StatusCode, resp.Header.Get("Content-Type"), resp.Header.Get("Content-Encoding"))
+		}
+		return bufio.NewReader(resp.Body)
+	}
+
+	if resp, _ := get(t, app, "/api/logs/stream"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("log stream without the token answered %d", resp.StatusCode)
+	}
+
+	// The buffered lines, as many as fit, then new ones as they are logged
+	stream := connect("")
+	for i := 3; i <= 5; i++ {
+		if event, want := readEvent(t, stream), fmt.Sprintf("%d: line %d", i, i); event != want {
+			t.Fatalf("backlog event %q, want %q", event, want)
+		}
+	}
+	log.Print("line 6")
+	if event := readEvent(t, stream); event != "6: line 6" {
+		t.Errorf("new line delivered as %q", event)
+	}
+
+	// A reconnecting viewer only gets what it missed
+	stream = connect("5")
+	if event := readEvent(t, stream); event != "6: line 6" {
+		t.Errorf("after Last-Event-ID 5 got %q", event)
+	}
+	log.Print("line 7")
+	if event := readEvent(t, stream); event != "7: line 7" {
+		t.Errorf("new line after reconnecting delivered as %q", event)
+	}
+}
@@ -1,206 +1,196 @@
 package main
 
 import (
-	"fmt"
-	"html/template"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/pprof"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
-// Template data structure
-type PageData struct {
-	Title       string
-	MovieName   string
-	ContentType string
+func main() {
+	cfg := parseConfig()
+
+	// Keep recent log lines for /api/logs/stream as well
+	recentLogs.size = cfg.LogBuffer
+	log.SetOutput(io.MultiWriter(os.Stderr, recentLogs))
+
+	data, err := openUserData(cfg)
+	if err != nil {
+		log.Fatalf("Could not load %v", err)
+	}
+
+	// Heartbeat progress is written in the background, and on shutdown
+	go writeHeartbeats(cfg, data)
+
+	// Find out up front which of the ffmpeg-based features can work
+	probeTools(cfg)
+	log.Printf("Starting with %s", startupSummary(cfg))
+
+	// Apply -cache-size to what earlier runs left behind
+	go pruneCache(cfg)
+
+	// MP4s with their index at the end can't start until fully downloaded
+	go warnSlowStarts(cfg)
+
+	// Movie headers in memory for -header-cache-bytes
+	go warmHeaders(cfg)
+
+	if cfg.StreamIdleTimeout > 0 {
+		go reapIdleStreams(cfg.StreamIdleTimeout)
+	}
+
+	app := newApp(cfg, data)
+
+	// Start server, by default on all network interfaces at port 3000
+	log.Fatal(listen(app, cfg))
 }
 
-func main() {
-	app := fiber.New()
-	app.Use(logger.New()) // Logger for tracking requests
+// The server with its middleware and routes, without listening yet
+func newApp(cfg *Config, data *userData) *fiber.App {
+	app := fiber.New(fiber.Config{
+		// Stream writers and caches keep request values after the handler returns
+		Immutable: true,
+		// URLs escape movie names, so /video/The%20Matrix is the movie "The Matrix"
+		UnescapePath: true,
+		// Uploads are streamed to disk instead of being buffered in memory. Bodies over the
+		// BodyLimit are streamed too rather than refused, limitBody and the uploads limit them.
+		StreamRequestBody: true,
+		// Only applies between requests, a stream in progress is never idle
+		DisableKeepalive: !cfg.KeepAlive,
+		IdleTimeout:      cfg.IdleTimeout,
+	})
+	// Uploads that would be refused are refused before their body is sent
+	app.Server().ContinueHandler = continueUpload(cfg)
+
+	app.Use(requestid.New())      // X-Request-ID, reusing the client's when it sends one
+	app.Use(newAccessLogger(cfg)) // Logger for tracking requests
+	app.Use(customHeaders(cfg))   // Headers from -headers on every response
+	app.Use(ipFilter(cfg))        // 403 for clients outside -allow-ips or in -deny-ips
+	app.Use(limitBody())          // 413 for bodies over 64 KB, except uploads
+	useCORS(app, cfg)             // Access from pages on other origins, per -cors-origins
+
+	// Compress text responses, with Brotli when the client accepts it and gzip otherwise. Video
+	// is already compressed and must keep its byte ranges intact. Any range is left alone, a
+	// compressed body wouldn't match its Content-Range. The log stream has to reach viewers
+	// line by line rather than in compressed blocks. -compress-skip adds more paths.
+	app.Use(compress.New(compress.Config{
+		Level: cfg.Compression,
+		Next: func(c *fiber.Ctx) bool {
+			return strings.HasPrefix(c.Path(), "/video/") || strings.HasPrefix(c.Path(), "/remote/") || strings.HasPrefix(c.Path(), "/download-folder/") ||
+				c.Path() == "/api/logs/stream" || c.Get(fiber.HeaderRange) != "" || cfg.skipsCompression(c.Path())
+		},
+	}))
+
+	// Kiosk-style setups send visitors straight to one page
+	if cfg.RootRedirect != "" {
+		app.Get("/", func(c *fiber.Ctx) error {
+			return c.Redirect(cfg.RootRedirect, fiber.StatusFound)
+		})
+	}
+	// Otherwise a fresh install explains where the movies go
+	app.Get("/", welcomeHandler(cfg))
 
 	// Route to serve the HTML player
-	app.Get("/stream/:movie", func(c *fiber.Ctx) error {
-		movieName := c.Params("movie")
-		var movieFilePath string
-		supportedExtensions := []string{"mp4", "mkv"}
-		found := false
-
-		// Locate file with supported extension
-		for _, ext := range supportedExtensions {
-			path := fmt.Sprintf("movies/%s.%s", movieName, ext)
-			if _, err := os.Stat(path); err == nil {
-				movieFilePath = path
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
-		}
-
-		// Set the correct content type based on file extension
-		ext := strings.ToLower(filepath.Ext(movieFilePath))
-		var contentType string
-		switch ext {
-		case ".mp4":
-			contentType = "video/mp4"
-		case ".mkv":
-			contentType = "video/x-matroska"
-		default:
-			return c.Status(fiber.StatusForbidden).SendString("Unsupported file format.")
-		}
-
-		// Parse and execute the HTML template
-		tmpl, err := template.ParseFiles("index.html")
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to load HTML template.")
-		}
-
-		data := PageData{
-			Title:       fmt.Sprintf("Streaming %s", movieName),
-			MovieName:   movieName,
-			ContentType: contentType,
-		}
-
-		// Render the template into the response
-		var renderedPage strings.Builder
-		if err := tmpl.Execute(&renderedPage, data); err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to render HTML template.")
-		}
-
-		return c.Status(fiber.StatusOK).Type("html").SendString(renderedPage.String())
-	})
+	app.Get("/stream/:movie", playerHandler(cfg))
 
-	// Route for serving the video file with range support
-	app.Get("/video/:movie", func(c *fiber.Ctx) error {
-		movieName := c.Params("movie")
-		var movieFilePath string
-		supportedExtensions := []string{"mp4", "mkv"}
-		found := false
-
-		// Locate file path for video file
-		for _, ext := range supportedExtensions {
-			path := fmt.Sprintf("movies/%s.%s", movieName, ext)
-			if _, err := os.Stat(path); err == nil {
-				movieFilePath = path
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
-		}
-
-		file, err := os.Open(movieFilePath)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Could not open video file.")
-		}
-		defer file.Close()
-
-		// Get file size
-		fileInfo, err := file.Stat()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Could not get file info.")
-		}
-		fileSize := fileInfo.Size()
-
-		// Set headers for content type and range support
-		ext := strings.ToLower(filepath.Ext(movieFilePath))
-		switch ext {
-		case ".mp4":
-			c.Set("Content-Type", "video/mp4")
-		case ".mkv":
-			c.Set("Content-Type", "video/x-matroska")
-		}
-		c.Set("Accept-Ranges", "bytes")
-
-		// Handle range requests
-		rangeHeader := c.Get("Range")
-		if rangeHeader == "" {
-			// If no range is specified, send the first 2MB for fast starting
-			c.Set("Content-Length", strconv.FormatInt(2*1024*1024, 10)) // 2 MB
-			return c.SendFile(movieFilePath)
-		}
-
-		// Parse the range header (e.g., bytes=0-1048575)
-		rangeParts := strings.Split(rangeHeader, "=")
-		// Check if the first value is 'bytes', and the second value is a valid range
-		if len(rangeParts) != 2 || rangeParts[0] != "bytes" {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid Range header.")
-		}
-
-		rangeValues := strings.Split(rangeParts[1], "-")
-		// Now check if the first value is defined, and if the second is empty then set it to a large value which should correspond to the start and the file.
-		if rangeValues[0] == "" {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid Range header.")
-		}
-		start, err := strconv.ParseInt(rangeValues[0], 10, 64)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid start byte in Range header.")
-		}
-		end := start + 2*1024*1024 - 1 // 2 MB
-
-		// Ensure the 'start' is within the file size
-		if start < 0 || start >= fileSize {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid start byte in Range header.")
-		}
-
-		// Make sure the range does not exceed the file size
-		if end >= fileSize {
-			end = fileSize - 1
-		}
-
-		// Calculate the length of the data to be sent
-		length := end - start + 1
-
-		// Set headers for partial content
-		c.Status(fiber.StatusPartialContent)
-		c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
-		c.Set("Content-Length", strconv.FormatInt(length, 10))
-
-		// Stream the requested byte range
-		file.Seek(start, 0)
-		buffer := make([]byte, 6144) // Read in 6KB chunks (adjustable)
-		bytesSent := int64(0)
-
-		for bytesSent < length {
-			remaining := length - bytesSent
-			readSize := int64(len(buffer))
-			if remaining < readSize {
-				readSize = remaining
-			}
-
-			n, err := file.Read(buffer[:readSize])
-			if err != nil && err.Error() != "EOF" { // Handle error other than EOF
-				log.Printf("Error reading file: %v", err)
-				break
-			}
-
-			// Ensure that we don't break prematurely
-			if n == 0 {
-				break
-			}
-
-			// Write the data chunk to the response
-			if _, err := c.Write(buffer[:n]); err != nil {
-				log.Printf("Failed to send video content: %v", err)
-				return c.Status(fiber.StatusInternalServerError).SendString("Failed to send video content.")
-			}
-
-			bytesSent += int64(n)
-		}
-
-		return nil
-	})
+	// Manifest, service worker and icons that make the player installable
+	if cfg.PWA {
+		app.Get("/manifest.json", manifestHandler)
+		app.Get("/sw.js", serviceWorkerHandler)
+		app.Get("/icons/:file", iconHandler)
+	}
 
-	// Start server on all network interfaces at port 3000
-	log.Fatal(app.Listen("0.0.0.0:3000"))
+	// Route for serving the video file with range support
+	app.Get("/video/:movie", limitPerClient(cfg), videoHandler(cfg))
+
+	// Files of -remote-url relayed with their ranges
+	if cfg.RemoteURL != nil {
+		app.Get("/remote/*", limitPerClient(cfg), remoteHandler(cfg))
+	}
+
+	// Route for the movie poster, falling back to a placeholder
+	app.Get("/poster/:movie", posterHandler(cfg))
+
+	// Route for subtitles, converted to WebVTT when needed
+	app.Get("/subtitles/:movie", subtitleHandler(cfg))
+
+	// Routes for the DASH manifest and segments, packaged on first request
+	app.Get("/dash/:movie/:file", dashHandler(cfg))
+
+	// The library listing, e.g. /api/movies?format=mp4,webm
+	app.Get("/api/movies", moviesHandler(cfg))
+	app.Get("/api/movies/:movie/playback", playbackHandler(cfg))
+	app.Get("/api/next/:movie", nextHandler(cfg))
+	app.Get("/api/movies/:movie/sources", sourcesHandler(cfg))
+	app.Get("/api/movies/:movie/fileinfo", fileInfoHandler(cfg))
+	app.Get("/api/movies/:movie/subtitles", subtitleTracksHandler(cfg))
+	app.Get("/api/movies/:movie/exists", movieExistsHandler(cfg))
+	app.Get("/api/duplicates", duplicatesHandler(cfg))
+
+	// Thumbnails for seek bar previews, a sprite sheet and the WebVTT track mapping times to tiles
+	app.Get("/sprite/:movie", spriteHandler(cfg, false))
+	app.Get("/sprite/:movie/thumbnails.vtt", spriteHandler(cfg, true))
+	app.Get("/thumbnails/:movie/track.vtt", spriteHandler(cfg, true))
+
+	// A short clip of the start of the movie, for previews while browsing
+	app.Get("/preview/:movie", previewHandler(cfg))
+
+	// Watch parties, relaying play, pause and seek between players in the same room
+	app.Get("/ws/sync/:room", partyUpgrade, websocket.New(partyHandler))
+
+	// Favorites, watched movies and playback progress, shared by everyone using the server
+	app.Get("/api/favorites", listMovieSetHandler(cfg, data.favorites))
+	app.Put("/api/favorites/:movie", writable(cfg), addToMovieSetHandler(cfg, data.favorites))
+	app.Delete("/api/favorites/:movie", writable(cfg), removeFromMovieSetHandler(data.favorites))
+	app.Get("/api/watched", listMovieSetHandler(cfg, data.watched))
+	app.Put("/api/watched/:movie", writable(cfg), addToMovieSetHandler(cfg, data.watched))
+	app.Delete("/api/watched/:movie", writable(cfg), removeFromMovieSetHandler(data.watched))
+	app.Get("/api/progress/:movie", getProgressHandler(data))
+	app.Put("/api/progress/:movie", writable(cfg), putProgressHandler(cfg, data))
+	app.Post("/api/progress/:movie/heartbeat", writable(cfg), heartbeatHandler(cfg, data))
+
+	// Build information
+	app.Get("/api/version", versionHandler)
+	app.Get("/api/bandwidth", bandwidthHandler)
+
+	// Whole folders as a ZIP, e.g. a season of a show
+	app.Get("/download-folder/*", requireAuth(cfg), limitPerClient(cfg), downloadFolderHandler(cfg))
+
+	// Library management
+	app.Patch("/api/movies/:movie", requireAuth(cfg), writable(cfg), renameHandler(cfg, data))
+	app.Put("/api/upload/:file", requireAuth(cfg), writable(cfg), uploadHandler(cfg))
+	app.Post("/api/uploads", requireAuth(cfg), writable(cfg), tusCreateHandler(cfg))
+	app.Head("/api/uploads/:id", requireAuth(cfg), tusOffsetHandler(cfg))
+	app.Patch("/api/uploads/:id", requireAuth(cfg), writable(cfg), tusAppendHandler(cfg))
+	app.Delete("/api/uploads/:id", requireAuth(cfg), writable(cfg), tusDeleteHandler(cfg))
+	app.Put("/api/movies/:movie/meta", requireAuth(cfg), writable(cfg), putMetaHandler(cfg))
+	app.Post("/api/movies/:movie/faststart", requireAuth(cfg), writable(cfg), faststartHandler(cfg))
+	app.Post("/api/movies/:movie/regenerate", requireAuth(cfg), writable(cfg), regenerateHandler(cfg))
+	app.Post("/api/reload", requireAuth(cfg), reloadHandler(cfg))
+	app.Post("/api/rescan", requireAuth(cfg), rescanHandler(cfg))
+	app.Get("/api/logs/stream", requireAuth(cfg), logStreamHandler)
+	app.Get("/api/debug/streams", requireAuth(cfg), debugStreamsHandler)
+	app.Get("/api/read-only", requireAuth(cfg), readOnlyHandler(cfg))
+	app.Put("/api/read-only", requireAuth(cfg), readOnlyHandler(cfg))
+
+	// CPU, memory and goroutine profiles for go tool pprof, e.g. during heavy streaming
+	if cfg.Pprof {
+		app.Use("/debug/pprof", requireAuth(cfg), pprof.New())
+	}
+
+	// Prometheus metrics and health checks, left out of the access log by -log-skip
+	app.Get("/metrics", metricsHandler)
+	app.Get("/healthz", healthzHandler)
+	app.Get("/readyz", readyzHandler(cfg))
+
+	// OPTIONS on any route lists the methods it supports
+	allowOptions(app)
+
+	return app
 }
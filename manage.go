@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Body of a rename request
+type renameRequest struct {
+	NewName string `json:"newName"`
+}
+
+// Rename a movie together with its sidecars
+func renameHandler(cfg *Config, data *userData) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		var req renameRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid request body.")
+		}
+		if !validMovieName(req.NewName) {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid new name.")
+		}
+		if req.NewName == movieStem(movieFilePath) {
+			return c.Status(fiber.StatusBadRequest).SendString("New name is the same as the current one.")
+		}
+		// With -case-insensitive, fixing only the case of a name finds the movie itself
+		if takenBy, taken := findMovie(cfg, req.NewName); taken && takenBy != movieFilePath {
+			return c.Status(fiber.StatusConflict).SendString("A movie with that name already exists.")
+		}
+
+		// Work out every move up front, so a collision is reported before anything is touched
+		renames := map[string]string{}
+		for _, path := range append([]string{movieFilePath}, findSidecars(cfg, movieName)...) {
+			ext := strings.TrimPrefix(filepath.Base(path), movieStem(movieFilePath))
+			target := filepath.Join(filepath.Dir(path), req.NewName+ext)
+			if _, err := os.Stat(target); err == nil {
+				return c.Status(fiber.StatusConflict).SendString("A file named " + filepath.Base(target) + " already exists.")
+			}
+			renames[path] = target
+		}
+
+		// Move the files, putting back what was already moved if one of them fails
+		done := map[string]string{}
+		for from, to := range renames {
+			if err := os.Rename(from, to); err != nil {
+				log.Printf("Failed to rename %s to %s: %v", from, to, err)
+				for movedFrom, movedTo := range done {
+					os.Rename(movedTo, movedFrom)
+				}
+				return c.Status(fiber.StatusInternalServerError).SendString("Failed to rename movie.")
+			}
+			done[from] = to
+		}
+
+		catalog.expire()
+
+		// DASH output, thumbnails, previews and the cover (or its .none marker) are cached by
+		// name: the old name's must not go to a future movie, a gone movie's under the new
+		// name must not go to this one
+		clearArtifacts(cfg, movieName)
+		clearArtifacts(cfg, req.NewName)
+
+		if err := data.rename(movieName, req.NewName); err != nil {
+			log.Printf("Could not move favorites, watched state or progress of %s to %s: %v", movieName, req.NewName, err)
+		}
+
+		entry, err := movieEntry(cfg, req.NewName, renames[movieFilePath])
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not get file info.")
+		}
+		return c.JSON(entry)
+	}
+}
+
+// Re-read the command line and -config file, applying what can change without a restart
+func reloadHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		next, err := loadConfig(os.Args[1:])
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid configuration: " + err.Error())
+		}
+
+		applied, restartRequired := cfg.reload(next)
+		// Formats change what the catalog lists
+		if len(applied) > 0 {
+			catalog.expire()
+		}
+		logRequest(requestID(c), "Reloaded configuration, applied %v, needing a restart %v", applied, restartRequired)
+		return c.JSON(fiber.Map{
+			"applied":         nonNil(applied),
+			"restartRequired": nonNil(restartRequired),
+		})
+	}
+}
+
+// Encode empty lists as [] rather than null
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+// Body of a read-only toggle
+type readOnlyRequest struct {
+	ReadOnly *bool `json:"readOnly"`
+}
+
+// Report or switch read-only mode
+func readOnlyHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodPut {
+			var req readOnlyRequest
+			if err := c.BodyParser(&req); err != nil || req.ReadOnly == nil {
+				return c.Status(fiber.StatusBadRequest).SendString(`Expected {"readOnly": true} or {"readOnly": false}.`)
+			}
+			cfg.readOnly.Store(*req.ReadOnly)
+			logRequest(requestID(c), "Read-only mode set to %t", *req.ReadOnly)
+		}
+		return c.JSON(fiber.Map{"readOnly": cfg.readOnly.Load()})
+	}
+}
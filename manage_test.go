@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Rename a movie through PATCH /api/movies/:movie
+func renameMovie(t *testing.T, app *fiber.App, movie, newName string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPatch, "/api/movies/"+movie, strings.NewReader(`{"newName":"`+newName+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	return send(t, app, authorized(req))
+}
+
+func TestRenameWithSidecars(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	for _, file := range []string{"a.mp4", "a.srt", "a.jpg", "a.nfo"} {
+		writeFile(t, filepath.Join("movies", file), []byte(file))
+	}
+
+	resp, body := renameMovie(t, app, "a", "b")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	var entry MovieEntry
+	if err := json.Unmarshal([]byte(body), &entry); err != nil || entry.Name != "b" || entry.VideoURL != "/video/b" {
+		t.Errorf("rename answered %s", body)
+	}
+	for _, ext := range []string{"mp4", "srt", "jpg", "nfo"} {
+		if content, err := os.ReadFile(filepath.Join("movies", "b."+ext)); err != nil || string(content) != "a."+ext {
+			t.Errorf("b.%s after the rename: %q, %v", ext, content, err)
+		}
+		if _, err := os.Stat(filepath.Join("movies", "a."+ext)); !os.IsNotExist(err) {
+			t.Errorf("a.%s is left after the rename: %v", ext, err)
+		}
+	}
+}
+
+func TestRenameClearsCover(t *testing.T) {
+	app, cfg := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	// The cover of a and the marker of a b that is gone, kept under the names in -cover-dir
+	left := []string{filepath.Join(cfg.CoverDir, "a.jpg"), filepath.Join(cfg.CoverDir, "a.none"), filepath.Join(cfg.CoverDir, "b.none")}
+	for _, path := range left {
+		writeFile(t, path, nil)
+	}
+
+	if resp, body := renameMovie(t, app, "a", "b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	for _, path := range left {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is left after the rename: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestRenameCollision(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "a.srt"), nil)
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "c.srt"), nil)
+
+	for newName, want := range map[string]string{
+		"b": "A movie with that name already exists.",
+		"c": "A file named c.srt already exists.",
+	} {
+		if resp, body := renameMovie(t, app, "a", newName); resp.StatusCode != http.StatusConflict || body != want {
+			t.Errorf("rename to %s answered %d: %s", newName, resp.StatusCode, body)
+		}
+	}
+	// Nothing moved, not even the files that had no collision
+	for _, file := range []string{"a.mp4", "a.srt"} {
+		if _, err := os.Stat(filepath.Join("movies", file)); err != nil {
+			t.Errorf("%s after refused renames: %v", file, err)
+		}
+	}
+}
+
+func TestReloadFormats(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, config, []byte(`{"formats": "mp4"}`))
+	app, _ := newTestServer(t, "-config", config, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mkv"), []byte(testMovie))
+	reload := func() (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodPost, "/api/reload", nil)
+		return send(t, app, authorized(req))
+	}
+
+	if resp, _ := get(t, app, "/video/a"); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("MKV served with -formats mp4: %d", resp.StatusCode)
+	}
+
+	writeFile(t, config, []byte(`{"formats": "mkv,mp4", "listen": "127.0.0.1:4000"}`))
+	resp, body := reload()
+	if resp.StatusCode != http.StatusOK || body != `{"applied":["formats"],"restartRequired":["listen"]}` {
+		t.Fatalf("reload answered %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, app, "/video/a"); resp.StatusCode != http.StatusOK {
+		t.Errorf("MKV not served after adding it to -formats: %d", resp.StatusCode)
+	}
+
+	// A broken file changes nothing
+	writeFile(t, config, []byte(`{"formats": "mp4", "no-such-flag": 1}`))
+	if resp, body := reload(); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid configuration answered %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, app, "/video/a"); resp.StatusCode != http.StatusOK {
+		t.Error("invalid configuration was applied")
+	}
+
+	// The command line still wins over the file
+	writeFile(t, config, []byte(`{"api-token": "other"}`))
+	if resp, body := reload(); resp.StatusCode != http.StatusOK || strings.Contains(body, "api-token") {
+		t.Errorf("reload with the token given on the command line answered %d: %s", resp.StatusCode, body)
+	}
+}
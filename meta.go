@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Largest [Movie].meta.json accepted, it's for tags and notes, not documents
+const maxMetaSize = 64 << 10
+
+// The movie's [Movie].meta.json next to its file
+func metaPath(movieFilePath string) string {
+	return filepath.Join(filepath.Dir(movieFilePath), movieStem(movieFilePath)+".meta.json")
+}
+
+// Read the movie's custom metadata, nil when it has none. A sidecar that isn't a JSON
+// object is left out rather than breaking the catalog.
+func readMeta(movieFilePath string) json.RawMessage {
+	content, err := os.ReadFile(metaPath(movieFilePath))
+	if err != nil {
+		return nil
+	}
+	meta, ok := validMeta(content)
+	if !ok {
+		return nil
+	}
+	return meta
+}
+
+// Only the top level is checked: any JSON object goes, whatever tags, ratings or notes it
+// holds. Returns it compacted.
+func validMeta(content []byte) (json.RawMessage, bool) {
+	var object map[string]json.RawMessage
+	if len(content) > maxMetaSize || json.Unmarshal(content, &object) != nil || object == nil {
+		return nil, false
+	}
+	var compact bytes.Buffer
+	if json.Compact(&compact, content) != nil {
+		return nil, false
+	}
+	return compact.Bytes(), true
+}
+
+// Replace the movie's custom metadata with the JSON object in the body
+func putMetaHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+
+		meta, ok := validMeta(c.Body())
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).SendString("Expected a JSON object of at most 64 KB.")
+		}
+
+		// Written next to the movie and renamed, so readers never see half a file
+		path := metaPath(movieFilePath)
+		tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+		if err != nil {
+			logRequest(rid, "Could not create %s: %v", path, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not save metadata.")
+		}
+		defer os.Remove(tmp.Name())
+		_, err = tmp.Write(append(meta, '\n'))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			os.Chmod(tmp.Name(), 0o644)
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			logRequest(rid, "Could not write %s: %v", path, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not save metadata.")
+		}
+		catalog.expire()
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
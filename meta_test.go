@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func putMeta(t *testing.T, app *fiber.App, movie, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPut, "/api/movies/"+movie+"/meta", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return send(t, app, authorized(req))
+}
+
+func TestMovieMeta(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+	// Edited by hand
+	writeFile(t, filepath.Join("movies", "b.meta.json"), []byte("{\n  \"rating\": 4,\n  \"tags\": [\"noir\"]\n}\n"))
+
+	if resp, body := putMeta(t, app, "a", `{"tags": ["comedy", "80s"], "rating": 5, "notes": {"seen": "twice"}}`); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("writing metadata answered %d: %s", resp.StatusCode, body)
+	}
+	const stored = `{"tags":["comedy","80s"],"rating":5,"notes":{"seen":"twice"}}`
+	if content, _ := os.ReadFile(filepath.Join("movies", "a.meta.json")); string(content) != stored+"\n" {
+		t.Errorf("stored as %q", content)
+	}
+	if info := playback(t, app, "a"); string(info.Meta) != stored {
+		t.Errorf("playback has meta %s", info.Meta)
+	}
+	if info := playback(t, app, "b"); string(info.Meta) != `{"rating":4,"tags":["noir"]}` {
+		t.Errorf("playback has the hand-written meta as %s", info.Meta)
+	}
+
+	// Merged into the catalog, and left out for movies without any
+	_, catalog := get(t, app, "/api/movies")
+	for _, want := range []string{`"name":"a",`, `"meta":` + stored + `}`, `"meta":{"rating":4,"tags":["noir"]}}`} {
+		if !strings.Contains(catalog, want) {
+			t.Errorf("catalog lacks %s:\n%s", want, catalog)
+		}
+	}
+	os.Remove(filepath.Join("movies", "b.meta.json"))
+	if _, catalog := get(t, app, "/api/movies"); strings.Count(catalog, `"meta"`) != 1 {
+		t.Errorf("catalog without b's sidecar: %s", catalog)
+	}
+
+	// Replacing it, and rejecting what isn't an object
+	if resp, _ := putMeta(t, app, "a", `{"rating": 3}`); resp.StatusCode != http.StatusNoContent || string(playback(t, app, "a").Meta) != `{"rating":3}` {
+		t.Errorf("replacing metadata answered %d", resp.StatusCode)
+	}
+	for body, status := range map[string]int{
+		`["comedy"]`: http.StatusBadRequest,
+		`"comedy"`:   http.StatusBadRequest,
+		`null`:       http.StatusBadRequest,
+		`{"rating":`: http.StatusBadRequest,
+		`{"notes":"` + strings.Repeat("x", maxMetaSize) + `"}`: http.StatusRequestEntityTooLarge,
+	} {
+		if resp, _ := putMeta(t, app, "a", body); resp.StatusCode != status {
+			t.Errorf("metadata %.20q answered %d", body, resp.StatusCode)
+		}
+	}
+	if string(playback(t, app, "a").Meta) != `{"rating":3}` {
+		t.Error("rejected metadata replaced the sidecar")
+	}
+	if resp, _ := putMeta(t, app, "missing", `{}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("metadata for a missing movie answered %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest(http.MethodPut, "/api/movies/a/meta", strings.NewReader(`{}`))
+	if resp, _ := send(t, app, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("metadata without the token answered %d", resp.StatusCode)
+	}
+
+	// A sidecar that isn't an object is ignored
+	writeFile(t, filepath.Join("movies", "a.meta.json"), []byte("not json"))
+	if info := playback(t, app, "a"); string(info.Meta) != "null" {
+		t.Errorf("broken sidecar shown as %s", info.Meta)
+	}
+
+	// Renames take it along
+	putMeta(t, app, "a", `{"rating": 1}`)
+	if resp, body := renameMovie(t, app, "a", "c"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	if info := playback(t, app, "c"); string(info.Meta) != `{"rating":1}` {
+		t.Errorf("renamed movie has meta %s", info.Meta)
+	}
+	if files, _ := filepath.Glob(filepath.Join("movies", ".*.tmp")); len(files) != 0 {
+		t.Errorf("writes left %v", files)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Minimal Prometheus-style histogram, enough for the handful of metrics we expose
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets ...float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Write the histogram in the Prometheus text exposition format
+func (h *histogram) write(b *strings.Builder, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(upper, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(b, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+// Write a single gauge value in the Prometheus text exposition format
+func writeGauge(b *strings.Builder, name, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+}
+
+// Write a single counter value in the Prometheus text exposition format
+func writeCounter(b *strings.Builder, name, help string, value int64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// Time from receiving a /video range request to writing its first byte
+var streamStartSeconds = newHistogram(0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5)
+
+func metricsHandler(c *fiber.Ctx) error {
+	var b strings.Builder
+	streamStartSeconds.write(&b, "display_stream_start_seconds", "Time from receiving a video range request to writing its first byte.")
+	writeGauge(&b, "display_open_streams", "Video streams currently holding an open file.", float64(openStreams.Load()))
+	writeCounter(&b, "display_video_bytes_served_total", "Video bytes sent since startup.", bytesServed.Load())
+	writeGauge(&b, "display_header_cache_bytes", "Bytes of movie headers kept in memory.", float64(headers.bytes()))
+	writeBreakerStates(&b)
+	writeGauge(&b, "display_cache_bytes", "Bytes used by generated covers, DASH packages and sprite sheets.", float64(cacheBytes.Load()))
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.SendString(b.String())
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Content types sent for each format the server knows how to serve
+var contentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+}
+
+// A movie as returned by the API
+type MovieEntry struct {
+	Name        string `json:"name"`
+	Library     string `json:"library"`
+	Format      string `json:"format"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	StreamURL   string `json:"streamUrl"`
+	VideoURL    string `json:"videoUrl"`
+	// Display title and release year cleaned up from the file name, the year null without one
+	Title string `json:"title"`
+	Year  *int   `json:"year"`
+	// Whether an MP4 can start playing before it is fully downloaded, null for other formats
+	Faststart *bool `json:"faststart"`
+	// Duration read from the MP4 header, null for other formats or when it doesn't say
+	DurationSeconds *float64 `json:"durationSeconds"`
+	// Custom tags, ratings and notes from [Movie].meta.json, left out when there is none
+	Meta json.RawMessage `json:"meta,omitempty"`
+	// Every quality of a title stored as several files, best first, left out for single files
+	Qualities []qualityVariant `json:"qualities,omitempty"`
+}
+
+// Locate the movie file, searching the -movies-dir directories in order and trying the
+// -formats extensions in order within each. When a title exists in several formats this
+// picks the one browsers are most likely to play.
+func findMovie(cfg *Config, movieName string) (string, bool) {
+	return findMovieIn(cfg, movieName, cfg.Tunables().Formats)
+}
+
+// Like findMovie, but only trying the given formats, e.g. to find one specific variant
+func findMovieIn(cfg *Config, movieName string, formats []string) (string, bool) {
+	if !validMovieName(movieName) {
+		return "", false
+	}
+	for _, root := range cfg.MoviesDirs {
+		for _, ext := range formats {
+			path := filepath.Join(root, movieName+"."+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path, true
+			}
+		}
+	}
+	if cfg.CaseInsensitive {
+		return findMovieIgnoringCase(cfg, movieName, formats)
+	}
+	return "", false
+}
+
+// Names already logged as found through their case-insensitive match
+var caseMatchesLogged sync.Map
+
+// Like findMovie, but comparing names without regard to case, for libraries whose file
+// names don't match the links pointing at them. When several files match, e.g. both
+// Matrix.mp4 and MATRIX.mp4, the first one in name order wins.
+func findMovieIgnoringCase(cfg *Config, movieName string, formats []string) (string, bool) {
+	for _, root := range cfg.MoviesDirs {
+		files, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, ext := range formats {
+			var matches []string
+			for _, file := range files {
+				if !file.IsDir() && strings.EqualFold(file.Name(), movieName+"."+ext) {
+					matches = append(matches, file.Name())
+				}
+			}
+			if len(matches) == 0 {
+				continue
+			}
+
+			path := filepath.Join(root, matches[0])
+			// Players fetch a movie in many ranges, once per name is enough
+			if _, logged := caseMatchesLogged.LoadOrStore(movieName+"\x00"+path, true); !logged {
+				if len(matches) > 1 {
+					log.Printf("%q matches %s ignoring case, using %s", movieName, strings.Join(matches, ", "), path)
+				} else {
+					log.Printf("%q matches %s ignoring case", movieName, path)
+				}
+			}
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Files named like the movie in a format that isn't served, such as a .mov or an extension
+// left out of -formats
+func findUnsupported(cfg *Config, movieName string) []string {
+	if !validMovieName(movieName) {
+		return nil
+	}
+
+	var names []string
+	for _, root := range cfg.MoviesDirs {
+		files, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !file.IsDir() && strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())) == movieName && !isSidecar(file.Name()) {
+				names = append(names, file.Name())
+			}
+		}
+	}
+	return names
+}
+
+// Whether the file name ends like a sidecar, matching whole extensions such as meta.json
+// rather than the part after the last dot
+func isSidecar(fileName string) bool {
+	for _, ext := range sidecarExtensions() {
+		if strings.HasSuffix(strings.ToLower(fileName), "."+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Answer a request for a movie findMovie didn't find. With -explain-unsupported a file that
+// exists in a format that isn't served gets a 415 naming it, instead of a bare 404.
+func movieNotFound(c *fiber.Ctx, cfg *Config, movieName string) error {
+	if cfg.ExplainUnsupported {
+		if found := findUnsupported(cfg, movieName); len(found) > 0 {
+			return c.Status(fiber.StatusUnsupportedMediaType).SendString(fmt.Sprintf("Found %s, but only %s files are served.",
+				strings.Join(found, ", "), strings.Join(cfg.Tunables().Formats, ", ")))
+		}
+	}
+	return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+}
+
+// Path of one of the movie's sidecar files, which live next to the movie itself. Names
+// that aren't in the library yet belong to the first directory.
+func sidecarPath(cfg *Config, movieName, ext string) string {
+	dir, stem := sidecarBase(cfg, movieName)
+	return filepath.Join(dir, stem+"."+ext)
+}
+
+// Directory and file name stem the movie's sidecars use
+func sidecarBase(cfg *Config, movieName string) (string, string) {
+	if movieFilePath, found := findMovie(cfg, movieName); found {
+		// Spelled like the movie file, which differs from the name with -case-insensitive
+		return filepath.Dir(movieFilePath), movieStem(movieFilePath)
+	}
+	return cfg.MoviesDirs[0], movieName
+}
+
+// The movie's file name without its extension
+func movieStem(movieFilePath string) string {
+	base := filepath.Base(movieFilePath)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Names end up in file paths, so only allow plain file names
+func validMovieName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > 200 {
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\\x00")
+}
+
+// Resolve a path relative to one of the movie directories, refusing anything that ends up
+// outside of it, whether through ".." or through a symlink
+func libraryPath(dir, rel string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if !isWithin(root, path) {
+		return "", errOutsideLibrary
+	}
+
+	// Symlinks are fine as long as they stay inside the library
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil || !isWithin(realRoot, resolved) {
+			return "", errOutsideLibrary
+		}
+	}
+	return path, nil
+}
+
+var errOutsideLibrary = errors.New("path is outside the movie directory")
+
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Describe a resolved movie file for the API
+func movieEntry(cfg *Config, movieName, movieFilePath string) (MovieEntry, error) {
+	info, err := os.Stat(movieFilePath)
+	if err != nil {
+		return MovieEntry{}, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(movieFilePath))
+	entry := MovieEntry{
+		Name:        movieName,
+		Library:     filepath.Dir(movieFilePath),
+		Format:      strings.TrimPrefix(ext, "."),
+		ContentType: contentTypes[ext],
+		Size:        info.Size(),
+		StreamURL:   "/stream/" + url.PathEscape(movieName),
+		VideoURL:    "/video/" + url.PathEscape(movieName),
+	}
+	entry.Title, entry.Year = cleanTitle(movieName, cfg.TitleTags)
+	if faststart, ok := isFaststart(movieFilePath); ok {
+		entry.Faststart = &faststart
+	}
+	if duration, ok := mp4Duration(movieFilePath); ok {
+		entry.DurationSeconds = &duration
+	}
+	entry.Meta = readMeta(movieFilePath)
+	return entry, nil
+}
+
+// Extensions of files stored next to a movie under the same name
+func sidecarExtensions() []string {
+	exts := append([]string{}, subtitleExtensions...)
+	exts = append(exts, posterExtensions...)
+	return append(exts, "nfo", "meta.json")
+}
+
+// Existing sidecar files belonging to the movie
+func findSidecars(cfg *Config, movieName string) []string {
+	var paths []string
+	for _, ext := range sidecarExtensions() {
+		path := sidecarPath(cfg, movieName, ext)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	// Subtitles per language, including those the player doesn't use since another
+	// format of the same language wins
+	for _, file := range languageSubtitles(cfg, movieName) {
+		paths = append(paths, file.path)
+	}
+	return paths
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreferredFormat(t *testing.T) {
+	for _, tt := range []struct {
+		formats, want string
+	}{
+		{"", "video/mp4"},
+		{"mkv,mp4", "video/x-matroska"},
+	} {
+		var args []string
+		if tt.formats != "" {
+			args = []string{"-formats", tt.formats}
+		}
+		app, _ := newTestServer(t, args...)
+		writeFile(t, filepath.Join("movies", "a.mkv"), []byte(testMovie))
+		writeFile(t, filepath.Join("movies", "a.avi"), []byte(testMovie))
+		writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+		if resp, body := get(t, app, "/stream/a"); resp.StatusCode != http.StatusOK || !strings.Contains(body, `type="`+tt.want+`"`) {
+			t.Errorf("-formats %q: player answered %d without a %s source:\n%s", tt.formats, resp.StatusCode, tt.want, body)
+		}
+		if resp, _ := get(t, app, "/video/a"); resp.Header.Get("Content-Type") != tt.want {
+			t.Errorf("-formats %q: video answered as %s, want %s", tt.formats, resp.Header.Get("Content-Type"), tt.want)
+		}
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		status int
+		body   string
+	}{
+		{nil, http.StatusUnsupportedMediaType, "Found a.mov, but only mp4, webm, mkv, avi files are served."},
+		{[]string{"-explain-unsupported=false"}, http.StatusNotFound, "Movie not found."},
+	} {
+		app, _ := newTestServer(t, tt.args...)
+		writeFile(t, filepath.Join("movies", "a.mov"), []byte(testMovie))
+		writeFile(t, filepath.Join("movies", "a.srt"), []byte(testMovie))
+		for _, target := range []string{"/stream/a", "/video/a"} {
+			if resp, body := get(t, app, target); resp.StatusCode != tt.status || body != tt.body {
+				t.Errorf("%s with %v answered %d: %s", target, tt.args, resp.StatusCode, body)
+			}
+		}
+	}
+
+	// A sidecar alone isn't a movie in another format, and a served format leaves out its own extension
+	app, _ := newTestServer(t, "-formats", "mp4")
+	writeFile(t, filepath.Join("movies", "b.srt"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "c.mkv"), []byte(testMovie))
+	if resp, body := get(t, app, "/video/b"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("subtitles alone answered %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := get(t, app, "/video/c"); resp.StatusCode != http.StatusUnsupportedMediaType || body != "Found c.mkv, but only mp4 files are served." {
+		t.Errorf("MKV left out of -formats answered %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "thematrix.mp4"), []byte("thematrix.mp4"))
+	if resp, _ := get(t, app, "/video/TheMatrix"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("without -case-insensitive TheMatrix answered %d", resp.StatusCode)
+	}
+
+	app, _ = newTestServer(t, "-case-insensitive", "-api-token", testToken)
+	for _, file := range []string{"thematrix.mp4", "Alien.mp4", "ALIEN.mp4", "alien.mp4"} {
+		writeFile(t, filepath.Join("movies", file), []byte(file))
+	}
+	writeFile(t, filepath.Join("movies", "thematrix.srt"), []byte(strings.Replace(testSRT, "%s", "Wake up", 1)))
+	logs := captureLog(t)
+	for target, want := range map[string]string{
+		"/video/thematrix": "thematrix.mp4",
+		"/video/TheMatrix": "thematrix.mp4",
+		"/video/Alien":     "Alien.mp4",
+		"/video/alien":     "alien.mp4",
+		// Several files fold to aLiEn, the first in name order wins
+		"/video/aLiEn": "ALIEN.mp4",
+	} {
+		if resp, body := get(t, app, target); resp.StatusCode != http.StatusOK || body != want {
+			t.Errorf("%s answered %d with %q, want %s", target, resp.StatusCode, body, want)
+		}
+	}
+	get(t, app, "/video/aLiEn")
+	log := logs.String()
+	if strings.Count(log, `"aLiEn" matches ALIEN.mp4, Alien.mp4, alien.mp4 ignoring case, using movies/ALIEN.mp4`) != 1 ||
+		strings.Count(log, `"TheMatrix" matches movies/thematrix.mp4 ignoring case`) != 1 || strings.Contains(log, `"Alien" matches`) {
+		t.Errorf("case-insensitive matches not logged once each:\n%s", log)
+	}
+	if resp, _ := get(t, app, "/video/TheMatrix2"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("no match answered %d", resp.StatusCode)
+	}
+
+	// Sidecars follow the file found, so does fixing the case by renaming
+	if resp, body := get(t, app, "/subtitles/TheMatrix"); resp.StatusCode != http.StatusOK || !strings.Contains(body, "Wake up") {
+		t.Errorf("subtitles of TheMatrix answered %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := renameMovie(t, app, "TheMatrix", "TheMatrix"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename fixing the case answered %d: %s", resp.StatusCode, body)
+	}
+	for _, file := range []string{"TheMatrix.mp4", "TheMatrix.srt"} {
+		if _, err := os.Stat(filepath.Join("movies", file)); err != nil {
+			t.Errorf("rename fixing the case: %v", err)
+		}
+	}
+	if resp, _ := renameMovie(t, app, "thematrix", "TheMatrix"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("rename to the file's own name answered %d", resp.StatusCode)
+	}
+}
+
+func TestDottedNames(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	for file, content := range map[string]string{
+		"Movie.mp4":            testMovie,
+		"Movie.2020.mp4":       testMovie,
+		"Movie.de.mp4":         testMovie,
+		"Movie.srt":            strings.Replace(testSRT, "%s", "Movie", 1),
+		"Movie.2020.srt":       strings.Replace(testSRT, "%s", "Movie.2020", 1),
+		"Movie.2020.en.srt":    strings.Replace(testSRT, "%s", "Movie.2020 in English", 1),
+		"Movie.de.srt":         strings.Replace(testSRT, "%s", "Movie.de", 1),
+		"Movie.2020.jpg":       "poster of Movie.2020",
+		"Movie.2020.meta.json": `{"rating":4}`,
+	} {
+		writeFile(t, filepath.Join("movies", file), []byte(content))
+	}
+
+	if got := listFormats(t, app, ""); got != "Movie.mp4 Movie.2020.mp4 Movie.de.mp4" {
+		t.Errorf("listed %q", got)
+	}
+	for target, want := range map[string]string{
+		"/subtitles/Movie":              "Movie\n",
+		"/subtitles/Movie.2020":         "Movie.2020\n",
+		"/subtitles/Movie.2020?lang=en": "Movie.2020 in English\n",
+		"/subtitles/Movie.de":           "Movie.de\n",
+		"/poster/Movie.2020":            "poster of Movie.2020",
+	} {
+		if resp, body := get(t, app, target); resp.StatusCode != http.StatusOK || !strings.HasSuffix(body, want) {
+			t.Errorf("%s answered %d: %q", target, resp.StatusCode, body)
+		}
+	}
+	if _, body := get(t, app, "/api/movies"); strings.Count(body, `"rating":4`) != 1 {
+		t.Errorf("meta of Movie.2020 shown for other movies: %s", body)
+	}
+
+	// Movie.de.srt belongs to the movie Movie.de, not to Movie in German
+	if resp, _ := get(t, app, "/subtitles/Movie?lang=de"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("German subtitles of Movie answered %d", resp.StatusCode)
+	}
+	// Sidecars aren't movies in another format
+	if resp, body := get(t, app, "/video/Movie.2020.meta"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Movie.2020.meta answered %d: %s", resp.StatusCode, body)
+	}
+
+	// A rename takes only the movie's own sidecars along
+	if resp, body := renameMovie(t, app, "Movie", "Film"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	for _, file := range []string{"Film.mp4", "Film.srt", "Movie.2020.mp4", "Movie.2020.srt", "Movie.2020.en.srt", "Movie.2020.jpg", "Movie.2020.meta.json", "Movie.de.mp4", "Movie.de.srt"} {
+		if _, err := os.Stat(filepath.Join("movies", file)); err != nil {
+			t.Errorf("after the rename: %v", err)
+		}
+	}
+}
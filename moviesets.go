@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A set of movies by name, with the time each was added, like the favorites or the
+// movies that were watched
+type movieSet map[string]time.Time
+
+// A member of a movie set as returned by the API
+type movieSetEntry struct {
+	MovieEntry
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// List the movies of the set that are in the library, most recently added first. Names
+// whose movie is missing, e.g. on a drive that isn't mounted, are kept but not listed.
+func listMovieSetHandler(cfg *Config, set *JSONStore[movieSet]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		added := movieSet{}
+		set.Read(func(movies movieSet) {
+			for name, at := range movies {
+				added[name] = at
+			}
+		})
+
+		entries := []movieSetEntry{}
+		for name, at := range added {
+			movieFilePath, found := findMovie(cfg, name)
+			if !found {
+				continue
+			}
+			entry, err := movieEntry(cfg, name, movieFilePath)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, movieSetEntry{MovieEntry: entry, AddedAt: at})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].AddedAt.After(entries[j].AddedAt) })
+		return c.JSON(entries)
+	}
+}
+
+// Add a movie to the set, keeping the original time when it already is in it
+func addToMovieSetHandler(cfg *Config, set *JSONStore[movieSet]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+		if _, found := findMovie(cfg, movieName); !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		if err := addToMovieSet(set, movieName); err != nil {
+			logRequest(requestID(c), "Could not save %s: %v", set.path, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not save changes.")
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func removeFromMovieSetHandler(set *JSONStore[movieSet]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+		err := set.Update(func(movies *movieSet) error {
+			delete(*movies, movieName)
+			return nil
+		})
+		if err != nil {
+			logRequest(requestID(c), "Could not save %s: %v", set.path, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not save changes.")
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func addToMovieSet(set *JSONStore[movieSet], movieName string) error {
+	return set.Update(func(movies *movieSet) error {
+		if *movies == nil {
+			*movies = movieSet{}
+		}
+		if _, ok := (*movies)[movieName]; !ok {
+			(*movies)[movieName] = time.Now().UTC()
+		}
+		return nil
+	})
+}
+
+// Whether the movie is in the set
+func inMovieSet(set *JSONStore[movieSet], movieName string) bool {
+	found := false
+	set.Read(func(movies movieSet) { _, found = movies[movieName] })
+	return found
+}
+
+// Keep a renamed movie in the set under its new name
+func renameInMovieSet(set *JSONStore[movieSet], oldName, newName string) error {
+	return set.Update(func(movies *movieSet) error {
+		if at, ok := (*movies)[oldName]; ok {
+			delete(*movies, oldName)
+			(*movies)[newName] = at
+		}
+		return nil
+	})
+}
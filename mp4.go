@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Whether an MP4 file has its index (the moov atom) before the media data, so players can
+// start before downloading the whole file. The second result is false when the file isn't
+// an MP4 or its top-level atoms can't be read.
+func isFaststart(path string) (bool, bool) {
+	if strings.ToLower(filepath.Ext(path)) != ".mp4" {
+		return false, false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false, false
+	}
+	defer file.Close()
+
+	// Walk the top-level atoms, seeking past their contents, until moov or mdat shows up
+	offset := int64(0)
+	header := make([]byte, 16)
+	for {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return false, false
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:8]) {
+		case "moov":
+			return true, true
+		case "mdat":
+			return false, true
+		}
+
+		switch size {
+		case 0:
+			// The atom runs to the end of the file, nothing after it
+			return false, false
+		case 1:
+			// 64-bit size right after the type
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return false, false
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return false, false
+		}
+		offset += size
+	}
+}
+
+// The atom of the given type among the atoms between start and end, as the offset and
+// length of its contents
+func findAtom(file *os.File, start, end int64, kind string) (int64, int64, bool) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, false
+		}
+		size, headerLen := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, false
+			}
+			size, headerLen = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if size < headerLen || offset+size > end {
+			return 0, 0, false
+		}
+		if string(header[4:8]) == kind {
+			return offset + headerLen, size - headerLen, true
+		}
+		offset += size
+	}
+	return 0, 0, false
+}
+
+// Durations read from mvhd by path, reused until the file changes
+var mp4DurationCache sync.Map
+
+type cachedDuration struct {
+	modTime  time.Time
+	size     int64
+	duration float64
+	ok       bool
+}
+
+// The duration of an MP4 in seconds from its movie header (moov/mvhd), without running
+// ffprobe. False for other formats and when the header doesn't say.
+func mp4Duration(path string) (float64, bool) {
+	if strings.ToLower(filepath.Ext(path)) != ".mp4" {
+		return 0, false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, false
+	}
+	if cached, ok := mp4DurationCache.Load(path); ok {
+		cached := cached.(cachedDuration)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.duration, cached.ok
+		}
+	}
+
+	duration, ok := readMvhdDuration(file, info.Size())
+	mp4DurationCache.Store(path, cachedDuration{modTime: info.ModTime(), size: info.Size(), duration: duration, ok: ok})
+	return duration, ok
+}
+
+func readMvhdDuration(file *os.File, fileSize int64) (float64, bool) {
+	moov, moovLen, ok := findAtom(file, 0, fileSize, "moov")
+	if !ok {
+		return 0, false
+	}
+	mvhd, mvhdLen, ok := findAtom(file, moov, moov+moovLen, "mvhd")
+	if !ok || mvhdLen < 20 {
+		return 0, false
+	}
+
+	// Version 0 has 32-bit times and duration, version 1 64-bit ones, both after the
+	// version and flags and the creation and modification times
+	body := make([]byte, 32)
+	if _, err := file.ReadAt(body[:min(mvhdLen, 32)], mvhd); err != nil {
+		return 0, false
+	}
+	var timescale uint32
+	var duration uint64
+	switch body[0] {
+	case 0:
+		timescale = binary.BigEndian.Uint32(body[12:16])
+		duration = uint64(binary.BigEndian.Uint32(body[16:20]))
+		if duration == 0xffffffff {
+			return 0, false
+		}
+	case 1:
+		if mvhdLen < 32 {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(body[20:24])
+		duration = binary.BigEndian.Uint64(body[24:32])
+		if duration == 0xffffffffffffffff {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if timescale == 0 || duration == 0 {
+		return 0, false
+	}
+	return float64(duration) / float64(timescale), true
+}
+
+// Log every MP4 in the library that isn't set up for streaming
+func warnSlowStarts(cfg *Config) {
+	movies, err := listMovies(cfg)
+	if err != nil {
+		return
+	}
+	for _, movie := range movies {
+		if movie.Faststart != nil && !*movie.Faststart {
+			movieFilePath, _ := findMovie(cfg, movie.Name)
+			log.Printf("%s has its index at the end, so playback waits for the whole file. "+
+				"Fix with POST /api/movies/%s/faststart or ffmpeg -i in.mp4 -c copy -movflags +faststart out.mp4", movieFilePath, movie.Name)
+		}
+	}
+}
+
+var faststartLocks sync.Map
+
+// Remux an MP4 with its index moved to the front. Nothing is re-encoded, the streams are copied.
+func faststartHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+		if faststart, ok := isFaststart(movieFilePath); !ok {
+			return c.Status(fiber.StatusUnprocessableEntity).SendString("Only MP4 files can be optimized for streaming.")
+		} else if faststart {
+			return c.Status(fiber.StatusOK).SendString("Movie is already optimized for streaming.")
+		}
+		if !haveTool("ffmpeg") {
+			return c.Status(fiber.StatusNotImplemented).SendString("Optimizing needs ffmpeg, which is not installed.")
+		}
+
+		defer lockKey(&faststartLocks, movieName)()
+
+		// Write next to the movie so the final rename stays on one filesystem. Streams that
+		// are open keep reading the old file until they finish.
+		tmp := filepath.Join(filepath.Dir(movieFilePath), ".faststart-"+filepath.Base(movieFilePath))
+		logRequest(rid, "Moving the index of %s to the front", movieFilePath)
+		_, err := runToolFor(rid, cfg.JobTimeout, "ffmpeg", "-nostdin", "-loglevel", "error",
+			"-i", movieFilePath, "-map", "0", "-c", "copy", "-movflags", "+faststart", "-f", "mp4", "-y", tmp)
+		if err == nil {
+			if faststart, ok := isFaststart(tmp); !ok || !faststart {
+				err = errors.New("remuxed file still has its index at the end")
+			}
+		}
+		if err == nil {
+			err = os.Rename(tmp, movieFilePath)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			logRequest(rid, "Could not optimize %s: %v", movieFilePath, err)
+			return toolFailure(c, err, "Failed to optimize movie.")
+		}
+		catalog.expire()
+
+		entry, err := movieEntry(cfg, movieName, movieFilePath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not get file info.")
+		}
+		return c.JSON(entry)
+	}
+}
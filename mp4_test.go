@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An MP4 atom with a 32-bit size
+func atom(kind string, payload string) string {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(8+len(payload)))
+	return string(size) + kind + payload
+}
+
+// An MP4 atom with its size in the 64-bit field
+func largeAtom(kind string, payload string) string {
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(16+len(payload)))
+	return "\x00\x00\x00\x01" + kind + string(size) + payload
+}
+
+var (
+	faststartMP4 = atom("ftyp", "isom") + atom("moov", "index") + atom("mdat", "frames")
+	slowStartMP4 = atom("ftyp", "isom") + atom("mdat", "frames") + atom("moov", "index")
+)
+
+func TestIsFaststart(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name, file, content string
+		faststart, ok       bool
+	}{
+		{"faststart", "a.mp4", faststartMP4, true, true},
+		{"moov at the end", "a.mp4", slowStartMP4, false, true},
+		{"64-bit sizes", "a.mp4", largeAtom("ftyp", "isom") + largeAtom("free", strings.Repeat("x", 100)) + atom("moov", "index"), true, true},
+		{"upper case extension", "a.MP4", faststartMP4, true, true},
+		{"atom running to the end", "a.mp4", "\x00\x00\x00\x00free" + slowStartMP4, false, false},
+		{"impossible size", "a.mp4", "\x00\x00\x00\x04free" + faststartMP4, false, false},
+		{"truncated", "a.mp4", atom("ftyp", "isom")[:6], false, false},
+		{"no moov or mdat", "a.mp4", atom("ftyp", "isom"), false, false},
+		{"not an MP4", "a.mkv", faststartMP4, false, false},
+	} {
+		path := filepath.Join(dir, tt.file)
+		writeFile(t, path, []byte(tt.content))
+		if faststart, ok := isFaststart(path); faststart != tt.faststart || ok != tt.ok {
+			t.Errorf("%s: got %t, %t; want %t, %t", tt.name, faststart, ok, tt.faststart, tt.ok)
+		}
+	}
+}
+
+func TestFaststartReported(t *testing.T) {
+	app, cfg := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "fast.mp4"), []byte(faststartMP4))
+	writeFile(t, filepath.Join("movies", "slow.mp4"), []byte(slowStartMP4))
+	writeFile(t, filepath.Join("movies", "other.mkv"), []byte(testMatroska))
+
+	for movie, want := range map[string]string{"fast": `"faststart":true`, "slow": `"faststart":false`, "other": `"faststart":null`} {
+		if _, body := get(t, app, "/api/movies/"+movie+"/playback"); !strings.Contains(body, want) {
+			t.Errorf("playback of %s: %s, want %s", movie, body, want)
+		}
+	}
+	if _, body := get(t, app, "/api/movies"); strings.Count(body, `"faststart":false`) != 1 || strings.Count(body, `"faststart":true`) != 1 {
+		t.Errorf("listing: %s", body)
+	}
+
+	logs := captureLog(t)
+	warnSlowStarts(cfg)
+	if !strings.Contains(logs.String(), filepath.Join("movies", "slow.mp4")+" has its index at the end") || strings.Contains(logs.String(), "fast.mp4") {
+		t.Errorf("startup warnings:\n%s", logs)
+	}
+}
+
+func TestFaststartRemux(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	movie := filepath.Join("movies", "slow.mp4")
+	writeFile(t, movie, []byte(slowStartMP4))
+	writeFile(t, filepath.Join("movies", "fast.mp4"), []byte(faststartMP4))
+	writeFile(t, filepath.Join("movies", "other.mkv"), []byte(testMovie))
+	remux := func(name string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, "/api/movies/"+name+"/faststart", nil)
+		resp, body := send(t, app, authorized(req))
+		return resp.StatusCode, body
+	}
+
+	if status, body := remux("slow"); status != http.StatusNotImplemented {
+		t.Errorf("remux without ffmpeg answered %d: %s", status, body)
+	}
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+
+	// A run that doesn't fix the file leaves the original alone
+	runs := scriptTool(t, "ffmpeg", toolRun{output: slowStartMP4}, toolRun{output: faststartMP4})
+	if status, body := remux("slow"); status != http.StatusInternalServerError {
+		t.Errorf("failed remux answered %d: %s", status, body)
+	}
+	if content, _ := os.ReadFile(movie); string(content) != slowStartMP4 {
+		t.Error("failed remux changed the movie")
+	}
+
+	if status, body := remux("slow"); status != http.StatusOK || !strings.Contains(body, `"faststart":true`) {
+		t.Errorf("remux answered %d: %s", status, body)
+	}
+	if content, _ := os.ReadFile(movie); !bytes.Equal(content, []byte(faststartMP4)) {
+		t.Error("remuxed file not moved into place")
+	}
+	if files, _ := filepath.Glob(filepath.Join("movies", ".faststart-*")); len(files) != 0 {
+		t.Errorf("remux left %v", files)
+	}
+
+	for name, status := range map[string]int{"fast": http.StatusOK, "other": http.StatusUnprocessableEntity, "missing": http.StatusNotFound} {
+		if got, body := remux(name); got != status {
+			t.Errorf("remux of %s answered %d: %s", name, got, body)
+		}
+	}
+	if runs.Load() != 2 {
+		t.Errorf("ffmpeg ran %d times, want 2", runs.Load())
+	}
+}
+
+// A movie header with the given timescale and duration, version 0 with 32-bit fields or
+// version 1 with 64-bit ones
+func mvhd(version byte, timescale uint32, duration uint64) string {
+	var b bytes.Buffer
+	b.Write([]byte{version, 0, 0, 0})
+	if version == 0 {
+		binary.Write(&b, binary.BigEndian, [2]uint32{}) // Creation and modification time
+		binary.Write(&b, binary.BigEndian, timescale)
+		binary.Write(&b, binary.BigEndian, uint32(duration))
+	} else {
+		binary.Write(&b, binary.BigEndian, [2]uint64{})
+		binary.Write(&b, binary.BigEndian, timescale)
+		binary.Write(&b, binary.BigEndian, duration)
+	}
+	b.Write(make([]byte, 80)) // Rate, volume, matrix and next track ID
+	return atom("mvhd", b.String())
+}
+
+func TestMP4Duration(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name, file, content string
+		want                float64
+		ok                  bool
+	}{
+		{"version 0 at the front", "a.mp4", atom("ftyp", "isom") + atom("moov", mvhd(0, 1000, 123456)) + atom("mdat", "frames"), 123.456, true},
+		{"version 1 behind a 64-bit mdat", "b.mp4", atom("ftyp", "isom") + largeAtom("mdat", "frames") + atom("moov", atom("free", "")+mvhd(1, 90000, 5400*90000)), 5400, true},
+		{"upper-case extension", "c.MP4", atom("moov", mvhd(0, 600, 600)), 1, true},
+		{"unknown duration", "d.mp4", atom("moov", mvhd(0, 1000, 0xffffffff)), 0, false},
+		{"zero timescale", "e.mp4", atom("moov", mvhd(0, 0, 1000)), 0, false},
+		{"no mvhd", "f.mp4", faststartMP4, 0, false},
+		{"no moov", "g.mp4", atom("ftyp", "isom") + atom("mdat", "frames"), 0, false},
+		{"truncated moov", "h.mp4", atom("moov", mvhd(0, 1000, 1000))[:30], 0, false},
+		{"other format", "i.mkv", atom("moov", mvhd(0, 1000, 1000)), 0, false},
+	} {
+		path := filepath.Join(dir, tt.file)
+		writeFile(t, path, []byte(tt.content))
+		if got, ok := mp4Duration(path); got != tt.want || ok != tt.ok {
+			t.Errorf("%s: %v, %t, want %v, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// A changed file is read again
+	path := filepath.Join(dir, "a.mp4")
+	writeFile(t, path, []byte(atom("moov", mvhd(0, 1000, 2000))))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if got, _ := mp4Duration(path); got != 2 {
+		t.Errorf("duration after a change %v", got)
+	}
+}
+
+func TestDurationReported(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(atom("moov", mvhd(0, 1000, 123456))))
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte(testMovie))
+
+	_, body := get(t, app, "/api/movies")
+	var movies []MovieEntry
+	if err := json.Unmarshal([]byte(body), &movies); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if len(movies) != 2 || movies[0].DurationSeconds == nil || *movies[0].DurationSeconds != 123.456 || movies[1].DurationSeconds != nil {
+		t.Errorf("catalog: %s", body)
+	}
+	if !strings.Contains(body, `"durationSeconds":null`) {
+		t.Errorf("no null duration for the MKV: %s", body)
+	}
+
+	// Without ffprobe the playback info falls back to the header
+	if info := playback(t, app, "a"); info.DurationSeconds == nil || *info.DurationSeconds != 123.456 {
+		t.Errorf("playback duration %v", info.DurationSeconds)
+	}
+}
+
+func TestDurationMatchesFFprobe(t *testing.T) {
+	requireTools(t, "ffmpeg", "ffprobe")
+	movie := filepath.Join(t.TempDir(), "a.mp4")
+	if out, err := exec.Command("ffmpeg", "-nostdin", "-loglevel", "error", "-f", "lavfi", "-i", "testsrc=duration=7.5:size=160x120:rate=24", "-pix_fmt", "yuv420p", movie).CombinedOutput(); err != nil {
+		t.Fatalf("making a test video: %v: %s", err, out)
+	}
+	probed, err := probeMovie("test", movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, ok := probed.duration()
+	if !ok {
+		t.Fatalf("ffprobe didn't tell the duration: %+v", probed)
+	}
+	if got, ok := mp4Duration(movie); !ok || math.Abs(got-want) > 0.1 {
+		t.Errorf("header says %v, ffprobe %v", got, want)
+	}
+}
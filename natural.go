@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Compare names the way people read them: runs of digits by their value, so Episode 2
+// comes before Episode 10, everything else ignoring case. Names equal that way, like
+// E01 and E1, fall back to a plain comparison so the order is stable.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			startA, startB := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			numA := strings.TrimLeft(a[startA:i], "0")
+			numB := strings.TrimLeft(b[startB:j], "0")
+			if len(numA) != len(numB) {
+				return len(numA) < len(numB)
+			}
+			if numA != numB {
+				return numA < numB
+			}
+			continue
+		}
+
+		runeA, sizeA := utf8.DecodeRuneInString(a[i:])
+		runeB, sizeB := utf8.DecodeRuneInString(b[j:])
+		if lowerA, lowerB := unicode.ToLower(runeA), unicode.ToLower(runeB); lowerA != lowerB {
+			return lowerA < lowerB
+		}
+		i += sizeA
+		j += sizeB
+	}
+	if remainingA, remainingB := len(a)-i, len(b)-j; remainingA != remainingB {
+		return remainingA < remainingB
+	}
+	return a < b
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"S01E02", "S01E10", true},
+		{"S01E10", "S01E02", false},
+		{"Episode 9", "Episode 10", true},
+		{"S01E10", "S02E01", true},
+		{"ep3", "Ep10", true},
+		{"Ep2", "ep3", true},
+		{"E01", "E1", true},
+		{"E1", "E01", false},
+		{"Show", "Show 2", true},
+		{"Élan 2", "élan 10", true},
+		{"Same", "Same", false},
+	} {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %t", tt.a, tt.b, got)
+		}
+	}
+
+	names := []string{"Ep10", "ep3", "Ep1", "Ep2", "Ep20"}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+	if got := strings.Join(names, ","); got != "Ep1,Ep2,ep3,Ep10,Ep20" {
+		t.Errorf("sorted as %s", got)
+	}
+}
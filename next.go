@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The movie after this one in natural order within its directory, for playing a series
+// episode after episode. Movies are read from the top of each movie directory, so the
+// directory is a season when each season has a -movies-dir of its own.
+func nextMovie(cfg *Config, movieFilePath string) (string, string, bool) {
+	files, err := os.ReadDir(filepath.Dir(movieFilePath))
+	if err != nil {
+		return "", "", false
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		movieName := strings.TrimSuffix(file.Name(), ext)
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || !cfg.servesFormat(ext) || seen[movieName] {
+			continue
+		}
+		seen[movieName] = true
+		names = append(names, movieName)
+	}
+	sort.Slice(names, func(i, j int) bool { return naturalLess(names[i], names[j]) })
+
+	current := movieStem(movieFilePath)
+	for i, movieName := range names {
+		if movieName != current {
+			continue
+		}
+		for _, next := range names[i+1:] {
+			// Skip names served from another directory, where they come first
+			if path, found := findMovie(cfg, next); found && filepath.Dir(path) == filepath.Dir(movieFilePath) {
+				return next, path, true
+			}
+		}
+		break
+	}
+	return "", "", false
+}
+
+// Route for what to play after a movie: the playback info of the next one, or null after
+// the last
+func nextHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+
+		next, nextFilePath, found := nextMovie(cfg, movieFilePath)
+		if !found {
+			return c.JSON(nil)
+		}
+		return c.JSON(playbackFor(c, cfg, next, nextFilePath))
+	}
+}
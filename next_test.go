@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestNextEpisode(t *testing.T) {
+	app, _ := newTestServer(t, "-movies-dir", "movies,season2")
+	for _, name := range []string{"Ep1.mp4", "ep3.mp4", "Ep10.mp4", "notes.txt", ".hidden.mp4"} {
+		writeFile(t, filepath.Join("movies", name), []byte(testMovie))
+	}
+	writeFile(t, filepath.Join("movies", "Ep2.mkv"), []byte(testMatroska))
+	// Ep2 plays from the first directory, so the second season skips it
+	for _, name := range []string{"Ep2.mp4", "S2Ep1.mp4", "S2Ep2.mp4"} {
+		writeFile(t, filepath.Join("season2", name), []byte(testMovie))
+	}
+
+	for _, tt := range []struct{ movie, next string }{
+		{"Ep1", "Ep2"},
+		{"Ep2", "ep3"},
+		{"ep3", "Ep10"},
+		{"S2Ep1", "S2Ep2"},
+	} {
+		resp, body := get(t, app, "/api/next/"+tt.movie)
+		var info playbackInfo
+		if resp.StatusCode != http.StatusOK || json.Unmarshal([]byte(body), &info) != nil {
+			t.Fatalf("next of %s answered %d: %s", tt.movie, resp.StatusCode, body)
+		}
+		if want := playback(t, app, tt.next); info.Name != tt.next || info.VideoURL != want.VideoURL || info.ContentType != want.ContentType {
+			t.Errorf("next of %s is %+v, want %+v", tt.movie, info, want)
+		}
+	}
+
+	// The end of a season
+	for _, movie := range []string{"Ep10", "S2Ep2"} {
+		if resp, body := get(t, app, "/api/next/"+movie); resp.StatusCode != http.StatusOK || body != "null" {
+			t.Errorf("next of the last movie %s answered %d: %s", movie, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := get(t, app, "/api/next/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("next of a missing movie answered %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Answer OPTIONS on every route with 204 and an Allow header listing its methods. Call it
+// after all routes are registered, it builds the list from them.
+func allowOptions(app *fiber.App) {
+	methods := map[string]map[string]bool{}
+	var paths []string
+	for _, route := range app.GetRoutes(true) {
+		if methods[route.Path] == nil {
+			methods[route.Path] = map[string]bool{fiber.MethodOptions: true}
+			paths = append(paths, route.Path)
+		}
+		methods[route.Path][route.Method] = true
+	}
+
+	for _, path := range paths {
+		var allowed []string
+		for method := range methods[path] {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		allow := strings.Join(allowed, ", ")
+
+		app.Options(path, func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderAllow, allow)
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+	}
+}
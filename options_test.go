@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	for target, allow := range map[string]string{
+		"/video/a":          "GET, HEAD, OPTIONS",
+		"/api/movies/a":     "OPTIONS, PATCH",
+		"/api/upload/a.mp4": "OPTIONS, PUT",
+		"/api/read-only":    "GET, HEAD, OPTIONS, PUT",
+	} {
+		// No token needed, the answer only describes the route
+		req, _ := http.NewRequest(http.MethodOptions, target, nil)
+		resp, body := send(t, app, req)
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Allow") != allow {
+			t.Errorf("OPTIONS %s answered %d with Allow %q, want %q: %s", target, resp.StatusCode, resp.Header.Get("Allow"), allow, body)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodOptions, "/no/such/route", nil)
+	if resp, _ := send(t, app, req); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("OPTIONS on an unknown path answered %d", resp.StatusCode)
+	}
+}
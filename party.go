@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Events players in a watch party send each other
+var partyEvents = map[string]bool{"play": true, "pause": true, "seek": true}
+
+// Messages a slow client may fall behind by before it is dropped
+const partySendBuffer = 32
+
+// A client connected to a watch party room
+type partyMember struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+var (
+	partyMu    sync.Mutex
+	partyRooms = map[string]map[*partyMember]bool{}
+)
+
+// Add the member to the room and tell everyone how many are watching
+func joinParty(room string, m *partyMember) {
+	partyMu.Lock()
+	defer partyMu.Unlock()
+	if partyRooms[room] == nil {
+		partyRooms[room] = map[*partyMember]bool{}
+	}
+	partyRooms[room][m] = true
+	broadcastMembers(room)
+}
+
+func leaveParty(room string, m *partyMember) {
+	partyMu.Lock()
+	defer partyMu.Unlock()
+	if !partyRooms[room][m] {
+		return
+	}
+	delete(partyRooms[room], m)
+	close(m.send)
+	if len(partyRooms[room]) == 0 {
+		delete(partyRooms, room)
+		return
+	}
+	broadcastMembers(room)
+}
+
+// Broadcast the member count, partyMu must be held
+func broadcastMembers(room string) {
+	msg, _ := json.Marshal(fiber.Map{"type": "members", "count": len(partyRooms[room])})
+	for member := range partyRooms[room] {
+		deliver(member, msg)
+	}
+}
+
+// Relay a message to everyone in the room except its sender
+func relayParty(room string, from *partyMember, msg []byte) {
+	partyMu.Lock()
+	defer partyMu.Unlock()
+	for member := range partyRooms[room] {
+		if member != from {
+			deliver(member, msg)
+		}
+	}
+}
+
+// Queue a message without blocking the room on one slow client, partyMu must be held
+func deliver(m *partyMember, msg []byte) {
+	select {
+	case m.send <- msg:
+	default:
+		// Too far behind to stay in sync anyway, closing makes its read loop leave the room
+		m.conn.Close()
+	}
+}
+
+// Relay play, pause and seek events between everyone in /ws/sync/:room
+func partyHandler(c *websocket.Conn) {
+	room := c.Params("room")
+	m := &partyMember{conn: c, send: make(chan []byte, partySendBuffer)}
+	c.SetReadLimit(4096)
+
+	// Only this goroutine writes to the connection
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range m.send {
+			if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.Close()
+			}
+		}
+	}()
+
+	joinParty(room, m)
+	for {
+		messageType, msg, err := c.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var event struct {
+			Type string `json:"type"`
+		}
+		if messageType != websocket.TextMessage || json.Unmarshal(msg, &event) != nil || !partyEvents[event.Type] {
+			log.Printf("Ignoring invalid watch party message in room %q", room)
+			continue
+		}
+		relayParty(room, m, msg)
+	}
+	leaveParty(room, m)
+	<-done
+}
+
+// Only let WebSocket upgrades through to the party endpoint, with a sane room name
+func partyUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	if room := c.Params("room"); room == "" || len(room) > 100 {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid room name.")
+	}
+	return c.Next()
+}
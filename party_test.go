@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+func TestWatchParty(t *testing.T) {
+	app, _ := newTestServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	join := func(room string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws/sync/"+room, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	expect := func(conn *websocket.Conn, who, want string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != want {
+			t.Fatalf("%s got %s, %v; want %s", who, msg, err, want)
+		}
+	}
+
+	// Everyone hears how many are in their room as members join
+	first := join("movie-night")
+	expect(first, "first", `{"count":1,"type":"members"}`)
+	second := join("movie-night")
+	expect(first, "first", `{"count":2,"type":"members"}`)
+	expect(second, "second", `{"count":2,"type":"members"}`)
+	third := join("movie-night")
+	for who, conn := range map[string]*websocket.Conn{"first": first, "second": second, "third": third} {
+		expect(conn, who, `{"count":3,"type":"members"}`)
+	}
+	other := join("elsewhere")
+	expect(other, "other room", `{"count":1,"type":"members"}`)
+
+	// Invalid messages are dropped, events reach everyone in the room except the sender
+	seek := `{"type":"seek","time":42.5}`
+	for _, msg := range []string{"hello", `{"type":"dance"}`, seek} {
+		if err := first.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(second, "second", seek)
+	expect(third, "third", seek)
+
+	// Leaving is announced, and the sender never got its own event back
+	third.Close()
+	expect(first, "first", `{"count":2,"type":"members"}`)
+	expect(second, "second", `{"count":2,"type":"members"}`)
+
+	// The other room heard nothing of it
+	other.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, msg, err := other.ReadMessage(); err == nil {
+		t.Errorf("other room got %s", msg)
+	}
+
+	if resp, _ := get(t, app, "/ws/sync/movie-night"); resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("plain HTTP request answered %d", resp.StatusCode)
+	}
+}
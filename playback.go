@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Everything a player needs to start a movie, so clients don't have to guess URLs
+type playbackInfo struct {
+	Name        string `json:"name"`
+	VideoURL    string `json:"videoUrl"`
+	ContentType string `json:"contentType"`
+
+	// Cleaned up from the file name like in the catalog, the year null without one
+	Title string `json:"title"`
+	Year  *int   `json:"year"`
+
+	// Null when the movie has no default subtitles, no poster (with -placeholder none), or when
+	// neither ffprobe nor the MP4 header tell its duration
+	SubtitleURL     *string  `json:"subtitleUrl"`
+	PosterURL       *string  `json:"posterUrl"`
+	DurationSeconds *float64 `json:"durationSeconds"`
+
+	// Whether an MP4 can start before it is fully downloaded, null for other formats
+	Faststart *bool `json:"faststart"`
+
+	// Every subtitle track, the one subtitleUrl points at marked default
+	Subtitles []subtitleTrack `json:"subtitles"`
+
+	// Custom metadata from [Movie].meta.json, null without one
+	Meta json.RawMessage `json:"meta"`
+}
+
+func playbackHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+		if !checkMovieHeader(c, movieFilePath) {
+			return nil
+		}
+		return c.JSON(playbackFor(c, cfg, movieName, movieFilePath))
+	}
+}
+
+// The playback info of a movie, with the default subtitles chosen for this request
+func playbackFor(c *fiber.Ctx, cfg *Config, movieName, movieFilePath string) playbackInfo {
+	escaped := url.PathEscape(movieName)
+	info := playbackInfo{
+		Name:        movieName,
+		VideoURL:    "/video/" + escaped,
+		ContentType: contentTypes[strings.ToLower(filepath.Ext(movieFilePath))],
+		Meta:        readMeta(movieFilePath),
+	}
+	info.Title, info.Year = cleanTitle(movieName, cfg.TitleTags)
+
+	if faststart, ok := isFaststart(movieFilePath); ok {
+		info.Faststart = &faststart
+	}
+
+	// The default track follows ?lang= or the browser's languages
+	info.Subtitles = findSubtitleTracks(cfg, movieName)
+	if info.Subtitles == nil {
+		info.Subtitles = []subtitleTrack{}
+	}
+	if track := chooseSubtitleTrack(info.Subtitles, preferredLanguages(c, cfg)); track != nil {
+		info.SubtitleURL = &track.URL
+	}
+
+	// The poster endpoint always has something to show unless the placeholder is off
+	hasPoster := cfg.Placeholder != "none"
+	if !hasPoster {
+		_, hasPoster = findPoster(cfg, movieName)
+	}
+	if !hasPoster {
+		_, hasPoster = findEmbeddedCover(cfg, requestID(c), movieName)
+	}
+	if hasPoster {
+		posterURL := "/poster/" + escaped
+		info.PosterURL = &posterURL
+	}
+
+	if haveTool("ffprobe") {
+		if probe, err := probeMovie(requestID(c), movieFilePath); err == nil {
+			if duration, ok := probe.duration(); ok {
+				info.DurationSeconds = &duration
+			}
+		} else {
+			logRequest(requestID(c), "Could not probe %s: %v", movieFilePath, err)
+		}
+	}
+	// Without ffprobe an MP4 still tells its duration in its header
+	if info.DurationSeconds == nil {
+		if duration, ok := mp4Duration(movieFilePath); ok {
+			info.DurationSeconds = &duration
+		}
+	}
+	return info
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func playback(t *testing.T, app *fiber.App, movie string) playbackInfo {
+	t.Helper()
+	resp, body := get(t, app, "/api/movies/"+movie+"/playback")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("playback of %s answered %d: %s", movie, resp.StatusCode, body)
+	}
+	var info playbackInfo
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	return info
+}
+
+func TestPlayback(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mkv"), []byte(testMatroska))
+	writeFile(t, filepath.Join("movies", "a.srt"), []byte(testSRT))
+	writeFile(t, filepath.Join("movies", "a.jpg"), []byte("poster"))
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+
+	// Stub ffprobe knows the duration
+	available := availableTools["ffprobe"]
+	availableTools["ffprobe"] = true
+	t.Cleanup(func() { availableTools["ffprobe"] = available })
+	probes := scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":320,"height":240}],"format":{"duration":"5400.5"}}`})
+
+	info := playback(t, app, "a")
+	if info.Name != "a" || info.VideoURL != "/video/a" || info.ContentType != "video/x-matroska" {
+		t.Errorf("a has name %q, video %q as %q", info.Name, info.VideoURL, info.ContentType)
+	}
+	if info.SubtitleURL == nil || *info.SubtitleURL != "/subtitles/a" || info.PosterURL == nil || *info.PosterURL != "/poster/a" {
+		t.Errorf("a has subtitles %v and poster %v", info.SubtitleURL, info.PosterURL)
+	}
+	if info.DurationSeconds == nil || *info.DurationSeconds != 5400.5 {
+		t.Errorf("a lasts %v", info.DurationSeconds)
+	}
+
+	// The probe is cached until the file changes
+	playback(t, app, "a")
+	if probes.Load() != 1 {
+		t.Errorf("ffprobe ran %d times for an unchanged movie", probes.Load())
+	}
+
+	// Without sidecars only the placeholder poster is left
+	info = playback(t, app, "b")
+	if info.SubtitleURL != nil || info.PosterURL == nil {
+		t.Errorf("b has subtitles %v and poster %v", info.SubtitleURL, info.PosterURL)
+	}
+
+	if resp, _ := get(t, app, "/api/movies/missing/playback"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing movie answered %d", resp.StatusCode)
+	}
+}
+
+func TestPlaybackNothingToShow(t *testing.T) {
+	app, _ := newTestServer(t, "-placeholder", "none")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	// No sidecars, no placeholder and no ffprobe: every optional field is null
+	_, body := get(t, app, "/api/movies/a/playback")
+	if want := `{"name":"a","videoUrl":"/video/a","contentType":"video/mp4","title":"a","year":null,"subtitleUrl":null,"posterUrl":null,"durationSeconds":null,"faststart":null,"subtitles":[],"meta":null}`; body != want {
+		t.Errorf("got %s, want %s", body, want)
+	}
+}
+
+// The URLs handed out have to lead back to the movie, whatever its name
+func TestPlaybackEscapedNames(t *testing.T) {
+	app, _ := newTestServer(t)
+	for _, name := range []string{"The Matrix", "100% Love", "Why?", "No #1"} {
+		writeFile(t, filepath.Join("movies", name+".mp4"), []byte("movie "+name))
+		writeFile(t, filepath.Join("movies", name+".srt"), []byte(testSRT))
+		writeFile(t, filepath.Join("movies", name+".jpg"), []byte("poster"))
+
+		info := playback(t, app, escapePath(name))
+		if info.Name != name {
+			t.Errorf("name %q, want %q", info.Name, name)
+		}
+		if info.SubtitleURL == nil || info.PosterURL == nil {
+			t.Fatalf("%s: subtitle or poster URL missing", name)
+		}
+		for _, target := range []string{info.VideoURL, *info.SubtitleURL, *info.PosterURL} {
+			if resp, body := get(t, app, target); resp.StatusCode != http.StatusOK {
+				t.Errorf("%s: %s answered %d: %s", name, target, resp.StatusCode, body)
+			}
+		}
+		if _, body := get(t, app, info.VideoURL); body != "movie "+name {
+			t.Errorf("%s: %s served %q", name, info.VideoURL, body)
+		}
+	}
+}
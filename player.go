@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Template data structure
+type PageData struct {
+	Title     string
+	MovieName string
+	// MovieName escaped for the page's URLs
+	MoviePath   string
+	ContentType string
+	Subtitles   []subtitleTrack
+	DashURL     string
+	// Seek bar thumbnails for players that read a metadata track, empty without ffmpeg
+	ThumbnailsURL string
+	PWA           bool
+	// Audio stream from ?audio=, numbered from 0, nil to leave it to the file
+	AudioTrack *int
+	// Nonce of the page's scripts and styles under a Content-Security-Policy, empty without one
+	Nonce string
+}
+
+func playerHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+
+		// Locate file with supported extension
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+
+		// Set the correct content type based on file extension, findMovie only finds known ones
+		contentType := contentTypes[strings.ToLower(filepath.Ext(movieFilePath))]
+
+		// Parse and execute the HTML template
+		tmpl, err := template.ParseFiles("index.html")
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to load HTML template.")
+		}
+
+		// Every subtitle track for the player's menu, the preferred language shown by default.
+		// A deep link's ?sub= names the language to show and has to have a track.
+		subtitles := findSubtitleTracks(cfg, movieName)
+		if sub := c.Query("sub"); sub != "" {
+			i := -1
+			if languageTag.MatchString(sub) {
+				i = subtitleTrackIn(subtitles, normalizeLanguage(sub))
+			}
+			if i < 0 {
+				var languages []string
+				for _, track := range subtitles {
+					if track.Language != "" {
+						languages = append(languages, track.Language)
+					}
+				}
+				if len(languages) == 0 {
+					return c.Status(fiber.StatusBadRequest).SendString("The movie has no subtitles in any language.")
+				}
+				return c.Status(fiber.StatusBadRequest).SendString("No subtitles in that language, expected one of: " + strings.Join(languages, ", ") + ".")
+			}
+			subtitles[i].Default = true
+		} else {
+			chooseSubtitleTrack(subtitles, preferredLanguages(c, cfg))
+		}
+		data := PageData{
+			Title:       fmt.Sprintf("Streaming %s", movieName),
+			MovieName:   movieName,
+			MoviePath:   url.PathEscape(movieName),
+			ContentType: contentType,
+			Subtitles:   subtitles,
+			PWA:         cfg.PWA,
+			Nonce:       cspNonce(c, cfg),
+		}
+
+		// ?audio= picks an audio stream, which only ffprobe can tell exists
+		if audio := c.Query("audio"); audio != "" {
+			if !haveTool("ffprobe") {
+				return c.Status(fiber.StatusNotImplemented).SendString("Choosing an audio track needs ffprobe, which is not installed.")
+			}
+			count, err := countAudioStreams(requestID(c), movieFilePath)
+			if err != nil {
+				return toolFailure(c, err, "Could not read the audio tracks.")
+			}
+			number, err := strconv.Atoi(audio)
+			if err != nil || number < 0 || number >= count {
+				return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("No such audio track, the movie has %d, numbered from 0.", count))
+			}
+			data.AudioTrack = &number
+		}
+
+		// Let the player switch to DASH when we can package the movie. Packages only carry
+		// the first audio track, a link asking for another one plays the file.
+		if cfg.Dash && haveTool("ffmpeg") && (data.AudioTrack == nil || *data.AudioTrack == 0) {
+			data.DashURL = fmt.Sprintf("/dash/%s/manifest.mpd", data.MoviePath)
+		}
+
+		// Thumbnails need ffprobe for the size and duration as well
+		if haveTool("ffmpeg") && haveTool("ffprobe") {
+			data.ThumbnailsURL = fmt.Sprintf("/thumbnails/%s/track.vtt", data.MoviePath)
+		}
+
+		// Render the whole page before sending any of it. Execute stops at the first runtime
+		// error with part of the page written, which must never reach the browser, so the
+		// template is not executed straight into the response.
+		var renderedPage strings.Builder
+		if err := tmpl.Execute(&renderedPage, data); err != nil {
+			logRequest(requestID(c), "Could not render the player for %s: %v", movieName, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to render HTML template.")
+		}
+
+		return c.Status(fiber.StatusOK).Type("html").SendString(renderedPage.String())
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	nonceAttr  = regexp.MustCompile(`nonce="([^"]*)"`)
+	nonceValue = regexp.MustCompile(`^[A-Za-z0-9_-]{22}$`)
+)
+
+// Check that every script and style tag of a page carries the nonce of its policy
+func checkNonces(t *testing.T, target string, resp *http.Response, page string) {
+	t.Helper()
+	policy := resp.Header.Get("Content-Security-Policy")
+	matches := nonceAttr.FindAllStringSubmatch(page, -1)
+	if len(matches) == 0 || len(matches) != strings.Count(page, "<script")+strings.Count(page, "<style") {
+		t.Fatalf("%s: %d nonces in the page:\n%s", target, len(matches), page)
+	}
+	for _, m := range matches {
+		// URL-safe, so the page carries the same text as the header
+		if !nonceValue.MatchString(m[1]) || !strings.Contains(policy, "'nonce-"+m[1]+"'") {
+			t.Errorf("%s: nonce %q not in policy %q", target, m[1], policy)
+		}
+	}
+}
+
+func TestPlayerRenderError(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	page := "<!DOCTYPE html><title>{{.Title}}</title>" + strings.Repeat("<p>Filler</p>", 1000)
+	writeFile(t, "index.html", []byte(page+"{{index .Subtitles 5}}</html>"))
+	logged := captureLog(t)
+
+	// Nothing of the part rendered before the error is sent
+	resp, body := get(t, app, "/stream/a")
+	if resp.StatusCode != http.StatusInternalServerError || body != "Failed to render HTML template." {
+		t.Errorf("failed render answered %d: %.100q", resp.StatusCode, body)
+	}
+	if !strings.Contains(logged.String(), "Could not render the player for a:") || !strings.Contains(logged.String(), "index") {
+		t.Errorf("log doesn't name the template error:\n%s", logged)
+	}
+
+	writeFile(t, "index.html", []byte(page+"</html>"))
+	if resp, body := get(t, app, "/stream/a"); resp.StatusCode != http.StatusOK || !strings.Contains(body, "<title>Streaming a</title>") {
+		t.Errorf("fixed template answered %d: %.100q", resp.StatusCode, body)
+	}
+}
+
+func TestPlayerDeepLinks(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	for _, file := range []string{"a.en.srt", "a.pt-BR.srt"} {
+		writeFile(t, filepath.Join("movies", file), []byte(strings.Replace(testSRT, "%s", "Hello", 1)))
+	}
+	ffmpeg, ffprobe := availableTools["ffmpeg"], availableTools["ffprobe"]
+	t.Cleanup(func() { availableTools["ffmpeg"], availableTools["ffprobe"] = ffmpeg, ffprobe })
+	availableTools["ffmpeg"], availableTools["ffprobe"] = true, true
+	probes := scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"index":1},{"index":2},{"index":3}]}`})
+
+	// The manifest URL as html/template escapes it in the script
+	dash := `"\/dash\/a\/manifest.mpd"`
+
+	// ?sub= wins over Accept-Language and is the only default
+	req, _ := http.NewRequest(http.MethodGet, "/stream/a?sub=pt&audio=2", nil)
+	req.Header.Set("Accept-Language", "en")
+	resp, page := send(t, app, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("deep link answered %d: %s", resp.StatusCode, page)
+	}
+	if !strings.Contains(page, `<track kind="subtitles" src="/subtitles/a?lang=pt-BR" label="Portuguese (BR)" srclang="pt-BR" default />`) ||
+		strings.Count(page, " default />") != 1 {
+		t.Errorf("player doesn't show only the pt-BR subtitles by default:\n%s", page)
+	}
+	// The third audio track is enabled, and DASH left out since packages only have the first
+	if !strings.Contains(page, "tracks[i].enabled = i ===  2 ;") || strings.Contains(page, dash) {
+		t.Errorf("player doesn't switch to audio track 2 without DASH:\n%s", page)
+	}
+	if _, page := get(t, app, "/stream/a?audio=0"); !strings.Contains(page, dash) {
+		t.Errorf("first audio track doesn't keep DASH:\n%s", page)
+	}
+	if probes.Load() != 1 {
+		t.Errorf("ffprobe ran %d times for an unchanged file", probes.Load())
+	}
+
+	for _, tt := range []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{"?sub=de", http.StatusBadRequest, "No subtitles in that language, expected one of: en, pt-BR."},
+		{"?sub=not+a+language", http.StatusBadRequest, "No subtitles in that language, expected one of: en, pt-BR."},
+		{"?audio=3", http.StatusBadRequest, "No such audio track, the movie has 3, numbered from 0."},
+		{"?audio=-1", http.StatusBadRequest, "No such audio track, the movie has 3, numbered from 0."},
+		{"?audio=first", http.StatusBadRequest, "No such audio track, the movie has 3, numbered from 0."},
+	} {
+		if resp, body := get(t, app, "/stream/a"+tt.query); resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("%s answered %d: %s", tt.query, resp.StatusCode, body)
+		}
+	}
+
+	availableTools["ffprobe"] = false
+	if resp, _ := get(t, app, "/stream/a?audio=1"); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("?audio= without ffprobe answered %d", resp.StatusCode)
+	}
+}
+
+func TestPlayerNonceMatchesPolicy(t *testing.T) {
+	app, _ := newTestServer(t, "-headers", "Content-Security-Policy: default-src 'self'")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	ffprobe := availableTools["ffprobe"]
+	availableTools["ffprobe"] = true
+	t.Cleanup(func() { availableTools["ffprobe"] = ffprobe })
+	scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"index":1},{"index":2}]}`})
+
+	// The audio track script as well, which sits inside {{ with .AudioTrack }}
+	for _, target := range []string{"/stream/a", "/stream/a?audio=1"} {
+		resp, page := get(t, app, target)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s answered %d: %s", target, resp.StatusCode, page)
+		}
+		checkNonces(t, target, resp, page)
+	}
+	resp, _ := get(t, app, "/stream/a")
+
+	// A fresh one for every request
+	again, _ := get(t, app, "/stream/a")
+	if again.Header.Get("Content-Security-Policy") == resp.Header.Get("Content-Security-Policy") {
+		t.Error("two requests got the same nonce")
+	}
+	// Other routes keep the policy as it is
+	if resp, _ := get(t, app, "/api/movies"); resp.Header.Get("Content-Security-Policy") != "default-src 'self'" {
+		t.Errorf("catalog sent Content-Security-Policy %q", resp.Header.Get("Content-Security-Policy"))
+	}
+}
+
+func TestPlayerWithoutPolicy(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	resp, page := get(t, app, "/stream/a")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Security-Policy") != "" {
+		t.Fatalf("player answered %d with Content-Security-Policy %q", resp.StatusCode, resp.Header.Get("Content-Security-Policy"))
+	}
+	for _, m := range nonceAttr.FindAllStringSubmatch(page, -1) {
+		if m[1] != "" {
+			t.Errorf("nonce %q without a policy", m[1])
+		}
+	}
+}
+
+func TestPlayerEscapesMovieName(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "100% Love?.mp4"), []byte(testMovie))
+
+	resp, page := get(t, app, "/stream/"+escapePath("100% Love?"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("player answered %d: %s", resp.StatusCode, page)
+	}
+	for _, want := range []string{`src="/video/100%25%20Love%3F"`, `poster="/poster/100%25%20Love%3F"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("player lacks %s", want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Placeholder shown when a movie has no poster of its own
+//
+//go:embed placeholder.svg
+var placeholderPoster []byte
+
+// Image extensions probed for a poster next to the movie file
+var posterExtensions = []string{"jpg", "jpeg", "png", "webp"}
+
+// Extensions for the image types cover art is usually embedded as
+var coverExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+	"image/gif":  "gif",
+}
+
+var coverLocks sync.Map
+
+// Find cover art embedded in the movie, extracting it with ffmpeg on first use.
+// Results, including "there is none", are cached until the movie file changes.
+func findEmbeddedCover(cfg *Config, rid, movieName string) (string, bool) {
+	movieFilePath, found := findMovie(cfg, movieName)
+	if !found {
+		return "", false
+	}
+	movieInfo, err := os.Stat(movieFilePath)
+	if err != nil {
+		return "", false
+	}
+
+	defer lockKey(&coverLocks, movieName)()
+
+	// A cached result is good as long as it is newer than the movie
+	noneMarker := filepath.Join(cfg.CoverDir, movieName+".none")
+	for _, candidate := range append([]string{noneMarker}, cachedCoverPaths(cfg, movieName)...) {
+		if info, err := os.Stat(candidate); err == nil && !info.ModTime().Before(movieInfo.ModTime()) {
+			touchCache(candidate)
+			return candidate, candidate != noneMarker
+		}
+	}
+
+	if !haveTool("ffmpeg") {
+		return "", false
+	}
+
+	// Copy the attached picture stream as-is, the format is sniffed from the bytes afterwards
+	image, err := runTool(rid, "ffmpeg", "-nostdin", "-loglevel", "error",
+		"-i", movieFilePath,
+		"-map", "0:v", "-map", "-0:V", "-frames:v", "1",
+		"-c", "copy", "-f", "image2pipe", "-")
+	if errors.Is(err, errToolUnavailable) {
+		// Says nothing about the movie, ask again once ffmpeg is back
+		return "", false
+	}
+
+	if err := os.MkdirAll(cfg.CoverDir, 0o755); err != nil {
+		logRequest(rid, "Could not create cover cache %s: %v", cfg.CoverDir, err)
+		return "", false
+	}
+	for _, stale := range append([]string{noneMarker}, cachedCoverPaths(cfg, movieName)...) {
+		os.Remove(stale)
+	}
+
+	ext, known := coverExtensions[http.DetectContentType(image)]
+	if err != nil || len(image) == 0 || !known {
+		// Most movies simply have no cover art, remember that instead of asking ffmpeg again
+		if err != nil {
+			logRequest(rid, "No embedded cover in %s: %v", movieFilePath, err)
+		}
+		os.WriteFile(noneMarker, nil, 0o644)
+		return "", false
+	}
+
+	path := filepath.Join(cfg.CoverDir, movieName+"."+ext)
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		// A partly written image, e.g. on a full disk, would be served as the cover
+		os.Remove(path)
+		logRequest(rid, "Could not cache cover for %s: %v", movieFilePath, err)
+		return "", false
+	}
+	logRequest(rid, "Extracted embedded cover of %s", movieFilePath)
+	pruneCache(cfg)
+	return path, true
+}
+
+func cachedCoverPaths(cfg *Config, movieName string) []string {
+	var paths []string
+	for _, ext := range coverExtensions {
+		paths = append(paths, filepath.Join(cfg.CoverDir, movieName+"."+ext))
+	}
+	return paths
+}
+
+// Locate a poster image next to the movie
+func findPoster(cfg *Config, movieName string) (string, bool) {
+	for _, ext := range posterExtensions {
+		path := sidecarPath(cfg, movieName, ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func posterHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+
+		// Locate a poster image sharing the movie's name
+		if path, found := findPoster(cfg, movieName); found {
+			return sendFile(c, path)
+		}
+
+		// Then cover art embedded in the movie itself
+		if path, found := findEmbeddedCover(cfg, requestID(c), movieName); found {
+			return sendFile(c, path)
+		}
+
+		// Fall back to the placeholder so the page never shows a broken image
+		switch cfg.Placeholder {
+		case "none":
+			return c.Status(fiber.StatusNotFound).SendString("Poster not found.")
+		case "builtin":
+			return sendBytes(c, placeholderPoster, "image/svg+xml")
+		default:
+			return sendFile(c, cfg.Placeholder)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPosterPlaceholder(t *testing.T) {
+	for _, tt := range []struct {
+		placeholder string
+		status      int
+		contentType string
+	}{
+		{"builtin", http.StatusOK, "image/svg+xml"},
+		{"none", http.StatusNotFound, "text/plain; charset=utf-8"},
+	} {
+		app, _ := newTestServer(t, "-placeholder", tt.placeholder)
+		resp, body := get(t, app, "/poster/a")
+		if resp.StatusCode != tt.status || resp.Header.Get("Content-Type") != tt.contentType {
+			t.Errorf("-placeholder %s answered %d as %s", tt.placeholder, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if tt.placeholder == "builtin" && body != string(placeholderPoster) {
+			t.Errorf("-placeholder builtin answered %q", body)
+		}
+	}
+}
+
+func TestPosterFallbackChain(t *testing.T) {
+	app, cfg := newTestServer(t, "-placeholder", "none")
+	past := time.Now().Add(-time.Hour)
+	for _, movie := range []string{"sidecar", "cached", "none", "stale"} {
+		path := filepath.Join("movies", movie+".mp4")
+		writeFile(t, path, []byte(testMovie))
+		os.Chtimes(path, past, past)
+	}
+	png := "\x89PNG\r\n\x1a\nimage"
+	writeFile(t, filepath.Join("movies", "sidecar.jpg"), []byte("sidecar poster"))
+	writeFile(t, filepath.Join(cfg.CoverDir, "sidecar.png"), []byte(png))
+	writeFile(t, filepath.Join(cfg.CoverDir, "cached.png"), []byte(png))
+	writeFile(t, filepath.Join(cfg.CoverDir, "none.none"), nil)
+	// A cover extracted before the movie last changed is extracted again, which finds
+	// nothing in a file that isn't really a video
+	writeFile(t, filepath.Join(cfg.CoverDir, "stale.png"), []byte(png))
+	older := past.Add(-time.Hour)
+	os.Chtimes(filepath.Join(cfg.CoverDir, "stale.png"), older, older)
+
+	tests := []struct {
+		movie, contentType, body string
+		status                   int
+	}{
+		{"sidecar", "image/jpeg", "sidecar poster", http.StatusOK},
+		{"cached", "image/png", png, http.StatusOK},
+		{"none", "text/plain; charset=utf-8", "Poster not found.", http.StatusNotFound},
+		{"stale", "text/plain; charset=utf-8", "Poster not found.", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		resp, body := get(t, app, "/poster/"+tt.movie)
+		if resp.StatusCode != tt.status || resp.Header.Get("Content-Type") != tt.contentType || body != tt.body {
+			t.Errorf("%s answered %d as %s: %q", tt.movie, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
+func TestPosterExtractsEmbeddedCover(t *testing.T) {
+	requireTools(t, "ffmpeg")
+	app, cfg := newTestServer(t, "-placeholder", "none")
+	cover, movie := filepath.Join(t.TempDir(), "cover.png"), filepath.Join("movies", "a.mkv")
+	for _, args := range [][]string{
+		{"-f", "lavfi", "-i", "color=red:size=32x32", "-frames:v", "1", cover},
+		{"-f", "lavfi", "-i", "testsrc=duration=1:size=64x64:rate=10", "-c:v", "mpeg4", "-attach", cover,
+			"-metadata:s:t", "mimetype=image/png", movie},
+	} {
+		if output, err := exec.Command("ffmpeg", append([]string{"-nostdin", "-loglevel", "error"}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("could not make a sample movie: %v: %s", err, output)
+		}
+	}
+
+	resp, body := get(t, app, "/poster/a")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" || !strings.HasPrefix(body, "\x89PNG") {
+		t.Fatalf("embedded cover answered %d as %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if _, err := os.Stat(filepath.Join(cfg.CoverDir, "a.png")); err != nil {
+		t.Errorf("extracted cover not cached: %v", err)
+	}
+}
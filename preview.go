@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var previewLocks sync.Map
+
+// Cached preview clip for the current -preview-duration and -preview-height
+func previewPath(cfg *Config, movieName string) string {
+	return filepath.Join(cfg.PreviewDir, movieName, fmt.Sprintf("preview-%dms-%d.mp4", cfg.PreviewDuration.Milliseconds(), cfg.PreviewHeight))
+}
+
+// Encode a short, small clip from the start of the movie with ffmpeg, unless it is cached
+// and newer than the movie. Only the first -preview-duration is read, so this is quick
+// even for long movies.
+func ensurePreview(cfg *Config, rid, movieName, movieFilePath string) (string, error) {
+	defer lockKey(&previewLocks, movieName)()
+
+	movieInfo, err := os.Stat(movieFilePath)
+	if err != nil {
+		return "", err
+	}
+	clip := previewPath(cfg, movieName)
+	if info, err := os.Stat(clip); err == nil && !info.ModTime().Before(movieInfo.ModTime()) {
+		touchCache(filepath.Dir(clip))
+		return clip, nil
+	}
+
+	dir := filepath.Dir(clip)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	// Silent H.264 at a low quality, playable everywhere and starting before it is fully loaded
+	logRequest(rid, "Generating a preview of %s", movieFilePath)
+	tmpClip := clip + ".tmp"
+	_, err = runToolFor(rid, cfg.JobTimeout, "ffmpeg", "-nostdin", "-loglevel", "error",
+		"-t", strconv.FormatFloat(cfg.PreviewDuration.Seconds(), 'f', -1, 64), "-i", movieFilePath,
+		"-map", "0:v:0", "-an", "-sn",
+		"-vf", fmt.Sprintf("scale=-2:%d", cfg.PreviewHeight),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "32", "-pix_fmt", "yuv420p",
+		"-movflags", "+faststart", "-f", "mp4", "-y", tmpClip)
+	if err != nil {
+		os.Remove(tmpClip)
+		// Only removed when empty, a clip of another length or height stays
+		os.Remove(dir)
+		logRequest(rid, "Failed to generate a preview of %s: %v", movieFilePath, err)
+		return "", err
+	}
+	if err := os.Rename(tmpClip, clip); err != nil {
+		os.Remove(tmpClip)
+		return "", err
+	}
+	pruneCache(cfg)
+	return clip, nil
+}
+
+// Route for a movie's preview clip, e.g. for previews on hover in the library
+func previewHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !haveTool("ffmpeg") {
+			return c.Status(fiber.StatusNotImplemented).SendString("Previews need ffmpeg, which is not installed.")
+		}
+
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		clip, err := ensurePreview(cfg, requestID(c), movieName, movieFilePath)
+		if err != nil {
+			return toolFailure(c, err, "Failed to generate a preview.")
+		}
+		return sendFileAs(c, clip, "video/mp4")
+	}
+}
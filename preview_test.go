@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPreviewCached(t *testing.T) {
+	app, cfg := newTestServer(t, "-preview-duration", "4s", "-preview-height", "120", "-api-token", testToken)
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(testMovie))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	runs := scriptTool(t, "ffmpeg", toolRun{exit: 1, stderr: "Invalid data found when processing input"}, toolRun{output: "clip"})
+
+	// A failed encode leaves nothing behind
+	if resp, body := get(t, app, "/preview/a"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("failed encode answered %d: %s", resp.StatusCode, body)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.PreviewDir, "a")); err == nil {
+		t.Error("failed encode left its directory")
+	}
+
+	resp, body := get(t, app, "/preview/a")
+	if resp.StatusCode != http.StatusOK || body != "clip" || resp.Header.Get("Content-Type") != "video/mp4" {
+		t.Fatalf("preview answered %d as %q: %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	clip := filepath.Join(cfg.PreviewDir, "a", "preview-4000ms-120.mp4")
+	if content, err := os.ReadFile(clip); string(content) != "clip" {
+		t.Errorf("cached clip %q: %v", content, err)
+	}
+
+	// Cached clips are reused and ranges work on them
+	req, _ := http.NewRequest(http.MethodGet, "/preview/a", nil)
+	req.Header.Set("Range", "bytes=1-")
+	if resp, body := send(t, app, req); resp.StatusCode != http.StatusPartialContent || body != "lip" {
+		t.Errorf("range of the preview answered %d: %q", resp.StatusCode, body)
+	}
+	if runs.Load() != 2 {
+		t.Errorf("ffmpeg ran %d times for a cached clip", runs.Load())
+	}
+
+	// A newer movie gets a new clip
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(movie, later, later)
+	get(t, app, "/preview/a")
+	if runs.Load() != 3 {
+		t.Errorf("ffmpeg ran %d times after the movie changed", runs.Load())
+	}
+
+	// A renamed movie doesn't leave its clip to the next one of the old name
+	if resp, body := renameMovie(t, app, "a", "b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	if _, err := os.Stat(clip); err == nil {
+		t.Error("clip kept after a rename")
+	}
+
+	if resp, _ := get(t, app, "/preview/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing movie answered %d", resp.StatusCode)
+	}
+}
+
+func TestPreviewSharedByConcurrentRequests(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	runs := scriptTool(t, "ffmpeg", toolRun{output: "0123456789", sleep: 200 * time.Millisecond})
+
+	// Viewers arriving while the clip is made wait for that one job, and seeks read the file
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "/preview/a", nil)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", i))
+			if resp, body := send(t, app, req); resp.StatusCode != http.StatusPartialContent || body != "0123456789"[i:] {
+				t.Errorf("seek to %d answered %d: %q", i, resp.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+	if runs.Load() != 1 {
+		t.Errorf("ffmpeg ran %d times for one clip", runs.Load())
+	}
+}
+
+func TestPreviewDiskFull(t *testing.T) {
+	app, cfg := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	scriptTool(t, "ffmpeg", toolRun{output: "cl", stderr: "Error writing trailer: No space left on device", exit: 1})
+
+	if resp, body := get(t, app, "/preview/a"); resp.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("encode on a full disk answered %d: %s", resp.StatusCode, body)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.PreviewDir, "a")); err == nil {
+		t.Error("encode on a full disk left its partial clip")
+	}
+}
+
+func TestPreviewWithoutFFmpeg(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = false
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	if resp, _ := get(t, app, "/preview/a"); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("preview without ffmpeg answered %d", resp.StatusCode)
+	}
+
+	for _, args := range [][]string{{"-preview-duration", "500ms"}, {"-preview-duration", "10m"}, {"-preview-height", "8"}, {"-preview-height", "2160"}} {
+		if _, err := loadConfig(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	requireTools(t, "ffmpeg", "ffprobe")
+	app, cfg := newTestServer(t, "-preview-duration", "2s", "-preview-height", "120")
+	if out, err := exec.Command("ffmpeg", "-nostdin", "-loglevel", "error", "-f", "lavfi", "-i", "testsrc=duration=6:size=640x480:rate=10",
+		"-pix_fmt", "yuv420p", filepath.Join("movies", "a.mp4")).CombinedOutput(); err != nil {
+		t.Fatalf("making a test video: %v: %s", err, out)
+	}
+
+	if resp, body := get(t, app, "/preview/a"); resp.StatusCode != http.StatusOK || !strings.Contains(body[:min(len(body), 64)], "ftyp") {
+		t.Fatalf("preview answered %d: %.64q", resp.StatusCode, body)
+	}
+	probed, err := probeMovie("test", previewPath(cfg, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	duration, _ := probed.duration()
+	if len(probed.Streams) != 1 || probed.Streams[0].Height != 120 || duration < 1.5 || duration > 2.5 {
+		t.Errorf("preview is %+v", probed)
+	}
+}
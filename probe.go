@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// What ffprobe reports about a movie, as far as the server needs it
+type probeResult struct {
+	Streams []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// Duration in seconds, false when ffprobe couldn't tell
+func (p *probeResult) duration() (float64, bool) {
+	d, err := strconv.ParseFloat(p.Format.Duration, 64)
+	return d, err == nil && d > 0
+}
+
+// Probe results by path, reused until the file changes
+var probeCache sync.Map
+
+type cachedProbe struct {
+	modTime time.Time
+	size    int64
+	result  *probeResult
+}
+
+// Ask ffprobe for the size of the first video stream and the duration of the movie
+func probeMovie(rid, movieFilePath string) (*probeResult, error) {
+	info, err := os.Stat(movieFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := probeCache.Load(movieFilePath); ok {
+		cached := cached.(cachedProbe)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.result, nil
+		}
+	}
+
+	output, err := runTool(rid, "ffprobe", "-v", "error",
+		"-select_streams", "v:0", "-show_entries", "stream=width,height:format=duration",
+		"-of", "json", movieFilePath)
+	if err != nil {
+		return nil, err
+	}
+	result := &probeResult{}
+	if err := json.Unmarshal(output, result); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %w", err)
+	}
+
+	probeCache.Store(movieFilePath, cachedProbe{modTime: info.ModTime(), size: info.Size(), result: result})
+	return result, nil
+}
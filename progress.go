@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Share of a movie after which it counts as watched, so the credits don't have to be sat through
+const watchedThreshold = 0.9
+
+// Where playback of a movie was left, by name
+type progressSet map[string]progressEntry
+
+type progressEntry struct {
+	Position  float64   `json:"position"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Body of a progress report, in seconds. The duration is only used when ffprobe can't tell.
+type progressRequest struct {
+	Position *float64 `json:"position"`
+	Duration float64  `json:"duration"`
+}
+
+func getProgressHandler(data *userData) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// A heartbeat not written yet is the latest position
+		entry, found := data.heartbeats.latest(c.Params("movie"))
+		if !found {
+			data.progress.Read(func(progress progressSet) { entry, found = progress[c.Params("movie")] })
+		}
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("No progress saved for this movie.")
+		}
+		return c.JSON(entry)
+	}
+}
+
+// Save the playback position, marking the movie watched once it is near the end
+func putProgressHandler(cfg *Config, data *userData) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		var req progressRequest
+		if err := c.BodyParser(&req); err != nil || req.Position == nil || *req.Position < 0 || req.Duration < 0 {
+			return c.Status(fiber.StatusBadRequest).SendString(`Expected {"position": seconds}.`)
+		}
+
+		entry := progressEntry{Position: *req.Position, UpdatedAt: time.Now().UTC()}
+		// A position reported through the heartbeat and not written yet is older than this one
+		data.heartbeats.flushMu.Lock()
+		data.heartbeats.discard(movieName)
+		err := saveProgress(rid, data, movieName, movieFilePath, entry, req.Duration)
+		data.heartbeats.flushMu.Unlock()
+		if err != nil {
+			logRequest(rid, "Could not save progress: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not save progress.")
+		}
+		return c.JSON(fiber.Map{"position": entry.Position, "watched": inMovieSet(data.watched, movieName)})
+	}
+}
+
+// Write a playback position and mark the movie watched when it is near the end. The
+// duration reported by the client is only used when ffprobe can't tell.
+func saveProgress(rid string, data *userData, movieName, movieFilePath string, entry progressEntry, reportedDuration float64) error {
+	err := data.progress.Update(func(progress *progressSet) error {
+		if *progress == nil {
+			*progress = progressSet{}
+		}
+		(*progress)[movieName] = entry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Trust the file over the client for the duration when possible
+	duration := reportedDuration
+	if haveTool("ffprobe") {
+		if probe, err := probeMovie(rid, movieFilePath); err == nil {
+			if d, ok := probe.duration(); ok {
+				duration = d
+			}
+		}
+	}
+	if duration > 0 && entry.Position >= watchedThreshold*duration && !inMovieSet(data.watched, movieName) {
+		if err := addToMovieSet(data.watched, movieName); err != nil {
+			logRequest(rid, "Could not mark %s watched: %v", movieName, err)
+		} else {
+			logRequest(rid, "Marked %s watched at %.0fs of %.0fs", movieName, entry.Position, duration)
+		}
+	}
+	return nil
+}
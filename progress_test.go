@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Report a playback position, answering the status and body
+func reportProgress(t *testing.T, app *fiber.App, movie, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPut, "/api/progress/"+movie, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, answer := send(t, app, req)
+	return resp.StatusCode, answer
+}
+
+func TestWatched(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+
+	for _, movie := range []string{"a", "b"} {
+		if status := changeMovieSet(t, app, http.MethodPut, "watched", movie); status != http.StatusNoContent {
+			t.Errorf("marking %s answered %d", movie, status)
+		}
+	}
+	if got := listMovieSet(t, app, "watched"); got != "b a" {
+		t.Errorf("watched lists %q", got)
+	}
+	if status := changeMovieSet(t, app, http.MethodDelete, "watched", "b"); status != http.StatusNoContent {
+		t.Errorf("unmarking b answered %d", status)
+	}
+	if got := listMovieSet(t, app, "watched"); got != "a" {
+		t.Errorf("watched lists %q after unmarking b", got)
+	}
+	if status := changeMovieSet(t, app, http.MethodPut, "watched", "missing"); status != http.StatusNotFound {
+		t.Errorf("marking a missing movie answered %d", status)
+	}
+	// Watched and favorites are kept apart
+	if got := listMovieSet(t, app, "favorites"); got != "" {
+		t.Errorf("favorites list %q", got)
+	}
+}
+
+func TestProgressMarksWatched(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	for _, tt := range []struct {
+		body, want string
+	}{
+		{`{"position": 50, "duration": 100}`, `{"position":50,"watched":false}`},
+		{`{"position": 89.9, "duration": 100}`, `{"position":89.9,"watched":false}`},
+		{`{"position": 95}`, `{"position":95,"watched":false}`},
+		{`{"position": 90, "duration": 100}`, `{"position":90,"watched":true}`},
+		// Going back doesn't unmark it
+		{`{"position": 10, "duration": 100}`, `{"position":10,"watched":true}`},
+	} {
+		if status, body := reportProgress(t, app, "a", tt.body); status != http.StatusOK || body != tt.want {
+			t.Errorf("%s answered %d: %s, want %s", tt.body, status, body, tt.want)
+		}
+	}
+	if got := listMovieSet(t, app, "watched"); got != "a" {
+		t.Errorf("watched lists %q", got)
+	}
+	if resp, body := get(t, app, "/api/progress/a"); resp.StatusCode != http.StatusOK || !strings.HasPrefix(body, `{"position":10,"updatedAt":`) {
+		t.Errorf("saved progress answered %d: %s", resp.StatusCode, body)
+	}
+
+	for _, body := range []string{`{}`, `{"position": -1}`, `{"position": 1, "duration": -5}`, `nonsense`} {
+		if status, answer := reportProgress(t, app, "a", body); status != http.StatusBadRequest {
+			t.Errorf("%s answered %d: %s", body, status, answer)
+		}
+	}
+	if status, _ := reportProgress(t, app, "missing", `{"position": 1}`); status != http.StatusNotFound {
+		t.Errorf("progress of a missing movie answered %d", status)
+	}
+	if resp, _ := get(t, app, "/api/progress/missing"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("progress never saved answered %d", resp.StatusCode)
+	}
+
+	// A rename takes the progress and watched state along
+	if resp, body := renameMovie(t, app, "a", "b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := get(t, app, "/api/progress/b"); resp.StatusCode != http.StatusOK || !strings.HasPrefix(body, `{"position":10,`) {
+		t.Errorf("progress after the rename answered %d: %s", resp.StatusCode, body)
+	}
+	if got := listMovieSet(t, app, "watched"); got != "b" {
+		t.Errorf("watched lists %q after the rename", got)
+	}
+}
+
+func TestProgressUsesProbedDuration(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	available := availableTools["ffprobe"]
+	availableTools["ffprobe"] = true
+	t.Cleanup(func() { availableTools["ffprobe"] = available })
+	scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":320,"height":240}],"format":{"duration":"1000"}}`})
+
+	// The file's duration wins over what the client says
+	if _, body := reportProgress(t, app, "a", `{"position": 95, "duration": 100}`); body != `{"position":95,"watched":false}` {
+		t.Errorf("position 95 of 1000s answered %s", body)
+	}
+	if _, body := reportProgress(t, app, "a", `{"position": 950}`); body != `{"position":950,"watched":true}` {
+		t.Errorf("position 950 of 1000s answered %s", body)
+	}
+}
+
+func TestProgressReadOnly(t *testing.T) {
+	app, _ := newTestServer(t, "-read-only")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	if status, body := reportProgress(t, app, "a", `{"position": 1}`); status != http.StatusServiceUnavailable {
+		t.Errorf("progress in read-only mode answered %d: %s", status, body)
+	}
+}
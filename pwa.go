@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Web app manifest and service worker, so the player can be installed on a phone's home screen
+//
+//go:embed manifest.json
+var webManifest []byte
+
+//go:embed sw.js
+var serviceWorker []byte
+
+// Icon sizes referenced by manifest.json
+var iconSizes = map[string]int{"icon-192.png": 192, "icon-512.png": 512}
+
+// Icons are drawn on first use rather than shipped as binary files
+var appIcons = sync.OnceValue(func() map[string][]byte {
+	icons := map[string][]byte{}
+	for file, size := range iconSizes {
+		var buf bytes.Buffer
+		png.Encode(&buf, drawAppIcon(size))
+		icons[file] = buf.Bytes()
+	}
+	return icons
+})
+
+// A play button on the player's background color. It stays inside the middle 80% so
+// launchers can crop it to a circle (the manifest marks it maskable).
+func drawAppIcon(size int) image.Image {
+	background := color.RGBA{0x12, 0x12, 0x12, 0xff}
+	foreground := color.RGBA{0xf5, 0xf5, 0xf5, 0xff}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	s := float64(size)
+	left, top, bottom, tip := 0.38*s, 0.3*s, 0.7*s, 0.72*s
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Inside the triangle when right of its left edge and within the two slanted edges
+			px, py := float64(x)+0.5, float64(y)+0.5
+			reach := (tip - left) * (1 - 2*abs(py-s/2)/(bottom-top))
+			if px >= left && py >= top && py <= bottom && px-left <= reach {
+				img.Set(x, y, foreground)
+			} else {
+				img.Set(x, y, background)
+			}
+		}
+	}
+	return img
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+func manifestHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "application/manifest+json")
+	return c.Send(webManifest)
+}
+
+func serviceWorkerHandler(c *fiber.Ctx) error {
+	// Browsers should pick up a new version on the next visit
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderContentType, "text/javascript; charset=utf-8")
+	return c.Send(serviceWorker)
+}
+
+func iconHandler(c *fiber.Ctx) error {
+	icon, ok := appIcons()[c.Params("file")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).SendString("Icon not found.")
+	}
+	return sendBytes(c, icon, "image/png")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPWA(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	resp, body := get(t, app, "/manifest.json")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/manifest+json" {
+		t.Fatalf("manifest answered %d as %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var manifest struct {
+		Display string `json:"display"`
+		Icons   []struct {
+			Src   string `json:"src"`
+			Sizes string `json:"sizes"`
+			Type  string `json:"type"`
+		} `json:"icons"`
+	}
+	if err := json.Unmarshal([]byte(body), &manifest); err != nil || manifest.Display != "standalone" || len(manifest.Icons) == 0 {
+		t.Fatalf("manifest %s: %v", body, err)
+	}
+
+	// Every icon the manifest lists exists in its size
+	for _, icon := range manifest.Icons {
+		resp, body := get(t, app, icon.Src)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != icon.Type {
+			t.Errorf("%s answered %d as %q", icon.Src, resp.StatusCode, resp.Header.Get("Content-Type"))
+			continue
+		}
+		img, err := png.DecodeConfig(strings.NewReader(body))
+		if err != nil || fmt.Sprintf("%dx%d", img.Width, img.Height) != icon.Sizes {
+			t.Errorf("%s is %dx%d, want %s: %v", icon.Src, img.Width, img.Height, icon.Sizes, err)
+		}
+	}
+	if resp, _ := get(t, app, "/icons/other.png"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown icon answered %d", resp.StatusCode)
+	}
+
+	resp, body = get(t, app, "/sw.js")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/javascript; charset=utf-8" || resp.Header.Get("Cache-Control") != "no-cache" || body == "" {
+		t.Errorf("service worker answered %d as %q with Cache-Control %q", resp.StatusCode, resp.Header.Get("Content-Type"), resp.Header.Get("Cache-Control"))
+	}
+
+	if _, body := get(t, app, "/stream/a"); !strings.Contains(body, `<link rel="manifest" href="/manifest.json" />`) || !strings.Contains(body, `register("/sw.js")`) {
+		t.Errorf("player doesn't link the manifest and worker:\n%s", body)
+	}
+}
+
+func TestPWADisabled(t *testing.T) {
+	app, _ := newTestServer(t, "-pwa=false")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	for _, target := range []string{"/manifest.json", "/sw.js", "/icons/icon-192.png"} {
+		if resp, _ := get(t, app, target); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s answered %d with -pwa=false", target, resp.StatusCode)
+		}
+	}
+	if _, body := get(t, app, "/stream/a"); strings.Contains(body, "manifest") || strings.Contains(body, "serviceWorker") {
+		t.Errorf("player still links the manifest or worker:\n%s", body)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// One quality of a title stored as several files, like Movie.1080p.mp4 and Movie.720p.mp4
+type qualityVariant struct {
+	Name      string `json:"name"`
+	Quality   string `json:"quality"`
+	Height    int    `json:"height"`
+	StreamURL string `json:"streamUrl"`
+	VideoURL  string `json:"videoUrl"`
+}
+
+// The base name and height of a name ending in a quality tag: Movie.1080p is Movie at
+// 1080 lines and Movie_4k is Movie at 2160. Returns false for names without one.
+func splitQuality(movieName string) (string, int, bool) {
+	i := strings.LastIndexAny(movieName, ". _-")
+	if i <= 0 {
+		return movieName, 0, false
+	}
+	base, tag := movieName[:i], strings.ToLower(movieName[i+1:])
+	if tag == "4k" {
+		return base, 2160, true
+	}
+	digits, found := strings.CutSuffix(tag, "p")
+	if !found || len(digits) < 3 || len(digits) > 4 || digits[0] == '0' {
+		return movieName, 0, false
+	}
+	height, err := strconv.Atoi(digits)
+	if err != nil {
+		return movieName, 0, false
+	}
+	return base, height, true
+}
+
+func newQualityVariant(movieName string, height int) qualityVariant {
+	quality := strconv.Itoa(height) + "p"
+	if height == 2160 && strings.EqualFold(movieName[len(movieName)-2:], "4k") {
+		quality = "4K"
+	}
+	return qualityVariant{
+		Name:      movieName,
+		Quality:   quality,
+		Height:    height,
+		StreamURL: "/stream/" + url.PathEscape(movieName),
+		VideoURL:  "/video/" + url.PathEscape(movieName),
+	}
+}
+
+// Merge the entries of titles stored in several qualities into one, listed under its best
+// quality with every quality in Qualities, best first. Titles with a single tagged file and
+// names without a tag are left as they are; the order of the list is kept.
+func groupQualities(movies []MovieEntry) []MovieEntry {
+	groups := map[string][]int{}
+	for i, movie := range movies {
+		if base, _, ok := splitQuality(movie.Name); ok {
+			groups[base] = append(groups[base], i)
+		}
+	}
+
+	grouped := []MovieEntry{}
+	for i, movie := range movies {
+		base, _, ok := splitQuality(movie.Name)
+		if !ok || len(groups[base]) < 2 {
+			grouped = append(grouped, movie)
+			continue
+		}
+		members := groups[base]
+		if members[0] != i {
+			continue
+		}
+
+		var variants []qualityVariant
+		best := members[0]
+		for _, member := range members {
+			_, height, _ := splitQuality(movies[member].Name)
+			variants = append(variants, newQualityVariant(movies[member].Name, height))
+		}
+		sort.SliceStable(variants, func(a, b int) bool { return variants[a].Height > variants[b].Height })
+		for _, member := range members {
+			if movies[member].Name == variants[0].Name {
+				best = member
+			}
+		}
+		entry := movies[best]
+		entry.Qualities = variants
+		grouped = append(grouped, entry)
+	}
+	return grouped
+}
+
+// Names of every quality of the title the movie belongs to, best first, the movie itself
+// included. Only the movie itself for names without a quality tag.
+func qualityVariants(cfg *Config, movieName string) []string {
+	base, _, ok := splitQuality(movieName)
+	if !ok {
+		return []string{movieName}
+	}
+
+	heights := map[string]int{}
+	for _, root := range cfg.MoviesDirs {
+		files, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			ext := filepath.Ext(file.Name())
+			name := strings.TrimSuffix(file.Name(), ext)
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") || !cfg.servesFormat(ext) {
+				continue
+			}
+			if b, height, ok := splitQuality(name); ok && b == base {
+				heights[name] = height
+			}
+		}
+	}
+	if _, found := heights[movieName]; !found {
+		// Found ignoring case, under a name that differs from the file's
+		_, heights[movieName], _ = splitQuality(movieName)
+	}
+
+	names := make([]string, 0, len(heights))
+	for name := range heights {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if heights[names[i]] != heights[names[j]] {
+			return heights[names[i]] > heights[names[j]]
+		}
+		return naturalLess(names[i], names[j])
+	})
+	return names
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitQuality(t *testing.T) {
+	for _, tt := range []struct {
+		name, base string
+		height     int
+		ok         bool
+	}{
+		{"Movie.1080p", "Movie", 1080, true},
+		{"Movie 720P", "Movie", 720, true},
+		{"Movie_4k", "Movie", 2160, true},
+		{"Movie-2160p", "Movie", 2160, true},
+		{"Movie.2001.480p", "Movie.2001", 480, true},
+		{"Movie", "Movie", 0, false},
+		{"Movie.2001", "Movie.2001", 0, false},
+		{"Movie.10p", "Movie.10p", 0, false},
+		{"Movie.0720p", "Movie.0720p", 0, false},
+		{"Movie.12345p", "Movie.12345p", 0, false},
+		{"1080p", "1080p", 0, false},
+	} {
+		base, height, ok := splitQuality(tt.name)
+		if base != tt.base || height != tt.height || ok != tt.ok {
+			t.Errorf("splitQuality(%q) = %q, %d, %t", tt.name, base, height, ok)
+		}
+	}
+}
+
+func TestQualityGrouping(t *testing.T) {
+	app, _ := newTestServer(t)
+	for _, file := range []string{"Movie.720p.mp4", "Movie.1080p.mp4", "Movie_4k.mkv", "Other.480p.mp4", "Plain.mp4"} {
+		writeFile(t, filepath.Join("movies", file), []byte(testMovie))
+	}
+
+	resp, body := get(t, app, "/api/movies")
+	var movies []MovieEntry
+	if err := json.Unmarshal([]byte(body), &movies); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("catalog answered %d: %s", resp.StatusCode, body)
+	}
+	var listed []string
+	for _, movie := range movies {
+		entry := movie.Name
+		for _, variant := range movie.Qualities {
+			entry += fmt.Sprintf(" %s=%s,%s", variant.Quality, variant.StreamURL, variant.VideoURL)
+		}
+		listed = append(listed, entry)
+	}
+	// One entry under the best quality, a single tagged file and untagged names as they are
+	want := []string{
+		"Movie_4k 4K=/stream/Movie_4k,/video/Movie_4k 1080p=/stream/Movie.1080p,/video/Movie.1080p 720p=/stream/Movie.720p,/video/Movie.720p",
+		"Other.480p",
+		"Plain",
+	}
+	if strings.Join(listed, "\n") != strings.Join(want, "\n") {
+		t.Errorf("catalog lists\n%s\nwant\n%s", strings.Join(listed, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The sources of one quality go on with the others, best first
+	resp, body = get(t, app, "/api/movies/Movie.720p/sources")
+	var sources []playbackSource
+	if err := json.Unmarshal([]byte(body), &sources); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("sources answered %d: %s", resp.StatusCode, body)
+	}
+	listed = nil
+	for _, source := range sources {
+		listed = append(listed, source.Label+" "+source.URL)
+	}
+	want = []string{"720p MP4 /video/Movie.720p?format=mp4", "2160p MKV /video/Movie_4k?format=mkv", "1080p MP4 /video/Movie.1080p?format=mp4"}
+	if strings.Join(listed, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sources are\n%s\nwant\n%s", strings.Join(listed, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Body of a regenerate request, all optional: without it the artifacts are only cleared
+// and made again on their next request
+type regenerateRequest struct {
+	Regenerate bool `json:"regenerate"`
+	Background bool `json:"background"`
+}
+
+// Remove everything generated from a movie: its DASH package, thumbnails, previews and
+// extracted cover. Each is removed under its movie's lock, so work in progress finishes
+// before its output goes. Returns the kinds of artifacts that were there.
+func clearArtifacts(cfg *Config, movieName string) []string {
+	cleared := []string{}
+	remove := func(kind string, locks *sync.Map, paths ...string) {
+		defer lockKey(locks, movieName)()
+		found := false
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				found = true
+			}
+			if err := os.RemoveAll(path); err != nil {
+				log.Printf("Could not remove %s: %v", path, err)
+			}
+		}
+		if found {
+			cleared = append(cleared, kind)
+		}
+	}
+	remove("dash", &dashLocks, filepath.Join(cfg.DashDir, movieName), filepath.Join(cfg.DashDir, movieName+".tmp"))
+	remove("thumbnails", &spriteLocks, filepath.Join(cfg.SpriteDir, movieName))
+	remove("preview", &previewLocks, filepath.Join(cfg.PreviewDir, movieName))
+	remove("cover", &coverLocks, append(cachedCoverPaths(cfg, movieName), filepath.Join(cfg.CoverDir, movieName+".none"))...)
+	pruneCache(cfg)
+	return cleared
+}
+
+// Make the thumbnails, preview and cover again, as far as the installed tools allow.
+// DASH packages take as long as copying the whole movie, they are left to the next play.
+// Returns what was made and why the rest failed.
+func regenerateArtifacts(cfg *Config, rid, movieName, movieFilePath string) ([]string, map[string]string) {
+	regenerated := []string{}
+	failed := map[string]string{}
+	if haveTool("ffmpeg") && haveTool("ffprobe") {
+		if _, _, err := ensureSprite(cfg, rid, movieName, movieFilePath); err != nil {
+			failed["thumbnails"] = err.Error()
+		} else {
+			regenerated = append(regenerated, "thumbnails")
+		}
+	}
+	if haveTool("ffmpeg") {
+		if _, err := ensurePreview(cfg, rid, movieName, movieFilePath); err != nil {
+			failed["preview"] = err.Error()
+		} else {
+			regenerated = append(regenerated, "preview")
+		}
+		// Most movies have no cover, finding out again is all there is to do for them
+		if _, found := findEmbeddedCover(cfg, rid, movieName); found {
+			regenerated = append(regenerated, "cover")
+		}
+	}
+	return regenerated, failed
+}
+
+// Route for refreshing a movie's generated files after the movie was replaced. They are
+// made again on their own when the file is newer than them, but not when the new file
+// carries an older modification time, as copies that preserve times do.
+func regenerateHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		var req regenerateRequest
+		if len(c.Body()) > 0 {
+			if err := json.Unmarshal(c.Body(), &req); err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString(`Expected e.g. {"regenerate": true, "background": true}.`)
+			}
+		}
+
+		rid := requestID(c)
+		cleared := clearArtifacts(cfg, movieName)
+		logRequest(rid, "Cleared generated files of %s: %v", movieName, cleared)
+		catalog.expire()
+		if !req.Regenerate {
+			return c.JSON(fiber.Map{"cleared": cleared})
+		}
+
+		if req.Background {
+			go func() {
+				regenerated, failed := regenerateArtifacts(cfg, rid, movieName, movieFilePath)
+				for kind, reason := range failed {
+					logRequest(rid, "Could not regenerate the %s of %s: %s", kind, movieName, reason)
+				}
+				logRequest(rid, "Regenerated %v for %s in the background", regenerated, movieName)
+			}()
+			return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"cleared": cleared})
+		}
+
+		regenerated, failed := regenerateArtifacts(cfg, rid, movieName, movieFilePath)
+		return c.JSON(fiber.Map{"cleared": cleared, "regenerated": regenerated, "failed": failed})
+	}
+}
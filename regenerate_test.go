@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func regenerate(t *testing.T, app *fiber.App, movie, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, "/api/movies/"+movie+"/regenerate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, answer := send(t, app, authorized(req))
+	return resp.StatusCode, answer
+}
+
+func TestRegenerate(t *testing.T) {
+	app, cfg := newTestServer(t, "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	ffmpeg, ffprobe := availableTools["ffmpeg"], availableTools["ffprobe"]
+	availableTools["ffmpeg"], availableTools["ffprobe"] = true, true
+	t.Cleanup(func() { availableTools["ffmpeg"], availableTools["ffprobe"] = ffmpeg, ffprobe })
+	scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":1920,"height":1080}],"format":{"duration":"25.5"}}`})
+	renders := scriptTool(t, "ffmpeg", toolRun{output: "jpeg"})
+
+	sheet := filepath.Join(cfg.SpriteDir, "a")
+	thumbnailsCached := func() bool {
+		files, _ := filepath.Glob(filepath.Join(sheet, "*.jpg"))
+		return len(files) == 1
+	}
+	if resp, _ := get(t, app, "/sprite/a"); resp.StatusCode != http.StatusOK || !thumbnailsCached() {
+		t.Fatalf("sprite answered %d", resp.StatusCode)
+	}
+
+	// Without a body the generated files are only cleared
+	if status, body := regenerate(t, app, "a", ""); status != http.StatusOK || body != `{"cleared":["thumbnails"]}` {
+		t.Errorf("clearing answered %d: %s", status, body)
+	}
+	if _, err := os.Stat(sheet); err == nil {
+		t.Error("thumbnails kept")
+	}
+
+	// Made again before answering
+	before := renders.Load()
+	status, body := regenerate(t, app, "a", `{"regenerate": true}`)
+	if status != http.StatusOK || body != `{"cleared":[],"failed":{},"regenerated":["thumbnails","preview"]}` {
+		t.Errorf("regenerating answered %d: %s", status, body)
+	}
+	if !thumbnailsCached() || renders.Load() == before {
+		t.Errorf("thumbnails not made again, ffmpeg ran %d times", renders.Load()-before)
+	}
+
+	// Or in the background
+	status, body = regenerate(t, app, "a", `{"regenerate": true, "background": true}`)
+	if status != http.StatusAccepted || body != `{"cleared":["thumbnails","preview","cover"]}` {
+		t.Errorf("regenerating in the background answered %d: %s", status, body)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !thumbnailsCached(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("thumbnails not made again in the background")
+		}
+	}
+
+	if status, _ := regenerate(t, app, "missing", ""); status != http.StatusNotFound {
+		t.Errorf("missing movie answered %d", status)
+	}
+	if status, _ := regenerate(t, app, "a", `{"regenerate": "yes"}`); status != http.StatusBadRequest {
+		t.Errorf("bad body answered %d", status)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "/api/movies/a/regenerate", nil)
+	if resp, _ := send(t, app, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("regenerating without a token answered %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Client for -remote-url. There is no overall timeout, relaying a movie takes as long as
+// the viewer watches; only waiting for the upstream to start answering is limited.
+var remoteClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+		// A body decompressed on the way would no longer match its Content-Range
+		DisableCompression: true,
+	},
+}
+
+// Request headers passed to the upstream, so it answers ranges and revalidation itself
+var remoteRequestHeaders = []string{fiber.HeaderRange, fiber.HeaderIfRange, fiber.HeaderIfModifiedSince, fiber.HeaderIfNoneMatch}
+
+// Response headers relayed back to the client
+var remoteResponseHeaders = []string{fiber.HeaderContentType, fiber.HeaderContentRange, fiber.HeaderAcceptRanges, fiber.HeaderLastModified, fiber.HeaderETag}
+
+// An upstream body, handing the client's stream slot back once fasthttp is done sending it
+type remoteBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *remoteBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// Route relaying a file from -remote-url: the client's Range goes upstream as it is and the
+// upstream's partial response comes back as it is, the body streamed through without
+// being stored. Redirects, like those to presigned URLs, are followed with the Range kept.
+func remoteHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+
+		// Only paths below the base URL, never ".." out of it. The path is already unescaped,
+		// so an escaped slash splits segments like a plain one.
+		var segments []string
+		for _, segment := range strings.Split(c.Params("*"), "/") {
+			if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, "\\") {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid path.")
+			}
+			segments = append(segments, segment)
+		}
+		target := cfg.RemoteURL.JoinPath(segments...)
+
+		// The request outlives the handler while the body is relayed, so it can't use the handler's context
+		req, err := http.NewRequestWithContext(context.Background(), c.Method(), target.String(), nil)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid path.")
+		}
+		for _, name := range remoteRequestHeaders {
+			if value := c.Get(name); value != "" {
+				req.Header.Set(name, value)
+			}
+		}
+
+		resp, err := remoteClient.Do(req)
+		if err != nil {
+			logRequest(rid, "Could not reach %s: %v", target.Redacted(), err)
+			return c.Status(fiber.StatusBadGateway).SendString("Could not reach the remote source.")
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+		case http.StatusNotFound, http.StatusGone:
+			resp.Body.Close()
+			return c.Status(fiber.StatusNotFound).SendString("Not found at the remote source.")
+		default:
+			// Anything else, like 403 for expired credentials, is the upstream's problem and not the client's
+			resp.Body.Close()
+			logRequest(rid, "%s answered %s", target.Redacted(), resp.Status)
+			return c.Status(fiber.StatusBadGateway).SendString("The remote source failed to answer.")
+		}
+
+		for _, name := range remoteResponseHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				c.Set(name, value)
+			}
+		}
+		c.Status(resp.StatusCode)
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return nil
+		}
+		// Without a Content-Length the body is sent chunked
+		c.Context().SetBodyStream(&remoteBody{ReadCloser: resp.Body, release: holdClientStream(c)}, int(resp.ContentLength))
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A store that answers every file with a redirect to a presigned URL, like S3 does, which
+// serves the content with ranges
+func newRedirectingStore(t *testing.T, content string) *httptest.Server {
+	t.Helper()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/movies/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/signed"+strings.TrimPrefix(r.URL.Path, "/movies")+"?signature=abc", http.StatusFound)
+	})
+	mux.HandleFunc("/failing/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/expired/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "expired", http.StatusForbidden)
+	})
+	mux.HandleFunc("/signed/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("signature") != "abc" {
+			http.Error(w, "expired", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, r.URL.Path, modTime, strings.NewReader(content))
+	})
+	store := httptest.NewServer(mux)
+	t.Cleanup(store.Close)
+	return store
+}
+
+func TestRemoteRangesThroughRedirects(t *testing.T) {
+	const content = "0123456789abcdefghij"
+	store := newRedirectingStore(t, content)
+	app, _ := newTestServer(t, "-remote-url", store.URL+"/movies/")
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+
+	tests := []struct {
+		name, rangeHeader, ifRange string
+		status                     int
+		contentRange, body         string
+	}{
+		{"whole file", "", "", http.StatusOK, "", content},
+		{"range", "bytes=2-5", "", http.StatusPartialContent, "bytes 2-5/20", "2345"},
+		{"open range", "bytes=15-", "", http.StatusPartialContent, "bytes 15-19/20", "fghij"},
+		{"suffix range", "bytes=-3", "", http.StatusPartialContent, "bytes 17-19/20", "hij"},
+		{"past the end", "bytes=30-", "", http.StatusRequestedRangeNotSatisfiable, "bytes */20", ""},
+		{"matching If-Range", "bytes=2-5", lastModified, http.StatusPartialContent, "bytes 2-5/20", "2345"},
+		{"outdated If-Range", "bytes=2-5", "Mon, 01 Jan 2024 00:00:00 GMT", http.StatusOK, "", content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/remote/Show/Episode%201.mp4", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			resp, body := send(t, app, req)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range %q, want %q", got, tt.contentRange)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && body != tt.body {
+				t.Errorf("body %q, want %q", body, tt.body)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && resp.Header.Get("Content-Type") != "video/mp4" {
+				t.Errorf("Content-Type %q", resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestRemoteRevalidation(t *testing.T) {
+	store := newRedirectingStore(t, "movie")
+	app, _ := newTestServer(t, "-remote-url", store.URL+"/movies/")
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+
+	req, _ := http.NewRequest(http.MethodGet, "/remote/a.mp4", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	if resp, body := send(t, app, req); resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("unchanged file answered %d: %q", resp.StatusCode, body)
+	}
+	req, _ = http.NewRequest(http.MethodHead, "/remote/a.mp4", nil)
+	resp, body := send(t, app, req)
+	if resp.StatusCode != http.StatusOK || body != "" || resp.Header.Get("Last-Modified") != lastModified || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("HEAD answered %d with Last-Modified %q and Accept-Ranges %q: %q",
+			resp.StatusCode, resp.Header.Get("Last-Modified"), resp.Header.Get("Accept-Ranges"), body)
+	}
+}
+
+func TestRemoteFailures(t *testing.T) {
+	store := newRedirectingStore(t, "movie")
+	base := strings.Replace(store.URL, "http://", "http://viewer:hunter2@", 1)
+	for _, tt := range []struct {
+		path, target string
+		status       int
+	}{
+		{"/elsewhere/", "/remote/a.mp4", http.StatusNotFound},
+		{"/elsewhere/", "/remote/a/%2e%2e/b.mp4", http.StatusBadRequest},
+		{"/elsewhere/", "/remote/a%2f..%2fb.mp4", http.StatusBadRequest},
+		{"/elsewhere/", "/remote/a//b.mp4", http.StatusBadRequest},
+		{"/failing/", "/remote/a.mp4", http.StatusBadGateway},
+		{"/expired/", "/remote/a.mp4", http.StatusBadGateway},
+	} {
+		app, cfg := newTestServer(t, "-remote-url", base+tt.path)
+		logged := captureLog(t)
+		if resp, body := get(t, app, tt.target); resp.StatusCode != tt.status {
+			t.Errorf("%s of %s answered %d: %s", tt.target, tt.path, resp.StatusCode, body)
+		}
+		// Credentials never reach the log
+		if summary := startupSummary(cfg); strings.Contains(logged.String()+summary, "hunter2") || !strings.Contains(summary, "remote=http://viewer:xxxxx@") {
+			t.Errorf("credentials shown: %s\n%s", summary, logged)
+		}
+	}
+
+	// An upstream that is gone
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	app, _ := newTestServer(t, "-remote-url", closed.URL+"/")
+	if resp, _ := get(t, app, "/remote/a.mp4"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("unreachable upstream answered %d", resp.StatusCode)
+	}
+
+	app, _ = newTestServer(t)
+	if resp, _ := get(t, app, "/remote/a.mp4"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/remote/ without -remote-url answered %d", resp.StatusCode)
+	}
+	for _, remoteURL := range []string{"ftp://example.com/", "example.com/movies", "http:///movies"} {
+		if _, err := loadConfig([]string{"-remote-url", remoteURL}); err == nil {
+			t.Errorf("-remote-url %s accepted", remoteURL)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// Send a file with its type guessed from the extension, see sendFileAs
+func sendFile(c *fiber.Ctx, path string) error {
+	return sendFileAs(c, path, "")
+}
+
+// Send a file as the given type, answering a Range request with 206 and If-Modified-Since
+// with 304. Fiber's SendFile isn't used: it keeps what it read of a file for 10 seconds,
+// so a file replaced meanwhile was answered stale, and it sets the type from the
+// extension itself. A handler's checks can't rule out the file disappearing before it is
+// sent, e.g. when the movie is renamed or the cache evicts it, so that case gets a plain 404.
+func sendFileAs(c *fiber.Ctx, path, contentType string) error {
+	// The caller may already have set a type for the file, errors are plain text
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		logRequest(requestID(c), "%s disappeared before it could be sent", path)
+		return c.Status(fiber.StatusNotFound).SendString("File not found.")
+	}
+	if err != nil {
+		logRequest(requestID(c), "Could not send %s: %v", path, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not send file.")
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		logRequest(requestID(c), "Could not send %s: not a file", path)
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not send file.")
+	}
+
+	size := info.Size()
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderLastModified, modTime.Format(http.TimeFormat))
+	if since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince)); err == nil && !modTime.After(since) {
+		file.Close()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	var body io.Reader = file
+	length := size
+	if rangeHeader := c.Request().Header.Peek(fiber.HeaderRange); len(rangeHeader) > 0 {
+		start, end, err := fasthttp.ParseByteRange(rangeHeader, int(size))
+		if err != nil {
+			file.Close()
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return c.Status(fiber.StatusRequestedRangeNotSatisfiable).SendString("Invalid Range header.")
+		}
+		if _, err := file.Seek(int64(start), io.SeekStart); err != nil {
+			file.Close()
+			logRequest(requestID(c), "Could not seek to byte %d of %s: %v", start, path, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not send file.")
+		}
+		length = int64(end - start + 1)
+		// The file alone would be sent to its end, fasthttp closes the file through the wrapper
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(file, length), file}
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		c.Status(fiber.StatusPartialContent)
+	}
+
+	if contentType == "" {
+		contentType = utils.GetMIME(filepath.Ext(path))
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	if c.Method() == fiber.MethodHead {
+		file.Close()
+		c.Response().Header.SetContentLength(int(length))
+		return nil
+	}
+	// A whole file goes out as the *os.File itself, which lets the kernel copy it with sendfile
+	c.Response().SetBodyStream(body, int(length))
+	return nil
+}
+
+// Send content held in memory, answering a Range request with 206 the way SendFile does
+// for files
+func sendBytes(c *fiber.Ctx, content []byte, contentType string) error {
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	rangeHeader := c.Request().Header.Peek(fiber.HeaderRange)
+	if len(rangeHeader) == 0 {
+		c.Set(fiber.HeaderContentType, contentType)
+		return c.Send(content)
+	}
+
+	start, end, err := fasthttp.ParseByteRange(rangeHeader, len(content))
+	if err != nil {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", len(content)))
+		return c.Status(fiber.StatusRequestedRangeNotSatisfiable).SendString("Invalid Range header.")
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+	return c.Status(fiber.StatusPartialContent).Send(content[start : end+1])
+}
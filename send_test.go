@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestImageRanges(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.jpg"), []byte("poster of b"))
+
+	// Stub tools that build a sprite sheet for a
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		available := availableTools[tool]
+		availableTools[tool] = true
+		t.Cleanup(func() { availableTools[tool] = available })
+	}
+	sheet := strings.Repeat("0123456789", 1000)
+	scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":320,"height":240}],"format":{"duration":"60"}}`})
+	scriptTool(t, "ffmpeg", toolRun{output: sheet})
+	_, track := get(t, app, "/sprite/a/thumbnails.vtt")
+	placeholder := string(placeholderPoster)
+
+	for _, tt := range []struct {
+		target, rangeHeader, want string
+	}{
+		{"/sprite/a", "bytes=1000-1009", sheet[1000:1010]},
+		{"/sprite/a", "bytes=-5", sheet[len(sheet)-5:]},
+		{"/sprite/a/thumbnails.vtt", "bytes=0-5", track[:6]},
+		{"/poster/b", "bytes=7-", "of b"},
+		{"/poster/a", "bytes=0-99", placeholder[:100]},
+		{"/poster/a", "bytes=-10", placeholder[len(placeholder)-10:]},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+		req.Header.Set("Range", tt.rangeHeader)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, body := send(t, app, req)
+		if resp.StatusCode != http.StatusPartialContent || body != tt.want {
+			t.Errorf("%s %s answered %d with %q, want %q", tt.target, tt.rangeHeader, resp.StatusCode, body, tt.want)
+		}
+		if resp.Header.Get("Content-Range") == "" || resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s %s sent Content-Range %q and Content-Encoding %q", tt.target, tt.rangeHeader, resp.Header.Get("Content-Range"), resp.Header.Get("Content-Encoding"))
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/poster/a", nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(placeholder)))
+	if resp, _ := send(t, app, req); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != fmt.Sprintf("bytes */%d", len(placeholder)) {
+		t.Errorf("range past the placeholder answered %d with Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+}
+
+func TestSendFileDisappeared(t *testing.T) {
+	newTestServer(t)
+	writeFile(t, "there.mpd", []byte("<MPD/>"))
+	writeFile(t, "gone.mp4", []byte(testMovie))
+	app := fiber.New()
+	// The handler has checked the file and set its type, then it goes away before the send
+	app.Get("/video", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "video/mp4")
+		os.Remove("gone.mp4")
+		return sendFile(c, "gone.mp4")
+	})
+	app.Get("/dash/:file", func(c *fiber.Ctx) error {
+		return sendFileAs(c, c.Params("file"), "application/dash+xml")
+	})
+	logs := captureLog(t)
+
+	for _, tt := range []struct {
+		target      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/video", http.StatusNotFound, fiber.MIMETextPlainCharsetUTF8, "File not found."},
+		{"/dash/missing.mpd", http.StatusNotFound, fiber.MIMETextPlainCharsetUTF8, "File not found."},
+		{"/dash/there.mpd", http.StatusOK, "application/dash+xml", "<MPD/>"},
+	} {
+		resp, body := get(t, app, tt.target)
+		if resp.StatusCode != tt.status || resp.Header.Get("Content-Type") != tt.contentType || body != tt.body {
+			t.Errorf("%s answered %d as %q: %q", tt.target, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+	if !strings.Contains(logs.String(), "gone.mp4 disappeared before it could be sent") || strings.Contains(logs.String(), "there.mpd") {
+		t.Errorf("disappearances not logged:\n%s", logs)
+	}
+}
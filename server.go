@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Start serving on the configured TCP address or Unix socket
+func listen(app *fiber.App, cfg *Config) error {
+	if cfg.UnixSocket == "" {
+		if cfg.TLS == nil {
+			return app.Listen(cfg.Listen)
+		}
+		ln, err := net.Listen("tcp", cfg.Listen)
+		if err != nil {
+			return err
+		}
+		log.Printf("Serving HTTPS on %s", cfg.Listen)
+		return app.Listener(tls.NewListener(ln, cfg.TLS))
+	}
+
+	// A socket left behind by a previous run would make the listen fail
+	if info, err := os.Lstat(cfg.UnixSocket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", cfg.UnixSocket)
+		}
+		if err := os.Remove(cfg.UnixSocket); err != nil {
+			return err
+		}
+	}
+
+	ln, err := net.Listen("unix", cfg.UnixSocket)
+	if err != nil {
+		return err
+	}
+
+	// The reverse proxy usually runs as another user, this is no more open than a TCP port
+	if err := os.Chmod(cfg.UnixSocket, 0o666); err != nil {
+		ln.Close()
+		return err
+	}
+
+	log.Printf("Listening on unix socket %s", cfg.UnixSocket)
+	if cfg.TLS != nil {
+		return app.Listener(tls.NewListener(ln, cfg.TLS))
+	}
+	return app.Listener(ln)
+}
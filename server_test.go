@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocket(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, so keep it short
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "display.sock")
+
+	// Leave a stale socket behind as a crashed run would
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	app, cfg := newTestServer(t, "-unix-socket", socket)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	done := make(chan error, 1)
+	go func() { done <- listen(app, cfg) }()
+	t.Cleanup(func() {
+		app.Shutdown()
+		<-done
+	})
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp, err = client.Get("http://display/video/a")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("video over the socket answered %d", resp.StatusCode)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o666 {
+		t.Errorf("socket has mode %v, want a world-writable socket", info.Mode())
+	}
+}
+
+func TestUnixSocketRefusesOtherFiles(t *testing.T) {
+	app, cfg := newTestServer(t, "-unix-socket", "not-a-socket")
+	writeFile(t, "not-a-socket", []byte("keep me"))
+	if err := listen(app, cfg); err == nil {
+		t.Fatal("listened on top of a regular file")
+	}
+	if data, err := os.ReadFile("not-a-socket"); err != nil || string(data) != "keep me" {
+		t.Errorf("regular file was touched: %q, %v", data, err)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	for _, tt := range []struct {
+		args        []string
+		keepAlive   bool
+		idleTimeout time.Duration
+	}{
+		{nil, true, 2 * time.Minute},
+		{[]string{"-keep-alive=false"}, false, 2 * time.Minute},
+		{[]string{"-idle-timeout", "1s"}, true, time.Second},
+	} {
+		app, _ := newTestServer(t, tt.args...)
+		if config := app.Config(); config.DisableKeepalive == tt.keepAlive || config.IdleTimeout != tt.idleTimeout {
+			t.Errorf("%v: keep-alive disabled is %t with idle timeout %s", tt.args, config.DisableKeepalive, config.IdleTimeout)
+		}
+	}
+	if _, err := loadConfig([]string{"-idle-timeout", "-1s"}); err == nil {
+		t.Error("negative -idle-timeout accepted")
+	}
+}
+
+func TestIdleConnectionClosed(t *testing.T) {
+	app, _ := newTestServer(t, "-idle-timeout", "200ms")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	// The connection is reused for a second request, then closed once it sits idle
+	for i := 0; i < 2; i++ {
+		fmt.Fprintf(conn, "GET /healthz HTTP/1.1\r\nHost: test\r\n\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("idle connection still open: %v", err)
+	}
+}
+
+func TestRootRedirect(t *testing.T) {
+	app, _ := newTestServer(t, "-root-redirect", "/stream/Kiosk%20Movie?autoplay=1")
+	resp, _ := get(t, app, "/")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/stream/Kiosk%20Movie?autoplay=1" {
+		t.Errorf("/ answered %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// Without it, / is only routed while the library is empty
+	app, _ = newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	if resp, _ := get(t, app, "/"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/ without -root-redirect answered %d", resp.StatusCode)
+	}
+
+	for _, target := range []string{"stream/a", "//evil.example/", `/\evil.example`, "https://evil.example/", "http:/stream"} {
+		if _, err := loadConfig([]string{"-root-redirect", target}); err == nil {
+			t.Errorf("-root-redirect %q accepted", target)
+		}
+	}
+}
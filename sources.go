@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// One way to play a movie, for a quality or source selector
+type playbackSource struct {
+	Label       string `json:"label"`
+	URL         string `json:"url"`
+	Kind        string `json:"kind"` // "file" or "dash"
+	ContentType string `json:"contentType"`
+	// What a plain /video or /stream request would play
+	Default bool `json:"default"`
+
+	// Only for files; the height is null without ffprobe
+	Format string `json:"format,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Height *int   `json:"height,omitempty"`
+}
+
+// Every file of the movie in a served format, preferred one first, then DASH when available
+func sourcesHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		movieName := c.Params("movie")
+		if _, found := findMovie(cfg, movieName); !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+
+		escaped := url.PathEscape(movieName)
+		sources := []playbackSource{}
+		// Other qualities stored as their own files, like Movie.720p next to Movie.1080p, follow the movie's own
+		names := []string{movieName}
+		for _, name := range qualityVariants(cfg, movieName) {
+			if name != movieName {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			_, tagged, hasTag := splitQuality(name)
+			for _, format := range cfg.Tunables().Formats {
+				movieFilePath, found := findMovieIn(cfg, name, []string{format})
+				if !found {
+					continue
+				}
+				info, err := os.Stat(movieFilePath)
+				if err != nil {
+					continue
+				}
+
+				ext := strings.ToLower(filepath.Ext(movieFilePath))
+				source := playbackSource{
+					Label:       strings.ToUpper(format),
+					URL:         "/video/" + url.PathEscape(name) + "?format=" + format,
+					Kind:        "file",
+					ContentType: contentTypes[ext],
+					Default:     len(sources) == 0,
+					Format:      format,
+					Size:        info.Size(),
+				}
+				height := 0
+				if haveTool("ffprobe") {
+					if probe, err := probeMovie(rid, movieFilePath); err == nil && len(probe.Streams) > 0 {
+						height = probe.Streams[0].Height
+					}
+				}
+				if height == 0 && hasTag {
+					height = tagged
+				}
+				if height > 0 {
+					source.Height = &height
+					source.Label = fmt.Sprintf("%dp %s", height, source.Label)
+				}
+				sources = append(sources, source)
+			}
+		}
+
+		// DASH is packaged from the preferred file, the player switches to it on its own
+		if cfg.Dash && haveTool("ffmpeg") {
+			sources = append(sources, playbackSource{
+				Label:       "Auto (DASH)",
+				URL:         "/dash/" + escaped + "/manifest.mpd",
+				Kind:        "dash",
+				ContentType: "application/dash+xml",
+			})
+		}
+		return c.JSON(sources)
+	}
+}
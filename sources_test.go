@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mkv"), []byte("mkv"))
+	writeFile(t, filepath.Join("movies", "a.webm"), []byte("webm file"))
+	writeFile(t, filepath.Join("movies", "a.mov"), []byte("not served"))
+
+	// Without the tools, only the files, preferred format first
+	want := `[{"label":"WEBM","url":"/video/a?format=webm","kind":"file","contentType":"video/webm","default":true,"format":"webm","size":9},` +
+		`{"label":"MKV","url":"/video/a?format=mkv","kind":"file","contentType":"video/x-matroska","default":false,"format":"mkv","size":3}]`
+	if resp, body := get(t, app, "/api/movies/a/sources"); resp.StatusCode != http.StatusOK || body != want {
+		t.Errorf("sources answered %d:\n%s\nwant\n%s", resp.StatusCode, body, want)
+	}
+
+	// Heights from ffprobe, and DASH once ffmpeg is there
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		available := availableTools[tool]
+		availableTools[tool] = true
+		t.Cleanup(func() { availableTools[tool] = available })
+	}
+	scriptTool(t, "ffprobe",
+		toolRun{stdout: `{"streams":[{"width":1920,"height":1080}],"format":{"duration":"60"}}`},
+		toolRun{stdout: `{"streams":[{"width":1280,"height":720}],"format":{"duration":"60"}}`})
+	want = `[{"label":"1080p WEBM","url":"/video/a?format=webm","kind":"file","contentType":"video/webm","default":true,"format":"webm","size":9,"height":1080},` +
+		`{"label":"720p MKV","url":"/video/a?format=mkv","kind":"file","contentType":"video/x-matroska","default":false,"format":"mkv","size":3,"height":720},` +
+		`{"label":"Auto (DASH)","url":"/dash/a/manifest.mpd","kind":"dash","contentType":"application/dash+xml","default":false}]`
+	if resp, body := get(t, app, "/api/movies/a/sources"); resp.StatusCode != http.StatusOK || body != want {
+		t.Errorf("sources with the tools answered %d:\n%s\nwant\n%s", resp.StatusCode, body, want)
+	}
+
+	if resp, _ := get(t, app, "/api/movies/missing/sources"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("sources of a missing movie answered %d", resp.StatusCode)
+	}
+}
+
+func TestVideoFormat(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mkv"), []byte("mkv"))
+	writeFile(t, filepath.Join("movies", "a.webm"), []byte("webm"))
+
+	for target, want := range map[string]struct {
+		status int
+		body   string
+	}{
+		"/video/a":             {http.StatusOK, "webm"},
+		"/video/a?format=mkv":  {http.StatusOK, "mkv"},
+		"/video/a?format=MKV":  {http.StatusOK, "mkv"},
+		"/video/a?format=webm": {http.StatusOK, "webm"},
+		"/video/a?format=mp4":  {http.StatusNotFound, "Movie not found in that format."},
+		"/video/a?format=mov":  {http.StatusBadRequest, "Unknown format, expected one of: mp4, webm, mkv, avi."},
+		"/video/b?format=mkv":  {http.StatusNotFound, "Movie not found in that format."},
+	} {
+		if resp, body := get(t, app, target); resp.StatusCode != want.status || body != want.body {
+			t.Errorf("%s answered %d: %q, want %d: %q", target, resp.StatusCode, body, want.status, want.body)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Thumbnails per row of a sprite sheet
+const spriteColumns = 10
+
+// JPEG images can't be taller than this
+const maxSpriteHeight = 65500
+
+var spriteLocks sync.Map
+
+// Cached sprite sheet and thumbnail track for the current -sprite-interval and -sprite-width
+func spritePaths(cfg *Config, movieName string) (image, vtt string) {
+	base := filepath.Join(cfg.SpriteDir, movieName, fmt.Sprintf("sprite-%dms-%d", cfg.SpriteInterval.Milliseconds(), cfg.SpriteWidth))
+	return base + ".jpg", base + ".vtt"
+}
+
+// Build the sprite sheet and its WebVTT mapping with ffmpeg, unless they are cached and
+// newer than the movie
+func ensureSprite(cfg *Config, rid, movieName, movieFilePath string) (string, string, error) {
+	defer lockKey(&spriteLocks, movieName)()
+
+	movieInfo, err := os.Stat(movieFilePath)
+	if err != nil {
+		return "", "", err
+	}
+	image, vtt := spritePaths(cfg, movieName)
+	if info, err := os.Stat(vtt); err == nil && !info.ModTime().Before(movieInfo.ModTime()) {
+		if _, err := os.Stat(image); err == nil {
+			touchCache(filepath.Dir(image))
+			return image, vtt, nil
+		}
+	}
+
+	probe, err := probeMovie(rid, movieFilePath)
+	if err != nil {
+		return "", "", err
+	}
+	duration, ok := probe.duration()
+	if !ok || len(probe.Streams) == 0 || probe.Streams[0].Width <= 0 {
+		return "", "", errors.New("could not determine the video size and duration")
+	}
+
+	// Tiles keep the video's aspect ratio, rounded to the even sizes encoders like
+	interval := cfg.SpriteInterval.Seconds()
+	width := cfg.SpriteWidth
+	height := max(2, int(math.Round(float64(width*probe.Streams[0].Height)/float64(probe.Streams[0].Width)/2))*2)
+	tiles := int(math.Ceil(duration / interval))
+	rows := (tiles + spriteColumns - 1) / spriteColumns
+	if rows*height > maxSpriteHeight {
+		return "", "", fmt.Errorf("%d thumbnails don't fit in one sprite sheet, raise -sprite-interval", tiles)
+	}
+
+	dir := filepath.Dir(image)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+
+	logRequest(rid, "Generating %d thumbnails for %s", tiles, movieFilePath)
+	tmpImage := image + ".tmp"
+	_, err = runToolFor(rid, cfg.JobTimeout, "ffmpeg", "-nostdin", "-loglevel", "error",
+		"-i", movieFilePath,
+		"-vf", fmt.Sprintf("fps=1/%g,scale=%d:%d,tile=%dx%d", interval, width, height, spriteColumns, rows),
+		"-frames:v", "1", "-c:v", "mjpeg", "-q:v", "5", "-f", "image2", "-y", tmpImage)
+	if err != nil {
+		os.Remove(tmpImage)
+		// Only removed when empty, an older sheet of another interval or width stays
+		os.Remove(dir)
+		logRequest(rid, "Failed to generate thumbnails for %s: %v", movieFilePath, err)
+		return "", "", err
+	}
+	if err := os.Rename(tmpImage, image); err != nil {
+		os.Remove(tmpImage)
+		return "", "", err
+	}
+
+	// Each cue points at its tile with a media fragment, the way players expect thumbnail tracks.
+	// The sprite is named relative to the track, which is two levels deep at both of its
+	// routes, so the track keeps working when a reverse proxy serves everything below a prefix.
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n")
+	src := "../../sprite/" + url.PathEscape(movieName)
+	for i := 0; i < tiles; i++ {
+		start := time.Duration(float64(i) * interval * float64(time.Second))
+		end := time.Duration(math.Min(float64(i+1)*interval, duration) * float64(time.Second))
+		sb.WriteString(fmt.Sprintf("\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatTimestamp(start.Milliseconds()), formatTimestamp(end.Milliseconds()),
+			src, i%spriteColumns*width, i/spriteColumns*height, width, height))
+	}
+	tmpVTT := vtt + ".tmp"
+	if err := os.WriteFile(tmpVTT, []byte(sb.String()), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(tmpVTT, vtt); err != nil {
+		os.Remove(tmpVTT)
+		return "", "", err
+	}
+	pruneCache(cfg)
+	return image, vtt, nil
+}
+
+// Route for the thumbnail sprite sheet, or with track set its WebVTT mapping
+func spriteHandler(cfg *Config, track bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !haveTool("ffmpeg") || !haveTool("ffprobe") {
+			return c.Status(fiber.StatusNotImplemented).SendString("Thumbnails need ffmpeg and ffprobe, which are not installed.")
+		}
+
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+		}
+
+		image, vtt, err := ensureSprite(cfg, requestID(c), movieName, movieFilePath)
+		if err != nil {
+			logRequest(requestID(c), "No thumbnails for %s: %v", movieFilePath, err)
+			return toolFailure(c, err, "Failed to generate thumbnails.")
+		}
+		if track {
+			return sendFileAs(c, vtt, "text/vtt; charset=utf-8")
+		}
+		return sendFileAs(c, image, "image/jpeg")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"image/jpeg"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpriteMapping(t *testing.T) {
+	_, cfg := newTestServer(t, "-sprite-interval", "2s", "-sprite-width", "160")
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(testMovie))
+	probes := scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":1920,"height":1080}],"format":{"duration":"25.5"}}`})
+	renders := scriptTool(t, "ffmpeg", toolRun{output: "jpeg"})
+
+	image, vtt, err := ensureSprite(cfg, "rid", "a", movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(image); string(content) != "jpeg" {
+		t.Errorf("sprite sheet holds %q", content)
+	}
+	track, err := os.ReadFile(vtt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 13 tiles of 160x90, ten to a row, the last one cut short by the end of the movie
+	cues := strings.Split(strings.TrimSpace(string(track)), "\n\n")
+	if len(cues) != 14 || cues[0] != "WEBVTT" {
+		t.Fatalf("got %d cues:\n%s", len(cues)-1, track)
+	}
+	for i, want := range map[int]string{
+		1:  "00:00:00.000 --> 00:00:02.000\n../../sprite/a#xywh=0,0,160,90",
+		2:  "00:00:02.000 --> 00:00:04.000\n../../sprite/a#xywh=160,0,160,90",
+		11: "00:00:20.000 --> 00:00:22.000\n../../sprite/a#xywh=0,90,160,90",
+		13: "00:00:24.000 --> 00:00:25.500\n../../sprite/a#xywh=320,90,160,90",
+	} {
+		if cues[i] != want {
+			t.Errorf("cue %d is %q, want %q", i, cues[i], want)
+		}
+	}
+
+	// Cached until the movie changes
+	if _, _, err := ensureSprite(cfg, "rid", "a", movie); err != nil || probes.Load() != 1 || renders.Load() != 1 {
+		t.Errorf("second request ran ffprobe %d and ffmpeg %d times: %v", probes.Load(), renders.Load(), err)
+	}
+}
+
+func TestSprite(t *testing.T) {
+	requireTools(t, "ffmpeg", "ffprobe")
+	app, _ := newTestServer(t, "-sprite-interval", "1s", "-sprite-width", "64")
+	movie := filepath.Join("movies", "a.mp4")
+	if out, err := exec.Command("ffmpeg", "-nostdin", "-loglevel", "error", "-f", "lavfi", "-i", "testsrc=duration=5:size=320x240:rate=10", "-pix_fmt", "yuv420p", movie).CombinedOutput(); err != nil {
+		t.Fatalf("making a test video: %v: %s", err, out)
+	}
+
+	resp, body := get(t, app, "/sprite/a/thumbnails.vtt")
+	if resp.StatusCode != http.StatusOK || strings.Count(body, "#xywh=") != 5 || !strings.Contains(body, "../../sprite/a#xywh=256,0,64,48") {
+		t.Fatalf("thumbnail track answered %d:\n%s", resp.StatusCode, body)
+	}
+	resp, body = get(t, app, "/sprite/a")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("sprite sheet answered %d as %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	sheet, err := jpeg.DecodeConfig(strings.NewReader(body))
+	if err != nil || sheet.Width != 640 || sheet.Height != 48 {
+		t.Errorf("sprite sheet is %dx%d: %v", sheet.Width, sheet.Height, err)
+	}
+}
+
+func TestThumbnailsTrack(t *testing.T) {
+	app, _ := newTestServer(t, "-sprite-interval", "10s", "-sprite-width", "160")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	ffmpeg, ffprobe := availableTools["ffmpeg"], availableTools["ffprobe"]
+	t.Cleanup(func() { availableTools["ffmpeg"], availableTools["ffprobe"] = ffmpeg, ffprobe })
+
+	availableTools["ffmpeg"], availableTools["ffprobe"] = false, false
+	if resp, _ := get(t, app, "/thumbnails/a/track.vtt"); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("track without the tools answered %d", resp.StatusCode)
+	}
+	if _, body := get(t, app, "/stream/a"); strings.Contains(body, `kind="metadata"`) {
+		t.Error("player offers thumbnails without the tools")
+	}
+
+	availableTools["ffmpeg"], availableTools["ffprobe"] = true, true
+	scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":1920,"height":1080}],"format":{"duration":"25.5"}}`})
+	scriptTool(t, "ffmpeg", toolRun{output: "jpeg"})
+
+	// Both routes serve a track whose cues lead to tiles of the sprite sheet
+	for _, target := range []string{"/thumbnails/a/track.vtt", "/sprite/a/thumbnails.vtt"} {
+		resp, body := get(t, app, target)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/vtt; charset=utf-8" {
+			t.Fatalf("%s answered %d as %q", target, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		cues := strings.Split(strings.TrimSpace(body), "\n\n")
+		if len(cues) != 4 || !strings.HasSuffix(cues[3], "--> 00:00:25.500\n../../sprite/a#xywh=320,0,160,90") {
+			t.Fatalf("%s has cues %q", target, cues)
+		}
+		for i, cue := range cues[1:] {
+			_, src, _ := strings.Cut(cue, "\n")
+			ref, err := url.Parse(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tile := (&url.URL{Path: target}).ResolveReference(ref)
+			if tile.Path != "/sprite/a" || tile.Fragment != fmt.Sprintf("xywh=%d,0,160,90", 160*i) {
+				t.Errorf("%s cue %d leads to %s", target, i+1, tile)
+			}
+			if resp, body := get(t, app, tile.Path); resp.StatusCode != http.StatusOK || body != "jpeg" {
+				t.Errorf("%s cue %d: sprite answered %d", target, i+1, resp.StatusCode)
+			}
+		}
+	}
+
+	// Unlike /video, the sheet is sent in exactly the range asked for
+	req, _ := http.NewRequest(http.MethodGet, "/sprite/a", nil)
+	req.Header.Set("Range", "bytes=1-2")
+	if resp, body := send(t, app, req); resp.StatusCode != http.StatusPartialContent || body != "pe" || resp.Header.Get("Content-Range") != "bytes 1-2/4" {
+		t.Errorf("range of the sprite sheet answered %d with Content-Range %q: %q", resp.StatusCode, resp.Header.Get("Content-Range"), body)
+	}
+
+	if _, body := get(t, app, "/stream/a"); !strings.Contains(body, `<track kind="metadata" label="thumbnails" src="/thumbnails/a/track.vtt" />`) {
+		t.Errorf("player lacks the thumbnails track:\n%s", body)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// A single subtitle cue, with times in milliseconds
+type cue struct {
+	id    string
+	start int64
+	end   int64
+	lines []string
+}
+
+var (
+	// SRT timing line, tolerant of dots, short milliseconds and single digit hours
+	srtTiming = regexp.MustCompile(`^\s*(\d+):(\d{1,2}):(\d{1,2})(?:[,.](\d{1,3}))?\s*-->\s*(\d+):(\d{1,2}):(\d{1,2})(?:[,.](\d{1,3}))?`)
+
+	// Markup in cue text, either an HTML-like tag or an ASS override block like {\an8}
+	cueMarkup = regexp.MustCompile(`</?([a-zA-Z]+)[^<>]*>|\{\\[^}]*\}`)
+)
+
+// Tags that WebVTT understands the same way SRT players do
+var keptTags = map[string]bool{"b": true, "i": true, "u": true}
+
+// Turn subtitle file contents into UTF-8 text without a byte order mark. Files in neither
+// UTF-8 nor UTF-16 are decoded as guessSubtitleEncoding says.
+func decodeSubtitleText(b []byte, fallback encoding.Encoding) string {
+	switch {
+	case len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF:
+		return string(b[3:])
+	case len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE:
+		return decodeUTF16(b[2:], false)
+	case len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		return decodeUTF16(b[2:], true)
+	case utf8.Valid(b):
+		return string(b)
+	}
+
+	// The decoders replace what they can't map instead of failing
+	text, err := guessSubtitleEncoding(b, fallback).NewDecoder().Bytes(b)
+	if err != nil {
+		return strings.ToValidUTF8(string(b), "\uFFFD")
+	}
+	return string(text)
+}
+
+// The encoding of a subtitle file that isn't UTF-8. Shift-JIS is recognized by its byte
+// pairs, which other encodings rarely form throughout a file. Text made up mostly of bytes
+// above ASCII is taken for Cyrillic Windows-1251, the usual encoding of older Russian
+// subtitles; Western European text has far more ASCII letters than accented ones. That
+// guess is only made while the fallback is the default Windows-1252, a library in another
+// single-byte encoding, like Greek Windows-1253, names it with -subtitle-encoding.
+func guessSubtitleEncoding(b []byte, fallback encoding.Encoding) encoding.Encoding {
+	if looksLikeShiftJIS(b) {
+		return japanese.ShiftJIS
+	}
+	if fallback != charmap.Windows1252 {
+		return fallback
+	}
+	high, letters := 0, 0
+	for _, c := range b {
+		switch {
+		case c >= 0x80:
+			high++
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			letters++
+		}
+	}
+	if high > letters {
+		return charmap.Windows1251
+	}
+	return fallback
+}
+
+// Whether the bytes are valid Shift-JIS with some kana, the hiragana and katakana every
+// Japanese text has. Without the kana, Cyrillic text can pass as made up of kanji.
+func looksLikeShiftJIS(b []byte) bool {
+	kana := 0
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c < 0x80, c >= 0xA1 && c <= 0xDF:
+			// ASCII and half-width katakana are one byte
+		case c >= 0x81 && c <= 0x9F, c >= 0xE0 && c <= 0xFC:
+			if i+1 == len(b) || b[i+1] < 0x40 || b[i+1] == 0x7F || b[i+1] > 0xFC {
+				return false
+			}
+			// Rows 0x82 and 0x83 hold hiragana and katakana
+			if c == 0x82 || c == 0x83 {
+				kana++
+			}
+			i++
+		default:
+			return false
+		}
+	}
+	return kana > 0
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		} else {
+			units[i] = uint16(b[2*i+1])<<8 | uint16(b[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// Milliseconds from the four captured timestamp parts
+func parseTimestamp(parts []string) int64 {
+	h, _ := strconv.ParseInt(parts[0], 10, 64)
+	m, _ := strconv.ParseInt(parts[1], 10, 64)
+	s, _ := strconv.ParseInt(parts[2], 10, 64)
+
+	// "5" after the comma means 500ms, not 5ms
+	ms := int64(0)
+	if parts[3] != "" {
+		ms, _ = strconv.ParseInt((parts[3] + "00")[:3], 10, 64)
+	}
+	return ((h*60+m)*60+s)*1000 + ms
+}
+
+func formatTimestamp(ms int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// Escape cue text for WebVTT, keeping the basic styling tags and dropping those it can't show
+func vttCueText(line string) string {
+	var sb strings.Builder
+	last := 0
+	for _, m := range cueMarkup.FindAllStringSubmatchIndex(line, -1) {
+		sb.WriteString(vttEscape(line[last:m[0]]))
+		if m[2] >= 0 && keptTags[strings.ToLower(line[m[2]:m[3]])] {
+			closing := strings.HasPrefix(line[m[0]:], "</")
+			tag := strings.ToLower(line[m[2]:m[3]])
+			if closing {
+				sb.WriteString("</" + tag + ">")
+			} else {
+				sb.WriteString("<" + tag + ">")
+			}
+		}
+		last = m[1]
+	}
+	sb.WriteString(vttEscape(line[last:]))
+	return sb.String()
+}
+
+// Escape plain text for a WebVTT cue
+func vttEscape(text string) string {
+	// WebVTT has no &#39; or &#34; entities, and quotes need no escaping
+	return strings.NewReplacer("&#39;", "'", "&#34;", `"`).Replace(html.EscapeString(text))
+}
+
+// Parse SRT cues, skipping blocks without a usable timing line
+func parseSRT(srt string) []cue {
+	srt = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(srt)
+
+	var cues []cue
+	for _, block := range strings.Split(srt, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+
+		// The timing is normally the second line, after the cue number
+		timing := -1
+		for i := 0; i < len(lines) && i < 2; i++ {
+			if srtTiming.MatchString(lines[i]) {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			continue
+		}
+
+		m := srtTiming.FindStringSubmatch(lines[timing])
+		c := cue{start: parseTimestamp(m[1:5]), end: parseTimestamp(m[5:9])}
+		if timing == 1 {
+			c.id = strings.TrimSpace(lines[0])
+		}
+		for _, line := range lines[timing+1:] {
+			if strings.TrimSpace(line) != "" {
+				c.lines = append(c.lines, vttCueText(line))
+			}
+		}
+		if len(c.lines) > 0 {
+			cues = append(cues, c)
+		}
+	}
+
+	// WebVTT wants cues ordered by start time, overlapping ones are fine
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].start < cues[j].start })
+	return cues
+}
+
+// Convert SRT subtitles into WebVTT so browsers can use them as a <track>
+func srtToVTT(srt string) string {
+	return cuesToVTT(parseSRT(srt))
+}
+
+func cuesToVTT(cues []cue) string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n")
+	for _, c := range cues {
+		sb.WriteString("\n")
+		if c.id != "" && !strings.Contains(c.id, "-->") {
+			sb.WriteString(c.id + "\n")
+		}
+		sb.WriteString(formatTimestamp(c.start) + " --> " + formatTimestamp(c.end) + "\n")
+		sb.WriteString(strings.Join(c.lines, "\n") + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestSRTToVTT(t *testing.T) {
+	tests := []struct {
+		name, srt, vtt string
+	}{
+		{
+			"cue",
+			"1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\nthere\r\n",
+			"WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.500\nHello\nthere\n",
+		},
+		{
+			"short milliseconds and hours",
+			"2\n1:02:03.5 --> 1:02:04,25\nLate\n",
+			"WEBVTT\n\n2\n01:02:03.500 --> 01:02:04.250\nLate\n",
+		},
+		{
+			"no cue number",
+			"00:00:01,000 --> 00:00:02,000\nUnnumbered\n",
+			"WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nUnnumbered\n",
+		},
+		{
+			"out of order",
+			"1\n00:00:05,000 --> 00:00:06,000\nSecond\n\n2\n00:00:01,000 --> 00:00:02,000\nFirst\n",
+			"WEBVTT\n\n2\n00:00:01.000 --> 00:00:02.000\nFirst\n\n1\n00:00:05.000 --> 00:00:06.000\nSecond\n",
+		},
+		{
+			"markup",
+			"1\n00:00:01,000 --> 00:00:02,000\n{\\an8}<I>Tom & Jerry</I> <font color=\"red\">say</font> \"hi\" <3\n",
+			"WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\n<i>Tom &amp; Jerry</i> say \"hi\" &lt;3\n",
+		},
+		{
+			"broken blocks",
+			"1\nnot a timing\nLost\n\n2\n00:00:01,000 --> 00:00:02,000\n\n\n3\n00:00:03,000 --> 00:00:04,000\nKept\n",
+			"WEBVTT\n\n3\n00:00:03.000 --> 00:00:04.000\nKept\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := srtToVTT(tt.srt); got != tt.vtt {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.vtt)
+		}
+	}
+}
+
+func encodeText(t *testing.T, e encoding.Encoding, text string) []byte {
+	t.Helper()
+	b, err := e.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeSubtitleText(t *testing.T) {
+	const (
+		russian      = "Привет, как дела? Всё хорошо."
+		japaneseText = "こんにちは、元気ですか？"
+		french       = "Ça va très bien, merci. À bientôt!"
+		greek        = "Καλημέρα, τι κάνεις;"
+	)
+	tests := []struct {
+		name     string
+		content  []byte
+		fallback encoding.Encoding
+		want     string
+	}{
+		{"utf-8", []byte(russian), charmap.Windows1252, russian},
+		{"utf-8 with a byte order mark", append([]byte{0xEF, 0xBB, 0xBF}, french...), charmap.Windows1252, french},
+		{"utf-16 little endian", []byte{0xFF, 0xFE, 'H', 0, 'i', 0}, charmap.Windows1252, "Hi"},
+		{"utf-16 big endian", []byte{0xFE, 0xFF, 0, 'H', 0, 'i'}, charmap.Windows1252, "Hi"},
+		{"shift-jis", encodeText(t, japanese.ShiftJIS, japaneseText), charmap.Windows1252, japaneseText},
+		{"windows-1251", encodeText(t, charmap.Windows1251, russian), charmap.Windows1252, russian},
+		{"windows-1252", encodeText(t, charmap.Windows1252, french), charmap.Windows1252, french},
+		{"windows-1252 punctuation", []byte("\xc7a co\xfbte 5 \x80 \x93enfin\x94"), charmap.Windows1252, "Ça coûte 5 € “enfin”"},
+		{"configured fallback", encodeText(t, charmap.Windows1253, greek), charmap.Windows1253, greek},
+	}
+	for _, tt := range tests {
+		if got := decodeSubtitleText(tt.content, tt.fallback); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGuessSubtitleEncoding(t *testing.T) {
+	russian := encodeText(t, charmap.Windows1251, "Привет, как дела?")
+	if got := guessSubtitleEncoding(russian, charmap.Windows1252); got != charmap.Windows1251 {
+		t.Errorf("Cyrillic text guessed as %v", got)
+	}
+	// Naming another fallback turns off the Cyrillic guess, but not the Shift-JIS one
+	if got := guessSubtitleEncoding(russian, charmap.Windows1253); got != charmap.Windows1253 {
+		t.Errorf("Cyrillic text with a Greek fallback guessed as %v", got)
+	}
+	if got := guessSubtitleEncoding(encodeText(t, japanese.ShiftJIS, "ありがとう"), charmap.Windows1253); got != japanese.ShiftJIS {
+		t.Errorf("Shift-JIS text guessed as %v", got)
+	}
+	// Kanji alone aren't taken for Shift-JIS
+	if got := guessSubtitleEncoding(encodeText(t, japanese.ShiftJIS, "漢字"), charmap.Windows1252); got == japanese.ShiftJIS {
+		t.Error("text without kana guessed as Shift-JIS")
+	}
+}
+
+func TestSubtitleEncodingFlag(t *testing.T) {
+	greek := "Καλημέρα"
+	app, cfg := newTestServer(t, "-subtitle-encoding", "windows-1253")
+	writeFile(t, filepath.Join(cfg.MoviesDirs[0], "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join(cfg.MoviesDirs[0], "a.srt"), encodeText(t, charmap.Windows1253, strings.Replace(testSRT, "%s", greek, 1)))
+
+	resp, body := get(t, app, "/subtitles/a")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, greek) || !strings.HasPrefix(body, "WEBVTT") {
+		t.Errorf("subtitles answered %d: %q", resp.StatusCode, body)
+	}
+
+	// Cyrillic and Japanese files are recognised with the default fallback, and sent as UTF-8
+	app, _ = newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	for language, text := range map[string]string{"ru": "Привет, как дела?", "ja": "こんにちは"} {
+		e := encoding.Encoding(charmap.Windows1251)
+		if language == "ja" {
+			e = japanese.ShiftJIS
+		}
+		writeFile(t, filepath.Join("movies", "a."+language+".srt"), encodeText(t, e, strings.Replace(testSRT, "%s", text, 1)))
+		resp, body := get(t, app, "/subtitles/a?lang="+language)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, text) || resp.Header.Get("Content-Type") != "text/vtt; charset=utf-8" {
+			t.Errorf("%s subtitles answered %d as %q: %q", language, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+
+	if _, err := loadConfig([]string{"-subtitle-encoding", "klingon"}); err == nil {
+		t.Error("an unknown -subtitle-encoding was accepted")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"strconv"
+	"strings"
+)
+
+// One line of key=value pairs with the settings that matter most, logged on startup so
+// operators can see that their configuration took effect. Values with spaces are quoted.
+func startupSummary(cfg *Config) string {
+	t := cfg.Tunables()
+	onOff := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	listen := cfg.Listen
+	if cfg.UnixSocket != "" {
+		listen = "unix:" + cfg.UnixSocket
+	}
+	// Credentials in the URL stay out of the log
+	remote := "off"
+	if cfg.RemoteURL != nil {
+		remote = cfg.RemoteURL.Redacted()
+	}
+	tlsVersion := "off"
+	if cfg.TLS != nil {
+		tlsVersion = strings.TrimPrefix(tls.VersionName(cfg.TLS.MinVersion), "TLS ") + "+"
+	}
+
+	pairs := [][2]string{
+		{"version", version},
+		{"listen", listen},
+		{"movies", strings.Join(cfg.MoviesDirs, ",")},
+		{"formats", strings.Join(t.Formats, ",")},
+		{"auth", onOff(cfg.APIToken != "")},
+		{"read-only", onOff(cfg.readOnly.Load())},
+		{"tls", tlsVersion},
+		{"cors", onOff(len(t.CORSOrigins)+len(t.CORSAPIOrigins)+len(t.CORSMediaOrigins) > 0)},
+		{"ip-filter", onOff(len(cfg.AllowIPs)+len(cfg.DenyIPs) > 0)},
+		{"remote", remote},
+		{"metrics", "/metrics"},
+		{"pprof", onOff(cfg.Pprof)},
+		{"ffmpeg", onOff(haveTool("ffmpeg"))},
+		{"ffprobe", onOff(haveTool("ffprobe"))},
+		{"dash", onOff(cfg.Dash && haveTool("ffmpeg"))},
+		{"max-streams", strconv.Itoa(t.MaxStreams)},
+		{"max-streams-per-ip", strconv.Itoa(t.MaxStreamsPerIP)},
+		{"prefetch-bytes", strconv.FormatInt(t.PrefetchBytes, 10)},
+		{"max-upload-size", strconv.FormatInt(cfg.MaxUploadSize, 10)},
+		{"cache-size", strconv.FormatInt(cfg.CacheSize, 10)},
+	}
+	var line strings.Builder
+	for i, pair := range pairs {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		value := pair[1]
+		if value == "" || strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(pair[0] + "=" + value)
+	}
+	return line.String()
+}
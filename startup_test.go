@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStartupSummary(t *testing.T) {
+	available := availableTools
+	availableTools = map[string]bool{"ffmpeg": true}
+	t.Cleanup(func() { availableTools = available })
+	certFile, keyFile := selfSignedCert(t)
+
+	for _, tt := range []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{
+			"version=" + version + " ", "listen=0.0.0.0:3000 ", "movies=movies ", "auth=off ", "read-only=off ", "tls=off ",
+			"cors=off ", "ip-filter=off ", "remote=off ", "metrics=/metrics ", "pprof=off ", "ffmpeg=on ", "ffprobe=off ", "dash=on ",
+			"max-streams=0 ", "max-streams-per-ip=5 ", "prefetch-bytes=2097152 ", "max-upload-size=8589934592 ", "cache-size=21474836480",
+		}},
+		{[]string{
+			"-api-token", testToken, "-read-only", "-movies-dir", "movies,My Movies", "-formats", "mp4,webm",
+			"-unix-socket", "/run/display.sock", "-tls-cert", certFile, "-tls-key", keyFile, "-tls-min-version", "1.3",
+			"-cors-media-origins", "*", "-deny-ips", "10.0.0.0/8", "-dash=false", "-max-streams", "4", "-pprof",
+		}, []string{
+			`movies="movies,My Movies" `, "formats=mp4,webm ", "listen=unix:/run/display.sock ", "auth=on ", "read-only=on ",
+			"tls=1.3+ ", "cors=on ", "ip-filter=on ", "pprof=on ", "dash=off ", "max-streams=4 ",
+		}},
+	} {
+		_, cfg := newTestServer(t, tt.args...)
+		summary := startupSummary(cfg)
+		for _, want := range tt.want {
+			if !strings.Contains(summary, want) {
+				t.Errorf("%v: summary lacks %q:\n%s", tt.args, want, summary)
+			}
+		}
+		if strings.Contains(summary, testToken) {
+			t.Errorf("summary shows the API token:\n%s", summary)
+		}
+	}
+}
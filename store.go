@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A value persisted as a JSON file, shared by every feature that keeps state on disk.
+// Access is serialized, and saves write a temporary file that is renamed over the old one,
+// so a crash mid-write leaves the previous version intact instead of a truncated file.
+type JSONStore[T any] struct {
+	mu    sync.RWMutex
+	path  string
+	data  T
+	saved []byte
+}
+
+// Load the store from path, starting from the zero value when the file doesn't exist yet
+func openJSONStore[T any](path string) (*JSONStore[T], error) {
+	s := &JSONStore[T]{path: path}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &s.data); err != nil {
+		return nil, err
+	}
+	s.saved = content
+	return s, nil
+}
+
+// Run fn with the current value; fn must not keep or modify it
+func (s *JSONStore[T]) Read(fn func(data T)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.data)
+}
+
+// Change the value with fn and save it. When fn or the save fails the change is undone.
+func (s *JSONStore[T]) Update(fn func(data *T) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := fn(&s.data)
+	if err == nil {
+		err = s.save()
+	}
+	if err != nil {
+		s.restore()
+	}
+	return err
+}
+
+func (s *JSONStore[T]) save() error {
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// The temporary file lives next to the store so the rename can't cross filesystems
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	s.saved = content
+	return nil
+}
+
+// Go back to the last saved value
+func (s *JSONStore[T]) restore() {
+	var data T
+	if s.saved != nil {
+		json.Unmarshal(s.saved, &data)
+	}
+	s.data = data
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestJSONStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "counts.json")
+	store, err := openJSONStore[map[string]int](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Read(func(data map[string]int) {
+		if len(data) != 0 {
+			t.Errorf("a new store starts with %v", data)
+		}
+	})
+
+	// Concurrent updates are serialized, none is lost
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Update(func(data *map[string]int) error {
+				if *data == nil {
+					*data = map[string]int{}
+				}
+				(*data)["n"]++
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := openJSONStore[map[string]int](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Read(func(data map[string]int) {
+		if data["n"] != 20 {
+			t.Errorf("reloaded %v, want n 20", data)
+		}
+	})
+	if leftover, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(leftover) > 0 {
+		t.Errorf("temporary files left over: %v", leftover)
+	}
+}
+
+func TestJSONStoreFailedUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := openJSONStore[map[string]any](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update(func(data *map[string]any) error {
+		*data = map[string]any{"kept": true}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(path)
+
+	// A change fn refuses and one that can't be saved are both undone, the file keeps the last save
+	failed := errors.New("refused")
+	if err := store.Update(func(data *map[string]any) error {
+		(*data)["lost"] = true
+		return failed
+	}); !errors.Is(err, failed) {
+		t.Errorf("Update answered %v, want its function's error", err)
+	}
+	if err := store.Update(func(data *map[string]any) error {
+		(*data)["unsaveable"] = make(chan int)
+		return nil
+	}); err == nil {
+		t.Error("a value that can't be encoded was saved")
+	}
+	store.Read(func(data map[string]any) {
+		if len(data) != 1 || data["kept"] != true {
+			t.Errorf("after failed updates the store holds %v", data)
+		}
+	})
+	if content, _ := os.ReadFile(path); string(content) != string(saved) {
+		t.Errorf("failed updates changed the file to %s", content)
+	}
+	if leftover, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(leftover) > 0 {
+		t.Errorf("temporary files left over: %v", leftover)
+	}
+}
+
+func TestJSONStoreDamagedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	writeFile(t, path, []byte(`{"n": 1`))
+	if _, err := openJSONStore[map[string]int](path); err == nil {
+		t.Error("a truncated file was loaded")
+	}
+}
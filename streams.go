@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Number of video streams currently holding an open file
+var openStreams atomic.Int64
+
+// Reserve a slot for a new stream, respecting -max-streams
+func acquireStream(cfg *Config) bool {
+	limit := cfg.Tunables().MaxStreams
+	if n := openStreams.Add(1); limit > 0 && n > int64(limit) {
+		openStreams.Add(-1)
+		return false
+	}
+	return true
+}
+
+func releaseStream() {
+	openStreams.Add(-1)
+}
+
+// Streams held per client address, for -max-streams-per-ip
+var (
+	clientStreamsMu sync.Mutex
+	clientStreams   = map[string]int{}
+)
+
+// A client's slot under -max-streams-per-ip, released once
+type clientStream struct {
+	ip      string
+	handed  bool
+	release func()
+}
+
+// Keep a single client from holding all of -max-streams, e.g. a download manager opening
+// dozens of connections. The client is resolved through -trusted-proxies, otherwise
+// everyone behind the proxy would share one limit. The slot is released when the handler
+// returns, unless it takes the slot along with holdClientStream for a body written later.
+func limitPerClient(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := cfg.Tunables().MaxStreamsPerIP
+		if limit == 0 {
+			return c.Next()
+		}
+
+		ip := clientIP(cfg, c)
+		clientStreamsMu.Lock()
+		if clientStreams[ip] >= limit {
+			clientStreamsMu.Unlock()
+			logRequest(requestID(c), "Refused a stream for %s, which already holds %d", clientLabel(cfg, ip), limit)
+			c.Set(fiber.HeaderRetryAfter, "5")
+			return c.Status(fiber.StatusTooManyRequests).SendString("Too many streams from your address, try again shortly.")
+		}
+		clientStreams[ip]++
+		clientStreamsMu.Unlock()
+
+		var once sync.Once
+		slot := &clientStream{ip: ip, release: func() {
+			once.Do(func() {
+				clientStreamsMu.Lock()
+				if clientStreams[ip]--; clientStreams[ip] <= 0 {
+					delete(clientStreams, ip)
+				}
+				clientStreamsMu.Unlock()
+			})
+		}}
+		c.Locals("clientStream", slot)
+		defer func() {
+			if !slot.handed {
+				slot.release()
+			}
+		}()
+		return c.Next()
+	}
+}
+
+// Take the client's -max-streams-per-ip slot into a body stream writer, which calls the
+// returned function when it is done. Without a limit in place that does nothing.
+func holdClientStream(c *fiber.Ctx) func() {
+	slot, ok := c.Locals("clientStream").(*clientStream)
+	if !ok {
+		return func() {}
+	}
+	slot.handed = true
+	return slot.release
+}
+
+// The process or the whole system ran out of file descriptors
+func isFileLimitError(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// A range response currently being written to a client
+type activeStream struct {
+	requestID string
+	movie     string
+	clientIP  string
+	start     int64
+	end       int64
+	started   time.Time
+	conn      net.Conn
+
+	bytesSent atomic.Int64
+	lastWrite atomic.Int64 // Unix nanoseconds of the last chunk the client accepted
+}
+
+// Record that the client accepted another chunk
+func (s *activeStream) wrote(n int) {
+	s.bytesSent.Add(int64(n))
+	s.lastWrite.Store(time.Now().UnixNano())
+}
+
+var (
+	streamsMu     sync.Mutex
+	activeStreams = map[*activeStream]struct{}{}
+)
+
+func trackStream(s *activeStream) {
+	s.lastWrite.Store(time.Now().UnixNano())
+	streamsMu.Lock()
+	activeStreams[s] = struct{}{}
+	streamsMu.Unlock()
+}
+
+func untrackStream(s *activeStream) {
+	streamsMu.Lock()
+	delete(activeStreams, s)
+	streamsMu.Unlock()
+}
+
+// Close connections whose client stopped reading, e.g. a paused video in a forgotten tab.
+// Closing the connection makes the blocked write fail, which frees the file and the stream slot.
+func reapIdleStreams(idleTimeout time.Duration) {
+	interval := idleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	for range time.Tick(interval) {
+		cutoff := time.Now().Add(-idleTimeout).UnixNano()
+
+		streamsMu.Lock()
+		for s := range activeStreams {
+			if s.lastWrite.Load() < cutoff {
+				log.Printf("[%s] Reaping idle stream of %s for %s after %s without progress (%d bytes sent)", s.requestID, s.movie, s.clientIP, idleTimeout, s.bytesSent.Load())
+				s.conn.Close()
+				delete(activeStreams, s)
+			}
+		}
+		streamsMu.Unlock()
+	}
+}
+
+// What /api/debug/streams shows about a stream
+type streamInfo struct {
+	RequestID       string  `json:"requestId"`
+	Movie           string  `json:"movie"`
+	Client          string  `json:"client"`
+	Start           int64   `json:"start"`
+	End             int64   `json:"end"`
+	BytesSent       int64   `json:"bytesSent"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	IdleSeconds     float64 `json:"idleSeconds"`
+}
+
+// The streams being written right now, oldest first, for finding out what keeps the server busy
+func debugStreamsHandler(c *fiber.Ctx) error {
+	now := time.Now()
+	streams := []streamInfo{}
+	streamsMu.Lock()
+	for s := range activeStreams {
+		streams = append(streams, streamInfo{
+			RequestID:       s.requestID,
+			Movie:           s.movie,
+			Client:          s.clientIP,
+			Start:           s.start,
+			End:             s.end,
+			BytesSent:       s.bytesSent.Load(),
+			DurationSeconds: now.Sub(s.started).Seconds(),
+			IdleSeconds:     now.Sub(time.Unix(0, s.lastWrite.Load())).Seconds(),
+		})
+	}
+	streamsMu.Unlock()
+
+	sort.Slice(streams, func(i, j int) bool { return streams[i].DurationSeconds > streams[j].DurationSeconds })
+	return c.JSON(streams)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestVideoOutOfFileDescriptors(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	// With no descriptors left to the process, opening the movie fails with EMFILE
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Skip("the open file limit can't be read:", err)
+	}
+	exhausted := limit
+	exhausted.Cur = 0
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &exhausted); err != nil {
+		t.Skip("the open file limit can't be lowered:", err)
+	}
+	resp, body := get(t, app, "/video/a")
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("open failing with EMFILE answered %d with Retry-After %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if n := openStreams.Load(); n != 0 {
+		t.Errorf("%d streams still counted after the failed open", n)
+	}
+}
+
+func TestVideoMaxStreams(t *testing.T) {
+	app, cfg := newTestServer(t, "-max-streams", "1")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	if !acquireStream(cfg) {
+		t.Fatal("no stream slot for the first stream")
+	}
+	resp, body := get(t, app, "/video/a")
+	releaseStream()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("stream beyond -max-streams answered %d with Retry-After %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if resp, body := get(t, app, "/video/a"); resp.StatusCode != http.StatusOK {
+		t.Errorf("stream within -max-streams answered %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestIdleStreamReaped(t *testing.T) {
+	app, cfg := newTestServer(t, "-stream-idle-timeout", "1s", "-prefetch-bytes", "67108864")
+	// Far more than the socket buffers hold, so the stream blocks once the client stops reading
+	file, err := os.Create(filepath.Join("movies", "a.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Truncate(64 << 20)
+	file.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	go reapIdleStreams(cfg.StreamIdleTimeout)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /video/a HTTP/1.1\r\nHost: test\r\nRange: bytes=0-\r\n\r\n")
+
+	// The client never reads, the stream stays tracked until the reaper closes the connection
+	waitFor := func(what string, done func(active int) bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+			streamsMu.Lock()
+			active := len(activeStreams)
+			streamsMu.Unlock()
+			if done(active) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("no %s after 10s (%d streams tracked, %d open)", what, active, openStreams.Load())
+			}
+		}
+	}
+	waitFor("stream", func(active int) bool { return active == 1 })
+	waitFor("reaping", func(active int) bool { return active == 0 && openStreams.Load() == 0 })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := io.Copy(io.Discard, conn); err != nil || n >= 64<<20 {
+		t.Errorf("reaped stream read %d bytes, ending with %v", n, err)
+	}
+}
+
+func TestDebugStreams(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken, "-prefetch-bytes", "67108864")
+	file, err := os.Create(filepath.Join("movies", "a.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Truncate(64 << 20)
+	file.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	list := func() []streamInfo {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "/api/debug/streams", nil)
+		resp, body := send(t, app, authorized(req))
+		var streams []streamInfo
+		if err := json.Unmarshal([]byte(body), &streams); resp.StatusCode != http.StatusOK || err != nil {
+			t.Fatalf("debug streams answered %d: %s", resp.StatusCode, body)
+		}
+		return streams
+	}
+	waitFor := func(what string, done func(streams []streamInfo) bool) []streamInfo {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+			if streams := list(); done(streams) {
+				return streams
+			} else if time.Now().After(deadline) {
+				t.Fatalf("no %s after 10s: %+v", what, streams)
+			}
+		}
+	}
+
+	if resp, _ := get(t, app, "/api/debug/streams"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("debug streams without the token answered %d", resp.StatusCode)
+	}
+	if streams := list(); len(streams) != 0 {
+		t.Errorf("streams listed before any started: %+v", streams)
+	}
+
+	// A client that stops reading keeps its stream in progress
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /video/a HTTP/1.1\r\nHost: test\r\nRange: bytes=1000-\r\nX-Request-ID: slow-client\r\n\r\n")
+	streams := waitFor("stream", func(streams []streamInfo) bool { return len(streams) == 1 && streams[0].BytesSent > 0 })
+	s := streams[0]
+	if s.RequestID != "slow-client" || s.Movie != "a" || s.Client != "127.0.0.1" || s.Start != 1000 || s.End != 64<<20-1 ||
+		s.BytesSent >= 64<<20-1000 || s.DurationSeconds <= 0 || s.IdleSeconds < 0 {
+		t.Errorf("stream listed as %+v", s)
+	}
+
+	conn.Close()
+	waitFor("end of the stream", func(streams []streamInfo) bool { return len(streams) == 0 })
+}
+
+func TestMaxStreamsPerIP(t *testing.T) {
+	app, _ := newTestServer(t, "-max-streams-per-ip", "2", "-trusted-proxies", "127.0.0.2", "-sendfile-min-size", "1073741824")
+	// Far more than the socket buffers hold, so a stream whose body isn't read stays open
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, nil)
+	if err := os.Truncate(movie, 256<<20); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.ShutdownWithTimeout(time.Second) })
+	logged := captureLog(t)
+
+	// Connect from the given loopback address, optionally naming a client behind it
+	fetch := func(from, forwardedFor string) *http.Response {
+		t.Helper()
+		dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(from)}}
+		client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext, DisableKeepAlives: true}}
+		req, _ := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/video/a", nil)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	var held []*http.Response
+	for i := 0; i < 2; i++ {
+		resp := fetch("127.0.0.1", "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("stream %d answered %d", i+1, resp.StatusCode)
+		}
+		held = append(held, resp)
+	}
+
+	resp := fetch("127.0.0.1", "")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("third stream answered %d with Retry-After %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if !strings.Contains(logged.String(), "Refused a stream for 127.0.0.1, which already holds 2") {
+		t.Errorf("refusal not logged:\n%s", logged)
+	}
+
+	// Other addresses have their own limit, also behind a trusted proxy
+	for _, tt := range []struct{ from, forwardedFor string }{{"127.0.0.3", ""}, {"127.0.0.2", "192.0.2.7"}} {
+		resp := fetch(tt.from, tt.forwardedFor)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("stream from %s for %q answered %d", tt.from, tt.forwardedFor, resp.StatusCode)
+		}
+	}
+
+	// Finished streams give their slots back
+	for _, resp := range held {
+		resp.Body.Close()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := fetch("127.0.0.1", "")
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stream after the others ended answered %d", resp.StatusCode)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if _, err := loadConfig([]string{"-max-streams-per-ip", "-1"}); err == nil {
+		t.Error("negative -max-streams-per-ip accepted")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A subtitle sidecar the player can offer, untagged ([Movie].srt) or for one language
+// ([Movie].en.srt, [Movie].pt-BR.vtt), or a text subtitle stream inside the movie file
+type subtitleTrack struct {
+	Language string `json:"language"` // Empty for the untagged file
+	Label    string `json:"label"`
+	Format   string `json:"format"` // Of the file or stream, the URL always serves WebVTT
+	URL      string `json:"url"`
+	Default  bool   `json:"default"`
+	Embedded bool   `json:"embedded"`
+
+	path string
+}
+
+// Language tags as they appear in sidecar names: a two or three letter code with optional subtags
+var languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// Names for the most common languages, and the three letter codes some tools name files with
+var languageNames = map[string]string{
+	"ar": "Arabic", "cs": "Czech", "da": "Danish", "de": "German", "el": "Greek",
+	"en": "English", "es": "Spanish", "fi": "Finnish", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "hu": "Hungarian", "it": "Italian", "ja": "Japanese", "ko": "Korean",
+	"nl": "Dutch", "no": "Norwegian", "pl": "Polish", "pt": "Portuguese", "ro": "Romanian",
+	"ru": "Russian", "sv": "Swedish", "th": "Thai", "tr": "Turkish", "uk": "Ukrainian",
+	"zh": "Chinese",
+}
+
+var threeLetterLanguages = map[string]string{
+	"ara": "ar", "cze": "cs", "ces": "cs", "dan": "da", "ger": "de", "deu": "de",
+	"gre": "el", "ell": "el", "eng": "en", "spa": "es", "fin": "fi", "fre": "fr",
+	"fra": "fr", "heb": "he", "hin": "hi", "hun": "hu", "ita": "it", "jpn": "ja",
+	"kor": "ko", "dut": "nl", "nld": "nl", "nor": "no", "pol": "pl", "por": "pt",
+	"rum": "ro", "ron": "ro", "rus": "ru", "swe": "sv", "tha": "th", "tur": "tr",
+	"ukr": "uk", "chi": "zh", "zho": "zh",
+}
+
+// Normalize a language tag the way it is compared: lower case language, upper case region,
+// and two letter codes where one exists ("ENG" and "en" are the same, "pt-br" is "pt-BR")
+func normalizeLanguage(tag string) string {
+	parts := strings.Split(tag, "-")
+	parts[0] = strings.ToLower(parts[0])
+	if short, ok := threeLetterLanguages[parts[0]]; ok {
+		parts[0] = short
+	}
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func languageLabel(language string) string {
+	primary, region, _ := strings.Cut(language, "-")
+	name, ok := languageNames[primary]
+	if !ok {
+		return language
+	}
+	if region != "" {
+		return name + " (" + region + ")"
+	}
+	return name
+}
+
+// Every subtitle sidecar of the movie: the untagged one first, then one per language in
+// tag order. For a language available in several formats, WebVTT wins like it does for
+// the untagged file.
+func findSubtitleTracks(cfg *Config, movieName string) []subtitleTrack {
+	var tracks []subtitleTrack
+	escaped := "/subtitles/" + url.PathEscape(movieName)
+	if path, found := findSubtitle(cfg, movieName); found {
+		tracks = append(tracks, subtitleTrack{Label: "Subtitles", Format: subtitleFormat(path), URL: escaped, path: path})
+	}
+
+	byLanguage := map[string]string{}
+	for _, file := range languageSubtitles(cfg, movieName) {
+		if byLanguage[file.language] == "" {
+			byLanguage[file.language] = file.path
+		}
+	}
+
+	languages := make([]string, 0, len(byLanguage))
+	for language := range byLanguage {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		tracks = append(tracks, subtitleTrack{
+			Language: language,
+			Label:    languageLabel(language),
+			Format:   subtitleFormat(byLanguage[language]),
+			URL:      escaped + "?lang=" + url.QueryEscape(language),
+			path:     byLanguage[language],
+		})
+	}
+	return tracks
+}
+
+func subtitleFormat(path string) string {
+	return strings.TrimPrefix(filepath.Ext(path), ".")
+}
+
+type languageSubtitle struct {
+	language string
+	path     string
+}
+
+// Every [Movie].[language].[ext] subtitle file, in the order of subtitleExtensions. Names
+// with dots make some ambiguous: next to a movie Show.de, Show.de.srt is its subtitle file
+// and not the German one of Show.
+func languageSubtitles(cfg *Config, movieName string) []languageSubtitle {
+	dir, stem := sidecarBase(cfg, movieName)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	movies := map[string]bool{}
+	for _, file := range files {
+		if ext := filepath.Ext(file.Name()); !file.IsDir() && cfg.servesFormat(ext) {
+			movies[strings.TrimSuffix(file.Name(), ext)] = true
+		}
+	}
+	var found []languageSubtitle
+	for _, ext := range subtitleExtensions {
+		for _, file := range files {
+			rest, ok := strings.CutPrefix(file.Name(), stem+".")
+			if !ok || file.IsDir() {
+				continue
+			}
+			if tag, ok := strings.CutSuffix(rest, "."+ext); ok && languageTag.MatchString(tag) && !movies[stem+"."+tag] {
+				found = append(found, languageSubtitle{normalizeLanguage(tag), filepath.Join(dir, file.Name())})
+			}
+		}
+	}
+	return found
+}
+
+// Languages the viewer wants, most wanted first: ?lang= (comma-separated), then the
+// browser's Accept-Language by weight, then -subtitle-languages
+func preferredLanguages(c *fiber.Ctx, cfg *Config) []string {
+	var preferred []string
+	for _, tag := range strings.Split(c.Query("lang"), ",") {
+		if tag = strings.TrimSpace(tag); languageTag.MatchString(tag) {
+			preferred = append(preferred, normalizeLanguage(tag))
+		}
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(c.Get(fiber.HeaderAcceptLanguage), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		if !languageTag.MatchString(tag) {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, weighted{normalizeLanguage(tag), q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	for _, a := range accepted {
+		preferred = append(preferred, a.tag)
+	}
+
+	return append(preferred, cfg.SubtitleLanguages...)
+}
+
+// Mark the track to show by default: the first preference with a track in that language,
+// exactly ("pt-BR") or by its language alone ("pt" for "pt-BR" and the other way round).
+// Without a match the untagged file is the default, if there is one. Returns the default.
+func chooseSubtitleTrack(tracks []subtitleTrack, preferred []string) *subtitleTrack {
+	pick := func(i int) *subtitleTrack {
+		tracks[i].Default = true
+		return &tracks[i]
+	}
+	for _, want := range preferred {
+		if i := subtitleTrackIn(tracks, want); i >= 0 {
+			return pick(i)
+		}
+	}
+	for i, track := range tracks {
+		if track.Language == "" {
+			return pick(i)
+		}
+	}
+	return nil
+}
+
+// The track in a language, matching exactly first and then by the primary language, -1
+// without one
+func subtitleTrackIn(tracks []subtitleTrack, want string) int {
+	for i, track := range tracks {
+		if track.Language == want {
+			return i
+		}
+	}
+	wantPrimary, _, _ := strings.Cut(want, "-")
+	for i, track := range tracks {
+		if primary, _, _ := strings.Cut(track.Language, "-"); track.Language != "" && primary == wantPrimary {
+			return i
+		}
+	}
+	return -1
+}
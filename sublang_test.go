@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestNormalizeLanguage(t *testing.T) {
+	for tag, want := range map[string]string{
+		"en":      "en",
+		"ENG":     "en",
+		"spa":     "es",
+		"pt-br":   "pt-BR",
+		"PT-BR":   "pt-BR",
+		"zh-Hant": "zh-Hant",
+		"xyz":     "xyz",
+	} {
+		if got := normalizeLanguage(tag); got != want {
+			t.Errorf("normalizeLanguage(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+// The language of the default track and every track's language, e.g. "es" and "- en es"
+func subtitleChoice(t *testing.T, app *fiber.App, query, acceptLanguage string) (string, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "/api/movies/a/playback"+query, nil)
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	resp, body := send(t, app, req)
+	info := playbackInfo{}
+	if err := json.Unmarshal([]byte(body), &info); resp.StatusCode != http.StatusOK || err != nil {
+		t.Fatalf("playback answered %d: %s", resp.StatusCode, body)
+	}
+	chosen, languages := "none", []string{}
+	for _, track := range info.Subtitles {
+		language := track.Language
+		if language == "" {
+			language = "-"
+		}
+		languages = append(languages, language)
+		if track.Default {
+			chosen = language
+			if info.SubtitleURL == nil || *info.SubtitleURL != track.URL {
+				t.Errorf("default track %s, but subtitleUrl %v", track.URL, info.SubtitleURL)
+			}
+		}
+	}
+	return chosen, strings.Join(languages, " ")
+}
+
+func TestSubtitleLanguages(t *testing.T) {
+	app, _ := newTestServer(t, "-subtitle-languages", "es", "-api-token", testToken)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	for file, text := range map[string]string{
+		"a.srt":       "Untagged",
+		"a.en.srt":    "Hello",
+		"a.es.srt":    "Hola (srt)",
+		"a.es.vtt":    "Hola",
+		"a.pt-BR.srt": "Olá",
+		"a.fra.srt":   "Bonjour",
+		"a.notes.txt": "not a subtitle",
+	} {
+		writeFile(t, filepath.Join("movies", file), []byte(strings.Replace(testSRT, "%s", text, 1)))
+	}
+
+	for _, tt := range []struct {
+		query, acceptLanguage, want string
+	}{
+		{"", "en-US,en;q=0.9", "en"},
+		{"", "fr;q=0.5, pt-PT;q=0.8", "pt-BR"},
+		{"", "de, fr;q=0.1", "fr"},
+		{"", "en;q=0", "es"},
+		{"?lang=pt", "en", "pt-BR"},
+		{"?lang=de,en", "es", "en"},
+		// -subtitle-languages when nothing the viewer wants exists
+		{"", "de", "es"},
+		{"", "", "es"},
+	} {
+		chosen, languages := subtitleChoice(t, app, tt.query, tt.acceptLanguage)
+		if chosen != tt.want || languages != "- en es fr pt-BR" {
+			t.Errorf("%q with Accept-Language %q chose %s of %s, want %s", tt.query, tt.acceptLanguage, chosen, languages, tt.want)
+		}
+	}
+
+	// The tracks themselves, WebVTT winning within a language
+	for target, want := range map[string]string{
+		"/subtitles/a?lang=es":    "Hola\n",
+		"/subtitles/a?lang=PT-br": "Olá\n",
+		"/subtitles/a?lang=fr":    "Bonjour",
+		"/subtitles/a":            "Untagged\n",
+		"/subtitles/a?lang=auto":  "Hola\n",
+	} {
+		if resp, body := get(t, app, target); resp.StatusCode != http.StatusOK || !strings.Contains(body, want) {
+			t.Errorf("%s answered %d: %q", target, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := get(t, app, "/subtitles/a?lang=de"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("subtitles in a missing language answered %d", resp.StatusCode)
+	}
+
+	// The player offers every track, the preferred one by default
+	req, _ := http.NewRequest(http.MethodGet, "/stream/a", nil)
+	req.Header.Set("Accept-Language", "en")
+	_, page := send(t, app, req)
+	for _, want := range []string{
+		`<track kind="subtitles" src="/subtitles/a" label="Subtitles"   />`,
+		`<track kind="subtitles" src="/subtitles/a?lang=en" label="English" srclang="en" default />`,
+		`<track kind="subtitles" src="/subtitles/a?lang=pt-BR" label="Portuguese (BR)" srclang="pt-BR"  />`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("player lacks %s", want)
+		}
+	}
+
+	// Renames take the language sidecars along
+	if resp, body := renameMovie(t, app, "a", "b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rename answered %d: %s", resp.StatusCode, body)
+	}
+	for _, file := range []string{"b.en.srt", "b.es.srt", "b.es.vtt", "b.pt-BR.srt", "b.fra.srt"} {
+		if _, err := os.Stat(filepath.Join("movies", file)); err != nil {
+			t.Errorf("after the rename: %v", err)
+		}
+	}
+}
+
+func TestSubtitleLanguagesUntagged(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "a.srt"), []byte(testSRT))
+	if chosen, languages := subtitleChoice(t, app, "", "de"); chosen != "-" || languages != "-" {
+		t.Errorf("a single untagged file: chose %s of %s", chosen, languages)
+	}
+
+	// Only tagged files and no match leaves subtitles off
+	os.Remove(filepath.Join("movies", "a.srt"))
+	writeFile(t, filepath.Join("movies", "a.en.srt"), []byte(testSRT))
+	if chosen, languages := subtitleChoice(t, app, "", "de"); chosen != "none" || languages != "en" {
+		t.Errorf("only an English file for a German viewer: chose %s of %s", chosen, languages)
+	}
+
+	if _, err := loadConfig([]string{"-subtitle-languages", "en,not a language"}); err == nil {
+		t.Error("invalid -subtitle-languages accepted")
+	}
+}
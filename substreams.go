@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Subtitle codecs ffmpeg converts to WebVTT. Image based ones (PGS, VobSub, DVB) can't be.
+var textSubtitleCodecs = map[string]bool{
+	"subrip": true, "ass": true, "ssa": true, "webvtt": true, "mov_text": true, "text": true,
+}
+
+// A subtitle stream inside the movie file, numbered like ffmpeg's 0:s:N
+type subtitleStream struct {
+	Number   int
+	Codec    string
+	Language string
+	Title    string
+}
+
+// Subtitle streams by movie path, reused until the file changes
+var subtitleStreamCache sync.Map
+
+type cachedSubtitleStreams struct {
+	modTime time.Time
+	size    int64
+	streams []subtitleStream
+}
+
+// Ask ffprobe for the subtitle streams of a movie
+func probeSubtitleStreams(rid, movieFilePath string) ([]subtitleStream, error) {
+	info, err := os.Stat(movieFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if cached, ok := subtitleStreamCache.Load(movieFilePath); ok {
+		cached := cached.(cachedSubtitleStreams)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.streams, nil
+		}
+	}
+
+	output, err := runTool(rid, "ffprobe", "-v", "error",
+		"-select_streams", "s", "-show_entries", "stream=codec_name:stream_tags=language,title",
+		"-of", "json", movieFilePath)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+			Tags      struct {
+				Language string `json:"language"`
+				Title    string `json:"title"`
+			} `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %w", err)
+	}
+	streams := []subtitleStream{}
+	for i, stream := range result.Streams {
+		language := ""
+		// "und" is how containers say they don't know
+		if languageTag.MatchString(stream.Tags.Language) && stream.Tags.Language != "und" {
+			language = normalizeLanguage(stream.Tags.Language)
+		}
+		streams = append(streams, subtitleStream{Number: i, Codec: stream.CodecName, Language: language, Title: stream.Tags.Title})
+	}
+
+	subtitleStreamCache.Store(movieFilePath, cachedSubtitleStreams{modTime: info.ModTime(), size: info.Size(), streams: streams})
+	return streams, nil
+}
+
+// The embedded subtitle streams that can be served as WebVTT, as tracks for the menu.
+// Empty without ffprobe and ffmpeg.
+func embeddedSubtitleTracks(rid, movieName, movieFilePath string) []subtitleTrack {
+	if !haveTool("ffprobe") || !haveTool("ffmpeg") {
+		return nil
+	}
+	streams, err := probeSubtitleStreams(rid, movieFilePath)
+	if err != nil {
+		logRequest(rid, "Could not probe subtitle streams of %s: %v", movieFilePath, err)
+		return nil
+	}
+	var tracks []subtitleTrack
+	for _, stream := range streams {
+		if !textSubtitleCodecs[stream.Codec] {
+			continue
+		}
+		label := stream.Title
+		if label == "" && stream.Language != "" {
+			label = languageLabel(stream.Language)
+		}
+		if label == "" {
+			label = fmt.Sprintf("Track %d", stream.Number+1)
+		}
+		tracks = append(tracks, subtitleTrack{
+			Language: stream.Language,
+			Label:    label,
+			Format:   stream.Codec,
+			URL:      "/subtitles/" + url.PathEscape(movieName) + "?stream=" + strconv.Itoa(stream.Number),
+			Embedded: true,
+		})
+	}
+	return tracks
+}
+
+var subtitleStreamLocks sync.Map
+
+// A subtitle stream of the movie as WebVTT, extracted with ffmpeg on first use and kept in
+// memory until the movie changes
+func loadSubtitleStream(cfg *Config, rid, movieFilePath string, number int) (string, error) {
+	info, err := os.Stat(movieFilePath)
+	if err != nil {
+		return "", err
+	}
+	key := movieFilePath + "#" + strconv.Itoa(number)
+	defer lockKey(&subtitleStreamLocks, key)()
+	if cached, ok := subtitleCache.Load(key); ok {
+		cached := cached.(cachedSubtitle)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.vtt, nil
+		}
+	}
+
+	output, err := runToolFor(rid, cfg.JobTimeout, "ffmpeg", "-nostdin", "-loglevel", "error",
+		"-i", movieFilePath,
+		"-map", "0:s:"+strconv.Itoa(number),
+		"-f", "webvtt", "-")
+	if err != nil {
+		return "", err
+	}
+	vtt := string(output)
+
+	subtitleCache.Store(key, cachedSubtitle{modTime: info.ModTime(), size: info.Size(), vtt: vtt})
+	return vtt, nil
+}
+
+// Serve /subtitles/[Movie]?stream=N, an embedded text subtitle stream
+func sendSubtitleStream(c *fiber.Ctx, cfg *Config, movieName, stream string) error {
+	rid := requestID(c)
+	number, err := strconv.Atoi(stream)
+	if err != nil || number < 0 {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid subtitle stream.")
+	}
+	if !haveTool("ffprobe") || !haveTool("ffmpeg") {
+		return c.Status(fiber.StatusNotImplemented).SendString("Embedded subtitles need ffmpeg and ffprobe, which are not installed.")
+	}
+	movieFilePath, found := findMovie(cfg, movieName)
+	if !found {
+		return c.Status(fiber.StatusNotFound).SendString("Movie not found.")
+	}
+
+	streams, err := probeSubtitleStreams(rid, movieFilePath)
+	if err != nil {
+		return toolFailure(c, err, "Could not read subtitle streams.")
+	}
+	if number >= len(streams) {
+		return c.Status(fiber.StatusNotFound).SendString("Subtitles not found.")
+	}
+	if !textSubtitleCodecs[streams[number].Codec] {
+		return c.Status(fiber.StatusUnsupportedMediaType).SendString("This subtitle stream is an image format and can't be converted to WebVTT.")
+	}
+
+	vtt, err := loadSubtitleStream(cfg, rid, movieFilePath, number)
+	if err != nil {
+		logRequest(rid, "Could not extract subtitle stream %d of %s: %v", number, movieFilePath, err)
+		return toolFailure(c, err, "Could not extract subtitles.")
+	}
+	c.Set(fiber.HeaderContentType, "text/vtt; charset=utf-8")
+	return c.SendString(vtt)
+}
+
+// Every subtitle track of the movie, sidecar files first with the default one marked, then
+// the text streams inside the file when ffprobe is installed
+func subtitleTracksHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Vary(fiber.HeaderAcceptLanguage)
+		movieName := c.Params("movie")
+		movieFilePath, found := findMovie(cfg, movieName)
+		if !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+
+		tracks := findSubtitleTracks(cfg, movieName)
+		chooseSubtitleTrack(tracks, preferredLanguages(c, cfg))
+		tracks = append(tracks, embeddedSubtitleTracks(requestID(c), movieName, movieFilePath)...)
+		if tracks == nil {
+			tracks = []subtitleTrack{}
+		}
+		return c.JSON(tracks)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The subtitle menu as "language:format:label[*] ..." with * marking the default
+func listSubtitleTracks(t *testing.T, app *fiber.App, movie, acceptLanguage string) string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "/api/movies/"+movie+"/subtitles", nil)
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	resp, body := send(t, app, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("subtitles of %s answered %d: %s", movie, resp.StatusCode, body)
+	}
+	var tracks []subtitleTrack
+	if err := json.Unmarshal([]byte(body), &tracks); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	var listed []string
+	for _, track := range tracks {
+		entry := track.Language + ":" + track.Format + ":" + track.Label
+		if track.Default {
+			entry += "*"
+		}
+		if track.Embedded != strings.Contains(track.URL, "?stream=") {
+			t.Errorf("track %+v is embedded %t", track, track.Embedded)
+		}
+		listed = append(listed, entry)
+	}
+	return strings.Join(listed, " ")
+}
+
+// Pretend ffmpeg and ffprobe are installed, whether or not they are
+func fakeSubtitleTools(t *testing.T) {
+	t.Helper()
+	ffmpeg, ffprobe := availableTools["ffmpeg"], availableTools["ffprobe"]
+	availableTools["ffmpeg"], availableTools["ffprobe"] = true, true
+	t.Cleanup(func() { availableTools["ffmpeg"], availableTools["ffprobe"] = ffmpeg, ffprobe })
+}
+
+func TestSubtitleTracks(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mp4"), []byte(testMovie))
+	srt := []byte(strings.Replace(testSRT, "%s", "Hello", 1))
+	for _, name := range []string{"a.srt", "a.es.srt", "a.fr.srt", "a.fr.vtt"} {
+		writeFile(t, filepath.Join("movies", name), srt)
+	}
+
+	for _, tt := range []struct {
+		movie, acceptLanguage, want string
+	}{
+		{"a", "", ":srt:Subtitles* es:srt:Spanish fr:vtt:French"},
+		{"a", "fr-CA, en;q=0.5", ":srt:Subtitles es:srt:Spanish fr:vtt:French*"},
+		{"b", "", ""},
+	} {
+		if got := listSubtitleTracks(t, app, tt.movie, tt.acceptLanguage); got != tt.want {
+			t.Errorf("%s with Accept-Language %q: %q, want %q", tt.movie, tt.acceptLanguage, got, tt.want)
+		}
+	}
+	if resp, body := get(t, app, "/api/movies/b/subtitles"); body != "[]" || resp.Header.Get("Vary") != "Accept-Language" {
+		t.Errorf("no subtitles answered %s with Vary %q", body, resp.Header.Get("Vary"))
+	}
+	if resp, _ := get(t, app, "/api/movies/missing/subtitles"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing movie answered %d", resp.StatusCode)
+	}
+
+	// Without the tools there is nothing embedded to list or serve
+	if resp, _ := get(t, app, "/subtitles/a?stream=0"); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("embedded subtitles without ffmpeg answered %d", resp.StatusCode)
+	}
+}
+
+func TestEmbeddedSubtitleTracks(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mkv"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "a.srt"), []byte(strings.Replace(testSRT, "%s", "Hello", 1)))
+	fakeSubtitleTools(t)
+	probes := scriptTool(t, "ffprobe", toolRun{stdout: `{"streams": [
+		{"codec_name": "subrip", "tags": {"language": "eng"}},
+		{"codec_name": "hdmv_pgs_subtitle", "tags": {"language": "eng"}},
+		{"codec_name": "ass", "tags": {"language": "und", "title": "Signs"}},
+		{"codec_name": "mov_text"}
+	]}`})
+	extractions := scriptTool(t, "ffmpeg", toolRun{stdout: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nEmbedded\n"})
+
+	want := ":srt:Subtitles* en:subrip:English :ass:Signs :mov_text:Track 4"
+	if got := listSubtitleTracks(t, app, "a", ""); got != want {
+		t.Errorf("tracks %q, want %q", got, want)
+	}
+
+	for _, tt := range []struct {
+		stream string
+		status int
+	}{
+		{"0", http.StatusOK},
+		{"0", http.StatusOK},
+		{"1", http.StatusUnsupportedMediaType},
+		{"3", http.StatusOK},
+		{"4", http.StatusNotFound},
+		{"x", http.StatusBadRequest},
+	} {
+		resp, body := get(t, app, "/subtitles/a?stream="+tt.stream)
+		if resp.StatusCode != tt.status {
+			t.Errorf("stream %s answered %d: %s", tt.stream, resp.StatusCode, body)
+		}
+		if tt.status == http.StatusOK && (!strings.Contains(body, "Embedded") || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/vtt")) {
+			t.Errorf("stream %s answered %q as %q", tt.stream, body, resp.Header.Get("Content-Type"))
+		}
+	}
+	// Both the stream list and the converted text are kept
+	if probes.Load() != 1 || extractions.Load() != 2 {
+		t.Errorf("ffprobe ran %d and ffmpeg %d times", probes.Load(), extractions.Load())
+	}
+}
+
+func TestEmbeddedSubtitlesWithFFmpeg(t *testing.T) {
+	requireTools(t, "ffmpeg", "ffprobe")
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.srt"), []byte(strings.Replace(testSRT, "%s", "Inside", 1)))
+	if out, err := exec.Command("ffmpeg", "-nostdin", "-loglevel", "error", "-f", "lavfi", "-i", "testsrc=duration=3:size=160x120:rate=10",
+		"-i", filepath.Join("movies", "a.srt"), "-map", "0", "-map", "1", "-metadata:s:s:0", "language=ger", "-c:s", "srt",
+		filepath.Join("movies", "b.mkv")).CombinedOutput(); err != nil {
+		t.Fatalf("making a test video: %v: %s", err, out)
+	}
+	if got := listSubtitleTracks(t, app, "b", ""); got != "de:subrip:German" {
+		t.Errorf("tracks %q", got)
+	}
+	if resp, body := get(t, app, "/subtitles/b?stream=0"); resp.StatusCode != http.StatusOK || !strings.HasPrefix(body, "WEBVTT") || !strings.Contains(body, "Inside") {
+		t.Errorf("embedded stream answered %d: %s", resp.StatusCode, body)
+	}
+}
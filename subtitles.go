@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Subtitle sidecar extensions, preferring WebVTT over formats that need converting
+var subtitleExtensions = []string{"vtt", "srt", "ass", "ssa"}
+
+// Converters to WebVTT for the subtitle formats browsers can't read
+var subtitleConverters = map[string]func(string) string{
+	".srt": srtToVTT,
+	".ass": assToVTT,
+	".ssa": assToVTT,
+}
+
+// Converted subtitles by path, reused until the file changes
+var subtitleCache sync.Map
+
+type cachedSubtitle struct {
+	modTime time.Time
+	size    int64
+	vtt     string
+}
+
+// Locate a subtitle sidecar for the movie
+func findSubtitle(cfg *Config, movieName string) (string, bool) {
+	for _, ext := range subtitleExtensions {
+		path := sidecarPath(cfg, movieName, ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// The subtitle file as WebVTT text
+func loadSubtitle(cfg *Config, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if cached, ok := subtitleCache.Load(path); ok {
+		cached := cached.(cachedSubtitle)
+		if cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+			return cached.vtt, nil
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	vtt := decodeSubtitleText(content, cfg.SubtitleEncoding)
+	if convert, ok := subtitleConverters[filepath.Ext(path)]; ok {
+		vtt = convert(vtt)
+	}
+
+	subtitleCache.Store(path, cachedSubtitle{modTime: info.ModTime(), size: info.Size(), vtt: vtt})
+	return vtt, nil
+}
+
+func subtitleHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// The body is compressed for clients that ask for it, so caches must key on it
+		c.Vary(fiber.HeaderAcceptEncoding)
+
+		// WebVTT for browsers unless ?format=srt asks for the SRT file itself, for native players
+		format := strings.ToLower(c.Query("format", "vtt"))
+		if format != "vtt" && format != "srt" {
+			return c.Status(fiber.StatusBadRequest).SendString("Unknown subtitle format, expected vtt or srt.")
+		}
+
+		// ?stream=N is a subtitle stream inside the movie file
+		if stream := c.Query("stream"); stream != "" {
+			if format == "srt" {
+				return c.Status(fiber.StatusBadRequest).SendString("Embedded subtitles are only served as WebVTT.")
+			}
+			return sendSubtitleStream(c, cfg, c.Params("movie"), stream)
+		}
+
+		tracks := findSubtitleTracks(cfg, c.Params("movie"))
+		if len(tracks) == 0 {
+			return c.Status(fiber.StatusNotFound).SendString("Subtitles not found.")
+		}
+
+		// Without ?lang= the untagged file (or the first language), ?lang= asks for one
+		// language and ?lang=auto for the track the player would show by default
+		track := &tracks[0]
+		if lang := c.Query("lang"); strings.EqualFold(lang, "auto") {
+			// The track depends on the browser's languages
+			c.Vary(fiber.HeaderAcceptLanguage)
+			if chosen := chooseSubtitleTrack(tracks, preferredLanguages(c, cfg)); chosen != nil {
+				track = chosen
+			}
+		} else if lang != "" {
+			track = nil
+			for i := range tracks {
+				if tracks[i].Language == normalizeLanguage(lang) {
+					track = &tracks[i]
+				}
+			}
+			if track == nil {
+				return c.Status(fiber.StatusNotFound).SendString("No subtitles in that language.")
+			}
+		}
+
+		if format == "srt" {
+			return sendSRT(c, cfg, track.path)
+		}
+
+		vtt, err := loadSubtitle(cfg, track.path)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not read subtitle file.")
+		}
+
+		c.Set(fiber.HeaderContentType, "text/vtt; charset=utf-8")
+		return c.SendString(vtt)
+	}
+}
+
+// Serve the SRT file of a track, the one next to it in the same language when the track
+// itself is another format. Only the text encoding is changed, to UTF-8.
+func sendSRT(c *fiber.Ctx, cfg *Config, path string) error {
+	if ext := filepath.Ext(path); ext != ".srt" {
+		path = strings.TrimSuffix(path, ext) + ".srt"
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c.Status(fiber.StatusNotFound).SendString("No SRT subtitles in that language.")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not read subtitle file.")
+	}
+	c.Set(fiber.HeaderContentType, "application/x-subrip; charset=utf-8")
+	return c.SendString(decodeSubtitleText(content, cfg.SubtitleEncoding))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSRT = "1\n00:00:01,000 --> 00:00:02,500\n%s\n"
+
+func TestSubtitlesCompressed(t *testing.T) {
+	app, _ := newTestServer(t)
+	text := strings.Repeat("A line long enough to be worth compressing. ", 20)
+	writeFile(t, filepath.Join("movies", "a.srt"), []byte(strings.Replace(testSRT, "%s", text, 1)))
+
+	req, _ := http.NewRequest(http.MethodGet, "/subtitles/a", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, body := send(t, app, req)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("subtitles answered %d with Content-Encoding %q and Vary %q", resp.StatusCode, resp.Header.Get("Content-Encoding"), resp.Header.Get("Vary"))
+	}
+	reader, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vtt, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.500\n" + text + "\n"; string(vtt) != want {
+		t.Errorf("decompressed subtitles %q, want %q", vtt, want)
+	}
+}
+
+func TestSubtitleCache(t *testing.T) {
+	app, _ := newTestServer(t)
+	path := filepath.Join("movies", "a.ass")
+	script := "[Events]\nDialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,%s\n"
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeScript := func(text string, modTime time.Time) {
+		writeFile(t, path, []byte(strings.Replace(script, "%s", text, 1)))
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subtitles := func() string {
+		resp, body := get(t, app, "/subtitles/a")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/vtt; charset=utf-8" {
+			t.Fatalf("subtitles answered %d as %q: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+		return body
+	}
+
+	if resp, _ := get(t, app, "/subtitles/a"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("no subtitles answered %d", resp.StatusCode)
+	}
+
+	writeScript("First", modTime)
+	if got := subtitles(); !strings.Contains(got, "First") {
+		t.Fatalf("got %q", got)
+	}
+
+	// Same size and time, so the earlier conversion is served
+	writeScript("Other", modTime)
+	if got := subtitles(); !strings.Contains(got, "First") {
+		t.Errorf("conversion not reused: %q", got)
+	}
+
+	// A newer file is converted again
+	writeScript("Other", modTime.Add(time.Minute))
+	if got := subtitles(); !strings.Contains(got, "Other") {
+		t.Errorf("changed file not converted again: %q", got)
+	}
+}
+
+func TestSubtitleFormats(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "a.en.srt"), []byte(strings.Replace(testSRT, "%s", "Hello", 1)))
+	writeFile(t, filepath.Join("movies", "a.es.vtt"), []byte("WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHola (vtt)\n"))
+	writeFile(t, filepath.Join("movies", "a.es.srt"), []byte(strings.Replace(testSRT, "%s", "Hola (srt)", 1)))
+	writeFile(t, filepath.Join("movies", "a.fr.ass"), []byte("[Events]\nDialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Bonjour\n"))
+
+	for _, tt := range []struct {
+		query       string
+		status      int
+		contentType string
+		body        string
+	}{
+		// The SRT file as it is for native players
+		{"?lang=en&format=srt", http.StatusOK, "application/x-subrip; charset=utf-8", strings.Replace(testSRT, "%s", "Hello", 1)},
+		{"?lang=en&format=SRT", http.StatusOK, "application/x-subrip; charset=utf-8", strings.Replace(testSRT, "%s", "Hello", 1)},
+		// The .srt next to a WebVTT track of the same language
+		{"?lang=es&format=srt", http.StatusOK, "application/x-subrip; charset=utf-8", strings.Replace(testSRT, "%s", "Hola (srt)", 1)},
+		// WebVTT for browsers, converted from the SRT
+		{"?lang=en", http.StatusOK, "text/vtt; charset=utf-8", "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.500\nHello\n"},
+		{"?lang=en&format=vtt", http.StatusOK, "text/vtt; charset=utf-8", "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.500\nHello\n"},
+		{"?lang=fr&format=srt", http.StatusNotFound, "", "No SRT subtitles in that language."},
+		{"?lang=en&format=ass", http.StatusBadRequest, "", "Unknown subtitle format, expected vtt or srt."},
+		{"?stream=0&format=srt", http.StatusBadRequest, "", "Embedded subtitles are only served as WebVTT."},
+	} {
+		resp, body := get(t, app, "/subtitles/a"+tt.query)
+		if resp.StatusCode != tt.status || body != tt.body || (tt.contentType != "" && resp.Header.Get("Content-Type") != tt.contentType) {
+			t.Errorf("%s answered %d as %q: %q", tt.query, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
+func TestSubtitleTrackChoice(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "a.srt"), []byte(strings.Replace(testSRT, "%s", "untagged", 1)))
+	writeFile(t, filepath.Join("movies", "a.de.srt"), []byte(strings.Replace(testSRT, "%s", "german", 1)))
+
+	for _, tt := range []struct {
+		target, acceptLanguage, want string
+	}{
+		{"/subtitles/a", "", "untagged"},
+		{"/subtitles/a", "de", "untagged"},
+		{"/subtitles/a?lang=de", "", "german"},
+		{"/subtitles/a?lang=auto", "de", "german"},
+		{"/subtitles/a?lang=auto", "fr", "untagged"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		resp, body := send(t, app, req)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, tt.want) {
+			t.Errorf("%s with Accept-Language %q answered %d: %q, want %s", tt.target, tt.acceptLanguage, resp.StatusCode, body, tt.want)
+		}
+		// Only negotiated answers depend on the browser's languages
+		if negotiated := strings.Contains(resp.Header.Get("Vary"), "Accept-Language"); negotiated != strings.Contains(tt.target, "auto") {
+			t.Errorf("%s: Vary %q", tt.target, resp.Header.Get("Vary"))
+		}
+	}
+}
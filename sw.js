@@ -0,0 +1,26 @@
+// Service worker for installing the player as an app. Movies are never cached, they are far
+// too large and always come from the server; only a failed page load gets an offline notice.
+const OFFLINE_PAGE = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <meta name="viewport" content="width=device-width, initial-scale=1.0" />
+    <title>Offline</title>
+  </head>
+  <body style="margin: 0; padding: 2em; background: #121212; color: #ccc; font-family: sans-serif">
+    <p>The server can't be reached. Check your connection and try again.</p>
+  </body>
+</html>`;
+
+self.addEventListener("install", () => self.skipWaiting());
+self.addEventListener("activate", (event) => event.waitUntil(self.clients.claim()));
+
+self.addEventListener("fetch", (event) => {
+  // Video, ranges and API calls go straight to the network
+  if (event.request.mode !== "navigate") return;
+  event.respondWith(
+    fetch(event.request).catch(
+      () => new Response(OFFLINE_PAGE, { headers: { "Content-Type": "text/html; charset=utf-8" } })
+    )
+  );
+});
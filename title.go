@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Release tags -title-tags strips by default: resolutions, sources, codecs, audio formats
+// and edition markers. Each is matched against a whole word, ignoring case.
+const defaultTitleTags = `[0-9]+p,4k,uhd,hdr,hdr10,dv,bluray,blu-ray,brrip,bdrip,bdremux,remux,web,web-dl,webdl,webrip,hdtv,hdrip,dvdrip,dvdscr,` +
+	`x264,x265,h264,h265,hevc,avc,xvid,divx,10bit,aac,ac3,eac3,dts,dts-hd,truehd,atmos,ddp5\.1,dd5\.1,5\.1,7\.1,` +
+	`proper,repack,extended,unrated,remastered,limited,internal,multi,subbed,dubbed`
+
+// Years taken for the release year, "(1999)" and "[1999]" included
+var titleYear = regexp.MustCompile(`^[(\[]?((?:19|20)[0-9]{2})[)\]]?$`)
+
+// Compile -title-tags, a comma-separated list of regular expressions for words that end the
+// title, into one expression matching a whole word
+func parseTitleTags(list string) (*regexp.Regexp, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in -title-tags: %v", pattern, err)
+		}
+		patterns = append(patterns, "(?:"+pattern+")")
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?i:` + strings.Join(patterns, "|") + `)$`)
+}
+
+// A display title and release year from a file name like The.Matrix.1999.1080p.BluRay.x264:
+// dots and underscores become spaces, and the title ends at the first release tag. The year
+// is the last one before the tags that isn't the first word, so "1917 2019" is 1917 from
+// 2019 and "Blade Runner 2049 2017" keeps 2049 in the title; without one the first year
+// among the tags counts. A name that can't be cleaned up to anything is returned as it is.
+func cleanTitle(name string, tags *regexp.Regexp) (string, *int) {
+	words := strings.Fields(strings.NewReplacer(".", " ", "_", " ").Replace(name))
+
+	// A release group in brackets up front, e.g. [YTS] Movie
+	for len(words) > 1 && strings.HasPrefix(words[0], "[") && strings.HasSuffix(words[0], "]") {
+		words = words[1:]
+	}
+
+	end := len(words)
+	for i, word := range words {
+		// Tags often carry the release group, as in x264-SPARKS
+		tag, _, _ := strings.Cut(word, "-")
+		if i > 0 && tags != nil && (tags.MatchString(word) || tags.MatchString(strings.Trim(tag, "[]()"))) {
+			end = i
+			break
+		}
+	}
+	words, rest := words[:end], words[end:]
+
+	var year *int
+	for i := len(words) - 1; i > 0; i-- {
+		if match := titleYear.FindStringSubmatch(words[i]); match != nil {
+			y, _ := strconv.Atoi(match[1])
+			year = &y
+			words = words[:i]
+			break
+		}
+	}
+	// Some names put the year after an edition tag, as in Movie EXTENDED 2001
+	for _, word := range rest {
+		if match := titleYear.FindStringSubmatch(word); match != nil && year == nil {
+			y, _ := strconv.Atoi(match[1])
+			year = &y
+		}
+	}
+
+	title := strings.Trim(strings.Join(words, " "), " -([")
+	if title == "" {
+		return name, year
+	}
+	return title, year
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanTitle(t *testing.T) {
+	tags, err := parseTitleTags(defaultTitleTags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, title string
+		year        int
+	}{
+		{"The.Matrix.1999.1080p.BluRay.x264-SPARKS", "The Matrix", 1999},
+		{"Blade_Runner_2049_2017_2160p_UHD_HDR", "Blade Runner 2049", 2017},
+		{"1917.2019.720p.WEB-DL", "1917", 2019},
+		{"[YTS] Inception (2010) [1080p]", "Inception", 2010},
+		{"Spider-Man.Into.the.Spider-Verse.2018.WEBRip.x265-GROUP", "Spider-Man Into the Spider-Verse", 2018},
+		{"Movie.EXTENDED.2001.DVDRip.XviD", "Movie", 2001},
+		{"Alien.Directors.Cut.REMASTERED.dts-hd", "Alien Directors Cut", 0},
+		{"Amelie", "Amelie", 0},
+		{"2001 A Space Odyssey", "2001 A Space Odyssey", 0},
+		{"1080p", "1080p", 0},
+	} {
+		title, year := cleanTitle(tt.name, tags)
+		got := 0
+		if year != nil {
+			got = *year
+		}
+		if title != tt.title || got != tt.year {
+			t.Errorf("%s: %q from %d, want %q from %d", tt.name, title, got, tt.title, tt.year)
+		}
+	}
+}
+
+func TestTitleTags(t *testing.T) {
+	app, _ := newTestServer(t, "-title-tags", "custom,[0-9]+p")
+	writeFile(t, filepath.Join("movies", "Some.Movie.2020.CUSTOM.BluRay.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "Other.Movie.BluRay.1999.mp4"), []byte(testMovie))
+
+	_, body := get(t, app, "/api/movies")
+	var movies []MovieEntry
+	if err := json.Unmarshal([]byte(body), &movies); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	var got []string
+	for _, movie := range movies {
+		got = append(got, fmt.Sprintf("%s %v", movie.Title, *movie.Year))
+	}
+	// BluRay isn't a tag any more, so it stays in the title
+	if fmt.Sprint(got) != "[Other Movie BluRay 1999 Some Movie 2020]" {
+		t.Errorf("titles %q", got)
+	}
+	if info := playback(t, app, "Some.Movie.2020.CUSTOM.BluRay"); info.Title != "Some Movie" || info.Year == nil || *info.Year != 2020 {
+		t.Errorf("playback title %q from %v", info.Title, info.Year)
+	}
+
+	if _, err := loadConfig([]string{"-title-tags", "x26[45"}); err == nil {
+		t.Error("invalid -title-tags accepted")
+	}
+}
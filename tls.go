@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLS versions -tls-min-version accepts. Anything older is broken and refused.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Build the TLS settings from -tls-cert, -tls-key, -tls-min-version and -tls-ciphers.
+// Cipher suites are picked by their Go names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, and
+// only the ones Go considers secure are allowed. They only apply to TLS 1.2, Go always
+// picks the suites of TLS 1.3 itself.
+func buildTLSConfig(certFile, keyFile, minVersion, ciphers string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLS certificate: %w", err)
+	}
+
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported -tls-min-version %q, expected 1.2 or 1.3", minVersion)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: version}
+
+	if ciphers == "" {
+		return config, nil
+	}
+	if version == tls.VersionTLS13 {
+		return nil, errors.New("-tls-ciphers has no effect with -tls-min-version 1.3")
+	}
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := map[string]bool{}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+	for _, name := range strings.Split(ciphers, ",") {
+		name = strings.TrimSpace(name)
+		if insecure[name] {
+			return nil, fmt.Errorf("cipher suite %s in -tls-ciphers is insecure", name)
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q in -tls-ciphers", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Write a self-signed certificate for 127.0.0.1 and its key, returning their paths
+func selfSignedCert(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := selfSignedCert(t)
+	load := func(args ...string) (*Config, error) {
+		return loadConfig(append([]string{"-tls-cert", certFile, "-tls-key", keyFile}, args...))
+	}
+
+	cfg, err := loadConfig(nil)
+	if err != nil || cfg.TLS != nil {
+		t.Fatalf("plain HTTP has TLS settings %v, %v", cfg.TLS, err)
+	}
+	for _, tt := range []struct {
+		args    []string
+		version uint16
+		ciphers []uint16
+	}{
+		{nil, tls.VersionTLS12, nil},
+		{[]string{"-tls-min-version", "1.3"}, tls.VersionTLS13, nil},
+		{[]string{"-tls-ciphers", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"},
+			tls.VersionTLS12, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}},
+	} {
+		cfg, err := load(tt.args...)
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if cfg.TLS.MinVersion != tt.version || len(cfg.TLS.Certificates) != 1 || !slices.Equal(cfg.TLS.CipherSuites, tt.ciphers) {
+			t.Errorf("%q: minimum version %x, ciphers %v", tt.args, cfg.TLS.MinVersion, cfg.TLS.CipherSuites)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-tls-min-version", "1.1"},
+		{"-tls-min-version", "1.0"},
+		{"-tls-ciphers", "TLS_RSA_WITH_RC4_128_SHA"},
+		{"-tls-ciphers", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,NOT_A_SUITE"},
+		{"-tls-min-version", "1.3", "-tls-ciphers", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	} {
+		if _, err := load(args...); err == nil {
+			t.Errorf("%q accepted", args)
+		}
+	}
+	if _, err := loadConfig([]string{"-tls-cert", certFile}); err == nil {
+		t.Error("-tls-cert without -tls-key accepted")
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile := selfSignedCert(t)
+	// A free port for listen to take
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	app, cfg := newTestServer(t, "-listen", addr, "-tls-cert", certFile, "-tls-key", keyFile, "-tls-min-version", "1.3")
+	done := make(chan error, 1)
+	go func() { done <- listen(app, cfg) }()
+	t.Cleanup(func() {
+		app.Shutdown()
+		<-done
+	})
+
+	dial := func(maxVersion uint16) (*tls.Conn, error) {
+		return tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion})
+	}
+	var conn *tls.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err = dial(0); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := conn.ConnectionState().Version; version != tls.VersionTLS13 {
+		t.Errorf("negotiated TLS version %x", version)
+	}
+	conn.Close()
+	if conn, err := dial(tls.VersionTLS12); err == nil {
+		conn.Close()
+		t.Error("TLS 1.2 accepted under -tls-min-version 1.3")
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + addr + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("health check over HTTPS answered %d", resp.StatusCode)
+	}
+}
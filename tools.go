@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Creates ffmpeg and ffprobe processes, swappable to exercise the retry logic without them
+var execCommand = exec.Command
+
+// Retries for ffmpeg and ffprobe runs that failed for reasons unrelated to the input
+const (
+	maxToolAttempts = 3
+	toolRetryDelay  = 250 * time.Millisecond
+	toolRetryBudget = 30 * time.Second
+)
+
+// A failed ffmpeg or ffprobe run, with what the tool printed about it
+type toolError struct {
+	tool   string
+	err    error
+	stderr string
+}
+
+func (e *toolError) Error() string {
+	if e.stderr == "" {
+		return fmt.Sprintf("%s: %v", e.tool, e.err)
+	}
+	return fmt.Sprintf("%s: %v: %s", e.tool, e.err, e.stderr)
+}
+
+func (e *toolError) Unwrap() error {
+	return e.err
+}
+
+// One entry per ffmpeg process running, nil for no -max-ffmpeg-jobs limit, and how long a
+// job waits for one (-ffmpeg-queue-timeout). Set by probeTools before the server starts.
+var (
+	ffmpegSlots        chan struct{}
+	ffmpegQueueTimeout time.Duration
+)
+
+// Matches the error runTool returns when all ffmpeg slots stayed taken for too long
+var errToolBusy = errors.New("all jobs allowed at once are running")
+
+type toolBusyError struct {
+	tool string
+	wait time.Duration
+}
+
+func (e *toolBusyError) Error() string {
+	return fmt.Sprintf("%s: %v, gave up waiting after %s", e.tool, errToolBusy, e.wait)
+}
+
+func (e *toolBusyError) Is(target error) bool {
+	return target == errToolBusy
+}
+
+// Tools found by probeTools at startup. Only written before the server starts, so reads,
+// like those of toolBreakers, need no locking.
+var availableTools = map[string]bool{}
+
+// Run "-version" of each tool the optional features need, logging what was found so a
+// missing dependency shows up at startup rather than on the first request
+func probeTools(cfg *Config) {
+	if cfg.MaxFFmpegJobs > 0 {
+		ffmpegSlots = make(chan struct{}, cfg.MaxFFmpegJobs)
+	}
+	ffmpegQueueTimeout = cfg.FFmpegQueueTimeout
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		toolBreakers[tool] = &toolBreaker{threshold: cfg.ToolBreakerFailures, cooldown: cfg.ToolBreakerCooldown}
+		output, err := execCommand(tool, "-version").Output()
+		if err != nil {
+			log.Printf("%s is not available, features that need it are disabled: %v", tool, err)
+			availableTools[tool] = false
+			continue
+		}
+		version, _, _ := strings.Cut(string(output), "\n")
+		log.Printf("Found %s", strings.TrimSpace(version))
+		availableTools[tool] = true
+	}
+}
+
+// Whether probeTools found the tool
+func haveTool(tool string) bool {
+	return availableTools[tool]
+}
+
+// Returned, wrapped in a toolError, when a job ran past its time limit and was killed
+var errJobTimeout = errors.New("took too long and was stopped")
+
+// Run ffmpeg or ffprobe and return its standard output. Failures caused by resource
+// contention are retried with backoff; failures caused by the input are returned right away.
+func runTool(rid, tool string, args ...string) ([]byte, error) {
+	return runToolFor(rid, 0, tool, args...)
+}
+
+// Like runTool, but killing the tool once all attempts together took longer than limit
+// (0 for no limit). For jobs whose run time grows with the movie, like packaging it.
+func runToolFor(rid string, limit time.Duration, tool string, args ...string) ([]byte, error) {
+	// ffprobe runs are short and left out, so probing a movie never waits behind packaging one.
+	// The slot comes first, a trial run of the breaker mustn't be held up waiting for one.
+	if tool == "ffmpeg" && ffmpegSlots != nil {
+		if !takeFFmpegSlot(rid) {
+			return nil, &toolBusyError{tool: tool, wait: ffmpegQueueTimeout}
+		}
+		defer func() { <-ffmpegSlots }()
+	}
+
+	breaker := toolBreakers[tool]
+	if !breaker.allow() {
+		return nil, &breakerOpenError{tool: tool, wait: breaker.retryAfter()}
+	}
+
+	// The time limit starts once the job runs, not while it waits for a slot
+	started := time.Now()
+	delay := toolRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		cmd := execCommand(tool, args...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		var timedOut atomic.Bool
+		runErr := cmd.Start()
+		if runErr == nil {
+			var timer *time.Timer
+			if limit > 0 {
+				timer = time.AfterFunc(limit-time.Since(started), func() {
+					timedOut.Store(true)
+					cmd.Process.Kill()
+				})
+			}
+			runErr = cmd.Wait()
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+		if timedOut.Load() {
+			// Slow says nothing about whether the tool works, so it doesn't count towards the breaker
+			breaker.record(tool, false)
+			logRequest(rid, "%s ran longer than %s, stopped it", tool, limit)
+			return stdout.Bytes(), &toolError{tool: tool, err: fmt.Errorf("%w after %s", errJobTimeout, limit), stderr: strings.TrimSpace(stderr.String())}
+		}
+		if runErr == nil {
+			breaker.record(tool, false)
+			return stdout.Bytes(), nil
+		}
+		err := &toolError{tool: tool, err: runErr, stderr: strings.TrimSpace(stderr.String())}
+
+		if !isTransientToolError(err) || attempt == maxToolAttempts || time.Since(started)+delay > toolRetryBudget {
+			// A bad input still shows the tool works, only outages count towards opening the breaker
+			breaker.record(tool, isToolOutage(err))
+			return stdout.Bytes(), err
+		}
+		logRequest(rid, "%s failed, retrying in %s (attempt %d of %d): %v", tool, delay, attempt, maxToolAttempts, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Wait for one of the -max-ffmpeg-jobs slots, at most -ffmpeg-queue-timeout (0 for as long
+// as it takes). Returns false when none came free in time.
+func takeFFmpegSlot(rid string) bool {
+	select {
+	case ffmpegSlots <- struct{}{}:
+		return true
+	default:
+	}
+	logRequest(rid, "Waiting for one of the %d ffmpeg jobs running to finish", cap(ffmpegSlots))
+	var timeout <-chan time.Time
+	if ffmpegQueueTimeout > 0 {
+		timer := time.NewTimer(ffmpegQueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ffmpegSlots <- struct{}{}:
+		return true
+	case <-timeout:
+		logRequest(rid, "Gave up waiting for an ffmpeg job to finish after %s", ffmpegQueueTimeout)
+		return false
+	}
+}
+
+// Whether a failed run is worth repeating: the process couldn't start for lack of
+// resources, was killed (e.g. by the OOM killer), or reported running out of resources
+func isTransientToolError(err *toolError) bool {
+	if errors.Is(err.err, exec.ErrNotFound) {
+		return false
+	}
+	if errors.Is(err.err, syscall.EAGAIN) || errors.Is(err.err, syscall.ENOMEM) || isFileLimitError(err.err) {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err.err, &exitErr) && exitErr.ExitCode() == -1 {
+		return true
+	}
+
+	for _, marker := range []string{"Resource temporarily unavailable", "Cannot allocate memory", "Too many open files"} {
+		if strings.Contains(err.stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// The filesystem is full or the quota used up, reported by one of our writes or by a tool
+// writing its output. Not worth retrying until someone frees space.
+func isDiskFullError(err error) bool {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return true
+	}
+	var toolErr *toolError
+	return errors.As(err, &toolErr) && (strings.Contains(toolErr.stderr, "No space left on device") || strings.Contains(toolErr.stderr, "Disk quota exceeded"))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunToolRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		failed toolRun
+	}{
+		{"out of resources", toolRun{stderr: "Resource temporarily unavailable", exit: 1}},
+		{"killed", toolRun{exit: -1}},
+	} {
+		logs := captureLog(t)
+		runs := scriptTool(t, "ffmpeg", tt.failed, toolRun{stdout: "done"})
+		out, err := runTool("rid", "ffmpeg", "-version")
+		if err != nil || string(out) != "done" || runs.Load() != 2 {
+			t.Errorf("%s: got %q, %v after %d runs, want done after a retry", tt.name, out, err, runs.Load())
+		}
+		if !strings.Contains(logs.String(), "[rid] ffmpeg failed, retrying in 250ms (attempt 1 of 3)") {
+			t.Errorf("%s: retry not logged:\n%s", tt.name, logs)
+		}
+	}
+}
+
+func TestRunToolGivesUp(t *testing.T) {
+	// A problem with the input doesn't go away by running again
+	runs := scriptTool(t, "ffmpeg", toolRun{stderr: "Unsupported codec id in stream 0", exit: 1})
+	_, err := runTool("rid", "ffmpeg", "-version")
+	if err == nil || !strings.Contains(err.Error(), "Unsupported codec") || runs.Load() != 1 {
+		t.Errorf("permanent failure gave %v after %d runs", err, runs.Load())
+	}
+
+	runs = scriptTool(t, "ffmpeg", toolRun{stderr: "Cannot allocate memory", exit: 1})
+	if _, err := runTool("rid", "ffmpeg", "-version"); err == nil || runs.Load() != maxToolAttempts {
+		t.Errorf("lasting contention gave %v after %d runs, want %d", err, runs.Load(), maxToolAttempts)
+	}
+}
+
+func TestProbeTools(t *testing.T) {
+	available, breakers := availableTools, toolBreakers
+	t.Cleanup(func() { availableTools, toolBreakers = available, breakers })
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	app, cfg := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	// Nothing on PATH
+	logs := captureLog(t)
+	probeTools(cfg)
+	if haveTool("ffmpeg") || haveTool("ffprobe") || !strings.Contains(logs.String(), "ffmpeg is not available") {
+		t.Fatalf("found tools on an empty PATH:\n%s", logs)
+	}
+	for _, target := range []string{"/sprite/a", "/dash/a/manifest.mpd"} {
+		if resp, _ := get(t, app, target); resp.StatusCode != http.StatusNotImplemented {
+			t.Errorf("%s without ffmpeg answered %d", target, resp.StatusCode)
+		}
+	}
+
+	// Only ffmpeg
+	script := "#!/bin/sh\necho 'ffmpeg version 6.1-test Copyright (c) the FFmpeg developers'\necho 'built with gcc'\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	probeTools(cfg)
+	if !haveTool("ffmpeg") || haveTool("ffprobe") || !strings.Contains(logs.String(), "Found ffmpeg version 6.1-test Copyright (c) the FFmpeg developers\n") {
+		t.Fatalf("ffmpeg %t, ffprobe %t with only ffmpeg on PATH:\n%s", haveTool("ffmpeg"), haveTool("ffprobe"), logs)
+	}
+	if resp, _ := get(t, app, "/sprite/a"); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("sprite without ffprobe answered %d", resp.StatusCode)
+	}
+
+	// A tool that can't even report its version is as good as missing
+	if err := os.WriteFile(filepath.Join(bin, "ffprobe"), []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	probeTools(cfg)
+	if !haveTool("ffmpeg") || haveTool("ffprobe") {
+		t.Errorf("a failing ffprobe was taken as available")
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	app, cfg := newTestServer(t, "-job-timeout", "300ms", "-api-token", testToken)
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(slowStartMP4))
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		available := availableTools[tool]
+		availableTools[tool] = true
+		t.Cleanup(func() { availableTools[tool] = available })
+	}
+	breaker := useBreaker(t, 1, time.Hour)
+	scriptTool(t, "ffprobe", toolRun{stdout: `{"streams":[{"width":320,"height":240}],"format":{"duration":"60"}}`})
+	// Writes part of its output, then takes far longer than allowed
+	runs := scriptTool(t, "ffmpeg", toolRun{output: "partial", sleep: time.Minute})
+	logs := captureLog(t)
+
+	started := time.Now()
+	req, _ := http.NewRequest(http.MethodPost, "/api/movies/a/faststart", nil)
+	resp, body := send(t, app, authorized(req))
+	if resp.StatusCode != http.StatusGatewayTimeout || body != "This took longer than the server allows and was stopped." {
+		t.Errorf("remux past -job-timeout answered %d: %s", resp.StatusCode, body)
+	}
+	// Waiting for the process only ends this soon if it was killed
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("remux past -job-timeout took %s", elapsed)
+	}
+	if files, _ := filepath.Glob(filepath.Join("movies", ".faststart-*")); len(files) != 0 {
+		t.Errorf("timed out remux left %v", files)
+	}
+	if content, _ := os.ReadFile(movie); string(content) != slowStartMP4 {
+		t.Error("timed out remux changed the movie")
+	}
+
+	image, _ := spritePaths(cfg, "a")
+	if resp, body := get(t, app, "/sprite/a"); resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("sprite past -job-timeout answered %d: %s", resp.StatusCode, body)
+	}
+	if _, err := os.Stat(filepath.Dir(image)); !os.IsNotExist(err) {
+		t.Errorf("timed out sprite left its directory: %v", err)
+	}
+
+	// Timeouts are never retried and don't open the breaker
+	if runs.Load() != 2 || breaker.currentState() != breakerClosed {
+		t.Errorf("ffmpeg ran %d times for two jobs, breaker state %d", runs.Load(), breaker.currentState())
+	}
+	if !strings.Contains(logs.String(), "ffmpeg ran longer than 300ms, stopped it") {
+		t.Errorf("timeout not logged:\n%s", logs)
+	}
+
+	// Without a limit the same job runs to completion
+	scriptTool(t, "ffmpeg", toolRun{stdout: "done", sleep: 500 * time.Millisecond})
+	if out, err := runTool("rid", "ffmpeg", "-version"); err != nil || string(out) != "done" {
+		t.Errorf("job without a limit gave %q, %v", out, err)
+	}
+}
+
+func TestFFmpegJobsWaitForASlot(t *testing.T) {
+	ffmpegRuns := scriptTool(t, "ffmpeg", toolRun{stdout: "done"})
+	ffprobeRuns := scriptTool(t, "ffprobe", toolRun{stdout: "{}"})
+	slots := ffmpegSlots
+	ffmpegSlots = make(chan struct{}, 1)
+	t.Cleanup(func() { ffmpegSlots = slots })
+	logs := captureLog(t)
+
+	// Another job holds the only slot
+	ffmpegSlots <- struct{}{}
+	finished := make(chan error)
+	go func() {
+		_, err := runTool("rid", "ffmpeg", "-version")
+		finished <- err
+	}()
+
+	if _, err := runTool("rid", "ffprobe", "-version"); err != nil || ffprobeRuns.Load() != 1 {
+		t.Errorf("ffprobe waited for the ffmpeg slot: %v", err)
+	}
+	select {
+	case <-finished:
+		t.Fatal("ffmpeg ran while the slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if ffmpegRuns.Load() != 0 {
+		t.Fatal("ffmpeg started while the slot was taken")
+	}
+
+	<-ffmpegSlots
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ffmpeg still waiting after the slot was freed")
+	}
+	if ffmpegRuns.Load() != 1 || len(ffmpegSlots) != 0 {
+		t.Errorf("ffmpeg ran %d times, %d slots still taken", ffmpegRuns.Load(), len(ffmpegSlots))
+	}
+	if !strings.Contains(logs.String(), "[rid] Waiting for one of the 1 ffmpeg jobs running to finish") {
+		t.Errorf("the wait was not logged:\n%s", logs)
+	}
+	if _, err := loadConfig([]string{"-max-ffmpeg-jobs", "-1"}); err == nil {
+		t.Error("negative -max-ffmpeg-jobs accepted")
+	}
+}
+
+func TestFFmpegQueueTimeout(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(slowStartMP4))
+	available := availableTools["ffmpeg"]
+	availableTools["ffmpeg"] = true
+	t.Cleanup(func() { availableTools["ffmpeg"] = available })
+	runs := scriptTool(t, "ffmpeg", toolRun{stdout: "done"})
+	slots, timeout := ffmpegSlots, ffmpegQueueTimeout
+	ffmpegSlots, ffmpegQueueTimeout = make(chan struct{}, 1), 50*time.Millisecond
+	t.Cleanup(func() { ffmpegSlots, ffmpegQueueTimeout = slots, timeout })
+	logs := captureLog(t)
+
+	// Another job holds the only slot for longer than a job may wait
+	ffmpegSlots <- struct{}{}
+	req, _ := http.NewRequest(http.MethodPost, "/api/movies/a/faststart", nil)
+	resp, body := send(t, app, authorized(req))
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("a job that found no slot answered %d with Retry-After %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if runs.Load() != 0 {
+		t.Errorf("ffmpeg ran %d times without a slot", runs.Load())
+	}
+	if !strings.Contains(logs.String(), "Gave up waiting for an ffmpeg job to finish after 50ms") {
+		t.Errorf("giving up was not logged:\n%s", logs)
+	}
+	if content, _ := os.ReadFile(movie); string(content) != slowStartMP4 {
+		t.Error("a job that never ran changed the movie")
+	}
+	if _, err := loadConfig([]string{"-ffmpeg-queue-timeout", "-1s"}); err == nil {
+		t.Error("negative -ffmpeg-queue-timeout accepted")
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The tus protocol version implemented, the core protocol plus creation and termination
+const tusVersion = "1.0.0"
+
+// IDs handed out by POST /api/uploads, nothing else may name a file
+var tusID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Uploads being appended to, so two PATCHes of one upload can't interleave
+var tusLocks sync.Map
+
+// What is known about an upload in progress, kept next to its data so it survives restarts
+type tusUpload struct {
+	FileName string `json:"fileName"`
+	Length   int64  `json:"length"`
+}
+
+// The upload's data and info files. Both live in the first movie directory so finishing is a
+// rename on one filesystem, and are hidden from the catalog by their leading dot.
+func tusPaths(cfg *Config, id string) (string, string) {
+	base := filepath.Join(cfg.MoviesDirs[0], ".tus-"+id)
+	return base + ".part", base + ".json"
+}
+
+func tusHeaders(c *fiber.Ctx, cfg *Config) {
+	c.Set("Tus-Resumable", tusVersion)
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", "creation,termination")
+	c.Set("Tus-Max-Size", strconv.FormatInt(cfg.MaxUploadSize, 10))
+}
+
+// Load an upload by the ID in the URL, answering 404 for anything unknown
+func loadTusUpload(c *fiber.Ctx, cfg *Config) (string, *tusUpload, error) {
+	id := c.Params("id")
+	if !tusID.MatchString(id) {
+		return "", nil, c.Status(fiber.StatusNotFound).SendString("Upload not found.")
+	}
+	_, infoPath := tusPaths(cfg, id)
+	content, err := os.ReadFile(infoPath)
+	if err != nil {
+		return "", nil, c.Status(fiber.StatusNotFound).SendString("Upload not found.")
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(content, &upload); err != nil {
+		return "", nil, c.Status(fiber.StatusInternalServerError).SendString("Could not read upload.")
+	}
+	return id, &upload, nil
+}
+
+// The file name from Upload-Metadata, a comma-separated list of "key base64(value)"
+func tusFileName(metadata string) string {
+	for _, pair := range strings.Split(metadata, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key != "filename" {
+			continue
+		}
+		if name, err := base64.StdEncoding.DecodeString(value); err == nil {
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// Check that an upload may become the given movie file, answering the request when not
+func checkTusTarget(c *fiber.Ctx, cfg *Config, fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	movieName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	switch {
+	case !cfg.servesFormat(ext):
+		c.Status(fiber.StatusUnsupportedMediaType).SendString("Unsupported file format.")
+	case !validMovieName(movieName):
+		c.Status(fiber.StatusBadRequest).SendString("Invalid movie name.")
+	default:
+		if _, taken := findMovie(cfg, movieName); taken {
+			c.Status(fiber.StatusConflict).SendString("A movie with that name already exists.")
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// Start an upload: Upload-Length gives the size, Upload-Metadata the file name
+func tusCreateHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		tusHeaders(c, cfg)
+
+		length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			return c.Status(fiber.StatusBadRequest).SendString("Expected the size of the file in Upload-Length.")
+		}
+		if length > cfg.MaxUploadSize {
+			return c.Status(fiber.StatusRequestEntityTooLarge).SendString("Upload is larger than the server allows.")
+		}
+		fileName := tusFileName(c.Get("Upload-Metadata"))
+		if fileName == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("Expected the file name as filename in Upload-Metadata.")
+		}
+		if !checkTusTarget(c, cfg, fileName) {
+			return nil
+		}
+
+		random := make([]byte, 16)
+		rand.Read(random)
+		id := hex.EncodeToString(random)
+		dataPath, infoPath := tusPaths(cfg, id)
+		info, _ := json.Marshal(tusUpload{FileName: fileName, Length: length})
+		if err := os.WriteFile(dataPath, nil, 0o644); err != nil {
+			logRequest(rid, "Could not create upload file: %v", err)
+			return uploadFailure(c, err)
+		}
+		if err := os.WriteFile(infoPath, info, 0o644); err != nil {
+			os.Remove(dataPath)
+			os.Remove(infoPath)
+			logRequest(rid, "Could not create upload file: %v", err)
+			return uploadFailure(c, err)
+		}
+		logRequest(rid, "Started upload %s of %s (%d bytes)", id, fileName, length)
+
+		// An empty file is complete right away
+		if length == 0 {
+			if !finishTusUpload(c, cfg, rid, id, fileName) {
+				return nil
+			}
+		}
+		c.Location("/api/uploads/" + id)
+		return c.SendStatus(fiber.StatusCreated)
+	}
+}
+
+// How far an upload got, for resuming after an interruption
+func tusOffsetHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tusHeaders(c, cfg)
+		id, upload, err := loadTusUpload(c, cfg)
+		if upload == nil {
+			return err
+		}
+		dataPath, _ := tusPaths(cfg, id)
+		info, err := os.Stat(dataPath)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString("Upload not found.")
+		}
+		c.Set("Upload-Offset", strconv.FormatInt(info.Size(), 10))
+		c.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.SendStatus(fiber.StatusOK)
+	}
+}
+
+// Append a chunk at Upload-Offset, which has to be where the upload stands. Whatever arrives
+// before a connection breaks is kept, so the client resumes from the offset HEAD reports.
+func tusAppendHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		tusHeaders(c, cfg)
+		if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+			return c.Status(fiber.StatusUnsupportedMediaType).SendString("Expected Content-Type: application/offset+octet-stream.")
+		}
+		id, upload, err := loadTusUpload(c, cfg)
+		if upload == nil {
+			return err
+		}
+		offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Expected Upload-Offset.")
+		}
+
+		unlock := lockKey(&tusLocks, id)
+		defer unlock()
+
+		dataPath, _ := tusPaths(cfg, id)
+		file, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).SendString("Upload not found.")
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not store upload.")
+		}
+		if info.Size() != offset {
+			file.Close()
+			c.Set("Upload-Offset", strconv.FormatInt(info.Size(), 10))
+			return c.Status(fiber.StatusConflict).SendString("Upload-Offset doesn't match the upload, ask for it again with HEAD.")
+		}
+
+		body := requestBody(c)
+		// Read one byte past the declared length to notice a client sending too much
+		written, err := io.Copy(file, io.LimitReader(body, upload.Length-offset+1))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		offset += written
+		if offset > upload.Length {
+			os.Truncate(dataPath, upload.Length)
+			return c.Status(fiber.StatusRequestEntityTooLarge).SendString("More data than Upload-Length.")
+		}
+		if err != nil {
+			logRequest(rid, "Upload %s interrupted at %d of %d bytes: %v", id, offset, upload.Length, err)
+			c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+			return uploadFailure(c, err)
+		}
+
+		if offset == upload.Length {
+			if !finishTusUpload(c, cfg, rid, id, upload.FileName) {
+				return nil
+			}
+		}
+		c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// Move a complete upload into the library. Answers the request and returns false when that
+// fails; the data is kept until the upload is deleted.
+func finishTusUpload(c *fiber.Ctx, cfg *Config, rid, id, fileName string) bool {
+	dataPath, infoPath := tusPaths(cfg, id)
+	movieName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	unlock := lockKey(&uploadLocks, strings.ToLower(movieName))
+	defer unlock()
+	// Another upload or a copy by hand may have taken the name meanwhile, and a reload may
+	// have dropped the format
+	if !checkTusTarget(c, cfg, fileName) {
+		return false
+	}
+	movieFilePath := uploadFilePath(cfg, fileName)
+	err := placeUpload(dataPath, movieFilePath)
+	if errors.Is(err, fs.ErrExist) {
+		c.Status(fiber.StatusConflict).SendString("A movie with that name already exists.")
+		return false
+	}
+	if err != nil {
+		logRequest(rid, "Could not move upload %s into place as %s: %v", id, movieFilePath, err)
+		c.Status(fiber.StatusInternalServerError).SendString("Could not store upload.")
+		return false
+	}
+	os.Remove(infoPath)
+	logRequest(rid, "Uploaded %s through upload %s", movieFilePath, id)
+	catalog.expire()
+	return true
+}
+
+// Give up on an upload and delete what was sent so far
+func tusDeleteHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tusHeaders(c, cfg)
+		id, upload, err := loadTusUpload(c, cfg)
+		if upload == nil {
+			return err
+		}
+		unlock := lockKey(&tusLocks, id)
+		defer unlock()
+		dataPath, infoPath := tusPaths(cfg, id)
+		os.Remove(dataPath)
+		os.Remove(infoPath)
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
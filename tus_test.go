@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Start an upload of the named file, returning the response and the upload's URL
+func tusCreate(t *testing.T, app *fiber.App, fileName string, length int) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, "/api/uploads", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(fileName)))
+	resp, _ := send(t, app, authorized(req))
+	return resp, resp.Header.Get("Location")
+}
+
+func tusPatch(t *testing.T, app *fiber.App, location string, offset int, chunk []byte) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPatch, location, bytes.NewReader(chunk))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	resp, _ := send(t, app, authorized(req))
+	return resp
+}
+
+func tusOffset(t *testing.T, app *fiber.App, location string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodHead, location, nil)
+	resp, _ := send(t, app, authorized(req))
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, ""
+	}
+	return resp.StatusCode, resp.Header.Get("Upload-Offset")
+}
+
+func TestTusUpload(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.ShutdownWithTimeout(time.Second) })
+
+	movie := bytes.Repeat([]byte("0123456789"), 300000)
+	resp, location := tusCreate(t, app, "big.mp4", len(movie))
+	if resp.StatusCode != http.StatusCreated || !strings.HasPrefix(location, "/api/uploads/") {
+		t.Fatalf("create answered %d at %q", resp.StatusCode, location)
+	}
+	if got := resp.Header.Get("Tus-Resumable"); got != tusVersion {
+		t.Errorf("Tus-Resumable is %q", got)
+	}
+
+	// The connection breaks after 1.2 of the 3 MB
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "PATCH %s HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer %s\r\nTus-Resumable: %s\r\n"+
+		"Content-Type: application/offset+octet-stream\r\nUpload-Offset: 0\r\nContent-Length: %d\r\n\r\n",
+		location, testToken, tusVersion, len(movie))
+	conn.Write(movie[:1200000])
+	conn.Close()
+
+	// What arrived is kept and HEAD says where to go on
+	offset := ""
+	for deadline := time.Now().Add(5 * time.Second); offset != "1200000" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		_, offset = tusOffset(t, app, location)
+	}
+	if offset != "1200000" {
+		t.Fatalf("offset after the interruption is %q", offset)
+	}
+	if _, err := os.Stat(filepath.Join("movies", "big.mp4")); err == nil {
+		t.Error("an incomplete upload is in the library")
+	}
+
+	// Resuming anywhere else is a conflict
+	resp = tusPatch(t, app, location, 0, movie[:10])
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("Upload-Offset") != "1200000" {
+		t.Errorf("PATCH at the wrong offset answered %d at %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+
+	resp = tusPatch(t, app, location, 1200000, movie[1200000:])
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != strconv.Itoa(len(movie)) {
+		t.Fatalf("resumed PATCH answered %d at %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	content, err := os.ReadFile(filepath.Join("movies", "big.mp4"))
+	if err != nil || !bytes.Equal(content, movie) {
+		t.Errorf("finished upload has %d bytes: %v", len(content), err)
+	}
+	if files, _ := filepath.Glob(filepath.Join("movies", ".tus-*")); len(files) != 0 {
+		t.Errorf("upload left %v", files)
+	}
+	if status, _ := tusOffset(t, app, location); status != http.StatusNotFound {
+		t.Errorf("HEAD of a finished upload answered %d", status)
+	}
+}
+
+func TestTusUploadChecks(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken, "-max-upload-size", "1000")
+	writeFile(t, filepath.Join("movies", "taken.mp4"), []byte("m"))
+
+	for _, tt := range []struct {
+		name, file string
+		length     int
+		status     int
+	}{
+		{"too large", "a.mp4", 1001, http.StatusRequestEntityTooLarge},
+		{"unsupported format", "a.txt", 10, http.StatusUnsupportedMediaType},
+		{"invalid name", "../a.mp4", 10, http.StatusBadRequest},
+		{"existing movie", "taken.mkv", 10, http.StatusConflict},
+		{"no file name", "", 10, http.StatusBadRequest},
+		{"negative length", "a.mp4", -1, http.StatusBadRequest},
+	} {
+		if resp, _ := tusCreate(t, app, tt.file, tt.length); resp.StatusCode != tt.status {
+			t.Errorf("%s: answered %d", tt.name, resp.StatusCode)
+		}
+	}
+
+	// An empty file is done once created
+	if resp, _ := tusCreate(t, app, "empty.mp4", 0); resp.StatusCode != http.StatusCreated {
+		t.Errorf("empty upload answered %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join("movies", "empty.mp4")); err != nil {
+		t.Errorf("empty upload: %v", err)
+	}
+
+	_, location := tusCreate(t, app, "b.mp4", 10)
+	req, _ := http.NewRequest(http.MethodPatch, location, strings.NewReader("0123456789"))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Upload-Offset", "0")
+	if resp, _ := send(t, app, authorized(req)); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("PATCH with the wrong type answered %d", resp.StatusCode)
+	}
+	if resp := tusPatch(t, app, location, 0, []byte("0123456789extra")); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("PATCH past Upload-Length answered %d", resp.StatusCode)
+	}
+	if status, offset := tusOffset(t, app, location); status != http.StatusOK || offset != "10" {
+		t.Errorf("HEAD after too much data answered %d at %q", status, offset)
+	}
+	for _, unknown := range []string{"/api/uploads/0123456789abcdef0123456789abcdef", "/api/uploads/..%2fmovies"} {
+		if resp := tusPatch(t, app, unknown, 0, []byte("m")); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("PATCH of %s answered %d", unknown, resp.StatusCode)
+		}
+	}
+
+	// Deleting drops what was sent
+	_, location = tusCreate(t, app, "c.mp4", 10)
+	tusPatch(t, app, location, 0, []byte("01234"))
+	req, _ = http.NewRequest(http.MethodDelete, location, nil)
+	if resp, _ := send(t, app, authorized(req)); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE answered %d", resp.StatusCode)
+	}
+	if status, _ := tusOffset(t, app, location); status != http.StatusNotFound {
+		t.Errorf("HEAD of a deleted upload answered %d", status)
+	}
+	if files, _ := filepath.Glob(filepath.Join("movies", ".tus-*")); len(files) != 2 {
+		t.Errorf("only the unfinished b.mp4 should be left, found %v", files)
+	}
+}
+
+func TestTusUploadDiskFull(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	_, location := tusCreate(t, app, "a.mp4", 10)
+
+	// Every write to /dev/full fails with ENOSPC
+	data := filepath.Join("movies", ".tus-"+strings.TrimPrefix(location, "/api/uploads/")+".part")
+	os.Remove(data)
+	if err := os.Symlink("/dev/full", data); err != nil {
+		t.Skip(err)
+	}
+	resp := tusPatch(t, app, location, 0, []byte("0123456789"))
+	if resp.StatusCode != http.StatusInsufficientStorage || resp.Header.Get("Upload-Offset") != "0" {
+		t.Errorf("PATCH on a full disk answered %d at %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if _, err := os.Stat(filepath.Join("movies", "a.mp4")); err == nil {
+		t.Error("a failed upload is in the library")
+	}
+}
+
+func TestTusUploadLowercasesExtension(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	_, location := tusCreate(t, app, "Movie.MP4", 5)
+	if resp := tusPatch(t, app, location, 0, []byte("movie")); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PATCH answered %d", resp.StatusCode)
+	}
+
+	// Stored the way plain uploads are, so the movie can be found
+	if _, err := os.Stat(filepath.Join("movies", "Movie.mp4")); err != nil {
+		t.Errorf("upload not stored as Movie.mp4: %v", err)
+	}
+	if resp, body := get(t, app, "/video/Movie"); resp.StatusCode != http.StatusOK || body != "movie" {
+		t.Errorf("uploaded movie answered %d: %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Check an upload to /api/upload/[file] by what its headers say, answering the status and
+// message to refuse it with, or 0. Also run before the body is accepted, see continueUpload.
+func checkUpload(cfg *Config, fileName string, contentLength int) (int, string) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	movieName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	if !cfg.servesFormat(ext) {
+		return fiber.StatusUnsupportedMediaType, "Unsupported file format."
+	}
+	if !validMovieName(movieName) {
+		return fiber.StatusBadRequest, "Invalid movie name."
+	}
+	if _, taken := findMovie(cfg, movieName); taken {
+		return fiber.StatusConflict, "A movie with that name already exists."
+	}
+	// Request bodies are streamed, so fasthttp leaves enforcing the limit to us
+	if contentLength > 0 && int64(contentLength) > cfg.MaxUploadSize {
+		return fiber.StatusRequestEntityTooLarge, "Upload is larger than the server allows."
+	}
+	return 0, ""
+}
+
+// The movie file an upload is stored as, its extension lowercased like findMovie looks for it
+func uploadFilePath(cfg *Config, fileName string) string {
+	ext := filepath.Ext(fileName)
+	return filepath.Join(cfg.MoviesDirs[0], strings.TrimSuffix(fileName, ext)+strings.ToLower(ext))
+}
+
+// Uploads by movie name, locked while an upload is checked against the library and moved
+// into place, so two finishing at once can't both take the name
+var uploadLocks sync.Map
+
+// Move a finished upload into place. A hard link fails with fs.ErrExist when the name was
+// taken since the upload was checked, where a rename would replace that file. Filesystems
+// without hard links, like exFAT, fall back to the rename, uploadLocks still keeps uploads
+// from replacing each other there.
+func placeUpload(tmpPath, movieFilePath string) error {
+	err := os.Link(tmpPath, movieFilePath)
+	if err == nil {
+		return os.Remove(tmpPath)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return os.Rename(tmpPath, movieFilePath)
+}
+
+// Answer an upload that could not be written, with 507 when the disk is full so clients
+// know retrying won't help until space is freed
+func uploadFailure(c *fiber.Ctx, err error) error {
+	if isDiskFullError(err) {
+		return c.Status(fiber.StatusInsufficientStorage).SendString("Not enough disk space to store the upload.")
+	}
+	return c.Status(fiber.StatusInternalServerError).SendString("Could not store upload.")
+}
+
+// Upload a movie by sending the file as the raw request body to /api/upload/Name.mp4.
+// The body is streamed straight to disk, never held in memory.
+func uploadHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rid := requestID(c)
+		fileName := c.Params("file")
+		movieName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+
+		if status, message := checkUpload(cfg, fileName, c.Request().Header.ContentLength()); status != 0 {
+			// The body isn't read, so the connection can't carry another request
+			c.Context().SetConnectionClose()
+			return c.Status(status).SendString(message)
+		}
+		body := requestBody(c)
+
+		// Write next to the movies so the final rename stays on one filesystem
+		tmp, err := os.CreateTemp(cfg.MoviesDirs[0], ".upload-*.tmp")
+		if err != nil {
+			logRequest(rid, "Could not create upload file: %v", err)
+			return uploadFailure(c, err)
+		}
+		defer os.Remove(tmp.Name())
+
+		// Read one byte past the limit to notice chunked bodies that are too large
+		written, err := io.Copy(tmp, io.LimitReader(body, cfg.MaxUploadSize+1))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			logRequest(rid, "Upload of %s failed after %d bytes: %v", fileName, written, err)
+			return uploadFailure(c, err)
+		}
+		if written > cfg.MaxUploadSize {
+			c.Context().SetConnectionClose()
+			return c.Status(fiber.StatusRequestEntityTooLarge).SendString("Upload is larger than the server allows.")
+		}
+
+		// CreateTemp makes the file private, movies should be readable like the rest of the library
+		os.Chmod(tmp.Name(), 0o644)
+
+		// Another upload or a copy by hand may have taken the name while this one was sent
+		movieFilePath := uploadFilePath(cfg, fileName)
+		unlock := lockKey(&uploadLocks, strings.ToLower(movieName))
+		if _, taken := findMovie(cfg, movieName); taken {
+			err = fs.ErrExist
+		} else {
+			err = placeUpload(tmp.Name(), movieFilePath)
+		}
+		unlock()
+		if errors.Is(err, fs.ErrExist) {
+			return c.Status(fiber.StatusConflict).SendString("A movie with that name already exists.")
+		}
+		if err != nil {
+			logRequest(rid, "Could not move upload into place as %s: %v", movieFilePath, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not store upload.")
+		}
+		logRequest(rid, "Uploaded %s (%d bytes)", movieFilePath, written)
+		catalog.expire()
+
+		entry, err := movieEntry(cfg, movieName, movieFilePath)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not get file info.")
+		}
+		return c.Status(fiber.StatusCreated).JSON(entry)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestUploadsOfOneNameAtOnce(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+
+	// Some with another extension, which takes the movie's name just the same
+	const uploads = 8
+	statuses := make([]int, uploads)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file := []string{"a.mp4", "a.mkv"}[i%2]
+			req, _ := http.NewRequest(http.MethodPut, "/api/upload/"+file, strings.NewReader(fmt.Sprintf("upload %d", i)))
+			resp, _ := send(t, app, authorized(req))
+			statuses[i] = resp.StatusCode
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, status := range statuses {
+		switch {
+		case status == http.StatusCreated && winner == -1:
+			winner = i
+		case status != http.StatusConflict:
+			t.Errorf("upload %d answered %d, only one of them should be stored", i, status)
+		}
+	}
+	if winner == -1 {
+		t.Fatalf("no upload was stored: %v", statuses)
+	}
+	files, _ := filepath.Glob(filepath.Join("movies", "*"))
+	if len(files) != 1 {
+		t.Fatalf("uploads left %v", files)
+	}
+	if content, _ := os.ReadFile(files[0]); string(content) != fmt.Sprintf("upload %d", winner) {
+		t.Errorf("%s holds %q, want upload %d", files[0], content, winner)
+	}
+}
+
+func TestTusUploadKeepsMovieTakenMeanwhile(t *testing.T) {
+	app, _ := newTestServer(t, "-api-token", testToken)
+	_, location := tusCreate(t, app, "a.mp4", 5)
+
+	// A plain upload takes the name before the last byte arrives
+	req, _ := http.NewRequest(http.MethodPut, "/api/upload/a.mkv", strings.NewReader("plain"))
+	if resp, body := send(t, app, authorized(req)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("plain upload answered %d: %s", resp.StatusCode, body)
+	}
+	if resp := tusPatch(t, app, location, 0, []byte("tus!!")); resp.StatusCode != http.StatusConflict {
+		t.Errorf("finishing a tus upload of a taken name answered %d", resp.StatusCode)
+	}
+	if content, _ := os.ReadFile(filepath.Join("movies", "a.mkv")); string(content) != "plain" {
+		t.Errorf("a.mkv holds %q", content)
+	}
+	if _, err := os.Stat(filepath.Join("movies", "a.mp4")); !os.IsNotExist(err) {
+		t.Errorf("the tus upload was stored next to the movie: %v", err)
+	}
+}
+
+func TestPlaceUploadKeepsExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	tmp, movie := filepath.Join(dir, ".upload.tmp"), filepath.Join(dir, "a.mp4")
+	writeFile(t, tmp, []byte("new"))
+	writeFile(t, movie, []byte("old"))
+
+	if err := placeUpload(tmp, movie); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("placing over an existing movie answered %v", err)
+	}
+	if content, _ := os.ReadFile(movie); string(content) != "old" {
+		t.Errorf("existing movie replaced by %q", content)
+	}
+	if err := placeUpload(tmp, filepath.Join(dir, "b.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("upload file left after placing it: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// State kept per movie across restarts, saved in -data-dir
+type userData struct {
+	favorites *JSONStore[movieSet]
+	watched   *JSONStore[movieSet]
+	progress  *JSONStore[progressSet]
+
+	// Heartbeat positions not written to progress yet
+	heartbeats *progressBuffer
+}
+
+func openUserData(cfg *Config) (*userData, error) {
+	var data userData
+	var err error
+	if data.favorites, err = openJSONStore[movieSet](filepath.Join(cfg.DataDir, "favorites.json")); err != nil {
+		return nil, fmt.Errorf("favorites: %w", err)
+	}
+	if data.watched, err = openJSONStore[movieSet](filepath.Join(cfg.DataDir, "watched.json")); err != nil {
+		return nil, fmt.Errorf("watched movies: %w", err)
+	}
+	if data.progress, err = openJSONStore[progressSet](filepath.Join(cfg.DataDir, "progress.json")); err != nil {
+		return nil, fmt.Errorf("playback progress: %w", err)
+	}
+	data.heartbeats = newProgressBuffer(cfg.ProgressWriteInterval)
+	return &data, nil
+}
+
+// Carry everything stored about a movie over to its new name
+func (data *userData) rename(oldName, newName string) error {
+	if err := renameInMovieSet(data.favorites, oldName, newName); err != nil {
+		return err
+	}
+	if err := renameInMovieSet(data.watched, oldName, newName); err != nil {
+		return err
+	}
+	data.heartbeats.rename(oldName, newName)
+	return data.progress.Update(func(progress *progressSet) error {
+		if p, ok := (*progress)[oldName]; ok {
+			delete(*progress, oldName)
+			(*progress)[newName] = p
+		}
+		return nil
+	})
+}
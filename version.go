@@ -0,0 +1,18 @@
+package main
+
+import "github.com/gofiber/fiber/v2"
+
+// Build information, set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildDate = "dev"
+)
+
+func versionHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"version":   version,
+		"commit":    commit,
+		"buildDate": buildDate,
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	app, _ := newTestServer(t)
+	want := map[string]string{"version": "dev", "commit": "dev", "buildDate": "dev"}
+	check := func() {
+		t.Helper()
+		resp, body := get(t, app, "/api/version")
+		var got map[string]string
+		if err := json.Unmarshal([]byte(body), &got); err != nil || resp.StatusCode != 200 || len(got) != len(want) {
+			t.Fatalf("version answered %d: %s", resp.StatusCode, body)
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Errorf("%s is %q, want %q", key, got[key], value)
+			}
+		}
+	}
+	check()
+
+	// What -ldflags -X sets at build time
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "1.2.3", "abc1234", "2024-05-01T12:00:00Z"
+	want = map[string]string{"version": "1.2.3", "commit": "abc1234", "buildDate": "2024-05-01T12:00:00Z"}
+	check()
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Route for serving the video file with range support
+func videoHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		received := time.Now()
+		rid := requestID(c)
+		tunables := cfg.Tunables()
+		movieName := c.Params("movie")
+
+		// Locate file path for video file, ?format= picks a variant other than the preferred one
+		movieFilePath, found := findMovie(cfg, movieName)
+		if format := strings.ToLower(c.Query("format")); format != "" {
+			if !cfg.servesFormat(format) {
+				return c.Status(fiber.StatusBadRequest).SendString("Unknown format, expected one of: " + strings.Join(tunables.Formats, ", ") + ".")
+			}
+			if movieFilePath, found = findMovieIn(cfg, movieName, []string{format}); !found {
+				return c.Status(fiber.StatusNotFound).SendString("Movie not found in that format.")
+			}
+		}
+		if !found {
+			return movieNotFound(c, cfg, movieName)
+		}
+
+		// Behind nginx with -accel-redirect, nginx sends the bytes and handles ranges itself
+		if cfg.AccelRedirect != "" {
+			return accelRedirect(c, cfg, movieFilePath)
+		}
+
+		// Every stream holds a file descriptor, so refuse new ones before the process runs out
+		if !acquireStream(cfg) {
+			c.Set(fiber.HeaderRetryAfter, "5")
+			return c.Status(fiber.StatusServiceUnavailable).SendString("Too many active streams, try again shortly.")
+		}
+
+		file, err := os.Open(movieFilePath)
+		if err != nil {
+			releaseStream()
+			if isFileLimitError(err) {
+				logRequest(rid, "Out of file descriptors opening %s, consider raising the open file limit (ulimit -n): %v", movieFilePath, err)
+				c.Set(fiber.HeaderRetryAfter, "5")
+				return c.Status(fiber.StatusServiceUnavailable).SendString("Server is busy, try again shortly.")
+			}
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not open video file.")
+		}
+		// The streaming path hands the file over to the stream writer, which closes it when done
+		streaming := false
+		defer func() {
+			if !streaming {
+				file.Close()
+				releaseStream()
+			}
+		}()
+
+		// Get file size
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not get file info.")
+		}
+		fileSize := fileInfo.Size()
+
+		// A recording or download in progress may not have reached the range yet, give it
+		// a moment. Its size so far isn't its final one, so ranges then leave the total open.
+		growing := cfg.GrowingWait > 0 && time.Since(fileInfo.ModTime()) < cfg.GrowingWait
+		if start, ok := rangeStart(c.Get(fiber.HeaderRange)); ok && cfg.GrowingWait > 0 && start >= fileSize && start < math.MaxInt64 {
+			if grown := waitForGrowth(file, start+1, cfg.GrowingWait); grown > fileSize {
+				fileSize, growing = grown, true
+			}
+		}
+
+		// An empty file can never satisfy a range, so say so instead of failing the range checks
+		if fileSize == 0 {
+			logRequest(rid, "Movie file %s is empty (0 bytes)", movieFilePath)
+			return c.Status(fiber.StatusUnprocessableEntity).SendString("Movie file is empty.")
+		}
+
+		// The type is only set once the file is sent, error responses are plain text
+		ext := strings.ToLower(filepath.Ext(movieFilePath))
+		contentType := contentTypes[ext]
+		c.Set("Accept-Ranges", "bytes")
+
+		// The full size on every response, so clients can show progress even for a partial body
+		c.Set("X-Total-Size", strconv.FormatInt(fileSize, 10))
+
+		// Download managers resume with If-Range against these, a changed file is sent in full
+		modTime := fileInfo.ModTime()
+		etag := videoETag(fileInfo)
+		c.Set(fiber.HeaderLastModified, modTime.UTC().Format(http.TimeFormat))
+		c.Set(fiber.HeaderETag, etag)
+		if c.Get(fiber.HeaderRange) != "" && !ifRangeMatches(c.Get(fiber.HeaderIfRange), modTime, etag) {
+			c.Request().Header.Del(fiber.HeaderRange)
+		}
+
+		// Some containers play better when each range is answered in full, SendFile does that
+		if cfg.NativeRangeFormats[strings.TrimPrefix(ext, ".")] {
+			return sendVideoFile(c, movieName, movieFilePath, contentType)
+		}
+
+		// Handle range requests
+		rangeHeader := c.Get("Range")
+		if rangeHeader == "" && useSendFile(cfg, fileSize) {
+			// The kernel copies the file straight to the socket, Content-Length is set by SendFile
+			return sendVideoFile(c, movieName, movieFilePath, contentType)
+		}
+
+		// The window depends on Save-Data, caches must not serve one client's to the other
+		c.Vary("Save-Data")
+
+		// Without a range the whole file goes through the same loop as a range would
+		start, end, window := int64(0), fileSize-1, fileSize
+		if rangeHeader != "" {
+			// Parse the range header (e.g., bytes=0-1048575)
+			rangeParts := strings.Split(rangeHeader, "=")
+			// Check if the first value is 'bytes', and the second value is a valid range
+			if len(rangeParts) != 2 || rangeParts[0] != "bytes" {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid Range header.")
+			}
+
+			rangeValues := strings.Split(rangeParts[1], "-")
+			// Now check if the first value is defined, and if the second is empty then set it to a large value which should correspond to the start and the file.
+			if rangeValues[0] == "" {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid Range header.")
+			}
+			start, err = strconv.ParseInt(rangeValues[0], 10, 64)
+			// A start too large for int64 is still a number, just past the end of any file
+			if errors.Is(err, strconv.ErrRange) && start > 0 {
+				err = nil
+			}
+			if err != nil || start < 0 {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid start byte in Range header.")
+			}
+			// Checked before any arithmetic, a crafted start near the int64 limit would overflow
+			if start >= fileSize {
+				c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", fileSize))
+				return c.Status(fiber.StatusRequestedRangeNotSatisfiable).SendString("Range starts past the end of the file.")
+			}
+
+			// The first request of a playback gets its own window so the metadata arrives quickly
+			window = tunables.PrefetchBytes
+			if start == 0 && tunables.StartWindow > 0 {
+				window = tunables.StartWindow
+			}
+			// Clients on metered connections ask for less, they fetch the rest as they play it
+			if saveData(c) && tunables.SaveDataBytes > 0 {
+				window = min(window, tunables.SaveDataBytes)
+			}
+			// The range ends with the window or the file, whichever comes first. Both terms are
+			// at most fileSize, so the sum can't overflow.
+			end = start + min(window, fileSize-start) - 1
+
+			// Set headers for partial content
+			c.Status(fiber.StatusPartialContent)
+			if growing {
+				c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end))
+			} else {
+				c.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
+			}
+		}
+
+		// Calculate the length of the data to be sent
+		length := end - start + 1
+		if contentType != "" {
+			c.Set("Content-Type", contentType)
+		}
+
+		// HEAD gets the headers a GET would, without opening a stream nobody reads
+		if c.Method() == fiber.MethodHead {
+			c.Response().Header.SetContentLength(int(length))
+			return nil
+		}
+
+		// The start of the file may be in memory with -header-cache-bytes, the file is only
+		// read past it. A first request for the movie reads it in for the next ones.
+		var cached []byte
+		if start < cfg.HeaderCacheBytes {
+			if header := headers.load(cfg, movieFilePath, fileInfo); start < int64(len(header)) {
+				cached = header[start:min(int64(len(header)), start+length)]
+			}
+		}
+
+		// Every request has its own file handle and buffer, so concurrent ranges of one file can't interfere
+		if _, err := file.Seek(start+int64(len(cached)), io.SeekStart); err != nil {
+			logRequest(rid, "Could not seek to byte %d of %s: %v", start, movieFilePath, err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not read video file.")
+		}
+
+		// Stream the requested bytes straight to the connection
+		streaming = true
+		stream := &activeStream{
+			requestID: rid,
+			movie:     movieName,
+			clientIP:  clientLabel(cfg, clientIP(cfg, c)),
+			start:     start,
+			end:       end,
+			started:   received,
+			conn:      c.Context().Conn(),
+		}
+		trackStream(stream)
+		releaseClient := holdClientStream(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer releaseClient()
+			defer releaseStream()
+			defer file.Close()
+			defer untrackStream(stream)
+
+			buffer := getReadBuffer(cfg)
+			defer putReadBuffer(buffer)
+			bytesSent := int64(0)
+
+			send := func(chunk []byte) bool {
+				if _, err := w.Write(chunk); err != nil {
+					logRequest(rid, "Failed to send video content: %v", err)
+					return false
+				}
+				if err := w.Flush(); err != nil {
+					logRequest(rid, "Failed to send video content: %v", err)
+					return false
+				}
+
+				if bytesSent == 0 {
+					ttfb := time.Since(received)
+					streamStartSeconds.Observe(ttfb.Seconds())
+					from := ""
+					if len(cached) > 0 {
+						from = ", from the header cache"
+					}
+					logRequest(rid, "Stream start for %s at byte %d: first byte after %s (window %d bytes%s)", movieName, start, ttfb, window, from)
+				}
+				bytesSent += int64(len(chunk))
+				stream.wrote(len(chunk))
+				countBytesServed(movieName, int64(len(chunk)))
+				return true
+			}
+			if len(cached) > 0 && !send(cached) {
+				return
+			}
+
+			for bytesSent < length {
+				remaining := length - bytesSent
+				readSize := int64(len(buffer))
+				if remaining < readSize {
+					readSize = remaining
+				}
+
+				// A read can return data together with io.EOF, so send what was read first
+				n, err := file.Read(buffer[:readSize])
+				if n > 0 && !send(buffer[:n]) {
+					return
+				}
+
+				if errors.Is(err, io.EOF) {
+					// Only reached early when the file shrank while being streamed
+					if bytesSent < length {
+						logRequest(rid, "%s ended after %d of %d bytes", movieFilePath, bytesSent, length)
+					}
+					return
+				}
+				if err != nil {
+					logRequest(rid, "Error reading file: %v", err)
+					return
+				}
+			}
+		})
+		// Setting the length after the stream writer keeps the response fixed-size instead of chunked
+		c.Response().Header.SetContentLength(int(length))
+
+		return nil
+	}
+}
+
+// Send the whole file or a native range with SendFile. The bytes are written after the
+// handler returns, so they are counted as served up front.
+func sendVideoFile(c *fiber.Ctx, movieName, movieFilePath, contentType string) error {
+	err := sendFileAs(c, movieFilePath, contentType)
+	if c.Method() != fiber.MethodHead && c.Response().StatusCode() < fiber.StatusMultipleChoices {
+		countBytesServed(movieName, int64(c.Response().Header.ContentLength()))
+	}
+	return err
+}
+
+// Read buffers of finished streams, so many concurrent streams don't each allocate one
+var readBuffers sync.Pool
+
+// A -read-buffer-bytes buffer for the streaming loop, hand it back with putReadBuffer
+func getReadBuffer(cfg *Config) []byte {
+	if buffer, ok := readBuffers.Get().(*[]byte); ok && len(*buffer) == cfg.ReadBufferBytes {
+		return *buffer
+	}
+	return make([]byte, cfg.ReadBufferBytes)
+}
+
+func putReadBuffer(buffer []byte) {
+	readBuffers.Put(&buffer)
+}
+
+// How often waitForGrowth looks at the file
+const growthPollInterval = 250 * time.Millisecond
+
+// The first byte a "bytes=start-" Range asks for, without validating the rest of it
+func rangeStart(rangeHeader string) (int64, bool) {
+	spec, ok := strings.CutPrefix(rangeHeader, "bytes=")
+	if !ok {
+		return 0, false
+	}
+	first, _, _ := strings.Cut(spec, "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// Wait until the file is at least size bytes or the wait is over, returning its size then
+func waitForGrowth(file *os.File, size int64, wait time.Duration) int64 {
+	deadline := time.Now().Add(wait)
+	for {
+		info, err := file.Stat()
+		if err != nil {
+			return 0
+		}
+		if info.Size() >= size || time.Now().After(deadline) {
+			return info.Size()
+		}
+		time.Sleep(growthPollInterval)
+	}
+}
+
+// Whether the client sent the Save-Data hint, e.g. a phone in data saver mode
+func saveData(c *fiber.Ctx) bool {
+	return strings.EqualFold(strings.TrimSpace(c.Get("Save-Data")), "on")
+}
+
+// Whether the Range of a request still applies under its If-Range, which holds either the
+// Last-Modified date or the ETag of the file; without If-Range the range always applies.
+func ifRangeMatches(ifRange string, modTime time.Time, etag string) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, "W/") || strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && date.Equal(modTime.Truncate(time.Second))
+}
+
+// The ETag of a video, made from its size and modification time. It's weak since the
+// content isn't hashed, but it changes whenever the date If-Range is checked against does,
+// and with any change of size, so matching it in If-Range is at least as safe as the date.
+func videoETag(info os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// Whether a request for the whole file is left to SendFile, which lets the kernel copy it
+// to the socket. That is the fastest way to move a big download, but the transfer is then
+// invisible to -max-streams, -stream-idle-timeout and the stream metrics, so files below
+// -sendfile-min-size go through the streaming loop like ranges do.
+func useSendFile(cfg *Config, fileSize int64) bool {
+	return fileSize >= cfg.SendFileMinSize
+}
+
+// Hand the file to nginx with an X-Accel-Redirect to the -accel-redirect location, which
+// maps to the root of the filesystem, so every movie directory works without more config
+func accelRedirect(c *fiber.Ctx, cfg *Config, movieFilePath string) error {
+	path, err := filepath.Abs(movieFilePath)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Could not resolve video file.")
+	}
+	// nginx keeps the type and range support the server announces
+	if contentType, ok := contentTypes[strings.ToLower(filepath.Ext(path))]; ok {
+		c.Set(fiber.HeaderContentType, contentType)
+	}
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set("X-Accel-Redirect", strings.TrimSuffix(cfg.AccelRedirect, "/")+(&url.URL{Path: filepath.ToSlash(path)}).EscapedPath())
+	c.Status(fiber.StatusOK)
+	return nil
+}
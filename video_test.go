@@ -0,0 +1,597 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMovie = "0123456789abcdefghij"
+
+// Starts with the EBML magic, so the header check takes it for Matroska or WebM
+const testMatroska = "\x1a\x45\xdf\xa3456789abcdefghij"
+
+func TestVideoWindows(t *testing.T) {
+	app, _ := newTestServer(t, "-prefetch-bytes", "8", "-start-window", "4")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	for rangeHeader, want := range map[string]string{
+		"bytes=0-": "0123",
+		"bytes=4-": "456789ab",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+		req.Header.Set("Range", rangeHeader)
+		if resp, body := send(t, app, req); resp.StatusCode != http.StatusPartialContent || body != want {
+			t.Errorf("Range %s answered %d: %q, want %q", rangeHeader, resp.StatusCode, body, want)
+		}
+	}
+}
+
+func TestStreamStartMetric(t *testing.T) {
+	app, _ := newTestServer(t, "-prefetch-bytes", "8")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+
+	streamStartSeconds.mu.Lock()
+	before := streamStartSeconds.count
+	streamStartSeconds.mu.Unlock()
+	req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+	req.Header.Set("Range", "bytes=2-")
+	if resp, body := send(t, app, req); resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("range answered %d: %s", resp.StatusCode, body)
+	}
+	streamStartSeconds.mu.Lock()
+	after := streamStartSeconds.count
+	streamStartSeconds.mu.Unlock()
+	if after != before+1 {
+		t.Errorf("stream start observed %d times for one range", after-before)
+	}
+
+	_, metrics := get(t, app, "/metrics")
+	if !strings.Contains(metrics, "# TYPE display_stream_start_seconds histogram") ||
+		!strings.Contains(metrics, "display_stream_start_seconds_count ") {
+		t.Errorf("metrics lack the stream start histogram:\n%s", metrics)
+	}
+}
+
+func TestVideoEmptyFile(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), nil)
+
+	resp, body := get(t, app, "/video/a")
+	if resp.StatusCode != http.StatusUnprocessableEntity || body != "Movie file is empty." {
+		t.Errorf("empty file answered %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestConcurrentRanges(t *testing.T) {
+	// All of app.Test's clients share one address
+	app, _ := newTestServer(t, "-max-streams-per-ip", "0")
+	movie := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(movie)
+	writeFile(t, filepath.Join("movies", "a.mp4"), movie)
+
+	// Ranges that overlap each other, fetched by many clients at once
+	type window struct{ start, end int }
+	windows := make(chan window)
+	go func() {
+		defer close(windows)
+		r := rand.New(rand.NewSource(2))
+		for i := 0; i < 200; i++ {
+			start := r.Intn(len(movie) - 1)
+			windows <- window{start, start + r.Intn(len(movie)-start)}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range windows {
+				req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+				req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", w.start, w.end))
+				resp, body := send(t, app, req)
+				if resp.StatusCode != http.StatusPartialContent {
+					t.Errorf("bytes=%d-%d answered %d", w.start, w.end, resp.StatusCode)
+					continue
+				}
+				// The server sends its own window from the start byte, which must match the file there
+				if body == "" || body != string(movie[w.start:w.start+len(body)]) {
+					t.Errorf("bytes=%d-%d returned %d bytes that don't match the file", w.start, w.end, len(body))
+				}
+				if want := fmt.Sprintf("bytes %d-%d/%d", w.start, w.start+len(body)-1, len(movie)); resp.Header.Get("Content-Range") != want {
+					t.Errorf("Content-Range is %q, want %q", resp.Header.Get("Content-Range"), want)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestVideoTotalSize(t *testing.T) {
+	app, _ := newTestServer(t, "-prefetch-bytes", "8")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	total := fmt.Sprint(len(testMovie))
+
+	// Without a range the whole file is sent
+	resp, body := get(t, app, "/video/a")
+	if resp.StatusCode != http.StatusOK || body != testMovie {
+		t.Errorf("no-range request answered %d with %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Total-Size") != total || resp.Header.Get("Content-Length") != total {
+		t.Errorf("no-range request sent X-Total-Size %q and Content-Length %q, want %s", resp.Header.Get("X-Total-Size"), resp.Header.Get("Content-Length"), total)
+	}
+
+	// A range gets a window, with the total on both headers
+	req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+	req.Header.Set("Range", "bytes=4-")
+	resp, body = send(t, app, req)
+	if resp.StatusCode != http.StatusPartialContent || body != testMovie[4:12] {
+		t.Errorf("range request answered %d with %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Total-Size") != total || resp.Header.Get("Content-Range") != "bytes 4-11/"+total {
+		t.Errorf("range request sent X-Total-Size %q and Content-Range %q", resp.Header.Get("X-Total-Size"), resp.Header.Get("Content-Range"))
+	}
+}
+
+func TestRangeEndingAtEOF(t *testing.T) {
+	app, _ := newTestServer(t, "-prefetch-bytes", "200000")
+	// Not a multiple of the read buffer, so the last read is a short one
+	movie := make([]byte, 100003)
+	rand.New(rand.NewSource(1)).Read(movie)
+	writeFile(t, filepath.Join("movies", "a.mp4"), movie)
+
+	for _, start := range []int{len(movie) - 1, len(movie) - 6144, len(movie) - 100000, 0} {
+		req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+		resp, body := send(t, app, req)
+		if resp.StatusCode != http.StatusPartialContent || body != string(movie[start:]) {
+			t.Errorf("bytes=%d- answered %d with %d bytes, want the last %d", start, resp.StatusCode, len(body), len(movie)-start)
+		}
+		if want := fmt.Sprintf("bytes %d-%d/%d", start, len(movie)-1, len(movie)); resp.Header.Get("Content-Range") != want {
+			t.Errorf("bytes=%d- sent Content-Range %q, want %q", start, resp.Header.Get("Content-Range"), want)
+		}
+	}
+}
+
+// Errors about the range are text, not a video the player tries to decode
+func TestRangeErrorsArePlainText(t *testing.T) {
+	app, _ := newTestServer(t)
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	for rangeHeader, status := range map[string]int{
+		"bytes=20-":   http.StatusRequestedRangeNotSatisfiable,
+		"bytes=x-":    http.StatusBadRequest,
+		"bytes=-5":    http.StatusBadRequest,
+		"items=0-5":   http.StatusBadRequest,
+		"bytes=19-":   http.StatusPartialContent,
+		"bytes=0-100": http.StatusPartialContent,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+		req.Header.Set("Range", rangeHeader)
+		resp, body := send(t, app, req)
+		want := "text/plain; charset=utf-8"
+		if status == http.StatusPartialContent {
+			want = "video/mp4"
+		}
+		if resp.StatusCode != status || resp.Header.Get("Content-Type") != want {
+			t.Errorf("%s answered %d as %q: %q", rangeHeader, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
+func TestExtremeRangeStarts(t *testing.T) {
+	const maxInt64 = "9223372036854775807"
+	for _, tt := range []struct {
+		args               []string
+		rangeHeader        string
+		status             int
+		body, contentRange string
+	}{
+		{nil, "bytes=" + maxInt64 + "-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{nil, "bytes=9223372036854775806-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{nil, "bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		// Too large for int64, but still past the end of any file
+		{nil, "bytes=9223372036854775808-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{nil, "bytes=99999999999999999999999-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{nil, "bytes=x-", http.StatusBadRequest, "", ""},
+		{nil, "bytes=19-", http.StatusPartialContent, "j", "bytes 19-19/20"},
+		// A window as large as it gets still ends with the file
+		{[]string{"-prefetch-bytes", maxInt64}, "bytes=15-", http.StatusPartialContent, testMovie[15:], "bytes 15-19/20"},
+		{[]string{"-prefetch-bytes", maxInt64}, "bytes=" + maxInt64 + "-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		// A growing file isn't waited for when the start can't be reached
+		{[]string{"-growing-wait", "2s"}, "bytes=" + maxInt64 + "-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		// Native ranges refuse them too
+		{[]string{"-native-range-formats", "mp4"}, "bytes=" + maxInt64 + "-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{[]string{"-native-range-formats", "mp4"}, "bytes=9223372036854775808-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+	} {
+		app, _ := newTestServer(t, tt.args...)
+		writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+		req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+		req.Header.Set("Range", tt.rangeHeader)
+		started := time.Now()
+		resp, body := send(t, app, req)
+		if resp.StatusCode != tt.status || (tt.body != "" && body != tt.body) || resp.Header.Get("Content-Range") != tt.contentRange {
+			t.Errorf("%v %s answered %d with Content-Range %q: %q", tt.args, tt.rangeHeader, resp.StatusCode, resp.Header.Get("Content-Range"), body)
+		}
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Errorf("%v %s took %s", tt.args, tt.rangeHeader, elapsed)
+		}
+	}
+}
+
+// Files are opened for each request, a movie replaced on disk is sent as it is now
+func TestSendFileReplaced(t *testing.T) {
+	app, _ := newTestServer(t, "-sendfile-min-size", "0", "-native-range-formats", "mkv")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte(testMovie))
+
+	ranged := func(target string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Range", "bytes=2-5")
+		return send(t, app, req)
+	}
+	if resp, body := get(t, app, "/video/a"); resp.StatusCode != http.StatusOK || body != testMovie || resp.Header.Get("Content-Type") != "video/mp4" {
+		t.Errorf("whole file answered %d as %q: %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	if resp, body := ranged("/video/b"); resp.StatusCode != http.StatusPartialContent || body != "2345" || resp.Header.Get("Content-Type") != "video/x-matroska" {
+		t.Errorf("native range answered %d as %q: %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte("replaced"))
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte("replaced"))
+	if _, body := get(t, app, "/video/a"); body != "replaced" {
+		t.Errorf("a replaced file was answered as %q", body)
+	}
+	if resp, body := ranged("/video/b"); body != "plac" || resp.Header.Get("Content-Range") != "bytes 2-5/8" {
+		t.Errorf("a replaced file's range was answered as %q with %q", body, resp.Header.Get("Content-Range"))
+	}
+}
+
+func TestSendFileChoice(t *testing.T) {
+	for _, tt := range []struct {
+		minSize, fileSize int64
+		want              bool
+	}{
+		{64 << 20, 5 << 20, false},
+		{64 << 20, 64 << 20, true},
+		{64 << 20, 1 << 30, true},
+		{0, 0, true},
+		{0, 20, true},
+	} {
+		if got := useSendFile(&Config{SendFileMinSize: tt.minSize}, tt.fileSize); got != tt.want {
+			t.Errorf("useSendFile with -sendfile-min-size %d for %d bytes = %t", tt.minSize, tt.fileSize, got)
+		}
+	}
+
+	streamStarts := func() uint64 {
+		streamStartSeconds.mu.Lock()
+		defer streamStartSeconds.mu.Unlock()
+		return streamStartSeconds.count
+	}
+	for _, tt := range []struct {
+		minSize, rangeHeader string
+		status               int
+		body                 string
+		streamed             bool
+	}{
+		{"10", "", http.StatusOK, testMovie, false},
+		{"21", "", http.StatusOK, testMovie, true},
+		{"10", "bytes=5-", http.StatusPartialContent, testMovie[5:], true},
+		{"21", "bytes=5-", http.StatusPartialContent, testMovie[5:], true},
+	} {
+		app, _ := newTestServer(t, "-sendfile-min-size", tt.minSize)
+		writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+		req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		before := streamStarts()
+		resp, body := send(t, app, req)
+		if resp.StatusCode != tt.status || body != tt.body || resp.ContentLength != int64(len(tt.body)) {
+			t.Errorf("-sendfile-min-size %s, Range %q: answered %d with %d bytes: %q", tt.minSize, tt.rangeHeader, resp.StatusCode, resp.ContentLength, body)
+		}
+		if streamed := streamStarts() > before; streamed != tt.streamed {
+			t.Errorf("-sendfile-min-size %s, Range %q: streaming loop used %t", tt.minSize, tt.rangeHeader, streamed)
+		}
+		if tt.rangeHeader == "" && resp.Header.Get("Content-Range") != "" {
+			t.Errorf("-sendfile-min-size %s: whole file sent with Content-Range %q", tt.minSize, resp.Header.Get("Content-Range"))
+		}
+	}
+}
+
+func TestNativeRangeFormats(t *testing.T) {
+	app, _ := newTestServer(t, "-native-range-formats", "webm", "-prefetch-bytes", "4")
+	writeFile(t, filepath.Join("movies", "a.webm"), []byte(testMovie))
+	writeFile(t, filepath.Join("movies", "b.mkv"), []byte(testMovie))
+
+	for _, tt := range []struct {
+		target, rangeHeader string
+		status              int
+		body, contentRange  string
+	}{
+		// Answered as asked for
+		{"/video/a", "bytes=10-14", http.StatusPartialContent, "abcde", "bytes 10-14/20"},
+		{"/video/a", "bytes=5-", http.StatusPartialContent, testMovie[5:], "bytes 5-19/20"},
+		{"/video/a", "", http.StatusOK, testMovie, ""},
+		{"/video/a", "bytes=30-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		// Other formats keep their windows
+		{"/video/b", "bytes=10-14", http.StatusPartialContent, "abcd", "bytes 10-13/20"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		resp, body := send(t, app, req)
+		if resp.StatusCode != tt.status || (tt.body != "" && body != tt.body) || resp.Header.Get("Content-Range") != tt.contentRange {
+			t.Errorf("%s with Range %q answered %d with Content-Range %q: %q", tt.target, tt.rangeHeader, resp.StatusCode, resp.Header.Get("Content-Range"), body)
+		}
+		if tt.target == "/video/a" && resp.StatusCode < 300 && resp.Header.Get("Content-Type") != "video/webm" {
+			t.Errorf("%s with Range %q sent as %q", tt.target, tt.rangeHeader, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	if _, err := loadConfig([]string{"-native-range-formats", "webm,xyz"}); err == nil {
+		t.Error("unknown -native-range-formats accepted")
+	}
+}
+
+func TestAccelRedirect(t *testing.T) {
+	app, _ := newTestServer(t, "-accel-redirect", "/internal-movies/", "-movies-dir", "movies,more")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	writeFile(t, filepath.Join("more", "b.webm"), []byte(testMovie))
+	wd, _ := os.Getwd()
+
+	streamStartSeconds.mu.Lock()
+	before := streamStartSeconds.count
+	streamStartSeconds.mu.Unlock()
+	for _, tt := range []struct {
+		target, rangeHeader, redirect, contentType string
+	}{
+		{"/video/a", "", "/internal-movies" + filepath.Join(wd, "movies", "a.mp4"), "video/mp4"},
+		{"/video/a", "bytes=5-", "/internal-movies" + filepath.Join(wd, "movies", "a.mp4"), "video/mp4"},
+		{"/video/b", "", "/internal-movies" + filepath.Join(wd, "more", "b.webm"), "video/webm"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		resp, body := send(t, app, req)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Accel-Redirect") != tt.redirect || body != "" {
+			t.Errorf("%s with Range %q answered %d with X-Accel-Redirect %q and %d bytes, want %s",
+				tt.target, tt.rangeHeader, resp.StatusCode, resp.Header.Get("X-Accel-Redirect"), len(body), tt.redirect)
+		}
+		// nginx takes the type and range support from our response
+		if resp.Header.Get("Content-Type") != tt.contentType || resp.Header.Get("Accept-Ranges") != "bytes" || resp.Header.Get("Content-Range") != "" {
+			t.Errorf("%s with Range %q sent as %q, Accept-Ranges %q, Content-Range %q", tt.target, tt.rangeHeader,
+				resp.Header.Get("Content-Type"), resp.Header.Get("Accept-Ranges"), resp.Header.Get("Content-Range"))
+		}
+	}
+	streamStartSeconds.mu.Lock()
+	after := streamStartSeconds.count
+	streamStartSeconds.mu.Unlock()
+	if after != before || openStreams.Load() != 0 {
+		t.Errorf("handing files to nginx started %d streams", after-before)
+	}
+
+	if resp, _ := get(t, app, "/video/missing"); resp.StatusCode != http.StatusNotFound || resp.Header.Get("X-Accel-Redirect") != "" {
+		t.Errorf("missing movie answered %d with X-Accel-Redirect %q", resp.StatusCode, resp.Header.Get("X-Accel-Redirect"))
+	}
+	if _, err := loadConfig([]string{"-accel-redirect", "internal"}); err == nil {
+		t.Error("-accel-redirect without a leading slash accepted")
+	}
+}
+
+func TestVideoHead(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	lastModified, etag := modTime.Format(http.TimeFormat), fmt.Sprintf(`W/"14-%x"`, modTime.UnixNano())
+	for _, minSize := range []string{"1000", "0"} {
+		app, _ := newTestServer(t, "-prefetch-bytes", "8", "-sendfile-min-size", minSize)
+		movie := filepath.Join("movies", "a.mp4")
+		writeFile(t, movie, []byte(testMovie))
+		os.Chtimes(movie, modTime, modTime)
+		logs := captureLog(t)
+
+		streamStartSeconds.mu.Lock()
+		before := streamStartSeconds.count
+		streamStartSeconds.mu.Unlock()
+		for _, tt := range []struct {
+			rangeHeader, ifRange string
+			status               int
+			length, contentRange string
+		}{
+			{"", "", http.StatusOK, "20", ""},
+			{"bytes=5-", "", http.StatusPartialContent, "8", "bytes 5-12/20"},
+			{"bytes=15-", "", http.StatusPartialContent, "5", "bytes 15-19/20"},
+			{"bytes=5-", lastModified, http.StatusPartialContent, "8", "bytes 5-12/20"},
+			// The file changed since the client got its first part, it needs all of it again
+			{"bytes=5-", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, "20", ""},
+			{"bytes=5-", etag, http.StatusPartialContent, "8", "bytes 5-12/20"},
+			{"bytes=5-", `W/"14-0"`, http.StatusOK, "20", ""},
+			{"bytes=5-", `"some-etag"`, http.StatusOK, "20", ""},
+		} {
+			req, _ := http.NewRequest(http.MethodHead, "/video/a", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			resp, body := send(t, app, req)
+			if resp.StatusCode != tt.status || resp.Header.Get("Content-Length") != tt.length || resp.Header.Get("Content-Range") != tt.contentRange || body != "" {
+				t.Errorf("-sendfile-min-size %s, HEAD with Range %q, If-Range %q: %d, Content-Length %q, Content-Range %q, %d bytes of body",
+					minSize, tt.rangeHeader, tt.ifRange, resp.StatusCode, resp.Header.Get("Content-Length"), resp.Header.Get("Content-Range"), len(body))
+			}
+			if resp.Header.Get("Accept-Ranges") != "bytes" || resp.Header.Get("Last-Modified") != lastModified || resp.Header.Get("ETag") != etag {
+				t.Errorf("-sendfile-min-size %s, HEAD with Range %q: Accept-Ranges %q, Last-Modified %q, ETag %q",
+					minSize, tt.rangeHeader, resp.Header.Get("Accept-Ranges"), resp.Header.Get("Last-Modified"), resp.Header.Get("ETag"))
+			}
+		}
+		streamStartSeconds.mu.Lock()
+		after := streamStartSeconds.count
+		streamStartSeconds.mu.Unlock()
+		if after != before || openStreams.Load() != 0 || logs.String() != "" {
+			t.Errorf("-sendfile-min-size %s: HEAD started %d streams, logging:\n%s", minSize, after-before, logs)
+		}
+	}
+
+	// A GET with a stale If-Range gets the whole file
+	app, _ := newTestServer(t, "-prefetch-bytes", "8", "-sendfile-min-size", "1000")
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(testMovie))
+	os.Chtimes(movie, modTime, modTime)
+	req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+	req.Header.Set("Range", "bytes=5-")
+	req.Header.Set("If-Range", modTime.Add(-time.Hour).Format(http.TimeFormat))
+	if resp, body := send(t, app, req); resp.StatusCode != http.StatusOK || body != testMovie {
+		t.Errorf("GET with a stale If-Range answered %d: %q", resp.StatusCode, body)
+	}
+}
+
+func TestGrowingFile(t *testing.T) {
+	app, _ := newTestServer(t, "-growing-wait", "2s", "-prefetch-bytes", "8")
+	movie := filepath.Join("movies", "a.mp4")
+	writeFile(t, movie, []byte(testMovie))
+	request := func(rangeHeader string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+		req.Header.Set("Range", rangeHeader)
+		return send(t, app, req)
+	}
+
+	// Still being written, the size so far isn't the total
+	if resp, body := request("bytes=4-"); resp.StatusCode != http.StatusPartialContent || body != "456789ab" || resp.Header.Get("Content-Range") != "bytes 4-11/*" {
+		t.Errorf("range of a growing file answered %d with Content-Range %q: %q", resp.StatusCode, resp.Header.Get("Content-Range"), body)
+	}
+
+	// A range past the end waits for the writer
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		f, err := os.OpenFile(movie, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		f.WriteString("KLMNOPQRST")
+		f.Close()
+	}()
+	if resp, body := request("bytes=25-"); resp.StatusCode != http.StatusPartialContent || body != "PQRST" || resp.Header.Get("Content-Range") != "bytes 25-29/*" {
+		t.Errorf("range past the end of a growing file answered %d with Content-Range %q: %q", resp.StatusCode, resp.Header.Get("Content-Range"), body)
+	}
+
+	// Up to -growing-wait, then as before
+	started := time.Now()
+	if resp, _ := request("bytes=100-"); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("range past the end of a stalled file answered %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(started); elapsed < 2*time.Second {
+		t.Errorf("gave up on the file after %s", elapsed)
+	}
+
+	// A finished file has its total again
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(movie, old, old)
+	if resp, _ := request("bytes=4-"); resp.Header.Get("Content-Range") != "bytes 4-11/30" {
+		t.Errorf("range of a finished file has Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+
+	// Without -growing-wait nothing waits
+	app, _ = newTestServer(t, "-prefetch-bytes", "8")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	started = time.Now()
+	if resp, _ := request("bytes=25-"); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || time.Since(started) > time.Second {
+		t.Errorf("range past the end answered %d after %s", resp.StatusCode, time.Since(started))
+	}
+	if resp, _ := request("bytes=4-"); resp.Header.Get("Content-Range") != "bytes 4-11/20" {
+		t.Errorf("range with -growing-wait 0 has Content-Range %q", resp.Header.Get("Content-Range"))
+	}
+}
+
+func TestSaveData(t *testing.T) {
+	app, _ := newTestServer(t, "-prefetch-bytes", "8", "-start-window", "6", "-save-data-bytes", "3")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	for _, tt := range []struct {
+		rangeHeader, saveData, body string
+	}{
+		{"bytes=0-", "", "012345"},
+		{"bytes=4-", "", "456789ab"},
+		{"bytes=0-", "on", "012"},
+		{"bytes=4-", "on", "456"},
+		{"bytes=4-", "On", "456"},
+		{"bytes=4-", "off", "456789ab"},
+		// The end of the file is no more than what is left
+		{"bytes=18-", "on", "ij"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+		req.Header.Set("Range", tt.rangeHeader)
+		if tt.saveData != "" {
+			req.Header.Set("Save-Data", tt.saveData)
+		}
+		resp, body := send(t, app, req)
+		if resp.StatusCode != http.StatusPartialContent || body != tt.body || resp.Header.Get("Vary") != "Save-Data" {
+			t.Errorf("Range %s, Save-Data %q: answered %d with Vary %q: %q, want %q", tt.rangeHeader, tt.saveData, resp.StatusCode, resp.Header.Get("Vary"), body, tt.body)
+		}
+	}
+
+	// 0 ignores the hint
+	app, _ = newTestServer(t, "-prefetch-bytes", "8", "-save-data-bytes", "0")
+	writeFile(t, filepath.Join("movies", "a.mp4"), []byte(testMovie))
+	req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+	req.Header.Set("Range", "bytes=4-")
+	req.Header.Set("Save-Data", "on")
+	if _, body := send(t, app, req); body != "456789ab" {
+		t.Errorf("with -save-data-bytes 0 Save-Data got %q", body)
+	}
+}
+
+func TestReadBufferBytes(t *testing.T) {
+	content := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(content)
+	for _, size := range []string{"7", "6144", "65536"} {
+		app, _ := newTestServer(t, "-read-buffer-bytes", size, "-prefetch-bytes", "1000000", "-sendfile-min-size", "1000000")
+		writeFile(t, filepath.Join("movies", "a.mp4"), content)
+		for _, rangeHeader := range []string{"", "bytes=0-", "bytes=12345-"} {
+			req, _ := http.NewRequest(http.MethodGet, "/video/a", nil)
+			if rangeHeader != "" {
+				req.Header.Set("Range", rangeHeader)
+			}
+			want := content
+			if rangeHeader == "bytes=12345-" {
+				want = content[12345:]
+			}
+			if resp, body := send(t, app, req); resp.StatusCode >= 300 || body != string(want) {
+				t.Errorf("-read-buffer-bytes %s, Range %q: answered %d with %d of %d bytes", size, rangeHeader, resp.StatusCode, len(body), len(want))
+			}
+		}
+	}
+
+	for _, size := range []string{"0", "16777217"} {
+		if _, err := loadConfig([]string{"-read-buffer-bytes", size}); err == nil {
+			t.Errorf("-read-buffer-bytes %s accepted", size)
+		}
+	}
+}
+
+// Allocations of the streaming loop's read buffer per stream, from the pool and made anew
+func BenchmarkReadBuffer(b *testing.B) {
+	cfg := &Config{ReadBufferBytes: 6144}
+	chunk := make([]byte, cfg.ReadBufferBytes)
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			buffer := getReadBuffer(cfg)
+			copy(buffer, chunk)
+			putReadBuffer(buffer)
+		}
+	})
+	b.Run("make", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			buffer := make([]byte, cfg.ReadBufferBytes)
+			copy(buffer, chunk)
+		}
+	})
+}
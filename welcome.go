@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Default for -empty-hint. {dirs} and {formats} are replaced with the movie directories
+// and served extensions.
+const defaultEmptyHint = "The library is empty. Copy video files ({formats}) into {dirs}; they show up without a restart."
+
+// Page shown at / while the library is empty
+//
+//go:embed welcome.html
+var welcomePage string
+
+var welcomeTemplate = template.Must(template.New("welcome").Parse(welcomePage))
+
+// The -empty-hint text for this server, empty when it is turned off
+func emptyHint(cfg *Config) string {
+	return strings.NewReplacer(
+		"{dirs}", strings.Join(cfg.MoviesDirs, ", "),
+		"{formats}", strings.Join(cfg.Tunables().Formats, ", "),
+	).Replace(cfg.EmptyHint)
+}
+
+// Whether no movie dir holds a single file in a served format. Stops at the first one, so
+// unlike listMovies this stays cheap for large libraries.
+func libraryEmpty(cfg *Config) bool {
+	for _, root := range cfg.MoviesDirs {
+		files, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") && cfg.servesFormat(filepath.Ext(file.Name())) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Route for / on a fresh install, telling where to put the movies. There is no library page
+// otherwise, so once a movie exists / answers 404 as before.
+func welcomeHandler(cfg *Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if cfg.EmptyHint == "" || !libraryEmpty(cfg) {
+			return c.Next()
+		}
+		var page bytes.Buffer
+		err := welcomeTemplate.Execute(&page, struct {
+			Hint    string
+			Dirs    []string
+			Formats []string
+			Nonce   string
+		}{emptyHint(cfg), cfg.MoviesDirs, cfg.Tunables().Formats, cspNonce(c, cfg)})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to render the page.")
+		}
+		// The page goes away with the first movie, it must not be cached
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Type("html", "utf-8")
+		return c.Send(page.Bytes())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmptyLibraryHint(t *testing.T) {
+	app, _ := newTestServer(t, "-movies-dir", "movies,more")
+	writeFile(t, filepath.Join("movies", "notes.txt"), []byte("not a movie"))
+	writeFile(t, filepath.Join("more", ".hidden.mp4"), []byte(testMovie))
+	hint := "The library is empty. Copy video files (mp4, webm, mkv, avi) into movies, more; they show up without a restart."
+
+	resp, body := get(t, app, "/api/movies")
+	if resp.StatusCode != http.StatusOK || body != "[]" || resp.Header.Get("X-Empty-Library-Hint") != hint {
+		t.Errorf("empty catalog answered %d with hint %q: %s", resp.StatusCode, resp.Header.Get("X-Empty-Library-Hint"), body)
+	}
+	resp, body = get(t, app, "/")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "<p>"+hint+"</p>") || !strings.Contains(body, "<li><code>more</code></li>") ||
+		resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("/ of an empty library answered %d with Cache-Control %q:\n%s", resp.StatusCode, resp.Header.Get("Cache-Control"), body)
+	}
+
+	// Gone with the first movie
+	writeFile(t, filepath.Join("more", "a.mkv"), []byte(testMovie))
+	if resp, _ := get(t, app, "/"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/ with a movie answered %d", resp.StatusCode)
+	}
+	if resp, _ := get(t, app, "/api/movies"); resp.Header.Get("X-Empty-Library-Hint") != "" {
+		t.Errorf("catalog with a movie has the hint %q", resp.Header.Get("X-Empty-Library-Hint"))
+	}
+}
+
+func TestEmptyLibraryHintConfigured(t *testing.T) {
+	app, _ := newTestServer(t, "-empty-hint", "Ask the admin to fill {dirs} with {formats} files.", "-formats", "mp4")
+	if resp, _ := get(t, app, "/api/movies"); resp.Header.Get("X-Empty-Library-Hint") != "Ask the admin to fill movies with mp4 files." {
+		t.Errorf("hint is %q", resp.Header.Get("X-Empty-Library-Hint"))
+	}
+
+	app, _ = newTestServer(t, "-empty-hint", "")
+	if resp, _ := get(t, app, "/"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/ without a hint answered %d", resp.StatusCode)
+	}
+	if resp, _ := get(t, app, "/api/movies"); resp.Header.Get("X-Empty-Library-Hint") != "" {
+		t.Errorf("hint turned off is still %q", resp.Header.Get("X-Empty-Library-Hint"))
+	}
+	if _, err := loadConfig([]string{"-empty-hint", "two\nlines"}); err == nil {
+		t.Error("-empty-hint over two lines accepted")
+	}
+}
+
+func TestEmptyLibraryNonce(t *testing.T) {
+	app, _ := newTestServer(t, "-headers", "Content-Security-Policy-Report-Only: default-src 'none'")
+	resp, page := get(t, app, "/")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/ answered %d: %s", resp.StatusCode, page)
+	}
+	m := nonceAttr.FindStringSubmatch(page)
+	if policy := resp.Header.Get("Content-Security-Policy-Report-Only"); m == nil || !strings.Contains(policy, "style-src 'nonce-"+m[1]+"'") {
+		t.Errorf("page nonce %v not in policy %q", m, policy)
+	}
+}